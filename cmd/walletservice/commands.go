@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/kelseyhightower/envconfig"
+
+	"github.com/shkov/wallet-service/internal/account"
+	"github.com/shkov/wallet-service/internal/audit"
+	"github.com/shkov/wallet-service/internal/importfile"
+	"github.com/shkov/wallet-service/internal/reconcile"
+	"github.com/shkov/wallet-service/internal/storage"
+	"github.com/shkov/wallet-service/internal/walletservice"
+)
+
+// commandTimeout limits a single storage query of a command.
+const commandTimeout = 30 * time.Second
+
+// dateLayout is the layout of the days given to the commands.
+const dateLayout = "2006-01-02"
+
+const usage = `usage: walletservice [command]
+
+Without a command the service is started.
+
+commands:
+  audit verify [-batch n]                     verify the hash chain of the audit log
+  reconcile [-format json|csv] [-output file]  report the accounts whose balances do not match their payments
+  accrue-interest -date day [-to day]          accrue the interest for the days, e.g. -date 2021-03-01
+  import accounts -file file [-batch n]        import the accounts and their opening balances from a .csv or .jsonl file
+`
+
+var (
+	errChainBroken     = errors.New("audit log chain is broken")
+	errBalanceMismatch = errors.New("balances do not match payments")
+	errInterestFailed  = errors.New("interest is not posted to some accounts")
+	errRowsRejected    = errors.New("some rows are not imported")
+)
+
+// runCommand runs the operational command given by the arguments.
+func runCommand(ctx context.Context, logger log.Logger, args []string) error {
+	switch {
+	case len(args) >= 2 && args[0] == "audit" && args[1] == "verify":
+		return runAuditVerify(ctx, logger, args[2:])
+	case args[0] == "reconcile":
+		return runReconcile(ctx, logger, args[1:])
+	case args[0] == "accrue-interest":
+		return runAccrueInterest(ctx, logger, args[1:])
+	case len(args) >= 2 && args[0] == "import" && args[1] == "accounts":
+		return runImportAccounts(ctx, logger, args[2:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		return fmt.Errorf("unknown command %q", args)
+	}
+}
+
+// newCommandStorage connects to the storage configured by the environment.
+func newCommandStorage(logger log.Logger) (storage.TransactionalStorage, error) {
+	var cfg PostgresConfiguration
+	if err := envconfig.Process("", &cfg); err != nil {
+		return nil, fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	var s storage.TransactionalStorage
+	s = storage.NewTransactional(cfg.storageConfig(commandTimeout, commandTimeout))
+	s = storage.NewLoggingMiddleware(s, logger)
+	return s, nil
+}
+
+func runAuditVerify(ctx context.Context, logger log.Logger, args []string) error {
+	flags := flag.NewFlagSet("audit verify", flag.ContinueOnError)
+	batch := flags.Int("batch", 1000, "number of entries read at once")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *batch <= 0 {
+		return errors.New("batch must be positive")
+	}
+
+	s, err := newCommandStorage(logger)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	report, err := audit.Verify(ctx, s, *batch)
+	if err != nil {
+		return fmt.Errorf("failed to verify audit log: %w", err)
+	}
+
+	fmt.Printf("verified %d entries, %d breaks\n", report.Entries, len(report.Breaks))
+	for _, b := range report.Breaks {
+		fmt.Println(b)
+	}
+	if len(report.Breaks) > 0 {
+		return errChainBroken
+	}
+	return nil
+}
+
+func runReconcile(ctx context.Context, logger log.Logger, args []string) error {
+	flags := flag.NewFlagSet("reconcile", flag.ContinueOnError)
+	format := flags.String("format", reconcile.FormatJSON, "report format: json or csv")
+	output := flags.String("output", "", "report file, stdout by default")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *format != reconcile.FormatJSON && *format != reconcile.FormatCSV {
+		return fmt.Errorf("unknown report format %q", *format)
+	}
+
+	s, err := newCommandStorage(logger)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	report, err := reconcile.Run(ctx, s, time.Now())
+	if err != nil {
+		return err
+	}
+
+	w := os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("failed to create report file: %w", err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := report.Write(w, *format); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+
+	if len(report.Discrepancies) > 0 {
+		return fmt.Errorf("%w: %d accounts", errBalanceMismatch, len(report.Discrepancies))
+	}
+	return nil
+}
+
+func runAccrueInterest(ctx context.Context, logger log.Logger, args []string) error {
+	flags := flag.NewFlagSet("accrue-interest", flag.ContinueOnError)
+	date := flags.String("date", "", "first day to accrue the interest for, YYYY-MM-DD")
+	to := flags.String("to", "", "last day to accrue the interest for, the first one by default")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	from, err := time.Parse(dateLayout, *date)
+	if err != nil {
+		return fmt.Errorf("invalid date: %w", err)
+	}
+	until := from
+	if *to != "" {
+		until, err = time.Parse(dateLayout, *to)
+		if err != nil {
+			return fmt.Errorf("invalid to: %w", err)
+		}
+	}
+	if until.Before(from) {
+		return errors.New("to must not be before date")
+	}
+
+	var cfg struct {
+		InterestConfiguration
+		BalancePolicyConfiguration
+	}
+	if err := envconfig.Process("", &cfg); err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+	if len(cfg.InterestRates) == 0 {
+		return errors.New("INTEREST_RATES is not set")
+	}
+	balancePolicies, err := cfg.balancePolicies()
+	if err != nil {
+		return fmt.Errorf("failed to initialize balance policies: %w", err)
+	}
+
+	s, err := newCommandStorage(logger)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	accruer, err := walletservice.NewInterestAccruer(cfg.interestAccruerConfig(logger, s, balancePolicies))
+	if err != nil {
+		return err
+	}
+
+	var failed int64
+	for day := from; !day.After(until); day = day.AddDate(0, 0, 1) {
+		report, err := accruer.Accrue(ctx, day)
+		if err != nil {
+			return fmt.Errorf("failed to accrue interest for %s: %w", day.Format(dateLayout), err)
+		}
+		fmt.Printf("%s: accrued %d, posted %d, failed %d\n", day.Format(dateLayout), report.Accrued, report.Posted, report.Failed)
+		failed += report.Failed
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%w: %d accounts", errInterestFailed, failed)
+	}
+	return nil
+}
+
+func runImportAccounts(ctx context.Context, logger log.Logger, args []string) error {
+	flags := flag.NewFlagSet("import accounts", flag.ContinueOnError)
+	file := flags.String("file", "", "file to import, its format is given by the extension: .csv or .jsonl")
+	batch := flags.Int("batch", 500, "number of rows imported in a transaction")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *batch <= 0 {
+		return errors.New("batch must be positive")
+	}
+	format, err := importfile.FormatOf(*file)
+	if err != nil {
+		return err
+	}
+
+	var cfg BalancePolicyConfiguration
+	if err := envconfig.Process("", &cfg); err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+	balancePolicies, err := cfg.balancePolicies()
+	if err != nil {
+		return fmt.Errorf("failed to initialize balance policies: %w", err)
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+	r, err := importfile.NewReader(f, format)
+	if err != nil {
+		return fmt.Errorf("failed to read file: %w", err)
+	}
+
+	s, err := newCommandStorage(logger)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	importer, err := walletservice.NewAccountImporter(walletservice.AccountImporterConfig{
+		Logger:          logger,
+		Storage:         s,
+		BalancePolicies: balancePolicies,
+	})
+	if err != nil {
+		return err
+	}
+
+	var created, updated, rejected int
+	reject := func(rec *importfile.Record, err error) {
+		rejected++
+		if rec.Row != nil {
+			fmt.Printf("line %d: account %d: %v\n", rec.Line, rec.Row.ID, err)
+		} else {
+			fmt.Printf("line %d: %v\n", rec.Line, err)
+		}
+	}
+
+	// records are the rows of the batch, a row of an account already in the batch starts the next one,
+	// so the last row of the account wins.
+	records := make([]*importfile.Record, 0, *batch)
+	ids := make(map[int64]bool, *batch)
+	flush := func() error {
+		if len(records) == 0 {
+			return nil
+		}
+		rows := make([]*account.ImportRow, len(records))
+		for i, rec := range records {
+			rows[i] = rec.Row
+		}
+
+		result, err := importer.Import(ctx, rows)
+		switch {
+		case ctx.Err() != nil:
+			return ctx.Err()
+		case err != nil:
+			// the failed batch is rejected as a whole, the next batches are still imported.
+			for _, rec := range records {
+				reject(rec, err)
+			}
+		default:
+			created += result.Created
+			updated += result.Updated
+			for _, rj := range result.Rejected {
+				reject(records[rj.Row], rj.Err)
+			}
+		}
+		records = records[:0]
+		ids = make(map[int64]bool, *batch)
+		return nil
+	}
+
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read file: %w", err)
+		}
+		if rec.Err != nil {
+			reject(rec, rec.Err)
+			continue
+		}
+
+		if ids[rec.Row.ID] || len(records) == *batch {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		records = append(records, rec)
+		ids[rec.Row.ID] = true
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	fmt.Printf("created %d, updated %d, rejected %d\n", created, updated, rejected)
+	if rejected > 0 {
+		return fmt.Errorf("%w: %d rows", errRowsRejected, rejected)
+	}
+	return nil
+}
@@ -13,36 +13,189 @@ import (
 	"github.com/kelseyhightower/envconfig"
 	"golang.org/x/sync/errgroup"
 
+	"github.com/shkov/wallet-service/internal/account"
+	"github.com/shkov/wallet-service/internal/health"
+	"github.com/shkov/wallet-service/internal/loglevel"
+	"github.com/shkov/wallet-service/internal/payout"
+	"github.com/shkov/wallet-service/internal/ratelimit"
+	"github.com/shkov/wallet-service/internal/reconcile"
+	"github.com/shkov/wallet-service/internal/risk"
+	"github.com/shkov/wallet-service/internal/snapshot"
 	"github.com/shkov/wallet-service/internal/storage"
+	"github.com/shkov/wallet-service/internal/tracing"
 	"github.com/shkov/wallet-service/internal/walletservice"
 )
 
-const metricPrefix = "wallet_service"
+const (
+	serviceName  = "walletservice"
+	metricPrefix = "wallet_service"
+)
 
 type configuration struct {
 	Port            string        `envconfig:"PORT" required:"true"`
+	AdminPort       string        `envconfig:"ADMIN_PORT" default:"8081"`
+	AdminHost       string        `envconfig:"ADMIN_HOST" default:"127.0.0.1"`
+	LogLevel        string        `envconfig:"LOG_LEVEL" default:"info"`
 	ReadTimeout     time.Duration `envconfig:"READ_TIMEOUT" default:"1s"`
 	WriteTimeout    time.Duration `envconfig:"WRITE_TIMEOUT" default:"1s"`
 	ShutdownTimeout time.Duration `envconfig:"SHUTDOWN_TIMEOUT" default:"1s"`
+	DrainDelay      time.Duration `envconfig:"DRAIN_DELAY" default:"0s"`
+	JWTSecret       string        `envconfig:"JWT_SECRET" secret:"true"`
+
+	RateLimit       ratelimit.Rule            `envconfig:"RATE_LIMIT" default:"600/1m"`
+	RateLimitRoutes map[string]ratelimit.Rule `envconfig:"RATE_LIMIT_ROUTES"`
+	// RateLimitUnauthenticated limits the requests failing to authenticate by client IP.
+	RateLimitUnauthenticated ratelimit.Rule `envconfig:"RATE_LIMIT_UNAUTHENTICATED" default:"60/1m"`
+
+	// ReconcileInterval enables the periodic reconciliation of balances.
+	ReconcileInterval time.Duration `envconfig:"RECONCILE_INTERVAL" default:"0s"`
+	// SnapshotInterval enables the daily balance snapshots speeding up the historical balances.
+	SnapshotInterval time.Duration `envconfig:"SNAPSHOT_INTERVAL" default:"1h"`
+	// SnapshotLag delays the snapshot for a midnight, it must be longer than the longest payment transaction.
+	SnapshotLag time.Duration `envconfig:"SNAPSHOT_LAG" default:"10m"`
+	// BalanceMetricsTTL limits the queries of the total balances summing all the accounts to one per the period.
+	BalanceMetricsTTL time.Duration `envconfig:"BALANCE_METRICS_TTL" default:"1m"`
 
+	PostgresConfiguration
+	RiskConfiguration
+	PayoutConfiguration
+	InterestConfiguration
+	BalancePolicyConfiguration
+
+	TracingExporter     string  `envconfig:"TRACING_EXPORTER" default:"none"`
+	TracingSampleRatio  float64 `envconfig:"TRACING_SAMPLE_RATIO" default:"1"`
+	TracingOTLPEndpoint string  `envconfig:"TRACING_OTLP_ENDPOINT" default:"localhost:4318"`
+	TracingOTLPInsecure bool    `envconfig:"TRACING_OTLP_INSECURE" default:"true"`
+}
+
+// PostgresConfiguration is shared by the server and the commands,
+// it is exported for the fields to be included in the admin config dump.
+type PostgresConfiguration struct {
 	PostgresHost        string        `envconfig:"POSTGRES_HOST" required:"true"`
 	PostgresPort        string        `envconfig:"POSTGRES_PORT" required:"true"`
 	PostgresDatabase    string        `envconfig:"POSTGRES_DATABASE" required:"true"`
 	PostgresUser        string        `envconfig:"POSTGRES_USER" required:"true"`
-	PostgresPassword    string        `envconfig:"POSTGRES_PASSWORD" required:"true"`
+	PostgresPassword    string        `envconfig:"POSTGRES_PASSWORD" required:"true" secret:"true"`
 	PostgresDialTimeout time.Duration `envconfig:"POSTGRES_DIAL_TIMEOUT" default:"1s"`
 }
 
+// RiskConfiguration configures the rules screening the payments, the rules are disabled by default.
+type RiskConfiguration struct {
+	RiskReviewAmount        string        `envconfig:"RISK_REVIEW_AMOUNT"`
+	RiskDenyAmount          string        `envconfig:"RISK_DENY_AMOUNT"`
+	RiskNewAccountAge       time.Duration `envconfig:"RISK_NEW_ACCOUNT_AGE" default:"0s"`
+	RiskNewAccountMaxAmount string        `envconfig:"RISK_NEW_ACCOUNT_MAX_AMOUNT"`
+	RiskVelocityWindow      time.Duration `envconfig:"RISK_VELOCITY_WINDOW" default:"0s"`
+	RiskVelocityMaxCount    int64         `envconfig:"RISK_VELOCITY_MAX_COUNT"`
+	RiskVelocityMaxAmount   string        `envconfig:"RISK_VELOCITY_MAX_AMOUNT"`
+	RiskBlockedAccounts     []int64       `envconfig:"RISK_BLOCKED_ACCOUNTS"`
+}
+
+func (cfg RiskConfiguration) riskConfig() risk.Config {
+	return risk.Config{
+		ReviewAmount:        cfg.RiskReviewAmount,
+		DenyAmount:          cfg.RiskDenyAmount,
+		NewAccountAge:       cfg.RiskNewAccountAge,
+		NewAccountMaxAmount: cfg.RiskNewAccountMaxAmount,
+		VelocityWindow:      cfg.RiskVelocityWindow,
+		VelocityMaxCount:    cfg.RiskVelocityMaxCount,
+		VelocityMaxAmount:   cfg.RiskVelocityMaxAmount,
+		BlockedAccounts:     cfg.RiskBlockedAccounts,
+	}
+}
+
+// PayoutConfiguration configures the payouts of the withdrawals, they are disabled by default.
+type PayoutConfiguration struct {
+	// PayoutProvider is the provider paying out the withdrawals: none or fake.
+	PayoutProvider  string        `envconfig:"PAYOUT_PROVIDER" default:"none"`
+	PayoutInterval  time.Duration `envconfig:"PAYOUT_INTERVAL" default:"10s"`
+	PayoutBatchSize int           `envconfig:"PAYOUT_BATCH_SIZE" default:"100"`
+	// PayoutFakeEnabled allows the fake provider settling the payouts without paying them out,
+	// it is only for the tests and the local runs.
+	PayoutFakeEnabled bool `envconfig:"PAYOUT_FAKE_ENABLED" default:"false"`
+	// PayoutFakeFailAmount is the amount of the payouts failed by the fake provider.
+	PayoutFakeFailAmount string `envconfig:"PAYOUT_FAKE_FAIL_AMOUNT"`
+}
+
+// payoutProvider returns the configured payout provider, nil if the payouts are disabled.
+func (cfg PayoutConfiguration) payoutProvider() (walletservice.PayoutProvider, error) {
+	switch cfg.PayoutProvider {
+	case "none":
+		return nil, nil
+	case "fake":
+		if !cfg.PayoutFakeEnabled {
+			return nil, fmt.Errorf("fake payout provider settles payouts without paying them out, it requires PAYOUT_FAKE_ENABLED")
+		}
+		return payout.NewFakeProvider(cfg.PayoutFakeFailAmount)
+	default:
+		return nil, fmt.Errorf("unknown payout provider %q", cfg.PayoutProvider)
+	}
+}
+
+// InterestConfiguration configures the interest accrued by the account types, no type accrues interest by default.
+type InterestConfiguration struct {
+	// InterestRates are the annual rates of the account types, e.g. savings:0.05.
+	InterestRates     map[string]string `envconfig:"INTEREST_RATES"`
+	InterestDayCount  string            `envconfig:"INTEREST_DAY_COUNT" default:"actual/365"`
+	InterestInterval  time.Duration     `envconfig:"INTEREST_INTERVAL" default:"1h"`
+	InterestBatchSize int               `envconfig:"INTEREST_BATCH_SIZE" default:"500"`
+}
+
+func (cfg InterestConfiguration) interestAccruerConfig(logger log.Logger, s storage.TransactionalStorage,
+	policies map[string]*account.BalancePolicy) walletservice.InterestAccruerConfig {
+	return walletservice.InterestAccruerConfig{
+		Logger:          logger,
+		Storage:         s,
+		Rates:           cfg.InterestRates,
+		DayCount:        cfg.InterestDayCount,
+		Interval:        cfg.InterestInterval,
+		BatchSize:       cfg.InterestBatchSize,
+		BalancePolicies: policies,
+	}
+}
+
+// BalancePolicyConfiguration bounds the balances of the user account types, e.g. emoney:5000.
+type BalancePolicyConfiguration struct {
+	BalanceMin map[string]string `envconfig:"BALANCE_MIN"`
+	BalanceMax map[string]string `envconfig:"BALANCE_MAX"`
+}
+
+func (cfg BalancePolicyConfiguration) balancePolicies() (map[string]*account.BalancePolicy, error) {
+	return account.NewBalancePolicies(cfg.BalanceMin, cfg.BalanceMax)
+}
+
+func (cfg PostgresConfiguration) storageConfig(readTimeout, writeTimeout time.Duration) storage.Config {
+	return storage.Config{
+		Host:         cfg.PostgresHost,
+		Port:         cfg.PostgresPort,
+		Database:     cfg.PostgresDatabase,
+		User:         cfg.PostgresUser,
+		Password:     cfg.PostgresPassword,
+		DialTimeout:  cfg.PostgresDialTimeout,
+		ReadTimeout:  readTimeout,
+		WriteTimeout: writeTimeout,
+	}
+}
+
 func main() {
-	logger := log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr))
+	leveled := loglevel.NewLogger(log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr)))
+	var logger log.Logger = leveled
 	logger = log.With(logger, "caller", log.DefaultCaller)
 	logger = log.With(logger, "ts", log.DefaultTimestampUTC)
 
 	ctx, cancel := signalContext(logger)
 	defer cancel()
 
+	if len(os.Args) > 1 {
+		if err := runCommand(ctx, logger, os.Args[1:]); err != nil {
+			level.Error(logger).Log("msg", "command is failed", "err", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	level.Info(logger).Log("msg", "service is starting")
-	if err := run(ctx, logger); err != nil {
+	if err := run(ctx, logger, leveled); err != nil {
 		level.Error(logger).Log("msg", "service is stopped with an error", "err", err)
 		os.Exit(1)
 	}
@@ -50,28 +203,61 @@ func main() {
 	level.Info(logger).Log("msg", "service is stopped")
 }
 
-func run(ctx context.Context, logger log.Logger) error {
+func run(ctx context.Context, logger log.Logger, leveled *loglevel.Logger) error {
 	var cfg configuration
 	if err := envconfig.Process("", &cfg); err != nil {
 		return fmt.Errorf("failed to load configuration: %w", err)
 	}
 
-	walletStorage := storage.NewInstrumentingMiddleware(
-		storage.NewTransactional(storage.Config{
-			Host:         cfg.PostgresHost,
-			Port:         cfg.PostgresPort,
-			Database:     cfg.PostgresDatabase,
-			User:         cfg.PostgresUser,
-			Password:     cfg.PostgresPassword,
-			DialTimeout:  cfg.PostgresDialTimeout,
-			ReadTimeout:  cfg.ReadTimeout,
-			WriteTimeout: cfg.WriteTimeout,
-		}),
-		metricPrefix,
-	)
+	if err := leveled.SetLevel(cfg.LogLevel); err != nil {
+		return fmt.Errorf("failed to set log level: %w", err)
+	}
+
+	shutdownTracing, err := tracing.Setup(ctx, tracing.Config{
+		ServiceName:  serviceName,
+		Exporter:     cfg.TracingExporter,
+		OTLPEndpoint: cfg.TracingOTLPEndpoint,
+		OTLPInsecure: cfg.TracingOTLPInsecure,
+		SampleRatio:  cfg.TracingSampleRatio,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to setup tracing: %w", err)
+	}
+
+	defer func() {
+		ctxShutdown, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+		defer cancel()
+		if err := shutdownTracing(ctxShutdown); err != nil {
+			level.Error(logger).Log("msg", "failed to shutdown tracing", "err", err)
+		}
+	}()
+
+	var walletStorage storage.TransactionalStorage
+	walletStorage = storage.NewTransactional(cfg.storageConfig(cfg.ReadTimeout, cfg.WriteTimeout))
+	walletStorage = storage.NewTracingMiddleware(walletStorage)
+	walletStorage = storage.NewLoggingMiddleware(walletStorage, logger)
+	walletStorage = storage.NewInstrumentingMiddleware(walletStorage, metricPrefix)
 
 	defer walletStorage.Close()
 
+	riskEngine, err := risk.NewRuleEngine(cfg.riskConfig())
+	if err != nil {
+		return fmt.Errorf("failed to initialize risk rules: %w", err)
+	}
+
+	balancePolicies, err := cfg.balancePolicies()
+	if err != nil {
+		return fmt.Errorf("failed to initialize balance policies: %w", err)
+	}
+
+	payoutProvider, err := cfg.payoutProvider()
+	if err != nil {
+		return fmt.Errorf("failed to initialize payout provider: %w", err)
+	}
+
+	checks := health.NewRegistry(cfg.ReadTimeout)
+	checks.Register("postgres", health.CheckerFunc(walletStorage.Ping))
+
 	srv, err := walletservice.NewServer(walletservice.ServerConfig{
 		Logger:          logger,
 		Storage:         walletStorage,
@@ -79,12 +265,37 @@ func run(ctx context.Context, logger log.Logger) error {
 		ReadTimeout:     cfg.ReadTimeout,
 		WriteTimeout:    cfg.WriteTimeout,
 		ShutdownTimeout: cfg.ShutdownTimeout,
+		DrainDelay:      cfg.DrainDelay,
 		MetricPrefix:    metricPrefix,
+		Health:          checks,
+		JWTSecret:       cfg.JWTSecret,
+		RateLimit: walletservice.RateLimitConfig{
+			Default:         cfg.RateLimit,
+			Routes:          cfg.RateLimitRoutes,
+			Unauthenticated: cfg.RateLimitUnauthenticated,
+		},
+		Risk:              riskEngine,
+		BalancePolicies:   balancePolicies,
+		Payouts:           payoutProvider != nil,
+		BalanceMetricsTTL: cfg.BalanceMetricsTTL,
 	})
 	if err != nil {
 		return fmt.Errorf("failed to initialize server: %w", err)
 	}
 
+	adminSrv, err := walletservice.NewAdminServer(walletservice.AdminServerConfig{
+		Host:            cfg.AdminHost,
+		Port:            cfg.AdminPort,
+		ShutdownTimeout: cfg.ShutdownTimeout,
+		DrainDelay:      cfg.DrainDelay,
+		Health:          checks,
+		LogLevel:        leveled.Handler(),
+		Config:          cfg,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to initialize admin server: %w", err)
+	}
+
 	g, ctx := errgroup.WithContext(ctx)
 
 	g.Go(func() error {
@@ -95,6 +306,59 @@ func run(ctx context.Context, logger log.Logger) error {
 		return nil
 	})
 
+	g.Go(func() error {
+		level.Info(logger).Log("msg", "starting admin http server", "host", cfg.AdminHost, "port", cfg.AdminPort)
+		if err := adminSrv.Serve(ctx); err != nil {
+			return fmt.Errorf("failed to serve admin http: %w", err)
+		}
+		return nil
+	})
+
+	if cfg.ReconcileInterval > 0 {
+		job := reconcile.NewJob(walletStorage, cfg.ReconcileInterval, logger, metricPrefix)
+		g.Go(func() error {
+			level.Info(logger).Log("msg", "starting reconciliation job", "interval", cfg.ReconcileInterval)
+			return job.Run(ctx)
+		})
+	}
+
+	if cfg.SnapshotInterval > 0 {
+		job := snapshot.NewJob(walletStorage, cfg.SnapshotInterval, cfg.SnapshotLag, logger)
+		g.Go(func() error {
+			level.Info(logger).Log("msg", "starting balance snapshot job", "interval", cfg.SnapshotInterval, "lag", cfg.SnapshotLag)
+			return job.Run(ctx)
+		})
+	}
+
+	if len(cfg.InterestRates) > 0 && cfg.InterestInterval > 0 {
+		accruer, err := walletservice.NewInterestAccruer(cfg.interestAccruerConfig(logger, walletStorage, balancePolicies))
+		if err != nil {
+			return fmt.Errorf("failed to initialize interest accruer: %w", err)
+		}
+		g.Go(func() error {
+			level.Info(logger).Log("msg", "starting interest accruer", "interval", cfg.InterestInterval)
+			return accruer.Run(ctx)
+		})
+	}
+
+	if payoutProvider != nil {
+		worker, err := walletservice.NewPayoutWorker(walletservice.PayoutWorkerConfig{
+			Logger:       logger,
+			Storage:      walletStorage,
+			Provider:     payoutProvider,
+			Interval:     cfg.PayoutInterval,
+			BatchSize:    cfg.PayoutBatchSize,
+			MetricPrefix: metricPrefix,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to initialize payout worker: %w", err)
+		}
+		g.Go(func() error {
+			level.Info(logger).Log("msg", "starting payout worker", "provider", cfg.PayoutProvider, "interval", cfg.PayoutInterval)
+			return worker.Run(ctx)
+		})
+	}
+
 	return g.Wait()
 }
 
@@ -11,17 +11,56 @@ type Account struct {
 	ID        int64     `pq:"id"`
 	Balance   string    `pq:"balance"`
 	CreatedAt time.Time `pq:"created_at"`
+
+	// InitialBalance is the balance the account is created with, the current balance
+	// must be equal to it plus the payments of the account.
+	InitialBalance string `pg:"initial_balance"`
+	Status         string `pg:"status"`
+
+	// OwnerRef is the reference of the customer owning the account in the external systems.
+	OwnerRef    string `pg:"owner_ref"`
+	DisplayName string `pg:"display_name"`
+	// Metadata are the labels of the account used to find it.
+	Metadata map[string]string `pg:"metadata"`
+	// Version is incremented by every update of the account details.
+	Version int64 `pg:"version"`
+	// Type is TypeUser for the user accounts and the type of the system account otherwise.
+	Type string `pg:"type"`
+	// Policy bounds the balance of the account, it is the policy of its type. Nil doesn't bound it.
+	Policy *BalancePolicy `pg:"-" json:"-"`
 }
 
+// Account statuses, only active accounts can send and receive payments.
+const (
+	StatusActive    = "active"
+	StatusSuspended = "suspended"
+	StatusClosed    = "closed"
+)
+
+// defaultBalance is the balance of a new account.
+const defaultBalance = "1000"
+
 func Create(id int64, createdAt time.Time) *Account {
+	balance, accountType := defaultBalance, TypeUser
+	if IsSystemAccount(id) {
+		balance, accountType = "0", systemAccountTypes[id]
+	}
 	return &Account{
-		ID:        id,
-		Balance:   "1000",
-		CreatedAt: createdAt,
+		ID:             id,
+		Balance:        balance,
+		CreatedAt:      createdAt,
+		InitialBalance: balance,
+		Status:         StatusActive,
+		Version:        1,
+		Type:           accountType,
 	}
 }
 
 func (a *Account) ApplyPayment(p *Payment) error {
+	if a.Status == StatusSuspended || a.Status == StatusClosed {
+		return ErrAccountNotActive
+	}
+
 	balance, err := decimal.NewFromString(a.Balance)
 	if err != nil {
 		return err
@@ -33,21 +72,65 @@ func (a *Account) ApplyPayment(p *Payment) error {
 
 	switch a.ID {
 	case p.From:
-		if balance.LessThan(amount) {
+		if balance.LessThan(amount) && !IsSystemAccount(a.ID) {
 			return ErrNotEnoughFunds
 		}
-		a.Balance = balance.Sub(amount).StringFixed(2)
-
-	case p.To:
-		a.Balance = balance.Add(amount).StringFixed(2)
+		balance = balance.Sub(amount)
+		if err = a.Policy.checkDebit(balance); err != nil {
+			return err
+		}
+		a.Balance = balance.StringFixed(2)
 
 	default:
+		credit, ok := p.Credit(a.ID)
+		if !ok {
+			return ErrMismatchPayment
+		}
+		amount, err = decimal.NewFromString(credit)
+		if err != nil {
+			return err
+		}
+		balance = balance.Add(amount)
+		if err = a.Policy.checkCredit(balance); err != nil {
+			return err
+		}
+		// the receivers of a pending payment are credited when it is confirmed, the cap is checked again then.
+		if p.Status == PaymentStatusPending {
+			return nil
+		}
+		a.Balance = balance.StringFixed(2)
+	}
+
+	return nil
+}
+
+// RefundPayment returns the amount of the cancelled or failed payment to its sender.
+func (a *Account) RefundPayment(p *Payment) error {
+	if a.ID != p.From {
 		return ErrMismatchPayment
 	}
 
+	balance, err := decimal.NewFromString(a.Balance)
+	if err != nil {
+		return err
+	}
+	amount, err := decimal.NewFromString(p.Amount)
+	if err != nil {
+		return err
+	}
+	a.Balance = balance.Add(amount).StringFixed(2)
 	return nil
 }
 
+// ValidateStatus checks that the status is known.
+func ValidateStatus(status string) error {
+	switch status {
+	case StatusActive, StatusSuspended, StatusClosed:
+		return nil
+	}
+	return ErrUnknownStatus
+}
+
 func ValidateAccountID(id int64) error {
 	if id <= 0 {
 		return ErrMustBePositive
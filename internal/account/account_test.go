@@ -152,6 +152,58 @@ func TestAccount_ApplyPayment(t *testing.T) {
 			},
 			wantErr: nil,
 		},
+		{
+			name: "to: pending payment",
+			account: &Account{
+				ID:      1,
+				Balance: "1000",
+			},
+			payment: &Payment{
+				From:   2,
+				To:     1,
+				Amount: "501",
+				Status: PaymentStatusPending,
+			},
+			wantAccount: &Account{
+				ID:      1,
+				Balance: "1000",
+			},
+			wantErr: nil,
+		},
+		{
+			name: "to: split payment leg",
+			account: &Account{
+				ID:      3,
+				Balance: "10",
+			},
+			payment: &Payment{
+				From:   1,
+				Amount: "500",
+				Legs:   []*PaymentLeg{{To: 2, Amount: "200"}, {To: 3, Amount: "300"}},
+			},
+			wantAccount: &Account{
+				ID:      3,
+				Balance: "310.00",
+			},
+			wantErr: nil,
+		},
+		{
+			name: "not a party of the split payment",
+			account: &Account{
+				ID:      4,
+				Balance: "10",
+			},
+			payment: &Payment{
+				From:   1,
+				Amount: "500",
+				Legs:   []*PaymentLeg{{To: 2, Amount: "200"}, {To: 3, Amount: "300"}},
+			},
+			wantAccount: &Account{
+				ID:      4,
+				Balance: "10",
+			},
+			wantErr: ErrMismatchPayment,
+		},
 		{
 			name: "invalid format of balance",
 			account: &Account{
@@ -186,6 +238,138 @@ func TestAccount_ApplyPayment(t *testing.T) {
 			},
 			wantErr: errors.New("can't convert 1,1 to decimal"),
 		},
+		{
+			name: "suspended account",
+			account: &Account{
+				ID:      1,
+				Balance: "1000",
+				Status:  StatusSuspended,
+			},
+			payment: &Payment{
+				From:   2,
+				To:     1,
+				Amount: "1",
+			},
+			wantAccount: &Account{
+				ID:      1,
+				Balance: "1000",
+				Status:  StatusSuspended,
+			},
+			wantErr: ErrAccountNotActive,
+		},
+		{
+			name: "from: system account goes negative",
+			account: &Account{
+				ID:      SystemAccountAdjustments,
+				Balance: "0",
+			},
+			payment: &Payment{
+				From:   SystemAccountAdjustments,
+				To:     1,
+				Amount: "25.5",
+			},
+			wantAccount: &Account{
+				ID:      SystemAccountAdjustments,
+				Balance: "-25.50",
+			},
+			wantErr: nil,
+		},
+		{
+			name: "from: minimum balance",
+			account: &Account{
+				ID:      1,
+				Balance: "1000",
+				Policy:  &BalancePolicy{Min: "100"},
+			},
+			payment: &Payment{
+				From:   1,
+				To:     2,
+				Amount: "900",
+			},
+			wantAccount: &Account{
+				ID:      1,
+				Balance: "100.00",
+				Policy:  &BalancePolicy{Min: "100"},
+			},
+			wantErr: nil,
+		},
+		{
+			name: "from: below minimum balance",
+			account: &Account{
+				ID:      1,
+				Balance: "1000",
+				Policy:  &BalancePolicy{Min: "100"},
+			},
+			payment: &Payment{
+				From:   1,
+				To:     2,
+				Amount: "900.01",
+			},
+			wantAccount: &Account{
+				ID:      1,
+				Balance: "1000",
+				Policy:  &BalancePolicy{Min: "100"},
+			},
+			wantErr: ErrBelowMinBalance,
+		},
+		{
+			name: "to: balance cap",
+			account: &Account{
+				ID:      2,
+				Balance: "4000",
+				Policy:  &BalancePolicy{Max: "5000"},
+			},
+			payment: &Payment{
+				From:   1,
+				To:     2,
+				Amount: "1000",
+			},
+			wantAccount: &Account{
+				ID:      2,
+				Balance: "5000.00",
+				Policy:  &BalancePolicy{Max: "5000"},
+			},
+			wantErr: nil,
+		},
+		{
+			name: "to: balance cap exceeded",
+			account: &Account{
+				ID:      2,
+				Balance: "4000",
+				Policy:  &BalancePolicy{Max: "5000"},
+			},
+			payment: &Payment{
+				From:   1,
+				To:     2,
+				Amount: "1000.01",
+			},
+			wantAccount: &Account{
+				ID:      2,
+				Balance: "4000",
+				Policy:  &BalancePolicy{Max: "5000"},
+			},
+			wantErr: ErrBalanceCapExceeded,
+		},
+		{
+			name: "to: balance cap exceeded by pending payment",
+			account: &Account{
+				ID:      2,
+				Balance: "4000",
+				Policy:  &BalancePolicy{Max: "5000"},
+			},
+			payment: &Payment{
+				From:   1,
+				To:     2,
+				Amount: "1000.01",
+				Status: PaymentStatusPending,
+			},
+			wantAccount: &Account{
+				ID:      2,
+				Balance: "4000",
+				Policy:  &BalancePolicy{Max: "5000"},
+			},
+			wantErr: ErrBalanceCapExceeded,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -201,3 +385,15 @@ func TestAccount_ApplyPayment(t *testing.T) {
 		})
 	}
 }
+
+func TestAccount_RefundPayment(t *testing.T) {
+	p := &Payment{From: 1, To: 2, Amount: "100.5", Status: PaymentStatusCancelled}
+
+	a := &Account{ID: 1, Balance: "10"}
+	assert.NoError(t, a.RefundPayment(p))
+	assert.Equal(t, "110.50", a.Balance)
+
+	receiver := &Account{ID: 2, Balance: "10"}
+	assert.Equal(t, ErrMismatchPayment, receiver.RefundPayment(p))
+	assert.Equal(t, "10", receiver.Balance)
+}
@@ -0,0 +1,138 @@
+package account
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// MaxAdjustmentReasonLength is the limit of the adjustment reason.
+const MaxAdjustmentReasonLength = 256
+
+// PaymentTypeAdjustment applies an approved balance adjustment, it is made from or to SystemAccountAdjustments.
+const PaymentTypeAdjustment = "adjustment"
+
+// Adjustment directions.
+const (
+	AdjustmentCredit = "credit"
+	AdjustmentDebit  = "debit"
+)
+
+// Adjustment statuses. A proposed adjustment is applied when another admin approves it.
+const (
+	AdjustmentStatusProposed = "proposed"
+	AdjustmentStatusApplied  = "applied"
+	AdjustmentStatusRejected = "rejected"
+)
+
+// Adjustment is a correction of the account balance proposed by one admin and reviewed by another one.
+type Adjustment struct {
+	tableName  struct{}  `pg:"adjustments"`
+	ID         int64     `pg:"id"`
+	AccountID  int64     `pg:"account_id"`
+	Direction  string    `pg:"direction"`
+	Amount     string    `pg:"amount"`
+	Reason     string    `pg:"reason"`
+	Status     string    `pg:"status"`
+	ProposedBy string    `pg:"proposed_by"`
+	ProposedAt time.Time `pg:"proposed_at"`
+
+	ReviewedBy string     `pg:"reviewed_by" json:",omitempty"`
+	ReviewedAt *time.Time `pg:"reviewed_at" json:",omitempty"`
+	// PaymentID is the payment applying the approved adjustment.
+	PaymentID int64 `pg:"payment_id" json:",omitempty"`
+}
+
+// AdjustmentRequest proposes an adjustment of the account balance.
+type AdjustmentRequest struct {
+	AccountID int64 `json:"-"`
+	Direction string
+	Amount    string
+	Reason    string
+}
+
+func (r *AdjustmentRequest) ToAdjustment(proposedBy string, proposedAt time.Time) *Adjustment {
+	return &Adjustment{
+		AccountID:  r.AccountID,
+		Direction:  r.Direction,
+		Amount:     r.Amount,
+		Reason:     r.Reason,
+		Status:     AdjustmentStatusProposed,
+		ProposedBy: proposedBy,
+		ProposedAt: proposedAt,
+	}
+}
+
+// Approve approves the proposed adjustment, it must be reviewed by another admin than the one proposed it.
+func (a *Adjustment) Approve(by string, at time.Time) error {
+	if by == a.ProposedBy {
+		return ErrAdjustmentSelfReview
+	}
+	return a.review(AdjustmentStatusApplied, by, at)
+}
+
+// Reject rejects the proposed adjustment, the admin proposed it may withdraw it.
+func (a *Adjustment) Reject(by string, at time.Time) error {
+	return a.review(AdjustmentStatusRejected, by, at)
+}
+
+func (a *Adjustment) review(status, by string, at time.Time) error {
+	if a.Status != AdjustmentStatusProposed {
+		return ErrAdjustmentNotProposed
+	}
+	a.Status = status
+	a.ReviewedBy = by
+	a.ReviewedAt = &at
+	return nil
+}
+
+// ToPayment returns the payment applying the adjustment: a credit is paid from SystemAccountAdjustments
+// to the account, a debit is paid from the account to it.
+func (a *Adjustment) ToPayment(createdAt time.Time) *Payment {
+	from, to := SystemAccountAdjustments, a.AccountID
+	if a.Direction == AdjustmentDebit {
+		from, to = a.AccountID, SystemAccountAdjustments
+	}
+	return &Payment{
+		From:        from,
+		To:          to,
+		Amount:      a.Amount,
+		CreatedAt:   createdAt,
+		Description: a.Reason,
+		ExternalRef: adjustmentRefPrefix + strconv.FormatInt(a.ID, 10),
+		Type:        PaymentTypeAdjustment,
+		Status:      PaymentStatusCompleted,
+		SettledAt:   &createdAt,
+	}
+}
+
+func ValidateAdjustmentRequest(r *AdjustmentRequest) error {
+	if err := ValidateAccountID(r.AccountID); err != nil {
+		return err
+	}
+	switch r.Direction {
+	case AdjustmentCredit, AdjustmentDebit:
+	default:
+		return ErrUnknownAdjustmentDirection
+	}
+	amount, err := decimal.NewFromString(r.Amount)
+	if err != nil {
+		return err
+	}
+	if !amount.IsPositive() {
+		return ErrNotPositiveAmount
+	}
+	if strings.TrimSpace(r.Reason) == "" || len(r.Reason) > MaxAdjustmentReasonLength || !printable(r.Reason) {
+		return ErrInvalidAdjustmentReason
+	}
+	return nil
+}
+
+func ValidateAdjustmentID(id int64) error {
+	if id <= 0 {
+		return ErrAdjustmentIDMustBePositive
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package account
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateAdjustmentRequest(t *testing.T) {
+	testCases := []struct {
+		name    string
+		request *AdjustmentRequest
+		wantErr error
+	}{
+		{
+			name:    "normal response",
+			request: makeAdjustmentRequest(t, nil),
+			wantErr: nil,
+		},
+		{
+			name:    "invalid account id",
+			request: makeAdjustmentRequest(t, func(r *AdjustmentRequest) { r.AccountID = SystemAccountAdjustments }),
+			wantErr: ErrMustBePositive,
+		},
+		{
+			name:    "unknown direction",
+			request: makeAdjustmentRequest(t, func(r *AdjustmentRequest) { r.Direction = "refund" }),
+			wantErr: ErrUnknownAdjustmentDirection,
+		},
+		{
+			name:    "invalid amount",
+			request: makeAdjustmentRequest(t, func(r *AdjustmentRequest) { r.Amount = "1,5" }),
+			wantErr: errors.New("can't convert 1,5 to decimal"),
+		},
+		{
+			name:    "amount must be positive",
+			request: makeAdjustmentRequest(t, func(r *AdjustmentRequest) { r.Amount = "0" }),
+			wantErr: ErrNotPositiveAmount,
+		},
+		{
+			name:    "empty reason",
+			request: makeAdjustmentRequest(t, func(r *AdjustmentRequest) { r.Reason = " " }),
+			wantErr: ErrInvalidAdjustmentReason,
+		},
+		{
+			name:    "reason is too long",
+			request: makeAdjustmentRequest(t, func(r *AdjustmentRequest) { r.Reason = strings.Repeat("r", MaxAdjustmentReasonLength+1) }),
+			wantErr: ErrInvalidAdjustmentReason,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.wantErr, ValidateAdjustmentRequest(tc.request))
+		})
+	}
+}
+
+func TestAdjustment_Review(t *testing.T) {
+	proposedAt := parseTime(t, "2001-01-03T10:00:00Z")
+	reviewedAt := parseTime(t, "2001-01-03T11:00:00Z")
+
+	a := makeAdjustmentRequest(t, nil).ToAdjustment("apikey:1", proposedAt)
+	assert.Equal(t, AdjustmentStatusProposed, a.Status)
+
+	assert.Equal(t, ErrAdjustmentSelfReview, a.Approve("apikey:1", reviewedAt))
+	assert.Equal(t, AdjustmentStatusProposed, a.Status)
+
+	assert.NoError(t, a.Approve("apikey:2", reviewedAt))
+	assert.Equal(t, AdjustmentStatusApplied, a.Status)
+	assert.Equal(t, "apikey:2", a.ReviewedBy)
+	assert.Equal(t, &reviewedAt, a.ReviewedAt)
+
+	assert.Equal(t, ErrAdjustmentNotProposed, a.Reject("apikey:2", reviewedAt))
+
+	// the proposer may withdraw the adjustment.
+	a = makeAdjustmentRequest(t, nil).ToAdjustment("apikey:1", proposedAt)
+	assert.NoError(t, a.Reject("apikey:1", reviewedAt))
+	assert.Equal(t, AdjustmentStatusRejected, a.Status)
+}
+
+func TestAdjustment_ToPayment(t *testing.T) {
+	at := parseTime(t, "2001-01-03T10:00:00Z")
+
+	a := makeAdjustmentRequest(t, nil).ToAdjustment("apikey:1", at)
+	a.ID = 5
+	p := a.ToPayment(at)
+	assert.Equal(t, &Payment{
+		From:        SystemAccountAdjustments,
+		To:          1,
+		Amount:      "10.5",
+		CreatedAt:   at,
+		Description: "duplicate fee",
+		ExternalRef: "adjustment:5",
+		Type:        PaymentTypeAdjustment,
+		Status:      PaymentStatusCompleted,
+		SettledAt:   &at,
+	}, p)
+
+	a.Direction = AdjustmentDebit
+	p = a.ToPayment(at)
+	assert.Equal(t, int64(1), p.From)
+	assert.Equal(t, SystemAccountAdjustments, p.To)
+}
+
+func makeAdjustmentRequest(t *testing.T, fn func(*AdjustmentRequest)) *AdjustmentRequest {
+	r := &AdjustmentRequest{
+		AccountID: 1,
+		Direction: AdjustmentCredit,
+		Amount:    "10.5",
+		Reason:    "duplicate fee",
+	}
+	if fn != nil {
+		fn(r)
+	}
+	return r
+}
@@ -0,0 +1,115 @@
+package account
+
+// Limits of the account details.
+const (
+	MaxOwnerRefLength      = 128
+	MaxDisplayNameLength   = 128
+	MaxMetadataKeys        = 32
+	MaxMetadataKeyLength   = 64
+	MaxMetadataValueLength = 256
+)
+
+// UpdateRequest changes the details of the account, the nil fields are left unchanged.
+type UpdateRequest struct {
+	ID int64 `json:"-"`
+	// Version is the version of the account the changes are made to.
+	Version int64 `json:"-"`
+
+	OwnerRef    *string
+	DisplayName *string
+	// Type changes the type of the user account, e.g. to TypeSavings.
+	Type *string
+	// Metadata is merged into the metadata of the account, a null value removes the key.
+	Metadata map[string]*string
+}
+
+// Update applies the request to the account details and increments its version.
+func (a *Account) Update(r *UpdateRequest) error {
+	if a.Version != r.Version {
+		return ErrVersionMismatch
+	}
+
+	if r.OwnerRef != nil {
+		a.OwnerRef = *r.OwnerRef
+	}
+	if r.DisplayName != nil {
+		a.DisplayName = *r.DisplayName
+	}
+	if r.Type != nil {
+		a.Type = *r.Type
+	}
+	if len(r.Metadata) > 0 {
+		metadata := make(map[string]string, len(a.Metadata)+len(r.Metadata))
+		for k, v := range a.Metadata {
+			metadata[k] = v
+		}
+		for k, v := range r.Metadata {
+			if v == nil {
+				delete(metadata, k)
+				continue
+			}
+			metadata[k] = *v
+		}
+		if len(metadata) > MaxMetadataKeys {
+			return ErrInvalidMetadata
+		}
+		a.Metadata = metadata
+	}
+
+	a.Version++
+	return nil
+}
+
+func ValidateUpdateRequest(r *UpdateRequest) error {
+	if err := ValidateAccountID(r.ID); err != nil {
+		return err
+	}
+	if r.OwnerRef != nil && len(*r.OwnerRef) > MaxOwnerRefLength {
+		return ErrOwnerRefTooLong
+	}
+	if r.DisplayName != nil && len(*r.DisplayName) > MaxDisplayNameLength {
+		return ErrDisplayNameTooLong
+	}
+	if r.Type != nil {
+		if err := ValidateUserType(*r.Type); err != nil {
+			return err
+		}
+	}
+	if len(r.Metadata) > MaxMetadataKeys {
+		return ErrInvalidMetadata
+	}
+	for k, v := range r.Metadata {
+		if !validMetadataKey(k) || (v != nil && len(*v) > MaxMetadataValueLength) {
+			return ErrInvalidMetadata
+		}
+	}
+	return nil
+}
+
+// ValidateMetadata checks the number of the metadata keys, their characters and the length of the values.
+func ValidateMetadata(m map[string]string) error {
+	if len(m) > MaxMetadataKeys {
+		return ErrInvalidMetadata
+	}
+	for k, v := range m {
+		if !validMetadataKey(k) || len(v) > MaxMetadataValueLength {
+			return ErrInvalidMetadata
+		}
+	}
+	return nil
+}
+
+// validMetadataKey allows letters, digits, '_', '-' and '.' in the keys.
+func validMetadataKey(k string) bool {
+	if k == "" || len(k) > MaxMetadataKeyLength {
+		return false
+	}
+	for _, c := range k {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '_', c == '-', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
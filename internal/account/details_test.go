@@ -0,0 +1,114 @@
+package account
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccount_Update(t *testing.T) {
+	name := "Savings"
+	gold := "gold"
+	savings := TypeSavings
+
+	testCases := []struct {
+		name        string
+		request     *UpdateRequest
+		wantAccount *Account
+		wantErr     error
+	}{
+		{
+			name: "merge",
+			request: &UpdateRequest{
+				Version:     3,
+				DisplayName: &name,
+				Type:        &savings,
+				Metadata:    map[string]*string{"tier": &gold, "legacy_id": nil},
+			},
+			wantAccount: &Account{
+				ID:          1,
+				OwnerRef:    "customer-1",
+				DisplayName: "Savings",
+				Metadata:    map[string]string{"tier": "gold", "region": "eu"},
+				Version:     4,
+				Type:        TypeSavings,
+			},
+		},
+		{
+			name:    "stale version",
+			request: &UpdateRequest{Version: 2, DisplayName: &name},
+			wantAccount: &Account{
+				ID:       1,
+				OwnerRef: "customer-1",
+				Metadata: map[string]string{"tier": "silver", "region": "eu", "legacy_id": "42"},
+				Version:  3,
+				Type:     TypeUser,
+			},
+			wantErr: ErrVersionMismatch,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			a := &Account{
+				ID:       1,
+				OwnerRef: "customer-1",
+				Metadata: map[string]string{"tier": "silver", "region": "eu", "legacy_id": "42"},
+				Version:  3,
+				Type:     TypeUser,
+			}
+			err := a.Update(tc.request)
+			assert.Equal(t, tc.wantErr, err)
+			assert.Equal(t, tc.wantAccount, a)
+		})
+	}
+}
+
+func TestValidateUpdateRequest(t *testing.T) {
+	long := strings.Repeat("x", MaxOwnerRefLength+1)
+	value := "v"
+	fees := TypeFees
+
+	testCases := []struct {
+		name    string
+		request *UpdateRequest
+		wantErr error
+	}{
+		{
+			name:    "ok",
+			request: &UpdateRequest{ID: 1, Metadata: map[string]*string{"order.id_1-a": &value, "removed": nil}},
+		},
+		{
+			name:    "invalid id",
+			request: &UpdateRequest{},
+			wantErr: ErrMustBePositive,
+		},
+		{
+			name:    "long owner reference",
+			request: &UpdateRequest{ID: 1, OwnerRef: &long},
+			wantErr: ErrOwnerRefTooLong,
+		},
+		{
+			name:    "long display name",
+			request: &UpdateRequest{ID: 1, DisplayName: &long},
+			wantErr: ErrDisplayNameTooLong,
+		},
+		{
+			name:    "system account type",
+			request: &UpdateRequest{ID: 1, Type: &fees},
+			wantErr: ErrNotUserType,
+		},
+		{
+			name:    "invalid metadata key",
+			request: &UpdateRequest{ID: 1, Metadata: map[string]*string{"tier:gold": &value}},
+			wantErr: ErrInvalidMetadata,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.wantErr, ValidateUpdateRequest(tc.request))
+		})
+	}
+}
@@ -6,12 +6,62 @@ import (
 
 // domain-level errors.
 var (
-	ErrNotFound                  = errors.New("account is not found")
-	ErrMismatchPayment           = errors.New("mismatch of payment to account")
-	ErrNotEnoughFunds            = errors.New("not enough funds in account")
-	ErrNotPositiveAmount         = errors.New("payment amount is not positive")
-	ErrAccountFromMustBePositive = errors.New("account from must be positive")
-	ErrAccountToMustBePositive   = errors.New("account to must be positive")
-	ErrMustBePositive            = errors.New("must be positive")
-	ErrFromAndToMustBeDifferent  = errors.New("from and to must be different")
+	ErrNotFound                   = errors.New("account is not found")
+	ErrMismatchPayment            = errors.New("mismatch of payment to account")
+	ErrNotEnoughFunds             = errors.New("not enough funds in account")
+	ErrNotPositiveAmount          = errors.New("payment amount is not positive")
+	ErrAccountFromMustBePositive  = errors.New("account from must be positive")
+	ErrAccountToMustBePositive    = errors.New("account to must be positive")
+	ErrMustBePositive             = errors.New("must be positive")
+	ErrFromAndToMustBeDifferent   = errors.New("from and to must be different")
+	ErrPaymentNotFound            = errors.New("payment is not found")
+	ErrIdempotencyKeyTooLong      = errors.New("idempotency key is too long")
+	ErrIdempotencyKeyReused       = errors.New("idempotency key is reused with another payment")
+	ErrIdempotencyKeyTaken        = errors.New("payment with the idempotency key is inserted concurrently")
+	ErrInvalidStatementPeriod     = errors.New("statement period must end after it starts")
+	ErrBalanceTimeInFuture        = errors.New("balance time is in the future")
+	ErrAccountNotActive           = errors.New("account is not active")
+	ErrUnknownStatus              = errors.New("unknown account status")
+	ErrInvalidBalanceRange        = errors.New("balance range is invalid")
+	ErrInvalidCreatedRange        = errors.New("creation period must end after it starts")
+	ErrUnknownSort                = errors.New("unknown sort order")
+	ErrInvalidLimit               = errors.New("limit is out of range")
+	ErrInvalidCursor              = errors.New("cursor is invalid")
+	ErrOwnerRefTooLong            = errors.New("owner reference is too long")
+	ErrDisplayNameTooLong         = errors.New("display name is too long")
+	ErrInvalidMetadata            = errors.New("metadata is invalid")
+	ErrVersionMismatch            = errors.New("account is changed by another request")
+	ErrInvalidDescription         = errors.New("description is too long or has control characters")
+	ErrInvalidExternalRef         = errors.New("external reference is too long or has invalid characters")
+	ErrReservedExternalRef        = errors.New("external reference has a prefix reserved for the service payments")
+	ErrExternalRefReused          = errors.New("external reference is used by another payment of the sender")
+	ErrSplitPaymentWithTo         = errors.New("split payment must not have to")
+	ErrTooManyLegs                = errors.New("split payment has too many legs")
+	ErrDuplicateLegReceiver       = errors.New("split payment has several legs to the same account")
+	ErrLegsSumMismatch            = errors.New("legs amounts must sum to the payment amount")
+	ErrPaymentNotPending          = errors.New("payment is not pending")
+	ErrPaymentIDMustBePositive    = errors.New("payment id must be positive")
+	ErrPaymentDenied              = errors.New("payment is denied by the risk screening")
+	ErrAdjustmentNotFound         = errors.New("adjustment is not found")
+	ErrAdjustmentNotProposed      = errors.New("adjustment is already reviewed")
+	ErrAdjustmentSelfReview       = errors.New("adjustment must be approved by another admin")
+	ErrUnknownAdjustmentDirection = errors.New("unknown adjustment direction")
+	ErrInvalidAdjustmentReason    = errors.New("adjustment reason is empty, too long or has control characters")
+	ErrAdjustmentIDMustBePositive = errors.New("adjustment id must be positive")
+	ErrUnknownType                = errors.New("unknown account type")
+	ErrInvalidFee                 = errors.New("fee must not be negative")
+	ErrPayoutNotFound             = errors.New("payout is not found")
+	ErrUnexpectedPayoutStatus     = errors.New("payout has unexpected status")
+	ErrPayoutInProgress           = errors.New("withdrawal is settled by its payout")
+	ErrNotUserType                = errors.New("account type is not a user account type")
+	ErrUnknownDayCount            = errors.New("unknown day count convention")
+	ErrInvalidInterestRate        = errors.New("interest rate must not be negative")
+	ErrInterestDayNotOver         = errors.New("interest is accrued only for the past days")
+	ErrBalanceCapExceeded         = errors.New("payment would exceed the balance cap of the account")
+	ErrBelowMinBalance            = errors.New("payment would leave less than the minimum balance of the account")
+	ErrWithdrawalsDisabled        = errors.New("withdrawals are not paid out")
+	ErrInvalidBalancePolicy       = errors.New("balance policy bounds are invalid")
+	ErrInvalidOpeningBalance      = errors.New("opening balance must not be negative")
+	ErrDuplicateImportRow         = errors.New("account is imported twice in the batch")
+	ErrAccountClosed              = errors.New("closed account can't be reopened")
 )
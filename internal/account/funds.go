@@ -0,0 +1,108 @@
+package account
+
+import (
+	"github.com/shopspring/decimal"
+)
+
+// Payment types moving the money between the user accounts and the outside.
+const (
+	// PaymentTypeDeposit is paid from SystemAccountFunding to the account.
+	PaymentTypeDeposit = "deposit"
+	// PaymentTypeWithdrawal is paid from the account to SystemAccountPayouts, and its fee to SystemAccountFees.
+	PaymentTypeWithdrawal = "withdrawal"
+)
+
+// FundsRequest deposits the money to the account from the outside or withdraws it from the account.
+type FundsRequest struct {
+	AccountID int64
+	Amount    string
+	// Fee is charged to the account on top of the withdrawn amount, it is not allowed for the deposits.
+	Fee string `json:",omitempty"`
+
+	// IdempotencyKey and ExternalRef are the ones of the payment, the deposits share the sender
+	// so their keys and references are unique among all the deposits.
+	IdempotencyKey string
+	ExternalRef    string
+	Description    string
+	Metadata       map[string]string
+}
+
+// ToDepositRequest returns the request of the payment depositing the amount.
+func (r *FundsRequest) ToDepositRequest() *PaymentRequest {
+	pr := r.toPaymentRequest()
+	pr.From, pr.To = SystemAccountFunding, r.AccountID
+	return pr
+}
+
+// ToWithdrawalRequest returns the request of the payment withdrawing the amount, with the fee
+// it is split between the payouts and fees system accounts.
+func (r *FundsRequest) ToWithdrawalRequest() *PaymentRequest {
+	pr := r.toPaymentRequest()
+	pr.From = r.AccountID
+	fee, _ := decimal.NewFromString(r.Fee)
+	if !fee.IsPositive() {
+		pr.To = SystemAccountPayouts
+		return pr
+	}
+	amount, _ := decimal.NewFromString(r.Amount)
+	pr.Amount = amount.Add(fee).String()
+	pr.Legs = []*PaymentLeg{
+		{To: SystemAccountPayouts, Amount: r.Amount},
+		{To: SystemAccountFees, Amount: r.Fee},
+	}
+	return pr
+}
+
+func (r *FundsRequest) toPaymentRequest() *PaymentRequest {
+	return &PaymentRequest{
+		Amount:         r.Amount,
+		IdempotencyKey: r.IdempotencyKey,
+		Description:    r.Description,
+		ExternalRef:    r.ExternalRef,
+		Metadata:       r.Metadata,
+	}
+}
+
+// ValidateDepositRequest checks the deposit, it can't have a fee.
+func ValidateDepositRequest(r *FundsRequest) error {
+	if r.Fee != "" {
+		return ErrInvalidFee
+	}
+	return validateFundsRequest(r)
+}
+
+func ValidateWithdrawalRequest(r *FundsRequest) error {
+	if r.Fee != "" {
+		fee, err := decimal.NewFromString(r.Fee)
+		if err != nil {
+			return err
+		}
+		if fee.IsNegative() {
+			return ErrInvalidFee
+		}
+	}
+	return validateFundsRequest(r)
+}
+
+func validateFundsRequest(r *FundsRequest) error {
+	if err := ValidateAccountID(r.AccountID); err != nil {
+		return err
+	}
+	amount, err := decimal.NewFromString(r.Amount)
+	if err != nil {
+		return err
+	}
+	if !amount.IsPositive() {
+		return ErrNotPositiveAmount
+	}
+	if len(r.IdempotencyKey) > MaxIdempotencyKeyLength {
+		return ErrIdempotencyKeyTooLong
+	}
+	if len(r.Description) > MaxDescriptionLength || !printable(r.Description) {
+		return ErrInvalidDescription
+	}
+	if err := validateExternalRef(r.ExternalRef); err != nil {
+		return err
+	}
+	return ValidateMetadata(r.Metadata)
+}
@@ -0,0 +1,97 @@
+package account
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateFundsRequest(t *testing.T) {
+	testCases := []struct {
+		name         string
+		request      *FundsRequest
+		wantDeposit  error
+		wantWithdraw error
+	}{
+		{
+			name:    "normal response",
+			request: &FundsRequest{AccountID: 1, Amount: "100"},
+		},
+		{
+			name:         "fee",
+			request:      &FundsRequest{AccountID: 1, Amount: "100", Fee: "1.5"},
+			wantDeposit:  ErrInvalidFee,
+			wantWithdraw: nil,
+		},
+		{
+			name:         "negative fee",
+			request:      &FundsRequest{AccountID: 1, Amount: "100", Fee: "-1"},
+			wantDeposit:  ErrInvalidFee,
+			wantWithdraw: ErrInvalidFee,
+		},
+		{
+			name:         "system account",
+			request:      &FundsRequest{AccountID: SystemAccountFunding, Amount: "100"},
+			wantDeposit:  ErrMustBePositive,
+			wantWithdraw: ErrMustBePositive,
+		},
+		{
+			name:         "amount must be positive",
+			request:      &FundsRequest{AccountID: 1, Amount: "0"},
+			wantDeposit:  ErrNotPositiveAmount,
+			wantWithdraw: ErrNotPositiveAmount,
+		},
+		{
+			name:         "invalid external reference",
+			request:      &FundsRequest{AccountID: 1, Amount: "100", ExternalRef: "bank ref"},
+			wantDeposit:  ErrInvalidExternalRef,
+			wantWithdraw: ErrInvalidExternalRef,
+		},
+		{
+			name:         "reserved external reference",
+			request:      &FundsRequest{AccountID: 1, Amount: "100", ExternalRef: "interest:1:2021-03"},
+			wantDeposit:  ErrReservedExternalRef,
+			wantWithdraw: ErrReservedExternalRef,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.wantDeposit, ValidateDepositRequest(tc.request))
+			assert.Equal(t, tc.wantWithdraw, ValidateWithdrawalRequest(tc.request))
+		})
+	}
+}
+
+func TestFundsRequest_ToPaymentRequest(t *testing.T) {
+	r := &FundsRequest{AccountID: 1, Amount: "100", IdempotencyKey: "k", ExternalRef: "bank:42"}
+
+	assert.Equal(t, &PaymentRequest{
+		From:           SystemAccountFunding,
+		To:             1,
+		Amount:         "100",
+		IdempotencyKey: "k",
+		ExternalRef:    "bank:42",
+	}, r.ToDepositRequest())
+
+	assert.Equal(t, &PaymentRequest{
+		From:           1,
+		To:             SystemAccountPayouts,
+		Amount:         "100",
+		IdempotencyKey: "k",
+		ExternalRef:    "bank:42",
+	}, r.ToWithdrawalRequest())
+
+	// the fee is a leg of the withdrawal to the fees system account.
+	r.Fee = "1.50"
+	assert.Equal(t, &PaymentRequest{
+		From:           1,
+		Amount:         "101.5",
+		IdempotencyKey: "k",
+		ExternalRef:    "bank:42",
+		Legs: []*PaymentLeg{
+			{To: SystemAccountPayouts, Amount: "100"},
+			{To: SystemAccountFees, Amount: "1.50"},
+		},
+	}, r.ToWithdrawalRequest())
+}
@@ -0,0 +1,152 @@
+package account
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// PaymentTypeOpeningBalance credits the imported account with its opening balance, it is paid
+// from SystemAccountOpeningBalances.
+const PaymentTypeOpeningBalance = "opening_balance"
+
+// ImportRow is an account imported from another system. A new account is created with zero balance
+// and credited with the opening balance, the details set by the row replace the ones of an existing account
+// keeping its balance, so the empty columns leave them unchanged.
+type ImportRow struct {
+	ID int64
+	// Type is one of the user account types, TypeUser for a new account if empty.
+	Type string
+	// Status is StatusActive for a new account if empty.
+	Status         string
+	OwnerRef       string
+	DisplayName    string
+	Metadata       map[string]string
+	OpeningBalance string
+	// CreatedAt is the creation time of the account in the other system, the import time if zero.
+	CreatedAt time.Time
+}
+
+// ToAccount returns the new account of the row credited with the opening balance by the returned payment,
+// the payment is nil if there is no opening balance. The balance policy of the account type bounds the opening
+// balance, and the account is credited before its status is set, so the accounts imported suspended or closed
+// get their opening balances too.
+func (r *ImportRow) ToAccount(importedAt time.Time, policies map[string]*BalancePolicy) (*Account, *Payment, error) {
+	createdAt := r.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = importedAt
+	}
+	a := Create(r.ID, createdAt)
+	a.Balance, a.InitialBalance = "0", "0"
+	a.Type = TypeUser
+	r.applyDetails(a)
+	a.Policy = policies[a.Type]
+	if !r.hasOpeningBalance() {
+		return a, nil, nil
+	}
+
+	status := a.Status
+	a.Status = StatusActive
+	p := r.toOpeningPayment(importedAt)
+	if err := a.ApplyPayment(p); err != nil {
+		return nil, nil, err
+	}
+	a.Status = status
+	return a, p, nil
+}
+
+// ApplyTo replaces the details of the account with the ones set by the row, the balance is left unchanged.
+// The balance must be within the policy of the new type of the account, and a closed account is not reopened.
+func (r *ImportRow) ApplyTo(a *Account, policies map[string]*BalancePolicy) error {
+	if a.Status == StatusClosed && r.Status != "" && r.Status != StatusClosed {
+		return ErrAccountClosed
+	}
+	if r.Type != "" && r.Type != a.Type {
+		if err := policies[r.Type].checkBalance(a.Balance); err != nil {
+			return err
+		}
+	}
+
+	r.applyDetails(a)
+	a.Policy = policies[a.Type]
+	a.Version++
+	return nil
+}
+
+// applyDetails sets the details of the account set by the row.
+func (r *ImportRow) applyDetails(a *Account) {
+	if r.Type != "" {
+		a.Type = r.Type
+	}
+	if r.Status != "" {
+		a.Status = r.Status
+	}
+	if r.OwnerRef != "" {
+		a.OwnerRef = r.OwnerRef
+	}
+	if r.DisplayName != "" {
+		a.DisplayName = r.DisplayName
+	}
+	if len(r.Metadata) > 0 {
+		a.Metadata = r.Metadata
+	}
+}
+
+// toOpeningPayment returns the payment crediting the new account with the opening balance,
+// its external reference makes it unique for the account.
+func (r *ImportRow) toOpeningPayment(createdAt time.Time) *Payment {
+	return &Payment{
+		From:        SystemAccountOpeningBalances,
+		To:          r.ID,
+		Amount:      r.OpeningBalance,
+		CreatedAt:   createdAt,
+		Description: "Opening balance",
+		ExternalRef: openingRefPrefix + strconv.FormatInt(r.ID, 10),
+		Type:        PaymentTypeOpeningBalance,
+		Status:      PaymentStatusCompleted,
+		SettledAt:   &createdAt,
+	}
+}
+
+// hasOpeningBalance reports whether the row has a positive opening balance to pay.
+func (r *ImportRow) hasOpeningBalance() bool {
+	balance, err := decimal.NewFromString(r.OpeningBalance)
+	return err == nil && balance.IsPositive()
+}
+
+func ValidateImportRow(r *ImportRow) error {
+	if err := ValidateAccountID(r.ID); err != nil {
+		return err
+	}
+	if r.Type != "" {
+		if err := ValidateUserType(r.Type); err != nil {
+			return err
+		}
+	}
+	if r.Status != "" {
+		if err := ValidateStatus(r.Status); err != nil {
+			return err
+		}
+	}
+	if len(r.OwnerRef) > MaxOwnerRefLength {
+		return ErrOwnerRefTooLong
+	}
+	if len(r.DisplayName) > MaxDisplayNameLength {
+		return ErrDisplayNameTooLong
+	}
+	if err := ValidateMetadata(r.Metadata); err != nil {
+		return err
+	}
+	if r.OpeningBalance == "" {
+		return nil
+	}
+	balance, err := decimal.NewFromString(r.OpeningBalance)
+	if err != nil {
+		return err
+	}
+	if balance.IsNegative() {
+		return ErrInvalidOpeningBalance
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package account
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateImportRow(t *testing.T) {
+	testCases := []struct {
+		name    string
+		row     *ImportRow
+		wantErr error
+	}{
+		{name: "minimal", row: &ImportRow{ID: 1}},
+		{name: "full", row: &ImportRow{ID: 1, Type: TypeSavings, Status: StatusSuspended, OpeningBalance: "10.50", Metadata: map[string]string{"tier": "gold"}}},
+		{name: "zero id", row: &ImportRow{}, wantErr: ErrMustBePositive},
+		{name: "system type", row: &ImportRow{ID: 1, Type: TypeFees}, wantErr: ErrNotUserType},
+		{name: "unknown status", row: &ImportRow{ID: 1, Status: "frozen"}, wantErr: ErrUnknownStatus},
+		{name: "negative opening balance", row: &ImportRow{ID: 1, OpeningBalance: "-1"}, wantErr: ErrInvalidOpeningBalance},
+		{name: "long display name", row: &ImportRow{ID: 1, DisplayName: string(make([]byte, MaxDisplayNameLength+1))}, wantErr: ErrDisplayNameTooLong},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.wantErr, ValidateImportRow(tc.row))
+		})
+	}
+}
+
+func TestImportRow_ToAccount(t *testing.T) {
+	importedAt := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	createdAt := time.Date(2019, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	r := &ImportRow{ID: 7, Type: TypeEMoney, Status: StatusClosed, DisplayName: "Old", OpeningBalance: "20", CreatedAt: createdAt}
+	a, p, err := r.ToAccount(importedAt, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "20.00", a.Balance)
+	assert.Equal(t, "0", a.InitialBalance)
+	assert.Equal(t, StatusClosed, a.Status)
+	assert.Equal(t, TypeEMoney, a.Type)
+	assert.Equal(t, createdAt, a.CreatedAt)
+	assert.Equal(t, SystemAccountOpeningBalances, p.From)
+	assert.Equal(t, int64(7), p.To)
+	assert.Equal(t, "opening:7", p.ExternalRef)
+	assert.Equal(t, PaymentTypeOpeningBalance, p.Type)
+	assert.Equal(t, importedAt, p.CreatedAt)
+
+	a, p, err = (&ImportRow{ID: 8}).ToAccount(importedAt, nil)
+	require.NoError(t, err)
+	assert.Nil(t, p)
+	assert.Equal(t, "0", a.Balance)
+	assert.Equal(t, TypeUser, a.Type)
+	assert.Equal(t, StatusActive, a.Status)
+	assert.Equal(t, importedAt, a.CreatedAt)
+
+	policies := map[string]*BalancePolicy{TypeUser: {Max: "10"}}
+	_, _, err = (&ImportRow{ID: 9, OpeningBalance: "20"}).ToAccount(importedAt, policies)
+	assert.Equal(t, ErrBalanceCapExceeded, err)
+}
+
+func TestImportRow_ApplyTo(t *testing.T) {
+	account := func(fn func(a *Account)) *Account {
+		a := Create(1, time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC))
+		a.Balance, a.OwnerRef, a.DisplayName = "15.00", "owner-0", "Old"
+		if fn != nil {
+			fn(a)
+		}
+		return a
+	}
+	policies := map[string]*BalancePolicy{TypeEMoney: {Max: "10"}}
+
+	testCases := []struct {
+		name    string
+		account *Account
+		row     *ImportRow
+		want    *Account
+		wantErr error
+	}{
+		{
+			name:    "details",
+			account: account(nil),
+			row:     &ImportRow{ID: 1, Type: TypeSavings, OwnerRef: "owner-1", OpeningBalance: "100"},
+			want:    account(func(a *Account) { a.Type, a.OwnerRef, a.Version = TypeSavings, "owner-1", 2 }),
+		},
+		{
+			name:    "empty columns",
+			account: account(func(a *Account) { a.Type, a.Status = TypeSavings, StatusSuspended }),
+			row:     &ImportRow{ID: 1},
+			want:    account(func(a *Account) { a.Type, a.Status, a.Version = TypeSavings, StatusSuspended, 2 }),
+		},
+		{
+			name:    "closed",
+			account: account(func(a *Account) { a.Status = StatusClosed }),
+			row:     &ImportRow{ID: 1, DisplayName: "New"},
+			want:    account(func(a *Account) { a.Status, a.DisplayName, a.Version = StatusClosed, "New", 2 }),
+		},
+		{
+			name:    "reopen closed",
+			account: account(func(a *Account) { a.Status = StatusClosed }),
+			row:     &ImportRow{ID: 1, Status: StatusActive},
+			want:    account(func(a *Account) { a.Status = StatusClosed }),
+			wantErr: ErrAccountClosed,
+		},
+		{
+			name:    "type over balance cap",
+			account: account(nil),
+			row:     &ImportRow{ID: 1, Type: TypeEMoney},
+			want:    account(nil),
+			wantErr: ErrBalanceCapExceeded,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.row.ApplyTo(tc.account, policies)
+			assert.Equal(t, tc.wantErr, err)
+			tc.want.Policy = tc.account.Policy
+			assert.Equal(t, tc.want, tc.account)
+		})
+	}
+}
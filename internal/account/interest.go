@@ -0,0 +1,113 @@
+package account
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// PaymentTypeInterest posts the interest accrued by the account, it is paid from SystemAccountInterest.
+const PaymentTypeInterest = "interest"
+
+// Day count conventions, they decide the number of days in a year the annual rate is divided by.
+const (
+	DayCountActual365    = "actual/365"
+	DayCountActual360    = "actual/360"
+	DayCountActualActual = "actual/actual"
+)
+
+// interestPrecision is the number of decimal places of the daily accruals, their sum is rounded to cents when posted.
+const interestPrecision = 8
+
+// InterestRate is the annual interest rate accrued daily on the balance.
+type InterestRate struct {
+	// Annual is the annual rate, e.g. 0.05 for 5%.
+	Annual   decimal.Decimal
+	DayCount string
+}
+
+// NewInterestRate returns the interest rate of the annual rate and the day count convention.
+func NewInterestRate(annual, dayCount string) (*InterestRate, error) {
+	rate, err := decimal.NewFromString(annual)
+	if err != nil {
+		return nil, err
+	}
+	if rate.IsNegative() {
+		return nil, ErrInvalidInterestRate
+	}
+	switch dayCount {
+	case DayCountActual365, DayCountActual360, DayCountActualActual:
+	default:
+		return nil, ErrUnknownDayCount
+	}
+	return &InterestRate{Annual: rate, DayCount: dayCount}, nil
+}
+
+// Daily returns the interest accrued on the balance at the end of the day, a negative balance accrues nothing.
+func (r *InterestRate) Daily(balance string, day time.Time) (string, error) {
+	amount, err := decimal.NewFromString(balance)
+	if err != nil {
+		return "", err
+	}
+	if !amount.IsPositive() {
+		return decimal.Zero.StringFixed(interestPrecision), nil
+	}
+	days := decimal.NewFromInt(int64(r.daysInYear(day)))
+	return amount.Mul(r.Annual).Div(days).StringFixed(interestPrecision), nil
+}
+
+func (r *InterestRate) daysInYear(day time.Time) int {
+	switch r.DayCount {
+	case DayCountActual360:
+		return 360
+	case DayCountActualActual:
+		// the last day of the year is 366 in the leap years.
+		return time.Date(day.Year(), 12, 31, 0, 0, 0, 0, time.UTC).YearDay()
+	default:
+		return 365
+	}
+}
+
+// InterestAccrual is the interest accrued by the account for a day, it is posted to the account monthly.
+type InterestAccrual struct {
+	tableName struct{} `pg:"interest_accruals"`
+	AccountID int64    `pg:"account_id"`
+	// Day is the start of the day in UTC, the interest is accrued on the balance at its end.
+	Day     time.Time `pg:"day"`
+	Balance string    `pg:"balance"`
+	Rate    string    `pg:"rate"`
+	Amount  string    `pg:"amount"`
+	// PaymentID is the payment posting the interest, zero until it is posted.
+	PaymentID int64 `pg:"payment_id"`
+}
+
+// NewInterestPosting returns the payment posting the interest accrued by the account for the month.
+// Its external reference makes the posting of the month unique.
+func NewInterestPosting(accountID int64, amount string, month, createdAt time.Time) *Payment {
+	return &Payment{
+		From:        SystemAccountInterest,
+		To:          accountID,
+		Amount:      amount,
+		CreatedAt:   createdAt,
+		Description: "Interest for " + month.Format("January 2006"),
+		ExternalRef: interestRefPrefix + strconv.FormatInt(accountID, 10) + ":" + month.Format("2006-01"),
+		Type:        PaymentTypeInterest,
+		Status:      PaymentStatusCompleted,
+		SettledAt:   &createdAt,
+	}
+}
+
+// RoundInterest rounds the accrued interest to cents when it is posted.
+func RoundInterest(amount string) (string, error) {
+	d, err := decimal.NewFromString(amount)
+	if err != nil {
+		return "", err
+	}
+	return d.Round(2).StringFixed(2), nil
+}
+
+// IsMonthEnd reports whether the day is the last day of its month.
+func IsMonthEnd(day time.Time) bool {
+	return day.AddDate(0, 0, 1).Day() == 1
+}
@@ -0,0 +1,84 @@
+package account
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInterestRate_Daily(t *testing.T) {
+	day := time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name       string
+		dayCount   string
+		balance    string
+		wantAmount string
+	}{
+		{
+			name:       "actual/365",
+			dayCount:   DayCountActual365,
+			balance:    "1000",
+			wantAmount: "0.13698630",
+		},
+		{
+			name:       "actual/360",
+			dayCount:   DayCountActual360,
+			balance:    "1000",
+			wantAmount: "0.13888889",
+		},
+		{
+			name:       "actual/actual in a leap year",
+			dayCount:   DayCountActualActual,
+			balance:    "1000",
+			wantAmount: "0.13661202",
+		},
+		{
+			name:       "negative balance",
+			dayCount:   DayCountActual365,
+			balance:    "-10",
+			wantAmount: "0.00000000",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rate, err := NewInterestRate("0.05", tc.dayCount)
+			require.NoError(t, err)
+			got, err := rate.Daily(tc.balance, day)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.wantAmount, got)
+		})
+	}
+}
+
+func TestNewInterestRate(t *testing.T) {
+	_, err := NewInterestRate("-0.01", DayCountActual365)
+	assert.Equal(t, ErrInvalidInterestRate, err)
+	_, err = NewInterestRate("0.01", "30/360")
+	assert.Equal(t, ErrUnknownDayCount, err)
+	_, err = NewInterestRate("1%", DayCountActual365)
+	assert.Error(t, err)
+}
+
+func TestNewInterestPosting(t *testing.T) {
+	month := time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC)
+	createdAt := time.Date(2021, 3, 1, 1, 0, 0, 0, time.UTC)
+
+	p := NewInterestPosting(7, "4.11", month, createdAt)
+	assert.Equal(t, SystemAccountInterest, p.From)
+	assert.Equal(t, int64(7), p.To)
+	assert.Equal(t, "interest:7:2021-02", p.ExternalRef)
+	assert.Equal(t, "Interest for February 2021", p.Description)
+	assert.Equal(t, PaymentTypeInterest, p.Type)
+	assert.Equal(t, PaymentStatusCompleted, p.Status)
+	assert.True(t, validExternalRef(p.ExternalRef))
+}
+
+func TestIsMonthEnd(t *testing.T) {
+	assert.True(t, IsMonthEnd(time.Date(2020, 2, 29, 0, 0, 0, 0, time.UTC)))
+	assert.False(t, IsMonthEnd(time.Date(2021, 2, 27, 0, 0, 0, 0, time.UTC)))
+	assert.True(t, IsMonthEnd(time.Date(2021, 12, 31, 0, 0, 0, 0, time.UTC)))
+}
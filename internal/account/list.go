@@ -0,0 +1,184 @@
+package account
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Sort orders of the account listing, a "-" prefix sorts in the descending order.
+const (
+	SortByID        = "id"
+	SortByCreatedAt = "created_at"
+	SortByBalance   = "balance"
+)
+
+// Limits of the accounts listing page.
+const (
+	DefaultListLimit = 50
+	MaxListLimit     = 500
+)
+
+// ListRequest asks for a page of the accounts matching all the given filters.
+type ListRequest struct {
+	Status   string
+	Type     string
+	OwnerRef string
+	// MinBalance and MaxBalance are the inclusive bounds of the balance.
+	MinBalance string
+	MaxBalance string
+	// CreatedFrom and CreatedTo are the period [CreatedFrom, CreatedTo) when the accounts are created.
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	// Labels are the metadata key-value pairs the accounts must have.
+	Labels map[string]string
+	// IDs restricts the listing to the given accounts, nil means all of them.
+	IDs []int64
+	// Sort is one of the sort orders, by id by default.
+	Sort string
+	// Limit is the size of the page, DefaultListLimit if zero.
+	Limit int
+	// After is the cursor of the previous page.
+	After *Cursor
+}
+
+// Page is a page of the account listing.
+type Page struct {
+	Accounts []*Account
+	// Next is the cursor of the next page, nil if it is the last one.
+	Next *Cursor `json:",omitempty"`
+}
+
+// Cursor is the position in the account listing: the sort key and id of the last account of the page.
+// It is passed to the clients as an opaque string.
+type Cursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v,omitempty"`
+	ID    int64  `json:"i"`
+}
+
+// NewCursor returns the cursor pointing after the given account.
+func NewCursor(sort string, a *Account) *Cursor {
+	c := &Cursor{Sort: sort, ID: a.ID}
+	field, _, _ := ParseSort(sort)
+	switch field {
+	case SortByCreatedAt:
+		c.Value = a.CreatedAt.UTC().Format(time.RFC3339Nano)
+	case SortByBalance:
+		c.Value = a.Balance
+	}
+	return c
+}
+
+// cursorJSON is the cursor without its text methods, it is encoded to JSON before being encoded to base64.
+type cursorJSON Cursor
+
+// MarshalText encodes the cursor as an opaque string.
+func (c Cursor) MarshalText() ([]byte, error) {
+	b, err := json.Marshal(cursorJSON(c))
+	if err != nil {
+		return nil, err
+	}
+	return []byte(base64.RawURLEncoding.EncodeToString(b)), nil
+}
+
+// UnmarshalText decodes the cursor returned by MarshalText.
+func (c *Cursor) UnmarshalText(text []byte) error {
+	b, err := base64.RawURLEncoding.DecodeString(string(text))
+	if err != nil {
+		return ErrInvalidCursor
+	}
+	var v cursorJSON
+	if err := json.Unmarshal(b, &v); err != nil {
+		return ErrInvalidCursor
+	}
+	*c = Cursor(v)
+	return nil
+}
+
+// ParseSort returns the field and direction of the sort order.
+func ParseSort(sort string) (field string, desc bool, err error) {
+	field = strings.TrimPrefix(sort, "-")
+	desc = field != sort
+	switch field {
+	case "":
+		if desc {
+			return "", false, ErrUnknownSort
+		}
+		return SortByID, false, nil
+	case SortByID, SortByCreatedAt, SortByBalance:
+		return field, desc, nil
+	}
+	return "", false, ErrUnknownSort
+}
+
+func ValidateListRequest(r *ListRequest) error {
+	if r.Status != "" {
+		if err := ValidateStatus(r.Status); err != nil {
+			return err
+		}
+	}
+	if r.Type != "" {
+		if err := ValidateType(r.Type); err != nil {
+			return err
+		}
+	}
+	if err := validateBalanceRange(r.MinBalance, r.MaxBalance); err != nil {
+		return err
+	}
+	if !r.CreatedFrom.IsZero() && !r.CreatedTo.IsZero() && !r.CreatedFrom.Before(r.CreatedTo) {
+		return ErrInvalidCreatedRange
+	}
+	field, desc, err := ParseSort(r.Sort)
+	if err != nil {
+		return err
+	}
+	if r.Limit < 0 || r.Limit > MaxListLimit {
+		return ErrInvalidLimit
+	}
+	if r.After != nil {
+		return validateCursor(r.After, field, desc)
+	}
+	return nil
+}
+
+func validateBalanceRange(min, max string) error {
+	var minBalance, maxBalance decimal.Decimal
+	var err error
+	if min != "" {
+		if minBalance, err = decimal.NewFromString(min); err != nil {
+			return ErrInvalidBalanceRange
+		}
+	}
+	if max != "" {
+		if maxBalance, err = decimal.NewFromString(max); err != nil {
+			return ErrInvalidBalanceRange
+		}
+	}
+	if min != "" && max != "" && minBalance.GreaterThan(maxBalance) {
+		return ErrInvalidBalanceRange
+	}
+	return nil
+}
+
+// validateCursor checks that the cursor is returned for the same sort order.
+func validateCursor(c *Cursor, field string, desc bool) error {
+	cursorField, cursorDesc, err := ParseSort(c.Sort)
+	if err != nil || cursorField != field || cursorDesc != desc {
+		return ErrInvalidCursor
+	}
+	switch field {
+	case SortByCreatedAt:
+		if _, err := time.Parse(time.RFC3339Nano, c.Value); err != nil {
+			return ErrInvalidCursor
+		}
+	case SortByBalance:
+		if _, err := decimal.NewFromString(c.Value); err != nil {
+			return ErrInvalidCursor
+		}
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package account
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateListRequest(t *testing.T) {
+	createdAt := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	a := &Account{ID: 7, Balance: "12.50", CreatedAt: createdAt}
+
+	testCases := []struct {
+		name    string
+		request *ListRequest
+		wantErr error
+	}{
+		{
+			name:    "empty",
+			request: &ListRequest{},
+		},
+		{
+			name: "all filters",
+			request: &ListRequest{
+				Status:      StatusActive,
+				MinBalance:  "0",
+				MaxBalance:  "100.5",
+				CreatedFrom: createdAt,
+				CreatedTo:   createdAt.Add(time.Hour),
+				Labels:      map[string]string{"tier": "gold"},
+				Sort:        "-balance",
+				Limit:       MaxListLimit,
+				After:       NewCursor("-balance", a),
+			},
+		},
+		{
+			name:    "unknown status",
+			request: &ListRequest{Status: "frozen"},
+			wantErr: ErrUnknownStatus,
+		},
+		{
+			name:    "invalid balance",
+			request: &ListRequest{MinBalance: "1,5"},
+			wantErr: ErrInvalidBalanceRange,
+		},
+		{
+			name:    "min balance above max",
+			request: &ListRequest{MinBalance: "10", MaxBalance: "9.99"},
+			wantErr: ErrInvalidBalanceRange,
+		},
+		{
+			name:    "empty creation period",
+			request: &ListRequest{CreatedFrom: createdAt, CreatedTo: createdAt},
+			wantErr: ErrInvalidCreatedRange,
+		},
+		{
+			name:    "unknown sort",
+			request: &ListRequest{Sort: "-"},
+			wantErr: ErrUnknownSort,
+		},
+		{
+			name:    "limit too large",
+			request: &ListRequest{Limit: MaxListLimit + 1},
+			wantErr: ErrInvalidLimit,
+		},
+		{
+			name:    "cursor of another sort",
+			request: &ListRequest{Sort: "created_at", After: NewCursor("-created_at", a)},
+			wantErr: ErrInvalidCursor,
+		},
+		{
+			name:    "cursor with invalid key",
+			request: &ListRequest{Sort: "balance", After: &Cursor{Sort: "balance", Value: "x", ID: 7}},
+			wantErr: ErrInvalidCursor,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.wantErr, ValidateListRequest(tc.request))
+		})
+	}
+}
+
+func TestCursor_Text(t *testing.T) {
+	a := &Account{ID: 7, Balance: "12.50", CreatedAt: time.Date(2021, 3, 1, 10, 0, 0, 123000, time.FixedZone("", 3*3600))}
+
+	for _, sort := range []string{"", "-id", "created_at", "-balance"} {
+		c := NewCursor(sort, a)
+		text, err := c.MarshalText()
+		assert.NoError(t, err)
+
+		got := &Cursor{}
+		assert.NoError(t, got.UnmarshalText(text))
+		assert.Equal(t, c, got)
+	}
+
+	assert.Equal(t, "2021-03-01T07:00:00.000123Z", NewCursor("created_at", a).Value)
+	assert.Equal(t, ErrInvalidCursor, (&Cursor{}).UnmarshalText([]byte("not a cursor")))
+}
@@ -1,34 +1,258 @@
 package account
 
 import (
+	"strings"
 	"time"
+	"unicode"
 
 	"github.com/shopspring/decimal"
 )
 
+// Limits of the payment request fields.
+const (
+	MaxIdempotencyKeyLength = 64
+	MaxDescriptionLength    = 256
+	MaxExternalRefLength    = 64
+	MaxPaymentLegs          = 16
+)
+
+// Prefixes of the external references of the payments made by the service, they are reserved
+// so that the payments of the callers can't take the references of the service payments in advance.
+const (
+	adjustmentRefPrefix = "adjustment:"
+	interestRefPrefix   = "interest:"
+	openingRefPrefix    = "opening:"
+)
+
+var reservedExternalRefPrefixes = []string{adjustmentRefPrefix, interestRefPrefix, openingRefPrefix}
+
+// Payment types.
+const (
+	// PaymentTypeTransfer moves the amount from one account to another.
+	PaymentTypeTransfer = "transfer"
+	// PaymentTypeSplit splits the amount of one account between the receivers of its legs.
+	PaymentTypeSplit = "split"
+)
+
+// Payment statuses. A pending payment has debited the sender and waits for the review: confirming it
+// completes the payment crediting the receivers, cancelling it or failing it returns the amount to the sender.
+const (
+	PaymentStatusPending   = "pending"
+	PaymentStatusCompleted = "completed"
+	PaymentStatusFailed    = "failed"
+	PaymentStatusCancelled = "cancelled"
+)
+
 type Payment struct {
-	tableName struct{}  `pq:"payments"`
-	ID        int64     `pq:"id"`
-	From      int64     `pg:"from_account_id"`
-	To        int64     `pg:"to_account_id"`
-	Amount    string    `pq:"amount"`
-	CreatedAt time.Time `pq:"create_at"`
+	tableName      struct{}  `pq:"payments"`
+	ID             int64     `pq:"id"`
+	From           int64     `pg:"from_account_id"`
+	To             int64     `pg:"to_account_id"`
+	Amount         string    `pq:"amount"`
+	IdempotencyKey string    `pg:"idempotency_key"`
+	CreatedAt      time.Time `pq:"create_at"`
+
+	Description string            `pg:"description"`
+	ExternalRef string            `pg:"external_ref"`
+	Metadata    map[string]string `pg:"metadata"`
+
+	Type string `pg:"type"`
+	// Legs are the receivers of the split payment, its To is zero.
+	Legs []*PaymentLeg `pg:"rel:has-many" json:",omitempty"`
+
+	Status string `pg:"status"`
+	// SettledAt is when the payment gets its final status, nil while it is pending.
+	SettledAt *time.Time `pg:"settled_at" json:",omitempty"`
+
+	// RiskDecision and RiskRules are the result of the risk screening of the payment.
+	RiskDecision string   `pg:"risk_decision" json:",omitempty"`
+	RiskRules    []string `pg:"risk_rules,array" json:",omitempty"`
+}
+
+// PaymentLeg is the part of the split payment credited to its receiver.
+type PaymentLeg struct {
+	tableName struct{} `pg:"payment_legs"`
+	ID        int64    `pg:"id" json:"-"`
+	PaymentID int64    `pg:"payment_id" json:"-"`
+	To        int64    `pg:"to_account_id"`
+	Amount    string   `pg:"amount"`
 }
 
 type PaymentRequest struct {
 	From   int64
 	To     int64
 	Amount string
+
+	// IdempotencyKey makes retries safe: a repeated request with the same key
+	// from the same sender returns the original payment instead of applying a new one.
+	IdempotencyKey string
+
+	// Description is the memo of the payment shown to the both sides.
+	Description string
+	// ExternalRef is the reference of the payment in the sender's system, e.g. the order id.
+	// A sender can't make two payments with the same reference.
+	ExternalRef string
+	Metadata    map[string]string
+
+	// Legs split the payment between several receivers instead of To, their amounts must sum to Amount.
+	Legs []*PaymentLeg `json:",omitempty"`
+
+	// Pending creates the payment reserving the amount on the sender until it is confirmed or cancelled.
+	Pending bool `json:",omitempty"`
+}
+
+// PaymentFilter selects the payments of the account.
+type PaymentFilter struct {
+	AccountID   int64
+	ExternalRef string
+	// Labels are the metadata key-value pairs the payments must have.
+	Labels map[string]string
 }
 
 func (r *PaymentRequest) ToPayment(createdAt time.Time) *Payment {
+	paymentType := PaymentTypeTransfer
+	var legs []*PaymentLeg
+	if len(r.Legs) > 0 {
+		paymentType = PaymentTypeSplit
+		legs = make([]*PaymentLeg, 0, len(r.Legs))
+		for _, l := range r.Legs {
+			legs = append(legs, &PaymentLeg{To: l.To, Amount: l.Amount})
+		}
+	}
+	status, settledAt := PaymentStatusCompleted, &createdAt
+	if r.Pending {
+		status, settledAt = PaymentStatusPending, nil
+	}
 	return &Payment{
-		ID:        0,
-		From:      r.From,
-		To:        r.To,
-		Amount:    r.Amount,
-		CreatedAt: createdAt,
+		ID:             0,
+		From:           r.From,
+		To:             r.To,
+		Amount:         r.Amount,
+		IdempotencyKey: r.IdempotencyKey,
+		CreatedAt:      createdAt,
+		Description:    r.Description,
+		ExternalRef:    r.ExternalRef,
+		Metadata:       r.Metadata,
+		Type:           paymentType,
+		Legs:           legs,
+		Status:         status,
+		SettledAt:      settledAt,
+	}
+}
+
+// AccountIDs returns the ids of the sender and the receivers of the payment.
+func (p *Payment) AccountIDs() []int64 {
+	if len(p.Legs) == 0 {
+		return []int64{p.From, p.To}
+	}
+	ids := make([]int64, 0, len(p.Legs)+1)
+	ids = append(ids, p.From)
+	for _, l := range p.Legs {
+		ids = append(ids, l.To)
+	}
+	return ids
+}
+
+// Credit returns the amount the payment credits to the account, false if the account doesn't receive it.
+func (p *Payment) Credit(accountID int64) (string, bool) {
+	if len(p.Legs) == 0 {
+		return p.Amount, accountID == p.To
+	}
+	for _, l := range p.Legs {
+		if l.To == accountID {
+			return l.Amount, true
+		}
+	}
+	return "", false
+}
+
+// Confirm completes the pending payment.
+func (p *Payment) Confirm(at time.Time) error {
+	return p.settle(PaymentStatusCompleted, at)
+}
+
+// Cancel cancels the pending payment.
+func (p *Payment) Cancel(at time.Time) error {
+	return p.settle(PaymentStatusCancelled, at)
+}
+
+// Fail marks the pending payment as failed.
+func (p *Payment) Fail(at time.Time) error {
+	return p.settle(PaymentStatusFailed, at)
+}
+
+func (p *Payment) settle(status string, at time.Time) error {
+	if p.Status != PaymentStatusPending {
+		return ErrPaymentNotPending
+	}
+	p.Status = status
+	p.SettledAt = &at
+	return nil
+}
+
+// Posting is a change of the account balance made by a payment.
+type Posting struct {
+	Amount decimal.Decimal
+	At     time.Time
+}
+
+// Postings returns the changes the payment makes to the balance of the account: the sender is debited
+// when the payment is created and refunded when it is cancelled or failed, the receivers are credited
+// when it is completed.
+func (p *Payment) Postings(accountID int64) ([]Posting, error) {
+	amount, err := decimal.NewFromString(p.Amount)
+	if err != nil {
+		return nil, err
 	}
+	if accountID == p.From {
+		postings := []Posting{{Amount: amount.Neg(), At: p.CreatedAt}}
+		if (p.Status == PaymentStatusCancelled || p.Status == PaymentStatusFailed) && p.SettledAt != nil {
+			postings = append(postings, Posting{Amount: amount, At: *p.SettledAt})
+		}
+		return postings, nil
+	}
+
+	credit, ok := p.Credit(accountID)
+	if !ok {
+		return nil, ErrMismatchPayment
+	}
+	if p.Status != PaymentStatusCompleted {
+		return nil, nil
+	}
+	amount, err = decimal.NewFromString(credit)
+	if err != nil {
+		return nil, err
+	}
+	settledAt := p.CreatedAt
+	if p.SettledAt != nil {
+		settledAt = *p.SettledAt
+	}
+	return []Posting{{Amount: amount, At: settledAt}}, nil
+}
+
+// Matches reports whether the payment was created by the given request.
+func (p *Payment) Matches(r *PaymentRequest) bool {
+	if p.From != r.From || p.To != r.To || len(p.Legs) != len(r.Legs) {
+		return false
+	}
+	for i, l := range p.Legs {
+		if l.To != r.Legs[i].To || !equalAmounts(l.Amount, r.Legs[i].Amount) {
+			return false
+		}
+	}
+	return equalAmounts(p.Amount, r.Amount)
+}
+
+func equalAmounts(a, b string) bool {
+	x, err := decimal.NewFromString(a)
+	if err != nil {
+		return false
+	}
+	y, err := decimal.NewFromString(b)
+	if err != nil {
+		return false
+	}
+	return x.Equal(y)
 }
 
 func ValidatePaymentRequest(r *PaymentRequest) error {
@@ -42,11 +266,115 @@ func ValidatePaymentRequest(r *PaymentRequest) error {
 	if r.From <= 0 {
 		return ErrAccountFromMustBePositive
 	}
-	if r.To <= 0 {
-		return ErrAccountToMustBePositive
+	if len(r.Legs) > 0 {
+		if err := validateLegs(r, amount); err != nil {
+			return err
+		}
+	} else {
+		if r.To <= 0 {
+			return ErrAccountToMustBePositive
+		}
+		if r.From == r.To {
+			return ErrFromAndToMustBeDifferent
+		}
+	}
+	if len(r.IdempotencyKey) > MaxIdempotencyKeyLength {
+		return ErrIdempotencyKeyTooLong
+	}
+	if len(r.Description) > MaxDescriptionLength || !printable(r.Description) {
+		return ErrInvalidDescription
+	}
+	if err := validateExternalRef(r.ExternalRef); err != nil {
+		return err
+	}
+	return ValidateMetadata(r.Metadata)
+}
+
+// validateExternalRef checks the external reference of a payment request.
+func validateExternalRef(ref string) error {
+	if len(ref) > MaxExternalRefLength || !validExternalRef(ref) {
+		return ErrInvalidExternalRef
 	}
-	if r.From == r.To {
-		return ErrFromAndToMustBeDifferent
+	for _, prefix := range reservedExternalRefPrefixes {
+		if strings.HasPrefix(ref, prefix) {
+			return ErrReservedExternalRef
+		}
 	}
 	return nil
 }
+
+// validateLegs checks that the legs of the split payment have distinct receivers and sum to its amount.
+func validateLegs(r *PaymentRequest, amount decimal.Decimal) error {
+	if r.To != 0 {
+		return ErrSplitPaymentWithTo
+	}
+	if len(r.Legs) > MaxPaymentLegs {
+		return ErrTooManyLegs
+	}
+	sum := decimal.Zero
+	receivers := make(map[int64]bool, len(r.Legs))
+	for _, l := range r.Legs {
+		legAmount, err := decimal.NewFromString(l.Amount)
+		if err != nil {
+			return err
+		}
+		if !legAmount.IsPositive() {
+			return ErrNotPositiveAmount
+		}
+		if l.To <= 0 {
+			return ErrAccountToMustBePositive
+		}
+		if l.To == r.From {
+			return ErrFromAndToMustBeDifferent
+		}
+		if receivers[l.To] {
+			return ErrDuplicateLegReceiver
+		}
+		receivers[l.To] = true
+		sum = sum.Add(legAmount)
+	}
+	if !sum.Equal(amount) {
+		return ErrLegsSumMismatch
+	}
+	return nil
+}
+
+func ValidatePaymentID(id int64) error {
+	if id <= 0 {
+		return ErrPaymentIDMustBePositive
+	}
+	return nil
+}
+
+func ValidatePaymentFilter(f *PaymentFilter) error {
+	if err := ValidateAccountID(f.AccountID); err != nil {
+		return err
+	}
+	if len(f.ExternalRef) > MaxExternalRefLength || !validExternalRef(f.ExternalRef) {
+		return ErrInvalidExternalRef
+	}
+	return nil
+}
+
+// printable reports whether the text has no control characters.
+func printable(s string) bool {
+	for _, c := range s {
+		if unicode.IsControl(c) || c == unicode.ReplacementChar {
+			return false
+		}
+	}
+	return true
+}
+
+// validExternalRef allows letters, digits, '_', '-', '.', ':', '/' and '#' in the external reference.
+func validExternalRef(s string) bool {
+	for _, c := range s {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '_', c == '-', c == '.', c == ':', c == '/', c == '#':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -2,6 +2,7 @@ package account
 
 import (
 	"errors"
+	"strings"
 	"testing"
 	"time"
 
@@ -47,6 +48,13 @@ func TestValidatePaymentRequest(t *testing.T) {
 			}),
 			wantErr: errors.New("account to must be positive"),
 		},
+		{
+			name: "idempotency key is too long",
+			paymentRequest: makePaymentRequest(t, func(pr *PaymentRequest) {
+				pr.IdempotencyKey = strings.Repeat("k", MaxIdempotencyKeyLength+1)
+			}),
+			wantErr: errors.New("idempotency key is too long"),
+		},
 		{
 			name: "from and to must be different",
 			paymentRequest: makePaymentRequest(t, func(pr *PaymentRequest) {
@@ -55,6 +63,101 @@ func TestValidatePaymentRequest(t *testing.T) {
 			}),
 			wantErr: errors.New("from and to must be different"),
 		},
+		{
+			name: "description, external reference and metadata",
+			paymentRequest: makePaymentRequest(t, func(pr *PaymentRequest) {
+				pr.Description = "Order #42 — 2 items"
+				pr.ExternalRef = "shop:order/42#1"
+				pr.Metadata = map[string]string{"channel": "web"}
+			}),
+			wantErr: nil,
+		},
+		{
+			name: "description is too long",
+			paymentRequest: makePaymentRequest(t, func(pr *PaymentRequest) {
+				pr.Description = strings.Repeat("d", MaxDescriptionLength+1)
+			}),
+			wantErr: ErrInvalidDescription,
+		},
+		{
+			name: "description has control characters",
+			paymentRequest: makePaymentRequest(t, func(pr *PaymentRequest) {
+				pr.Description = "line\nbreak"
+			}),
+			wantErr: ErrInvalidDescription,
+		},
+		{
+			name: "external reference has spaces",
+			paymentRequest: makePaymentRequest(t, func(pr *PaymentRequest) {
+				pr.ExternalRef = "order 42"
+			}),
+			wantErr: ErrInvalidExternalRef,
+		},
+		{
+			name: "external reference of an adjustment",
+			paymentRequest: makePaymentRequest(t, func(pr *PaymentRequest) {
+				pr.ExternalRef = "adjustment:42"
+			}),
+			wantErr: ErrReservedExternalRef,
+		},
+		{
+			name: "metadata value is too long",
+			paymentRequest: makePaymentRequest(t, func(pr *PaymentRequest) {
+				pr.Metadata = map[string]string{"note": strings.Repeat("v", MaxMetadataValueLength+1)}
+			}),
+			wantErr: ErrInvalidMetadata,
+		},
+		{
+			name:           "split payment",
+			paymentRequest: makeSplitPaymentRequest(t, nil),
+			wantErr:        nil,
+		},
+		{
+			name: "split payment with to",
+			paymentRequest: makeSplitPaymentRequest(t, func(pr *PaymentRequest) {
+				pr.To = 2
+			}),
+			wantErr: ErrSplitPaymentWithTo,
+		},
+		{
+			name: "split payment with too many legs",
+			paymentRequest: makeSplitPaymentRequest(t, func(pr *PaymentRequest) {
+				pr.Legs = nil
+				for i := 0; i <= MaxPaymentLegs; i++ {
+					pr.Legs = append(pr.Legs, &PaymentLeg{To: int64(i + 2), Amount: "1"})
+				}
+				pr.Amount = "17"
+			}),
+			wantErr: ErrTooManyLegs,
+		},
+		{
+			name: "split payment leg to the sender",
+			paymentRequest: makeSplitPaymentRequest(t, func(pr *PaymentRequest) {
+				pr.Legs[1].To = 1
+			}),
+			wantErr: ErrFromAndToMustBeDifferent,
+		},
+		{
+			name: "split payment legs to the same account",
+			paymentRequest: makeSplitPaymentRequest(t, func(pr *PaymentRequest) {
+				pr.Legs[1].To = 2
+			}),
+			wantErr: ErrDuplicateLegReceiver,
+		},
+		{
+			name: "split payment leg amount is not positive",
+			paymentRequest: makeSplitPaymentRequest(t, func(pr *PaymentRequest) {
+				pr.Legs[1].Amount = "0"
+			}),
+			wantErr: ErrNotPositiveAmount,
+		},
+		{
+			name: "split payment legs don't sum to the amount",
+			paymentRequest: makeSplitPaymentRequest(t, func(pr *PaymentRequest) {
+				pr.Amount = "500.01"
+			}),
+			wantErr: ErrLegsSumMismatch,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -83,6 +186,16 @@ func TestPaymentRequest_ToPayment(t *testing.T) {
 			payment:        makePayment(t, nil),
 			at:             parseTime(t, "2001-01-02T11:22:33+03:00"),
 		},
+		{
+			name:           "split payment",
+			paymentRequest: makeSplitPaymentRequest(t, nil),
+			payment: makePayment(t, func(p *Payment) {
+				p.To = 0
+				p.Type = PaymentTypeSplit
+				p.Legs = []*PaymentLeg{{To: 2, Amount: "200"}, {To: 3, Amount: "300"}}
+			}),
+			at: parseTime(t, "2001-01-02T11:22:33+03:00"),
+		},
 	}
 
 	for _, tc := range testCases {
@@ -93,6 +206,83 @@ func TestPaymentRequest_ToPayment(t *testing.T) {
 	}
 }
 
+func TestPayment_Matches(t *testing.T) {
+	testCases := []struct {
+		name           string
+		payment        *Payment
+		paymentRequest *PaymentRequest
+		want           bool
+	}{
+		{
+			name:           "same payment",
+			payment:        makePayment(t, nil),
+			paymentRequest: makePaymentRequest(t, nil),
+			want:           true,
+		},
+		{
+			name: "same amount in another format",
+			payment: makePayment(t, func(p *Payment) {
+				p.Amount = "500.00"
+			}),
+			paymentRequest: makePaymentRequest(t, nil),
+			want:           true,
+		},
+		{
+			name:    "another amount",
+			payment: makePayment(t, nil),
+			paymentRequest: makePaymentRequest(t, func(pr *PaymentRequest) {
+				pr.Amount = "501"
+			}),
+			want: false,
+		},
+		{
+			name:    "another receiver",
+			payment: makePayment(t, nil),
+			paymentRequest: makePaymentRequest(t, func(pr *PaymentRequest) {
+				pr.To = 3
+			}),
+			want: false,
+		},
+		{
+			name:           "same split payment",
+			payment:        makeSplitPaymentRequest(t, nil).ToPayment(time.Time{}),
+			paymentRequest: makeSplitPaymentRequest(t, nil),
+			want:           true,
+		},
+		{
+			name:    "another split",
+			payment: makeSplitPaymentRequest(t, nil).ToPayment(time.Time{}),
+			paymentRequest: makeSplitPaymentRequest(t, func(pr *PaymentRequest) {
+				pr.Legs[0].Amount = "300"
+				pr.Legs[1].Amount = "200"
+			}),
+			want: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, tc.payment.Matches(tc.paymentRequest))
+		})
+	}
+}
+
+func TestPayment_Settle(t *testing.T) {
+	at := parseTime(t, "2001-01-03T10:00:00Z")
+
+	p := makePaymentRequest(t, func(pr *PaymentRequest) { pr.Pending = true }).ToPayment(at.Add(-time.Hour))
+	assert.Equal(t, PaymentStatusPending, p.Status)
+	assert.Nil(t, p.SettledAt)
+
+	assert.NoError(t, p.Confirm(at))
+	assert.Equal(t, PaymentStatusCompleted, p.Status)
+	assert.Equal(t, &at, p.SettledAt)
+
+	assert.Equal(t, ErrPaymentNotPending, p.Cancel(at))
+	assert.Equal(t, ErrPaymentNotPending, p.Fail(at))
+	assert.Equal(t, PaymentStatusCompleted, p.Status)
+}
+
 func makePaymentRequest(t *testing.T, fn func(*PaymentRequest)) *PaymentRequest {
 	pr := &PaymentRequest{
 		From:   1,
@@ -105,6 +295,18 @@ func makePaymentRequest(t *testing.T, fn func(*PaymentRequest)) *PaymentRequest
 	return pr
 }
 
+func makeSplitPaymentRequest(t *testing.T, fn func(*PaymentRequest)) *PaymentRequest {
+	pr := &PaymentRequest{
+		From:   1,
+		Amount: "500",
+		Legs:   []*PaymentLeg{{To: 2, Amount: "200"}, {To: 3, Amount: "300"}},
+	}
+	if fn != nil {
+		fn(pr)
+	}
+	return pr
+}
+
 func makePayment(t *testing.T, fn func(*Payment)) *Payment {
 	p := &Payment{
 		ID:        0,
@@ -112,7 +314,10 @@ func makePayment(t *testing.T, fn func(*Payment)) *Payment {
 		To:        2,
 		Amount:    "500",
 		CreatedAt: parseTime(t, "2001-01-02T11:22:33+03:00"),
+		Type:      PaymentTypeTransfer,
 	}
+	p.Status = PaymentStatusCompleted
+	p.SettledAt = &p.CreatedAt
 	if fn != nil {
 		fn(p)
 	}
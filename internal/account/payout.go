@@ -0,0 +1,87 @@
+package account
+
+import (
+	"time"
+)
+
+// Payout statuses. A withdrawal is paid out by its payout: a requested payout is submitted to the provider
+// and gets settled or failed there. Settling the payout completes the withdrawal payment, failing it fails
+// the payment returning the amount to the account. The payout of a withdrawal sent to review by the risk
+// screening waits for the review before it is requested.
+const (
+	PayoutStatusReview    = "review"
+	PayoutStatusRequested = "requested"
+	PayoutStatusSubmitted = "submitted"
+	PayoutStatusSettled   = "settled"
+	PayoutStatusFailed    = "failed"
+)
+
+// Payout is the transfer of the withdrawn amount to the outside through a payment rail.
+type Payout struct {
+	tableName struct{} `pg:"payouts"`
+	ID        int64    `pg:"id"`
+	PaymentID int64    `pg:"payment_id"`
+	AccountID int64    `pg:"account_id"`
+	// Amount is the amount paid out, without the fee of the withdrawal.
+	Amount string `pg:"amount"`
+	Status string `pg:"status"`
+	// ProviderRef is the reference of the submitted payout in the provider.
+	ProviderRef   string    `pg:"provider_ref" json:",omitempty"`
+	FailureReason string    `pg:"failure_reason" json:",omitempty"`
+	CreatedAt     time.Time `pg:"created_at"`
+	UpdatedAt     time.Time `pg:"updated_at"`
+}
+
+// NewPayout returns the payout of the withdrawal payment.
+func NewPayout(p *Payment, createdAt time.Time) *Payout {
+	amount, _ := p.Credit(SystemAccountPayouts)
+	return &Payout{
+		PaymentID: p.ID,
+		AccountID: p.From,
+		Amount:    amount,
+		Status:    PayoutStatusRequested,
+		CreatedAt: createdAt,
+		UpdatedAt: createdAt,
+	}
+}
+
+// Release requests the payout of the withdrawal confirmed by the review.
+func (p *Payout) Release(at time.Time) error {
+	return p.transition(PayoutStatusReview, PayoutStatusRequested, at)
+}
+
+// Submit records the submission of the requested payout to the provider.
+func (p *Payout) Submit(ref string, at time.Time) error {
+	if err := p.transition(PayoutStatusRequested, PayoutStatusSubmitted, at); err != nil {
+		return err
+	}
+	p.ProviderRef = ref
+	return nil
+}
+
+// Settle records that the submitted payout is paid out.
+func (p *Payout) Settle(at time.Time) error {
+	return p.transition(PayoutStatusSubmitted, PayoutStatusSettled, at)
+}
+
+// Fail records that the submitted payout is failed or the withdrawal under review is cancelled.
+func (p *Payout) Fail(reason string, at time.Time) error {
+	from := PayoutStatusSubmitted
+	if p.Status == PayoutStatusReview {
+		from = PayoutStatusReview
+	}
+	if err := p.transition(from, PayoutStatusFailed, at); err != nil {
+		return err
+	}
+	p.FailureReason = reason
+	return nil
+}
+
+func (p *Payout) transition(from, to string, at time.Time) error {
+	if p.Status != from {
+		return ErrUnexpectedPayoutStatus
+	}
+	p.Status = to
+	p.UpdatedAt = at
+	return nil
+}
@@ -0,0 +1,99 @@
+package account
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewPayout(t *testing.T) {
+	createdAt := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	r := (&FundsRequest{AccountID: 1, Amount: "250", Fee: "2.5"}).ToWithdrawalRequest()
+	p := r.ToPayment(createdAt)
+	p.ID = 7
+
+	assert.Equal(t, &Payout{
+		PaymentID: 7,
+		AccountID: 1,
+		Amount:    "250",
+		Status:    PayoutStatusRequested,
+		CreatedAt: createdAt,
+		UpdatedAt: createdAt,
+	}, NewPayout(p, createdAt))
+}
+
+func TestPayout_Transitions(t *testing.T) {
+	at := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name       string
+		status     string
+		transition func(p *Payout) error
+		wantStatus string
+		wantErr    error
+	}{
+		{
+			name:       "release",
+			status:     PayoutStatusReview,
+			transition: func(p *Payout) error { return p.Release(at) },
+			wantStatus: PayoutStatusRequested,
+		},
+		{
+			name:       "submit",
+			status:     PayoutStatusRequested,
+			transition: func(p *Payout) error { return p.Submit("ref-1", at) },
+			wantStatus: PayoutStatusSubmitted,
+		},
+		{
+			name:       "settle",
+			status:     PayoutStatusSubmitted,
+			transition: func(p *Payout) error { return p.Settle(at) },
+			wantStatus: PayoutStatusSettled,
+		},
+		{
+			name:       "fail submitted",
+			status:     PayoutStatusSubmitted,
+			transition: func(p *Payout) error { return p.Fail("closed account", at) },
+			wantStatus: PayoutStatusFailed,
+		},
+		{
+			name:       "fail under review",
+			status:     PayoutStatusReview,
+			transition: func(p *Payout) error { return p.Fail("cancelled", at) },
+			wantStatus: PayoutStatusFailed,
+		},
+		{
+			name:       "submit under review",
+			status:     PayoutStatusReview,
+			transition: func(p *Payout) error { return p.Submit("ref-1", at) },
+			wantStatus: PayoutStatusReview,
+			wantErr:    ErrUnexpectedPayoutStatus,
+		},
+		{
+			name:       "settle requested",
+			status:     PayoutStatusRequested,
+			transition: func(p *Payout) error { return p.Settle(at) },
+			wantStatus: PayoutStatusRequested,
+			wantErr:    ErrUnexpectedPayoutStatus,
+		},
+		{
+			name:       "fail settled",
+			status:     PayoutStatusSettled,
+			transition: func(p *Payout) error { return p.Fail("closed account", at) },
+			wantStatus: PayoutStatusSettled,
+			wantErr:    ErrUnexpectedPayoutStatus,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &Payout{Status: tc.status}
+			assert.Equal(t, tc.wantErr, tc.transition(p))
+			assert.Equal(t, tc.wantStatus, p.Status)
+			if tc.wantErr == nil {
+				assert.Equal(t, at, p.UpdatedAt)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package account
+
+import (
+	"fmt"
+
+	"github.com/shopspring/decimal"
+)
+
+// BalancePolicy bounds the balance of the accounts of a user account type, an empty bound is not enforced.
+// The bounds are checked when the payments are applied, so a balance already out of them is not changed
+// by the policy, but can't be moved further away from it.
+type BalancePolicy struct {
+	// Min is the balance the payments sent by the account must leave on it.
+	Min string
+	// Max is the cap of the balance the payments received by the account must not exceed,
+	// e.g. the holding limit of the e-money wallets.
+	Max string
+}
+
+// NewBalancePolicies returns the policies of the user account types with the given minimum and maximum balances.
+func NewBalancePolicies(min, max map[string]string) (map[string]*BalancePolicy, error) {
+	policies := make(map[string]*BalancePolicy, len(min)+len(max))
+	policy := func(accountType string) (*BalancePolicy, error) {
+		if err := ValidateUserType(accountType); err != nil {
+			return nil, fmt.Errorf("account type %q: %w", accountType, err)
+		}
+		if policies[accountType] == nil {
+			policies[accountType] = &BalancePolicy{}
+		}
+		return policies[accountType], nil
+	}
+
+	for accountType, balance := range min {
+		p, err := policy(accountType)
+		if err != nil {
+			return nil, err
+		}
+		p.Min = balance
+	}
+	for accountType, balance := range max {
+		p, err := policy(accountType)
+		if err != nil {
+			return nil, err
+		}
+		p.Max = balance
+	}
+
+	for accountType, p := range policies {
+		if err := p.validate(); err != nil {
+			return nil, fmt.Errorf("balance policy of %q: %w", accountType, err)
+		}
+	}
+	return policies, nil
+}
+
+func (p *BalancePolicy) validate() error {
+	min, max := decimal.Zero, decimal.Zero
+	var err error
+	if p.Min != "" {
+		if min, err = decimal.NewFromString(p.Min); err != nil {
+			return err
+		}
+		if min.IsNegative() {
+			return ErrInvalidBalancePolicy
+		}
+	}
+	if p.Max != "" {
+		if max, err = decimal.NewFromString(p.Max); err != nil {
+			return err
+		}
+		if max.LessThan(min) || !max.IsPositive() {
+			return ErrInvalidBalancePolicy
+		}
+	}
+	return nil
+}
+
+// checkBalance checks that the balance is within both bounds, e.g. when the account gets the policy of another type.
+func (p *BalancePolicy) checkBalance(balance string) error {
+	if p == nil {
+		return nil
+	}
+	value, err := decimal.NewFromString(balance)
+	if err != nil {
+		return err
+	}
+	if err = p.checkDebit(value); err != nil {
+		return err
+	}
+	return p.checkCredit(value)
+}
+
+// checkDebit checks the balance left by a payment sent by the account.
+func (p *BalancePolicy) checkDebit(balance decimal.Decimal) error {
+	if p == nil || p.Min == "" {
+		return nil
+	}
+	min, err := decimal.NewFromString(p.Min)
+	if err != nil {
+		return err
+	}
+	if balance.LessThan(min) {
+		return ErrBelowMinBalance
+	}
+	return nil
+}
+
+// checkCredit checks the balance made by a payment received by the account.
+func (p *BalancePolicy) checkCredit(balance decimal.Decimal) error {
+	if p == nil || p.Max == "" {
+		return nil
+	}
+	max, err := decimal.NewFromString(p.Max)
+	if err != nil {
+		return err
+	}
+	if balance.GreaterThan(max) {
+		return ErrBalanceCapExceeded
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package account
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewBalancePolicies(t *testing.T) {
+	testCases := []struct {
+		name         string
+		min          map[string]string
+		max          map[string]string
+		wantPolicies map[string]*BalancePolicy
+		wantErr      error
+	}{
+		{
+			name: "min and max",
+			min:  map[string]string{TypeSavings: "100"},
+			max:  map[string]string{TypeSavings: "100000", TypeEMoney: "5000"},
+			wantPolicies: map[string]*BalancePolicy{
+				TypeSavings: {Min: "100", Max: "100000"},
+				TypeEMoney:  {Max: "5000"},
+			},
+		},
+		{
+			name:    "system account type",
+			max:     map[string]string{TypeFees: "5000"},
+			wantErr: ErrNotUserType,
+		},
+		{
+			name:    "negative min",
+			min:     map[string]string{TypeSavings: "-1"},
+			wantErr: ErrInvalidBalancePolicy,
+		},
+		{
+			name:    "max below min",
+			min:     map[string]string{TypeSavings: "100"},
+			max:     map[string]string{TypeSavings: "50"},
+			wantErr: ErrInvalidBalancePolicy,
+		},
+		{
+			name:    "invalid max",
+			max:     map[string]string{TypeEMoney: "5k"},
+			wantErr: errors.New("can't convert 5k to decimal"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := NewBalancePolicies(tc.min, tc.max)
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					assert.EqualError(t, errors.Unwrap(err), tc.wantErr.Error())
+				}
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.wantPolicies, got)
+		})
+	}
+}
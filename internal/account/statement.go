@@ -0,0 +1,76 @@
+package account
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// StatementRequest asks for the statement of the account for the period [From, To).
+type StatementRequest struct {
+	AccountID int64
+	// From is the start of the period, zero means since the account is created. The period starting
+	// before the account is created starts with its creation and opens with its initial balance.
+	From time.Time
+	// To is the end of the period, zero means until now.
+	To time.Time
+}
+
+// Statement is the summary of the account for the period.
+type Statement struct {
+	AccountID      int64
+	From           time.Time
+	To             time.Time
+	OpeningBalance string
+	// ClosingBalance is known only after all entries are read.
+	ClosingBalance string
+}
+
+// StatementEntry is a payment of the statement with the balance after it.
+type StatementEntry struct {
+	Payment *Payment
+	// Amount is signed: negative for the payments sent by the account. It sums the postings
+	// of the payment made in the period, so it is zero for a received payment still pending.
+	Amount  string
+	Balance string
+}
+
+// StatementWriter receives the statement as it is read from the storage: Begin with the
+// opening balance, Entry for every payment in the time order and End with the closing balance.
+type StatementWriter interface {
+	Begin(s *Statement) error
+	Entry(e *StatementEntry) error
+	End(s *Statement) error
+}
+
+// NewStatementEntry applies the postings the payment made to the account in the period of the statement
+// to the running balance.
+func NewStatementEntry(s *Statement, p *Payment, balance decimal.Decimal) (*StatementEntry, decimal.Decimal, error) {
+	postings, err := p.Postings(s.AccountID)
+	if err != nil {
+		return nil, balance, err
+	}
+	amount := decimal.Zero
+	for _, posting := range postings {
+		if posting.At.Before(s.From) || !posting.At.Before(s.To) {
+			continue
+		}
+		amount = amount.Add(posting.Amount)
+	}
+	balance = balance.Add(amount)
+	return &StatementEntry{
+		Payment: p,
+		Amount:  amount.StringFixed(2),
+		Balance: balance.StringFixed(2),
+	}, balance, nil
+}
+
+func ValidateStatementRequest(r *StatementRequest) error {
+	if err := ValidateAccountID(r.AccountID); err != nil {
+		return err
+	}
+	if !r.From.IsZero() && !r.To.IsZero() && !r.From.Before(r.To) {
+		return ErrInvalidStatementPeriod
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package account
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewStatementEntry(t *testing.T) {
+	day := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name        string
+		accountID   int64
+		wantAmount  string
+		wantBalance string
+		wantErr     error
+	}{
+		{
+			name:        "sent",
+			accountID:   1,
+			wantAmount:  "-100.50",
+			wantBalance: "899.50",
+		},
+		{
+			name:        "received",
+			accountID:   2,
+			wantAmount:  "100.50",
+			wantBalance: "1100.50",
+		},
+		{
+			name:        "other account",
+			accountID:   3,
+			wantBalance: "1000.00",
+			wantErr:     ErrMismatchPayment,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &Payment{ID: 1, From: 1, To: 2, Amount: "100.5", Status: PaymentStatusCompleted, CreatedAt: day}
+			s := &Statement{AccountID: tc.accountID, From: day, To: day.Add(24 * time.Hour)}
+			entry, balance, err := NewStatementEntry(s, p, decimal.NewFromInt(1000))
+			assert.Equal(t, tc.wantErr, err)
+			assert.Equal(t, tc.wantBalance, balance.StringFixed(2))
+			if err != nil {
+				return
+			}
+			assert.Equal(t, &StatementEntry{Payment: p, Amount: tc.wantAmount, Balance: tc.wantBalance}, entry)
+		})
+	}
+}
+
+func TestNewStatementEntry_Pending(t *testing.T) {
+	day := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	p := &Payment{ID: 1, From: 1, To: 2, Amount: "100", Status: PaymentStatusPending, CreatedAt: day.Add(-time.Hour)}
+
+	testCases := []struct {
+		name        string
+		accountID   int64
+		settle      func(p *Payment) error
+		wantAmount  string
+		wantBalance string
+	}{
+		{
+			name:        "sender of pending payment made before the period",
+			accountID:   1,
+			wantAmount:  "0.00",
+			wantBalance: "1000.00",
+		},
+		{
+			name:        "receiver of pending payment",
+			accountID:   2,
+			wantAmount:  "0.00",
+			wantBalance: "1000.00",
+		},
+		{
+			name:      "receiver of payment confirmed in the period",
+			accountID: 2,
+			settle: func(p *Payment) error {
+				return p.Confirm(day.Add(time.Hour))
+			},
+			wantAmount:  "100.00",
+			wantBalance: "1100.00",
+		},
+		{
+			name:      "sender of payment cancelled in the period",
+			accountID: 1,
+			settle: func(p *Payment) error {
+				return p.Cancel(day.Add(time.Hour))
+			},
+			wantAmount:  "100.00",
+			wantBalance: "1100.00",
+		},
+		{
+			name:      "receiver of cancelled payment",
+			accountID: 2,
+			settle: func(p *Payment) error {
+				return p.Cancel(day.Add(time.Hour))
+			},
+			wantAmount:  "0.00",
+			wantBalance: "1000.00",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := *p
+			if tc.settle != nil {
+				assert.NoError(t, tc.settle(&p))
+			}
+			s := &Statement{AccountID: tc.accountID, From: day, To: day.Add(24 * time.Hour)}
+			entry, balance, err := NewStatementEntry(s, &p, decimal.NewFromInt(1000))
+			assert.NoError(t, err)
+			assert.Equal(t, tc.wantBalance, balance.StringFixed(2))
+			assert.Equal(t, tc.wantAmount, entry.Amount)
+		})
+	}
+}
+
+func TestValidateStatementRequest(t *testing.T) {
+	day := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name    string
+		request *StatementRequest
+		wantErr error
+	}{
+		{
+			name:    "whole history",
+			request: &StatementRequest{AccountID: 1},
+		},
+		{
+			name:    "month",
+			request: &StatementRequest{AccountID: 1, From: day, To: day.AddDate(0, 1, 0)},
+		},
+		{
+			name:    "invalid account",
+			request: &StatementRequest{AccountID: 0},
+			wantErr: ErrMustBePositive,
+		},
+		{
+			name:    "empty period",
+			request: &StatementRequest{AccountID: 1, From: day, To: day},
+			wantErr: ErrInvalidStatementPeriod,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.wantErr, ValidateStatementRequest(tc.request))
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package account
+
+// System accounts are the counterparties of the money entering or leaving the user accounts. They have
+// negative ids so that they never collide with the user accounts, are created with zero balance on the first
+// payment and may go negative. The total balance of all accounts is the sum of their initial balances, so the
+// balances of the system accounts explain how the balance of the user accounts is changed.
+const (
+	// SystemAccountAdjustments is the counterparty of the approved balance adjustments.
+	SystemAccountAdjustments int64 = -1
+	// SystemAccountFunding pays the deposits, its negative balance is the money deposited from the outside.
+	SystemAccountFunding int64 = -2
+	// SystemAccountPayouts receives the withdrawals, its balance is the money paid out.
+	SystemAccountPayouts int64 = -3
+	// SystemAccountFees receives the fees of the withdrawals.
+	SystemAccountFees int64 = -4
+	// SystemAccountInterest pays the interest of the savings accounts, its negative balance is the interest expense.
+	SystemAccountInterest int64 = -5
+	// SystemAccountOpeningBalances pays the opening balances of the imported accounts.
+	SystemAccountOpeningBalances int64 = -6
+)
+
+// Account types.
+const (
+	TypeUser = "user"
+	// TypeSavings is a user account accruing interest.
+	TypeSavings = "savings"
+	// TypeEMoney is a user account holding the e-money, its balance is usually capped by the regulations.
+	TypeEMoney          = "emoney"
+	TypeAdjustments     = "adjustments"
+	TypeFunding         = "funding"
+	TypePayouts         = "payouts"
+	TypeFees            = "fees"
+	TypeInterest        = "interest"
+	TypeOpeningBalances = "opening_balances"
+)
+
+// userAccountTypes are the types the user accounts may have, TypeUser is the default one.
+var userAccountTypes = []string{TypeUser, TypeSavings, TypeEMoney}
+
+// systemAccountTypes are the types of the system accounts by their ids.
+var systemAccountTypes = map[int64]string{
+	SystemAccountAdjustments:     TypeAdjustments,
+	SystemAccountFunding:         TypeFunding,
+	SystemAccountPayouts:         TypePayouts,
+	SystemAccountFees:            TypeFees,
+	SystemAccountInterest:        TypeInterest,
+	SystemAccountOpeningBalances: TypeOpeningBalances,
+}
+
+// IsSystemAccount reports whether the id is of a system account.
+func IsSystemAccount(id int64) bool {
+	return id < 0
+}
+
+// ValidateType checks that the type is known.
+func ValidateType(t string) error {
+	if ValidateUserType(t) == nil {
+		return nil
+	}
+	for _, systemType := range systemAccountTypes {
+		if t == systemType {
+			return nil
+		}
+	}
+	return ErrUnknownType
+}
+
+// ValidateUserType checks that the type is one of the user account types.
+func ValidateUserType(t string) error {
+	for _, userType := range userAccountTypes {
+		if t == userType {
+			return nil
+		}
+	}
+	return ErrNotUserType
+}
@@ -0,0 +1,167 @@
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Actions recorded in the audit log.
+const (
+	ActionPayment    = "payment"
+	ActionRefund     = "refund"
+	ActionAdjustment = "adjustment"
+	ActionInterest   = "interest"
+	ActionImport     = "import"
+	// ActionUpdate is a change of the account details, e.g. of its type, described by the entry details.
+	ActionUpdate = "update"
+)
+
+// Entry is a record of a single account mutation. The entries of every account form a hash chain:
+// every entry contains the hash of the previous entry of the account, so that changing or removing
+// an entry breaks the chain. The chains are per account, so that they are appended under the locks
+// of the changed accounts without serializing the changes of the other accounts.
+type Entry struct {
+	tableName struct{} `pg:"audit_log"`
+	// Seq orders all the entries, it is assigned by the storage and is not hashed as it may have gaps.
+	Seq int64 `pg:"seq,pk"`
+	// AccountSeq is the number of the entry in the chain of the account starting from 1.
+	AccountSeq    int64     `pg:"account_seq"`
+	Action        string    `pg:"action"`
+	Actor         string    `pg:"actor"`
+	RequestID     string    `pg:"request_id"`
+	AccountID     int64     `pg:"account_id"`
+	PaymentID     int64     `pg:"payment_id"`
+	BalanceBefore string    `pg:"balance_before"`
+	BalanceAfter  string    `pg:"balance_after"`
+	Details       string    `pg:"details"`
+	CreatedAt     time.Time `pg:"created_at"`
+	PrevHash      string    `pg:"prev_hash"`
+	Hash          string    `pg:"hash"`
+}
+
+// hashedEntry fixes the hashed fields and their order.
+type hashedEntry struct {
+	AccountSeq    int64
+	Action        string
+	Actor         string
+	RequestID     string
+	AccountID     int64
+	PaymentID     int64
+	BalanceBefore string
+	BalanceAfter  string
+	Details       string
+	CreatedAt     string
+	PrevHash      string
+}
+
+// ComputeHash returns the hash of the entry content linked to its PrevHash.
+func (e *Entry) ComputeHash() string {
+	b, _ := json.Marshal(hashedEntry{
+		AccountSeq:    e.AccountSeq,
+		Action:        e.Action,
+		Actor:         e.Actor,
+		RequestID:     e.RequestID,
+		AccountID:     e.AccountID,
+		PaymentID:     e.PaymentID,
+		BalanceBefore: e.BalanceBefore,
+		BalanceAfter:  e.BalanceAfter,
+		Details:       e.Details,
+		CreatedAt:     e.CreatedAt.UTC().Format(time.RFC3339Nano),
+		PrevHash:      e.PrevHash,
+	})
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// Chain links the entries to the chains of their accounts given the last entries of the chains by account id,
+// assigning their numbers in the chains and their hashes. The chain of an account without the last entry starts
+// with the first of its entries. The last entries are replaced with the chained ones.
+func Chain(last map[int64]*Entry, entries []*Entry) {
+	for _, e := range entries {
+		var accountSeq int64
+		var prevHash string
+		if prev, ok := last[e.AccountID]; ok {
+			accountSeq, prevHash = prev.AccountSeq, prev.Hash
+		}
+		e.AccountSeq = accountSeq + 1
+		// postgres keeps timestamps with microsecond precision.
+		e.CreatedAt = e.CreatedAt.Truncate(time.Microsecond).UTC()
+		e.PrevHash = prevHash
+		e.Hash = e.ComputeHash()
+		last[e.AccountID] = e
+	}
+}
+
+// Break describes an entry that does not fit into the chain.
+type Break struct {
+	Seq    int64
+	Reason string
+}
+
+func (b Break) String() string {
+	return fmt.Sprintf("entry %d: %s", b.Seq, b.Reason)
+}
+
+// Source reads the audit log in the sequence order.
+type Source interface {
+	GetAuditEntries(ctx context.Context, afterSeq int64, limit int) ([]*Entry, error)
+}
+
+// Report is the result of the chain verification.
+type Report struct {
+	Entries int64
+	Breaks  []Break
+}
+
+// Verify walks the whole audit log in batches of the given size and reports the breaks of the chains of
+// the accounts. The entries of an account are appended in the order of their chain, so the log is read in
+// the sequence order keeping the last entry of every account. After a break the verification continues
+// from the broken entry, so that every break is reported.
+func Verify(ctx context.Context, src Source, batchSize int) (*Report, error) {
+	report := &Report{}
+	type chainHead struct {
+		accountSeq int64
+		hash       string
+	}
+	heads := make(map[int64]chainHead)
+	var lastSeq int64
+	for {
+		entries, err := src.GetAuditEntries(ctx, lastSeq, batchSize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get audit entries after %d: %w", lastSeq, err)
+		}
+
+		for _, e := range entries {
+			report.Entries++
+			head := heads[e.AccountID]
+			switch {
+			case e.AccountSeq > head.accountSeq+1:
+				report.Breaks = append(report.Breaks, Break{
+					Seq:    e.Seq,
+					Reason: fmt.Sprintf("missing entries %d-%d of account %d", head.accountSeq+1, e.AccountSeq-1, e.AccountID),
+				})
+			case e.AccountSeq <= head.accountSeq:
+				report.Breaks = append(report.Breaks, Break{
+					Seq:    e.Seq,
+					Reason: fmt.Sprintf("entry %d of account %d is out of order", e.AccountSeq, e.AccountID),
+				})
+			}
+			if e.PrevHash != head.hash {
+				report.Breaks = append(report.Breaks, Break{Seq: e.Seq, Reason: "previous hash mismatch"})
+			}
+			if e.ComputeHash() != e.Hash {
+				report.Breaks = append(report.Breaks, Break{Seq: e.Seq, Reason: "hash mismatch"})
+			}
+			heads[e.AccountID] = chainHead{accountSeq: e.AccountSeq, hash: e.Hash}
+			lastSeq = e.Seq
+		}
+
+		if len(entries) < batchSize {
+			return report, nil
+		}
+	}
+}
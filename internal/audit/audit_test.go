@@ -0,0 +1,134 @@
+package audit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type sourceMock []*Entry
+
+func (m sourceMock) GetAuditEntries(ctx context.Context, afterSeq int64, limit int) ([]*Entry, error) {
+	var out []*Entry
+	for _, e := range m {
+		if e.Seq > afterSeq && len(out) < limit {
+			out = append(out, e)
+		}
+	}
+	return out, nil
+}
+
+// makeChain returns the entries of the accounts 1 and 2 taking turns, chained in two appends.
+func makeChain(t *testing.T, n int) []*Entry {
+	t.Helper()
+	entries := make([]*Entry, n)
+	for i := range entries {
+		entries[i] = &Entry{
+			Seq:           int64(i + 1),
+			Action:        ActionPayment,
+			Actor:         "apikey:1",
+			RequestID:     "req",
+			AccountID:     int64(i%2 + 1),
+			PaymentID:     int64(i/2 + 1),
+			BalanceBefore: "1000.00",
+			BalanceAfter:  "900.00",
+			CreatedAt:     time.Date(2021, 6, 1, 12, 0, 0, 123456789, time.UTC),
+		}
+	}
+	last := make(map[int64]*Entry)
+	Chain(last, entries[:n/2])
+	Chain(last, entries[n/2:])
+	return entries
+}
+
+func TestVerify(t *testing.T) {
+	testCases := []struct {
+		name       string
+		tamper     func(entries []*Entry) []*Entry
+		wantBreaks []Break
+	}{
+		{
+			name:   "intact",
+			tamper: func(entries []*Entry) []*Entry { return entries },
+		},
+		{
+			name: "sequence gap",
+			tamper: func(entries []*Entry) []*Entry {
+				for _, e := range entries[4:] {
+					e.Seq += 10
+				}
+				return entries
+			},
+		},
+		{
+			name: "changed balance",
+			tamper: func(entries []*Entry) []*Entry {
+				entries[3].BalanceAfter = "100000.00"
+				return entries
+			},
+			wantBreaks: []Break{{Seq: 4, Reason: "hash mismatch"}},
+		},
+		{
+			name: "rehashed entry",
+			tamper: func(entries []*Entry) []*Entry {
+				entries[3].BalanceAfter = "100000.00"
+				entries[3].Hash = entries[3].ComputeHash()
+				return entries
+			},
+			wantBreaks: []Break{{Seq: 6, Reason: "previous hash mismatch"}},
+		},
+		{
+			name: "removed entry",
+			tamper: func(entries []*Entry) []*Entry {
+				return append(entries[:2:2], entries[3:]...)
+			},
+			wantBreaks: []Break{
+				{Seq: 5, Reason: "missing entries 2-2 of account 1"},
+				{Seq: 5, Reason: "previous hash mismatch"},
+			},
+		},
+		{
+			name: "reordered entries",
+			tamper: func(entries []*Entry) []*Entry {
+				entries[2].Seq, entries[4].Seq = entries[4].Seq, entries[2].Seq
+				entries[2], entries[4] = entries[4], entries[2]
+				return entries
+			},
+			wantBreaks: []Break{
+				{Seq: 3, Reason: "missing entries 2-2 of account 1"},
+				{Seq: 3, Reason: "previous hash mismatch"},
+				{Seq: 5, Reason: "entry 2 of account 1 is out of order"},
+				{Seq: 5, Reason: "previous hash mismatch"},
+				{Seq: 7, Reason: "missing entries 3-3 of account 1"},
+				{Seq: 7, Reason: "previous hash mismatch"},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			entries := tc.tamper(makeChain(t, 10))
+
+			report, err := Verify(context.Background(), sourceMock(entries), 3)
+			require.NoError(t, err)
+			assert.Equal(t, int64(len(entries)), report.Entries)
+			assert.Equal(t, tc.wantBreaks, report.Breaks)
+		})
+	}
+}
+
+func TestChain(t *testing.T) {
+	entries := makeChain(t, 6)
+	for i, e := range entries {
+		assert.Equal(t, int64(i/2+1), e.AccountSeq)
+		assert.Equal(t, 123456000, e.CreatedAt.Nanosecond())
+		if i > 1 {
+			assert.Equal(t, entries[i-2].Hash, e.PrevHash)
+		}
+	}
+	assert.Empty(t, entries[0].PrevHash)
+	assert.Empty(t, entries[1].PrevHash)
+}
@@ -0,0 +1,41 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"time"
+)
+
+// APIKey is a stored API key. Only the SHA-256 hash of the key is kept.
+type APIKey struct {
+	tableName  struct{}  `pg:"api_keys"`
+	ID         int64     `pg:"id"`
+	Name       string    `pg:"name"`
+	KeyHash    string    `pg:"key_hash"`
+	Scopes     []string  `pg:"scopes,array"`
+	AccountIDs []int64   `pg:"account_ids,array"`
+	CreatedAt  time.Time `pg:"created_at"`
+	RevokedAt  time.Time `pg:"revoked_at"`
+}
+
+// Principal returns the principal authenticated by the key.
+func (k *APIKey) Principal() *Principal {
+	return &Principal{
+		Subject:    "apikey:" + strconv.FormatInt(k.ID, 10),
+		Scopes:     k.Scopes,
+		AccountIDs: k.AccountIDs,
+	}
+}
+
+// KeyStore looks up API keys by their hash.
+type KeyStore interface {
+	GetAPIKey(ctx context.Context, keyHash string) (*APIKey, error)
+}
+
+// HashAPIKey returns the hex-encoded SHA-256 hash of the key.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,147 @@
+package auth
+
+import (
+	"context"
+	"errors"
+)
+
+// Scopes that can be granted to API keys and tokens.
+const (
+	ScopeAccountsRead  = "accounts:read"
+	ScopeAccountsWrite = "accounts:write"
+	ScopePaymentsRead  = "payments:read"
+	ScopePaymentsWrite = "payments:write"
+	// ScopePaymentsReview allows the caller to confirm and cancel the pending payments of any account.
+	ScopePaymentsReview = "payments:review"
+	// ScopeDeposits allows the caller to deposit the money to any account, it is granted to the payment gateways.
+	ScopeDeposits = "deposits:write"
+	// ScopeAdjustments allows the caller to propose, review and read the balance adjustments of any account.
+	ScopeAdjustments = "adjustments:admin"
+	// ScopeAccountTypes allows the caller to change the type of the accounts, e.g. to make them accrue interest.
+	ScopeAccountTypes = "accounts:type"
+
+	// ScopeAllAccounts allows the caller to debit any account, not only the owned ones.
+	ScopeAllAccounts = "accounts:all"
+)
+
+// auth errors.
+var (
+	ErrNoCredentials      = errors.New("no credentials provided")
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrKeyNotFound        = errors.New("api key is not found")
+)
+
+// Principal is an authenticated caller.
+type Principal struct {
+	Subject    string
+	Scopes     []string
+	AccountIDs []int64
+}
+
+// HasScope reports whether the principal is granted the given scope.
+func (p *Principal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// OwnsAccount reports whether the principal may debit the given account.
+func (p *Principal) OwnsAccount(id int64) bool {
+	if p.HasScope(ScopeAllAccounts) {
+		return true
+	}
+	for _, accountID := range p.AccountIDs {
+		if accountID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Credentials are the raw credentials presented by a caller.
+type Credentials struct {
+	APIKey      string
+	BearerToken string
+}
+
+// Authenticator authenticates callers by their credentials.
+type Authenticator interface {
+	Authenticate(ctx context.Context, c Credentials) (*Principal, error)
+}
+
+// AuthenticatorFunc is an adapter to allow the use of ordinary functions as Authenticator.
+type AuthenticatorFunc func(ctx context.Context, c Credentials) (*Principal, error)
+
+// Authenticate calls f(ctx, c).
+func (f AuthenticatorFunc) Authenticate(ctx context.Context, c Credentials) (*Principal, error) {
+	return f(ctx, c)
+}
+
+type authenticator struct {
+	keys      KeyStore
+	jwtSecret []byte
+}
+
+// NewAuthenticator creates an Authenticator that accepts API keys from the given store
+// and HS256-signed JWT bearer tokens. Bearer tokens are rejected if jwtSecret is empty.
+func NewAuthenticator(keys KeyStore, jwtSecret []byte) Authenticator {
+	return &authenticator{
+		keys:      keys,
+		jwtSecret: jwtSecret,
+	}
+}
+
+func (a *authenticator) Authenticate(ctx context.Context, c Credentials) (*Principal, error) {
+	switch {
+	case c.APIKey != "":
+		return a.authenticateAPIKey(ctx, c.APIKey)
+	case c.BearerToken != "":
+		if len(a.jwtSecret) == 0 {
+			return nil, ErrInvalidCredentials
+		}
+		return parseToken(c.BearerToken, a.jwtSecret)
+	default:
+		return nil, ErrNoCredentials
+	}
+}
+
+func (a *authenticator) authenticateAPIKey(ctx context.Context, key string) (*Principal, error) {
+	k, err := a.keys.GetAPIKey(ctx, HashAPIKey(key))
+	if err != nil {
+		if errors.Is(err, ErrKeyNotFound) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+	if !k.RevokedAt.IsZero() {
+		return nil, ErrInvalidCredentials
+	}
+	return k.Principal(), nil
+}
+
+type contextKey int
+
+const (
+	principalContextKey contextKey = iota
+	credentialsContextKey
+)
+
+// NewContext returns a new context that carries the given principal.
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalContextKey, p)
+}
+
+// FromContext returns the principal stored in ctx, if any.
+func FromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalContextKey).(*Principal)
+	return p, ok
+}
+
+// CredentialsFromContext returns the credentials stored in ctx by HTTPToContext.
+func CredentialsFromContext(ctx context.Context) Credentials {
+	c, _ := ctx.Value(credentialsContextKey).(Credentials)
+	return c
+}
@@ -0,0 +1,151 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+type keyStoreMock struct {
+	keys map[string]*APIKey
+}
+
+func (m *keyStoreMock) GetAPIKey(ctx context.Context, keyHash string) (*APIKey, error) {
+	k, ok := m.keys[keyHash]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return k, nil
+}
+
+func TestAuthenticator_Authenticate(t *testing.T) {
+	secret := []byte("secret")
+	keys := &keyStoreMock{
+		keys: map[string]*APIKey{
+			HashAPIKey("valid"): {
+				ID:         1,
+				Scopes:     []string{ScopePaymentsWrite},
+				AccountIDs: []int64{1, 2},
+			},
+			HashAPIKey("revoked"): {
+				ID:        2,
+				RevokedAt: time.Now(),
+			},
+		},
+	}
+
+	testCases := []struct {
+		name          string
+		credentials   Credentials
+		wantPrincipal *Principal
+		wantErr       error
+	}{
+		{
+			name:        "api key",
+			credentials: Credentials{APIKey: "valid"},
+			wantPrincipal: &Principal{
+				Subject:    "apikey:1",
+				Scopes:     []string{ScopePaymentsWrite},
+				AccountIDs: []int64{1, 2},
+			},
+		},
+		{
+			name:        "unknown api key",
+			credentials: Credentials{APIKey: "unknown"},
+			wantErr:     ErrInvalidCredentials,
+		},
+		{
+			name:        "revoked api key",
+			credentials: Credentials{APIKey: "revoked"},
+			wantErr:     ErrInvalidCredentials,
+		},
+		{
+			name: "bearer token",
+			credentials: Credentials{BearerToken: makeToken(t, secret, &Claims{
+				RegisteredClaims: jwt.RegisteredClaims{
+					Subject:   "user-1",
+					ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+				},
+				Scope:    "accounts:read payments:write",
+				Accounts: []int64{1},
+			})},
+			wantPrincipal: &Principal{
+				Subject:    "jwt:user-1",
+				Scopes:     []string{ScopeAccountsRead, ScopePaymentsWrite},
+				AccountIDs: []int64{1},
+			},
+		},
+		{
+			name: "token with the subject of an api key",
+			credentials: Credentials{BearerToken: makeToken(t, secret, &Claims{
+				RegisteredClaims: jwt.RegisteredClaims{
+					Subject:   "apikey:1",
+					ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+				},
+			})},
+			wantPrincipal: &Principal{
+				Subject: "jwt:apikey:1",
+				Scopes:  []string{},
+			},
+		},
+		{
+			name: "expired token",
+			credentials: Credentials{BearerToken: makeToken(t, secret, &Claims{
+				RegisteredClaims: jwt.RegisteredClaims{
+					Subject:   "user-1",
+					ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Hour)),
+				},
+			})},
+			wantErr: ErrInvalidCredentials,
+		},
+		{
+			name: "token signed with another secret",
+			credentials: Credentials{BearerToken: makeToken(t, []byte("other"), &Claims{
+				RegisteredClaims: jwt.RegisteredClaims{
+					Subject: "user-1",
+				},
+			})},
+			wantErr: ErrInvalidCredentials,
+		},
+		{
+			name:        "no credentials",
+			credentials: Credentials{},
+			wantErr:     ErrNoCredentials,
+		},
+	}
+
+	a := NewAuthenticator(keys, secret)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, gotErr := a.Authenticate(context.Background(), tc.credentials)
+			assert.Equal(t, tc.wantPrincipal, got)
+			if tc.wantErr == nil && gotErr != nil {
+				t.Fatalf("unexpected error: %v", gotErr)
+			}
+			if tc.wantErr != nil && !errors.Is(gotErr, tc.wantErr) {
+				t.Fatalf("want error %v, got %v", tc.wantErr, gotErr)
+			}
+		})
+	}
+}
+
+func TestPrincipal_OwnsAccount(t *testing.T) {
+	p := &Principal{AccountIDs: []int64{1}}
+	assert.True(t, p.OwnsAccount(1))
+	assert.False(t, p.OwnsAccount(2))
+
+	p.Scopes = []string{ScopeAllAccounts}
+	assert.True(t, p.OwnsAccount(2))
+}
+
+func makeToken(t *testing.T, secret []byte, c *Claims) string {
+	token, err := NewToken(c, secret)
+	if err != nil {
+		t.Fatalf("makeToken: %v", err)
+	}
+	return token
+}
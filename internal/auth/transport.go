@@ -0,0 +1,34 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// APIKeyHeader is the HTTP header carrying an API key.
+const APIKeyHeader = "X-API-Key"
+
+// HTTPToContext moves the credentials found in the request headers into the context.
+func HTTPToContext(ctx context.Context, r *http.Request) context.Context {
+	c := Credentials{
+		APIKey: r.Header.Get(APIKeyHeader),
+	}
+	if h := r.Header.Get("Authorization"); len(h) > 7 && strings.EqualFold(h[:7], "bearer ") {
+		c.BearerToken = strings.TrimSpace(h[7:])
+	}
+	return context.WithValue(ctx, credentialsContextKey, c)
+}
+
+// ContextToHTTP returns a request function that sets the given credentials on outgoing requests.
+func ContextToHTTP(c Credentials) func(ctx context.Context, r *http.Request) context.Context {
+	return func(ctx context.Context, r *http.Request) context.Context {
+		if c.APIKey != "" {
+			r.Header.Set(APIKeyHeader, c.APIKey)
+		}
+		if c.BearerToken != "" {
+			r.Header.Set("Authorization", "Bearer "+c.BearerToken)
+		}
+		return ctx
+	}
+}
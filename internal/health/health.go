@@ -0,0 +1,138 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Statuses reported by the handlers.
+const (
+	StatusOK          = "ok"
+	StatusUnavailable = "unavailable"
+	StatusDraining    = "draining"
+)
+
+// Checker checks a dependency of the service.
+type Checker interface {
+	Check(ctx context.Context) error
+}
+
+// CheckerFunc is an adapter to use an ordinary function as a Checker.
+type CheckerFunc func(ctx context.Context) error
+
+// Check calls f(ctx).
+func (f CheckerFunc) Check(ctx context.Context) error {
+	return f(ctx)
+}
+
+// Registry holds the dependency checks that decide whether the service is ready to serve traffic.
+type Registry struct {
+	timeout  time.Duration
+	draining int32
+
+	mu       sync.RWMutex
+	checkers map[string]Checker
+}
+
+// NewRegistry creates a new registry, every check is limited with the given timeout.
+func NewRegistry(timeout time.Duration) *Registry {
+	return &Registry{
+		timeout:  timeout,
+		checkers: make(map[string]Checker),
+	}
+}
+
+// Register adds a named check, a check with the same name is replaced.
+func (r *Registry) Register(name string, c Checker) {
+	r.mu.Lock()
+	r.checkers[name] = c
+	r.mu.Unlock()
+}
+
+// SetDraining marks the service as shutting down, it is not ready from then on.
+func (r *Registry) SetDraining() {
+	atomic.StoreInt32(&r.draining, 1)
+}
+
+// Draining tells whether the service is shutting down.
+func (r *Registry) Draining() bool {
+	return atomic.LoadInt32(&r.draining) == 1
+}
+
+// Report is the result of the checks.
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// Check runs all checks concurrently and reports their results.
+func (r *Registry) Check(ctx context.Context) Report {
+	if r.Draining() {
+		return Report{Status: StatusDraining}
+	}
+
+	r.mu.RLock()
+	names := make([]string, 0, len(r.checkers))
+	for name := range r.checkers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	checkers := make([]Checker, len(names))
+	for i, name := range names {
+		checkers[i] = r.checkers[name]
+	}
+	r.mu.RUnlock()
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	errs := make([]error, len(checkers))
+	var wg sync.WaitGroup
+	for i, c := range checkers {
+		wg.Add(1)
+		go func(i int, c Checker) {
+			defer wg.Done()
+			errs[i] = c.Check(ctx)
+		}(i, c)
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusOK, Checks: make(map[string]string, len(names))}
+	for i, name := range names {
+		report.Checks[name] = StatusOK
+		if errs[i] != nil {
+			report.Status = StatusUnavailable
+			report.Checks[name] = errs[i].Error()
+		}
+	}
+	return report
+}
+
+// LivenessHandler reports that the process is up, it does not run the checks
+// so that a failing dependency does not get the service restarted.
+func (r *Registry) LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		writeReport(w, Report{Status: StatusOK})
+	})
+}
+
+// ReadinessHandler runs the checks and responds with 503 if any of them fails or the service is draining.
+func (r *Registry) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		writeReport(w, r.Check(req.Context()))
+	})
+}
+
+func writeReport(w http.ResponseWriter, report Report) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if report.Status != StatusOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,93 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadinessHandler(t *testing.T) {
+	ok := CheckerFunc(func(ctx context.Context) error {
+		return nil
+	})
+	failing := CheckerFunc(func(ctx context.Context) error {
+		return errors.New("connection refused")
+	})
+	slow := CheckerFunc(func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	testCases := []struct {
+		name       string
+		checkers   map[string]Checker
+		draining   bool
+		wantCode   int
+		wantReport Report
+	}{
+		{
+			name:       "no checks",
+			wantCode:   http.StatusOK,
+			wantReport: Report{Status: StatusOK},
+		},
+		{
+			name:       "all checks pass",
+			checkers:   map[string]Checker{"postgres": ok, "cache": ok},
+			wantCode:   http.StatusOK,
+			wantReport: Report{Status: StatusOK, Checks: map[string]string{"postgres": StatusOK, "cache": StatusOK}},
+		},
+		{
+			name:       "check fails",
+			checkers:   map[string]Checker{"postgres": failing, "cache": ok},
+			wantCode:   http.StatusServiceUnavailable,
+			wantReport: Report{Status: StatusUnavailable, Checks: map[string]string{"postgres": "connection refused", "cache": StatusOK}},
+		},
+		{
+			name:       "check times out",
+			checkers:   map[string]Checker{"postgres": slow},
+			wantCode:   http.StatusServiceUnavailable,
+			wantReport: Report{Status: StatusUnavailable, Checks: map[string]string{"postgres": context.DeadlineExceeded.Error()}},
+		},
+		{
+			name:       "draining",
+			checkers:   map[string]Checker{"postgres": ok},
+			draining:   true,
+			wantCode:   http.StatusServiceUnavailable,
+			wantReport: Report{Status: StatusDraining},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := NewRegistry(10 * time.Millisecond)
+			for name, c := range tc.checkers {
+				r.Register(name, c)
+			}
+			if tc.draining {
+				r.SetDraining()
+			}
+
+			rec := httptest.NewRecorder()
+			r.ReadinessHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			assert.Equal(t, tc.wantCode, rec.Code)
+
+			var report Report
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&report))
+			if len(report.Checks) == 0 {
+				report.Checks = nil
+			}
+			assert.Equal(t, tc.wantReport, report)
+
+			rec = httptest.NewRecorder()
+			r.LivenessHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+			assert.Equal(t, http.StatusOK, rec.Code)
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package importfile
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shkov/wallet-service/internal/account"
+)
+
+// File formats.
+const (
+	FormatCSV   = "csv"
+	FormatJSONL = "jsonl"
+)
+
+// maxLineLength limits a line of a JSONL file.
+const maxLineLength = 1 << 20
+
+// CSV columns, every column but id is optional.
+const (
+	columnID             = "id"
+	columnType           = "type"
+	columnStatus         = "status"
+	columnOwnerRef       = "owner_ref"
+	columnDisplayName    = "display_name"
+	columnMetadata       = "metadata"
+	columnOpeningBalance = "opening_balance"
+	columnCreatedAt      = "created_at"
+)
+
+var columns = map[string]bool{
+	columnID:             true,
+	columnType:           true,
+	columnStatus:         true,
+	columnOwnerRef:       true,
+	columnDisplayName:    true,
+	columnMetadata:       true,
+	columnOpeningBalance: true,
+	columnCreatedAt:      true,
+}
+
+// Record is a row of the file.
+type Record struct {
+	// Line is the line of the row, a CSV row with multi-line fields counts as one line.
+	Line int
+	Row  *account.ImportRow
+	// Err is the reason the row failed to be parsed, the other rows are still read.
+	Err error
+}
+
+// Reader reads the rows of an import file.
+type Reader interface {
+	// Read returns the next row of the file or io.EOF after the last one.
+	Read() (*Record, error)
+}
+
+// FormatOf returns the format of the file by its extension.
+func FormatOf(path string) (string, error) {
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".csv":
+		return FormatCSV, nil
+	case ".jsonl":
+		return FormatJSONL, nil
+	default:
+		return "", fmt.Errorf("unknown file extension %q, expected .csv or .jsonl", ext)
+	}
+}
+
+// NewReader returns the reader of the file in the given format. A CSV file starts with the header naming
+// its columns, the metadata column holds a JSON object. A JSONL file has a JSON object on every line.
+func NewReader(r io.Reader, format string) (Reader, error) {
+	switch format {
+	case FormatCSV:
+		return newCSVReader(r)
+	case FormatJSONL:
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 0, 64*1024), maxLineLength)
+		return &jsonlReader{scanner: scanner}, nil
+	default:
+		return nil, fmt.Errorf("unknown file format %q", format)
+	}
+}
+
+type csvReader struct {
+	r      *csv.Reader
+	header []string
+	line   int
+}
+
+func newCSVReader(r io.Reader) (*csvReader, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+
+	hasID := false
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !columns[name] {
+			return nil, fmt.Errorf("unknown column %q", name)
+		}
+		hasID = hasID || name == columnID
+		header[i] = name
+	}
+	if !hasID {
+		return nil, errors.New("id column is missing")
+	}
+	return &csvReader{r: cr, header: header, line: 1}, nil
+}
+
+func (c *csvReader) Read() (*Record, error) {
+	fields, err := c.r.Read()
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	c.line++
+	if err != nil {
+		var parseErr *csv.ParseError
+		if !errors.As(err, &parseErr) {
+			return nil, err
+		}
+		return &Record{Line: c.line, Err: parseErr.Err}, nil
+	}
+
+	row := &account.ImportRow{}
+	for i, value := range fields {
+		if err = setColumn(row, c.header[i], strings.TrimSpace(value)); err != nil {
+			return &Record{Line: c.line, Err: fmt.Errorf("%s: %w", c.header[i], err)}, nil
+		}
+	}
+	return &Record{Line: c.line, Row: row}, nil
+}
+
+func setColumn(row *account.ImportRow, column, value string) error {
+	var err error
+	switch column {
+	case columnID:
+		row.ID, err = strconv.ParseInt(value, 10, 64)
+	case columnType:
+		row.Type = value
+	case columnStatus:
+		row.Status = value
+	case columnOwnerRef:
+		row.OwnerRef = value
+	case columnDisplayName:
+		row.DisplayName = value
+	case columnMetadata:
+		if value != "" {
+			err = json.Unmarshal([]byte(value), &row.Metadata)
+		}
+	case columnOpeningBalance:
+		row.OpeningBalance = value
+	case columnCreatedAt:
+		if value != "" {
+			row.CreatedAt, err = time.Parse(time.RFC3339, value)
+		}
+	}
+	return err
+}
+
+type jsonlReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+// jsonlRow is a row of a JSONL file, the opening balance may be a number or a string.
+type jsonlRow struct {
+	ID             int64             `json:"id"`
+	Type           string            `json:"type"`
+	Status         string            `json:"status"`
+	OwnerRef       string            `json:"owner_ref"`
+	DisplayName    string            `json:"display_name"`
+	Metadata       map[string]string `json:"metadata"`
+	OpeningBalance json.Number       `json:"opening_balance"`
+	CreatedAt      time.Time         `json:"created_at"`
+}
+
+func (j *jsonlReader) Read() (*Record, error) {
+	for j.scanner.Scan() {
+		j.line++
+		line := bytes.TrimSpace(j.scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var r jsonlRow
+		dec := json.NewDecoder(bytes.NewReader(line))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&r); err != nil {
+			return &Record{Line: j.line, Err: err}, nil
+		}
+		return &Record{Line: j.line, Row: &account.ImportRow{
+			ID:             r.ID,
+			Type:           r.Type,
+			Status:         r.Status,
+			OwnerRef:       r.OwnerRef,
+			DisplayName:    r.DisplayName,
+			Metadata:       r.Metadata,
+			OpeningBalance: r.OpeningBalance.String(),
+			CreatedAt:      r.CreatedAt,
+		}}, nil
+	}
+	if err := j.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
@@ -0,0 +1,105 @@
+package importfile
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/shkov/wallet-service/internal/account"
+)
+
+func readAll(t *testing.T, r Reader) []*Record {
+	var records []*Record
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return records
+		}
+		require.NoError(t, err)
+		records = append(records, rec)
+	}
+}
+
+func TestReader_CSV(t *testing.T) {
+	in := `id,type,status,display_name,metadata,opening_balance,created_at
+1,savings,active,Alice,"{""tier"":""gold""}",10.50,2019-05-01T00:00:00Z
+x,,,,,,
+2,,,Bob,,,
+3,,
+4,,,,not json,,
+`
+	r, err := NewReader(strings.NewReader(in), FormatCSV)
+	require.NoError(t, err)
+	records := readAll(t, r)
+	require.Len(t, records, 5)
+
+	assert.Equal(t, &Record{Line: 2, Row: &account.ImportRow{
+		ID:             1,
+		Type:           account.TypeSavings,
+		Status:         account.StatusActive,
+		DisplayName:    "Alice",
+		Metadata:       map[string]string{"tier": "gold"},
+		OpeningBalance: "10.50",
+		CreatedAt:      time.Date(2019, 5, 1, 0, 0, 0, 0, time.UTC),
+	}}, records[0])
+	assert.Equal(t, 3, records[1].Line)
+	assert.Error(t, records[1].Err)
+	assert.Equal(t, &Record{Line: 4, Row: &account.ImportRow{ID: 2, DisplayName: "Bob"}}, records[2])
+	assert.Equal(t, 5, records[3].Line)
+	assert.Error(t, records[3].Err)
+	assert.Equal(t, 6, records[4].Line)
+	assert.Error(t, records[4].Err)
+}
+
+func TestReader_CSVHeader(t *testing.T) {
+	_, err := NewReader(strings.NewReader("display_name\nAlice\n"), FormatCSV)
+	assert.Error(t, err)
+
+	_, err = NewReader(strings.NewReader("id,balance\n1,10\n"), FormatCSV)
+	assert.Error(t, err)
+}
+
+func TestReader_JSONL(t *testing.T) {
+	in := `{"id": 1, "opening_balance": 10.5, "metadata": {"tier": "gold"}}
+
+{"id": 2, "opening_balance": "20", "status": "suspended"}
+{"id": 3, "balance": "20"}
+{"id": 4
+`
+	r, err := NewReader(strings.NewReader(in), FormatJSONL)
+	require.NoError(t, err)
+	records := readAll(t, r)
+	require.Len(t, records, 4)
+
+	assert.Equal(t, &Record{Line: 1, Row: &account.ImportRow{
+		ID:             1,
+		OpeningBalance: "10.5",
+		Metadata:       map[string]string{"tier": "gold"},
+	}}, records[0])
+	assert.Equal(t, &Record{Line: 3, Row: &account.ImportRow{
+		ID:             2,
+		Status:         account.StatusSuspended,
+		OpeningBalance: "20",
+	}}, records[1])
+	assert.Equal(t, 4, records[2].Line)
+	assert.Error(t, records[2].Err)
+	assert.Equal(t, 5, records[3].Line)
+	assert.Error(t, records[3].Err)
+}
+
+func TestFormatOf(t *testing.T) {
+	format, err := FormatOf("accounts.CSV")
+	require.NoError(t, err)
+	assert.Equal(t, FormatCSV, format)
+
+	format, err = FormatOf("/tmp/accounts.jsonl")
+	require.NoError(t, err)
+	assert.Equal(t, FormatJSONL, format)
+
+	_, err = FormatOf("accounts.json")
+	assert.Error(t, err)
+}
@@ -0,0 +1,103 @@
+package loglevel
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// Supported levels.
+const (
+	Debug = "debug"
+	Info  = "info"
+	Warn  = "warn"
+	Error = "error"
+)
+
+var options = map[string]level.Option{
+	Debug: level.AllowDebug(),
+	Info:  level.AllowInfo(),
+	Warn:  level.AllowWarn(),
+	Error: level.AllowError(),
+}
+
+// Logger is a leveled logger whose level can be changed at runtime.
+type Logger struct {
+	base log.Logger
+
+	mu     sync.RWMutex
+	level  string
+	logger log.Logger
+}
+
+// NewLogger creates a new logger that passes the entries of the info level and above to base.
+func NewLogger(base log.Logger) *Logger {
+	return &Logger{
+		base:   base,
+		level:  Info,
+		logger: level.NewFilter(base, options[Info]),
+	}
+}
+
+// Log implements log.Logger.
+func (l *Logger) Log(keyvals ...interface{}) error {
+	l.mu.RLock()
+	logger := l.logger
+	l.mu.RUnlock()
+	return logger.Log(keyvals...)
+}
+
+// Level returns the current level.
+func (l *Logger) Level() string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.level
+}
+
+// SetLevel changes the level.
+func (l *Logger) SetLevel(lvl string) error {
+	opt, ok := options[lvl]
+	if !ok {
+		return fmt.Errorf("unknown log level %q", lvl)
+	}
+	l.mu.Lock()
+	l.level = lvl
+	l.logger = level.NewFilter(l.base, opt)
+	l.mu.Unlock()
+	return nil
+}
+
+type levelBody struct {
+	Level string `json:"level"`
+}
+
+// Handler reports the current level on GET and changes it on PUT with a {"level": "debug"} body.
+func (l *Logger) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			var body levelBody
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				http.Error(w, fmt.Sprintf("failed to decode request: %v", err), http.StatusBadRequest)
+				return
+			}
+			if err := l.SetLevel(body.Level); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			level.Info(l).Log("msg", "log level is changed", "level", body.Level)
+		default:
+			w.Header().Set("Allow", "GET, PUT")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		_ = json.NewEncoder(w).Encode(levelBody{Level: l.Level()})
+	})
+}
@@ -0,0 +1,80 @@
+package loglevel
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogger_SetLevel(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewLogger(log.NewLogfmtLogger(&buf))
+
+	level.Debug(l).Log("msg", "hidden")
+	level.Info(l).Log("msg", "shown")
+	assert.NotContains(t, buf.String(), "hidden")
+	assert.Contains(t, buf.String(), "shown")
+
+	require.NoError(t, l.SetLevel(Debug))
+	level.Debug(l).Log("msg", "debug shown")
+	assert.Contains(t, buf.String(), "debug shown")
+
+	assert.Error(t, l.SetLevel("verbose"))
+	assert.Equal(t, Debug, l.Level())
+}
+
+func TestLogger_Handler(t *testing.T) {
+	testCases := []struct {
+		name      string
+		method    string
+		body      string
+		wantCode  int
+		wantLevel string
+	}{
+		{
+			name:      "get",
+			method:    http.MethodGet,
+			wantCode:  http.StatusOK,
+			wantLevel: Info,
+		},
+		{
+			name:      "put",
+			method:    http.MethodPut,
+			body:      `{"level":"error"}`,
+			wantCode:  http.StatusOK,
+			wantLevel: Error,
+		},
+		{
+			name:      "put unknown level",
+			method:    http.MethodPut,
+			body:      `{"level":"verbose"}`,
+			wantCode:  http.StatusBadRequest,
+			wantLevel: Info,
+		},
+		{
+			name:      "post",
+			method:    http.MethodPost,
+			body:      `{"level":"error"}`,
+			wantCode:  http.StatusMethodNotAllowed,
+			wantLevel: Info,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			l := NewLogger(log.NewNopLogger())
+
+			rec := httptest.NewRecorder()
+			l.Handler().ServeHTTP(rec, httptest.NewRequest(tc.method, "/loglevel", strings.NewReader(tc.body)))
+			assert.Equal(t, tc.wantCode, rec.Code)
+			assert.Equal(t, tc.wantLevel, l.Level())
+		})
+	}
+}
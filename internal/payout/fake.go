@@ -0,0 +1,46 @@
+package payout
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/shkov/wallet-service/internal/account"
+)
+
+// FakeProvider is an in-process payout provider for the tests and the local runs. It settles every
+// submitted payout on the first status check except the ones of FailAmount which are failed.
+type FakeProvider struct {
+	// FailAmount is the amount of the payouts failed by the provider, empty fails none of them.
+	FailAmount string
+}
+
+// NewFakeProvider creates a new fake provider failing the payouts of the given amount.
+func NewFakeProvider(failAmount string) (*FakeProvider, error) {
+	if failAmount != "" {
+		if _, err := decimal.NewFromString(failAmount); err != nil {
+			return nil, err
+		}
+	}
+	return &FakeProvider{FailAmount: failAmount}, nil
+}
+
+// Submit returns the reference derived from the payout id, so it is idempotent.
+func (f *FakeProvider) Submit(ctx context.Context, p *account.Payout) (string, error) {
+	return "fake-" + strconv.FormatInt(p.ID, 10), nil
+}
+
+// Status settles the payout unless its amount is FailAmount.
+func (f *FakeProvider) Status(ctx context.Context, p *account.Payout) (string, string, error) {
+	if f.FailAmount != "" {
+		amount, err := decimal.NewFromString(p.Amount)
+		if err != nil {
+			return "", "", err
+		}
+		if amount.Equal(decimal.RequireFromString(f.FailAmount)) {
+			return account.PayoutStatusFailed, "rejected by the fake provider", nil
+		}
+	}
+	return account.PayoutStatusSettled, "", nil
+}
@@ -0,0 +1,32 @@
+package payout
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shkov/wallet-service/internal/account"
+)
+
+func TestFakeProvider(t *testing.T) {
+	f, err := NewFakeProvider("13.00")
+	assert.NoError(t, err)
+
+	ref, err := f.Submit(context.Background(), &account.Payout{ID: 7, Amount: "10"})
+	assert.NoError(t, err)
+	assert.Equal(t, "fake-7", ref)
+
+	status, reason, err := f.Status(context.Background(), &account.Payout{ID: 7, Amount: "10"})
+	assert.NoError(t, err)
+	assert.Equal(t, account.PayoutStatusSettled, status)
+	assert.Empty(t, reason)
+
+	status, reason, err = f.Status(context.Background(), &account.Payout{ID: 8, Amount: "13"})
+	assert.NoError(t, err)
+	assert.Equal(t, account.PayoutStatusFailed, status)
+	assert.NotEmpty(t, reason)
+
+	_, err = NewFakeProvider("x")
+	assert.Error(t, err)
+}
@@ -0,0 +1,31 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+)
+
+// Error is returned by the endpoint middleware when the limit is exceeded.
+type Error struct {
+	Result Result
+}
+
+// Error returns a string representation of the error.
+func (e *Error) Error() string {
+	return fmt.Sprintf("rate limit exceeded, retry after %s", e.Result.RetryAfter.Round(time.Second))
+}
+
+// NewEndpointMiddleware creates a go-kit middleware that limits calls by the key extracted from the context.
+func NewEndpointMiddleware(l *Limiter, key func(ctx context.Context) string) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			if res := l.Allow(key(ctx)); !res.Allowed {
+				return nil, &Error{Result: res}
+			}
+			return next(ctx, request)
+		}
+	}
+}
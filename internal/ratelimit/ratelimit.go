@@ -0,0 +1,151 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often idle buckets are dropped.
+const sweepInterval = time.Minute
+
+// Rule allows Limit requests per Period with bursts of up to Limit requests.
+type Rule struct {
+	Limit  int
+	Period time.Duration
+}
+
+// Decode parses a rule in the "<limit>/<period>" format, e.g. "100/1m".
+// It implements envconfig.Decoder.
+func (r *Rule) Decode(value string) error {
+	parts := strings.SplitN(value, "/", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid rate limit rule %q: must be <limit>/<period>", value)
+	}
+	limit, err := strconv.Atoi(parts[0])
+	if err != nil || limit <= 0 {
+		return fmt.Errorf("invalid rate limit rule %q: limit must be a positive integer", value)
+	}
+	period, err := time.ParseDuration(parts[1])
+	if err != nil || period <= 0 {
+		return fmt.Errorf("invalid rate limit rule %q: period must be a positive duration", value)
+	}
+	r.Limit = limit
+	r.Period = period
+	return nil
+}
+
+// String returns the rule in the "<limit>/<period>" format.
+func (r Rule) String() string {
+	return strconv.Itoa(r.Limit) + "/" + r.Period.String()
+}
+
+// Result is the outcome of a rate limit check.
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration // until the bucket is full again
+	RetryAfter time.Duration // until the next request is allowed, zero if allowed
+}
+
+type bucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+// Limiter is a token bucket rate limiter keyed by client.
+type Limiter struct {
+	rule Rule
+	rate float64 // tokens per second
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	sweptAt   time.Time
+	timeNowFn func() time.Time
+}
+
+// NewLimiter creates a new Limiter with the given rule.
+func NewLimiter(rule Rule) *Limiter {
+	return &Limiter{
+		rule:      rule,
+		rate:      float64(rule.Limit) / rule.Period.Seconds(),
+		buckets:   make(map[string]*bucket),
+		timeNowFn: time.Now,
+	}
+}
+
+// Rule returns the rule of the limiter.
+func (l *Limiter) Rule() Rule {
+	return l.rule
+}
+
+// Allow takes a token from the bucket of the given key.
+func (l *Limiter) Allow(key string) Result {
+	return l.take(key, true)
+}
+
+// Peek reports whether the bucket of the given key has a token without taking it.
+func (l *Limiter) Peek(key string) Result {
+	return l.take(key, false)
+}
+
+func (l *Limiter) take(key string, consume bool) Result {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.timeNowFn()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.rule.Limit), updatedAt: now}
+		l.buckets[key] = b
+	}
+	l.refill(b, now)
+
+	res := Result{
+		Limit: l.rule.Limit,
+	}
+	if b.tokens >= 1 {
+		if consume {
+			b.tokens--
+		}
+		res.Allowed = true
+	} else {
+		res.RetryAfter = l.durationFor(1 - b.tokens)
+	}
+	res.Remaining = int(math.Floor(b.tokens))
+	res.Reset = l.durationFor(float64(l.rule.Limit) - b.tokens)
+
+	return res
+}
+
+func (l *Limiter) refill(b *bucket, now time.Time) {
+	elapsed := now.Sub(b.updatedAt).Seconds()
+	if elapsed > 0 {
+		b.tokens = math.Min(float64(l.rule.Limit), b.tokens+elapsed*l.rate)
+		b.updatedAt = now
+	}
+}
+
+func (l *Limiter) durationFor(tokens float64) time.Duration {
+	return time.Duration(math.Ceil(tokens / l.rate * float64(time.Second)))
+}
+
+// sweep drops the buckets that are full, they are indistinguishable from new ones.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.sweptAt) < sweepInterval {
+		return
+	}
+	l.sweptAt = now
+	for key, b := range l.buckets {
+		l.refill(b, now)
+		if b.tokens >= float64(l.rule.Limit) {
+			delete(l.buckets, key)
+		}
+	}
+}
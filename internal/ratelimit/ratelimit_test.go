@@ -0,0 +1,109 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRule_Decode(t *testing.T) {
+	testCases := []struct {
+		name     string
+		value    string
+		wantRule Rule
+		wantErr  bool
+	}{
+		{
+			name:     "normal response",
+			value:    "100/1m",
+			wantRule: Rule{Limit: 100, Period: time.Minute},
+		},
+		{
+			name:    "no period",
+			value:   "100",
+			wantErr: true,
+		},
+		{
+			name:    "zero limit",
+			value:   "0/1s",
+			wantErr: true,
+		},
+		{
+			name:    "invalid period",
+			value:   "10/minute",
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var got Rule
+			gotErr := got.Decode(tc.value)
+			assert.Equal(t, tc.wantErr, gotErr != nil)
+			assert.Equal(t, tc.wantRule, got)
+		})
+	}
+}
+
+func TestLimiter_Allow(t *testing.T) {
+	now := time.Date(2001, 1, 2, 11, 22, 33, 0, time.UTC)
+	l := NewLimiter(Rule{Limit: 2, Period: 2 * time.Second})
+	l.timeNowFn = func() time.Time { return now }
+
+	assert.Equal(t, Result{Allowed: true, Limit: 2, Remaining: 1, Reset: time.Second}, l.Allow("a"))
+	assert.Equal(t, Result{Allowed: true, Limit: 2, Remaining: 0, Reset: 2 * time.Second}, l.Allow("a"))
+	assert.Equal(t, Result{Allowed: false, Limit: 2, Remaining: 0, Reset: 2 * time.Second, RetryAfter: time.Second}, l.Allow("a"))
+
+	// other keys have their own buckets.
+	assert.True(t, l.Allow("b").Allowed)
+
+	now = now.Add(time.Second)
+	assert.Equal(t, Result{Allowed: true, Limit: 2, Remaining: 0, Reset: 2 * time.Second}, l.Allow("a"))
+}
+
+func TestLimiter_Peek(t *testing.T) {
+	now := time.Date(2001, 1, 2, 11, 22, 33, 0, time.UTC)
+	l := NewLimiter(Rule{Limit: 1, Period: time.Second})
+	l.timeNowFn = func() time.Time { return now }
+
+	assert.Equal(t, Result{Allowed: true, Limit: 1, Remaining: 1}, l.Peek("a"))
+	assert.True(t, l.Allow("a").Allowed)
+	assert.Equal(t, Result{Allowed: false, Limit: 1, Remaining: 0, Reset: time.Second, RetryAfter: time.Second}, l.Peek("a"))
+}
+
+func TestLimiter_Sweep(t *testing.T) {
+	now := time.Date(2001, 1, 2, 11, 22, 33, 0, time.UTC)
+	l := NewLimiter(Rule{Limit: 1, Period: time.Second})
+	l.timeNowFn = func() time.Time { return now }
+
+	l.Allow("a")
+	now = now.Add(sweepInterval)
+	l.Allow("b")
+
+	assert.Len(t, l.buckets, 1)
+	assert.Contains(t, l.buckets, "b")
+}
+
+func TestNewEndpointMiddleware(t *testing.T) {
+	l := NewLimiter(Rule{Limit: 1, Period: time.Minute})
+	e := NewEndpointMiddleware(l, func(ctx context.Context) string {
+		return "key"
+	})(func(ctx context.Context, request interface{}) (interface{}, error) {
+		return request, nil
+	})
+
+	got, err := e(context.Background(), "req")
+	assert.NoError(t, err)
+	assert.Equal(t, "req", got)
+
+	_, err = e(context.Background(), "req")
+	var limitErr *Error
+	if !errors.As(err, &limitErr) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert.False(t, limitErr.Result.Allowed)
+	assert.InDelta(t, time.Minute, limitErr.Result.RetryAfter, float64(time.Second))
+}
@@ -0,0 +1,149 @@
+package reconcile
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/go-kit/kit/metrics"
+	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Report formats.
+const (
+	FormatJSON = "json"
+	FormatCSV  = "csv"
+)
+
+// Discrepancy is an account whose balance differs from the one recomputed
+// from its initial balance and payments.
+type Discrepancy struct {
+	AccountID  int64  `pg:"account_id"`
+	Balance    string `pg:"balance"`
+	Expected   string `pg:"expected"`
+	Difference string `pg:"difference"`
+}
+
+// Source finds the accounts whose balances do not match their payments.
+type Source interface {
+	GetBalanceDiscrepancies(ctx context.Context) ([]*Discrepancy, error)
+}
+
+// Report is the result of a reconciliation.
+type Report struct {
+	CheckedAt     time.Time
+	Discrepancies []*Discrepancy
+}
+
+// Run reconciles the balances of all accounts.
+func Run(ctx context.Context, src Source, now time.Time) (*Report, error) {
+	dd, err := src.GetBalanceDiscrepancies(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get balance discrepancies: %w", err)
+	}
+	return &Report{CheckedAt: now, Discrepancies: dd}, nil
+}
+
+// Write writes the report in the given format.
+func (r *Report) Write(w io.Writer, format string) error {
+	switch format {
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		_ = cw.Write([]string{"AccountID", "Balance", "Expected", "Difference"})
+		for _, d := range r.Discrepancies {
+			_ = cw.Write([]string{strconv.FormatInt(d.AccountID, 10), d.Balance, d.Expected, d.Difference})
+		}
+		cw.Flush()
+		return cw.Error()
+
+	default:
+		return fmt.Errorf("unknown report format %q", format)
+	}
+}
+
+// Job reconciles the balances periodically and exports the number of mismatched accounts.
+type Job struct {
+	src        Source
+	interval   time.Duration
+	logger     log.Logger
+	mismatched metrics.Gauge
+	lastRun    metrics.Gauge
+
+	now func() time.Time
+}
+
+// NewJob creates a new reconciliation job running with the given interval.
+func NewJob(src Source, interval time.Duration, logger log.Logger, prefix string) *Job {
+	return &Job{
+		src:      src,
+		interval: interval,
+		logger:   logger,
+		mismatched: kitprometheus.NewGaugeFrom(
+			prometheus.GaugeOpts{
+				Name: prefix + "_reconcile_mismatched_accounts",
+				Help: "Number of accounts whose balance does not match their payments.",
+			},
+			[]string{},
+		),
+		lastRun: kitprometheus.NewGaugeFrom(
+			prometheus.GaugeOpts{
+				Name: prefix + "_reconcile_last_success_timestamp_seconds",
+				Help: "Time of the last successful reconciliation.",
+			},
+			[]string{},
+		),
+		now: time.Now,
+	}
+}
+
+// Run reconciles the balances every interval until the context is canceled.
+func (j *Job) Run(ctx context.Context) error {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		j.runOnce(ctx)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (j *Job) runOnce(ctx context.Context) {
+	report, err := Run(ctx, j.src, j.now())
+	if err != nil {
+		if ctx.Err() == nil {
+			level.Error(j.logger).Log("component", "reconcile", "msg", "reconciliation is failed", "err", err)
+		}
+		return
+	}
+
+	j.mismatched.Set(float64(len(report.Discrepancies)))
+	j.lastRun.Set(float64(report.CheckedAt.Unix()))
+
+	for _, d := range report.Discrepancies {
+		level.Warn(j.logger).Log(
+			"component", "reconcile",
+			"msg", "balance mismatch",
+			"account_id", d.AccountID,
+			"balance", d.Balance,
+			"expected", d.Expected,
+			"difference", d.Difference,
+		)
+	}
+}
@@ -0,0 +1,121 @@
+package reconcile
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type sourceMock func(ctx context.Context) ([]*Discrepancy, error)
+
+func (m sourceMock) GetBalanceDiscrepancies(ctx context.Context) ([]*Discrepancy, error) {
+	return m(ctx)
+}
+
+type gaugeMock struct {
+	value float64
+}
+
+func (g *gaugeMock) With(labelValues ...string) metrics.Gauge { return g }
+func (g *gaugeMock) Set(value float64)                        { g.value = value }
+func (g *gaugeMock) Add(delta float64)                        { g.value += delta }
+
+var testDiscrepancies = []*Discrepancy{
+	{AccountID: 1, Balance: "500.00", Expected: "400.00", Difference: "100.00"},
+	{AccountID: 7, Balance: "0.00", Expected: "10.50", Difference: "-10.50"},
+}
+
+func TestReport_Write(t *testing.T) {
+	report := &Report{
+		CheckedAt:     time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC),
+		Discrepancies: testDiscrepancies,
+	}
+
+	testCases := []struct {
+		name    string
+		format  string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "csv",
+			format: FormatCSV,
+			want:   "AccountID,Balance,Expected,Difference\n1,500.00,400.00,100.00\n7,0.00,10.50,-10.50\n",
+		},
+		{
+			name:   "json",
+			format: FormatJSON,
+			want: `{
+  "CheckedAt": "2021-06-01T12:00:00Z",
+  "Discrepancies": [
+    {
+      "AccountID": 1,
+      "Balance": "500.00",
+      "Expected": "400.00",
+      "Difference": "100.00"
+    },
+    {
+      "AccountID": 7,
+      "Balance": "0.00",
+      "Expected": "10.50",
+      "Difference": "-10.50"
+    }
+  ]
+}
+`,
+		},
+		{
+			name:    "unknown format",
+			format:  "xml",
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := report.Write(&buf, tc.format)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, buf.String())
+		})
+	}
+}
+
+func TestJob_RunOnce(t *testing.T) {
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	result := testDiscrepancies
+	var resultErr error
+
+	mismatched, lastRun := &gaugeMock{}, &gaugeMock{}
+	j := &Job{
+		src: sourceMock(func(ctx context.Context) ([]*Discrepancy, error) {
+			return result, resultErr
+		}),
+		logger:     log.NewNopLogger(),
+		mismatched: mismatched,
+		lastRun:    lastRun,
+		now:        func() time.Time { return now },
+	}
+
+	j.runOnce(context.Background())
+	assert.Equal(t, float64(2), mismatched.value)
+	assert.Equal(t, float64(now.Unix()), lastRun.value)
+
+	// a failed run keeps the last values.
+	result, resultErr = nil, errors.New("connection refused")
+	now = now.Add(time.Hour)
+	j.runOnce(context.Background())
+	assert.Equal(t, float64(2), mismatched.value)
+	assert.Equal(t, float64(now.Add(-time.Hour).Unix()), lastRun.value)
+}
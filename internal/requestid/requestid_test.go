@@ -0,0 +1,59 @@
+package requestid
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValid(t *testing.T) {
+	testCases := []struct {
+		name string
+		id   string
+		want bool
+	}{
+		{
+			name: "generated id",
+			id:   New(),
+			want: true,
+		},
+		{
+			name: "uuid",
+			id:   "5f0c6a3e-3b7c-4a1e-9c36-2b8f0f2d6a11",
+			want: true,
+		},
+		{
+			name: "empty",
+			id:   "",
+			want: false,
+		},
+		{
+			name: "too long",
+			id:   strings.Repeat("a", maxLength+1),
+			want: false,
+		},
+		{
+			name: "with spaces",
+			id:   "id with spaces",
+			want: false,
+		},
+		{
+			name: "with newline",
+			id:   "id\nmsg=fake",
+			want: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, Valid(tc.id))
+		})
+	}
+}
+
+func TestContext(t *testing.T) {
+	assert.Equal(t, "", FromContext(context.Background()))
+	assert.Equal(t, "id", FromContext(NewContext(context.Background(), "id")))
+}
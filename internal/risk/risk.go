@@ -0,0 +1,181 @@
+package risk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/shkov/wallet-service/internal/account"
+)
+
+// Decisions of the risk screening from the least to the most severe: an allowed payment is applied
+// as requested, a reviewed one is created pending until it is confirmed or cancelled, a denied one fails.
+const (
+	DecisionAllow  = "allow"
+	DecisionReview = "review"
+	DecisionDeny   = "deny"
+)
+
+// Rules of the RuleEngine, their names are recorded with the payments they fire on.
+const (
+	RuleBlockedAccount = "blocked_account"
+	RuleDenyAmount     = "deny_amount"
+	RuleReviewAmount   = "review_amount"
+	RuleNewAccount     = "new_account"
+	RuleVelocity       = "velocity"
+)
+
+// Request is the payment being screened with its sender.
+type Request struct {
+	Payment *account.Payment
+	Sender  *account.Account
+}
+
+// Assessment is the decision on the payment with the rules that led to it.
+type Assessment struct {
+	Decision string
+	Rules    []string
+}
+
+// Velocity is the number and the total amount of the payments sent by an account in a period.
+type Velocity struct {
+	Count  int64  `pg:"count"`
+	Amount string `pg:"amount"`
+}
+
+// Storage provides the payment history to the rules.
+type Storage interface {
+	// GetPaymentVelocity returns the velocity of the payments sent by the account since the given time,
+	// the cancelled and failed ones are not counted.
+	GetPaymentVelocity(ctx context.Context, accountID int64, since time.Time) (*Velocity, error)
+}
+
+// Config configures the rules of the RuleEngine, the rules with zero settings are disabled.
+type Config struct {
+	// ReviewAmount and DenyAmount are the payment amounts from which the payments are reviewed or denied.
+	ReviewAmount string
+	DenyAmount   string
+
+	// The payments above NewAccountMaxAmount sent by the accounts younger than NewAccountAge are reviewed.
+	NewAccountAge       time.Duration
+	NewAccountMaxAmount string
+
+	// The payments sent by an account within VelocityWindow are denied above VelocityMaxCount payments
+	// or VelocityMaxAmount in total.
+	VelocityWindow    time.Duration
+	VelocityMaxCount  int64
+	VelocityMaxAmount string
+
+	// BlockedAccounts can neither send nor receive payments.
+	BlockedAccounts []int64
+}
+
+// RuleEngine screens the payments with the configured rules, the decision is the most severe one of the fired rules.
+type RuleEngine struct {
+	cfg                 Config
+	reviewAmount        *decimal.Decimal
+	denyAmount          *decimal.Decimal
+	newAccountMaxAmount *decimal.Decimal
+	velocityMaxAmount   *decimal.Decimal
+	blocked             map[int64]bool
+}
+
+// NewRuleEngine creates a new rule engine.
+func NewRuleEngine(cfg Config) (*RuleEngine, error) {
+	e := &RuleEngine{
+		cfg:     cfg,
+		blocked: make(map[int64]bool, len(cfg.BlockedAccounts)),
+	}
+	amounts := []struct {
+		name  string
+		value string
+		dst   **decimal.Decimal
+	}{
+		{name: "ReviewAmount", value: cfg.ReviewAmount, dst: &e.reviewAmount},
+		{name: "DenyAmount", value: cfg.DenyAmount, dst: &e.denyAmount},
+		{name: "NewAccountMaxAmount", value: cfg.NewAccountMaxAmount, dst: &e.newAccountMaxAmount},
+		{name: "VelocityMaxAmount", value: cfg.VelocityMaxAmount, dst: &e.velocityMaxAmount},
+	}
+	for _, a := range amounts {
+		if a.value == "" {
+			continue
+		}
+		d, err := decimal.NewFromString(a.value)
+		if err != nil || d.IsNegative() {
+			return nil, fmt.Errorf("invalid %s %q", a.name, a.value)
+		}
+		*a.dst = &d
+	}
+	if cfg.NewAccountAge < 0 || cfg.VelocityWindow < 0 || cfg.VelocityMaxCount < 0 {
+		return nil, errors.New("invalid risk rule periods or limits")
+	}
+	for _, id := range cfg.BlockedAccounts {
+		e.blocked[id] = true
+	}
+	return e, nil
+}
+
+// Evaluate screens the payment, the payment history is read from the given storage.
+func (e *RuleEngine) Evaluate(ctx context.Context, s Storage, r *Request) (*Assessment, error) {
+	p := r.Payment
+	amount, err := decimal.NewFromString(p.Amount)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &Assessment{Decision: DecisionAllow}
+
+	for _, id := range p.AccountIDs() {
+		if e.blocked[id] {
+			a.add(RuleBlockedAccount, DecisionDeny)
+			break
+		}
+	}
+
+	if e.denyAmount != nil && amount.GreaterThanOrEqual(*e.denyAmount) {
+		a.add(RuleDenyAmount, DecisionDeny)
+	}
+	if e.reviewAmount != nil && amount.GreaterThanOrEqual(*e.reviewAmount) {
+		a.add(RuleReviewAmount, DecisionReview)
+	}
+
+	if e.cfg.NewAccountAge > 0 && r.Sender.CreatedAt.After(p.CreatedAt.Add(-e.cfg.NewAccountAge)) &&
+		(e.newAccountMaxAmount == nil || amount.GreaterThan(*e.newAccountMaxAmount)) {
+		a.add(RuleNewAccount, DecisionReview)
+	}
+
+	if e.cfg.VelocityWindow > 0 && (e.cfg.VelocityMaxCount > 0 || e.velocityMaxAmount != nil) {
+		v, err := s.GetPaymentVelocity(ctx, p.From, p.CreatedAt.Add(-e.cfg.VelocityWindow))
+		if err != nil {
+			return nil, fmt.Errorf("failed to get payment velocity: %w", err)
+		}
+		total, err := decimal.NewFromString(v.Amount)
+		if err != nil {
+			return nil, err
+		}
+		// the velocity counts the screened payment too.
+		if (e.cfg.VelocityMaxCount > 0 && v.Count+1 > e.cfg.VelocityMaxCount) ||
+			(e.velocityMaxAmount != nil && total.Add(amount).GreaterThan(*e.velocityMaxAmount)) {
+			a.add(RuleVelocity, DecisionDeny)
+		}
+	}
+
+	return a, nil
+}
+
+// add records the fired rule raising the decision to the rule's one if it is more severe.
+func (a *Assessment) add(rule, decision string) {
+	a.Rules = append(a.Rules, rule)
+	if severity[decision] > severity[a.Decision] {
+		a.Decision = decision
+	}
+}
+
+var severity = map[string]int{
+	DecisionAllow:  0,
+	DecisionReview: 1,
+	DecisionDeny:   2,
+}
@@ -0,0 +1,135 @@
+package risk
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/shkov/wallet-service/internal/account"
+)
+
+type storageMock func(ctx context.Context, accountID int64, since time.Time) (*Velocity, error)
+
+func (m storageMock) GetPaymentVelocity(ctx context.Context, accountID int64, since time.Time) (*Velocity, error) {
+	return m(ctx, accountID, since)
+}
+
+func TestRuleEngine_Evaluate(t *testing.T) {
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	cfg := Config{
+		ReviewAmount:        "1000",
+		DenyAmount:          "10000",
+		NewAccountAge:       72 * time.Hour,
+		NewAccountMaxAmount: "100",
+		VelocityWindow:      time.Hour,
+		VelocityMaxCount:    3,
+		VelocityMaxAmount:   "2000",
+		BlockedAccounts:     []int64{13},
+	}
+
+	testCases := []struct {
+		name         string
+		payment      *account.Payment
+		senderAge    time.Duration
+		velocity     *Velocity
+		wantDecision string
+		wantRules    []string
+	}{
+		{
+			name:         "allowed",
+			payment:      &account.Payment{From: 1, To: 2, Amount: "500"},
+			wantDecision: DecisionAllow,
+		},
+		{
+			name:         "large amount",
+			payment:      &account.Payment{From: 1, To: 2, Amount: "1000"},
+			wantDecision: DecisionReview,
+			wantRules:    []string{RuleReviewAmount},
+		},
+		{
+			name:         "too large amount",
+			payment:      &account.Payment{From: 1, To: 2, Amount: "10000"},
+			velocity:     &Velocity{Count: 0, Amount: "0"},
+			wantDecision: DecisionDeny,
+			wantRules:    []string{RuleDenyAmount, RuleReviewAmount, RuleVelocity},
+		},
+		{
+			name:         "blocked receiver of split payment",
+			payment:      &account.Payment{From: 1, Amount: "20", Legs: []*account.PaymentLeg{{To: 2, Amount: "10"}, {To: 13, Amount: "10"}}},
+			wantDecision: DecisionDeny,
+			wantRules:    []string{RuleBlockedAccount},
+		},
+		{
+			name:         "new account",
+			payment:      &account.Payment{From: 1, To: 2, Amount: "101"},
+			senderAge:    time.Hour,
+			wantDecision: DecisionReview,
+			wantRules:    []string{RuleNewAccount},
+		},
+		{
+			name:         "new account small amount",
+			payment:      &account.Payment{From: 1, To: 2, Amount: "100"},
+			senderAge:    time.Hour,
+			wantDecision: DecisionAllow,
+		},
+		{
+			name:         "too many payments",
+			payment:      &account.Payment{From: 1, To: 2, Amount: "1"},
+			velocity:     &Velocity{Count: 3, Amount: "10"},
+			wantDecision: DecisionDeny,
+			wantRules:    []string{RuleVelocity},
+		},
+		{
+			name:         "too large total",
+			payment:      &account.Payment{From: 1, To: 2, Amount: "600"},
+			velocity:     &Velocity{Count: 1, Amount: "1500"},
+			wantDecision: DecisionDeny,
+			wantRules:    []string{RuleVelocity},
+		},
+	}
+
+	e, err := NewRuleEngine(cfg)
+	require.NoError(t, err)
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.payment.CreatedAt = now
+			senderAge := tc.senderAge
+			if senderAge == 0 {
+				senderAge = 30 * 24 * time.Hour
+			}
+			velocity := tc.velocity
+			if velocity == nil {
+				velocity = &Velocity{Count: 0, Amount: "0"}
+			}
+
+			got, err := e.Evaluate(context.Background(), storageMock(func(ctx context.Context, accountID int64, since time.Time) (*Velocity, error) {
+				assert.Equal(t, tc.payment.From, accountID)
+				assert.Equal(t, now.Add(-time.Hour), since)
+				return velocity, nil
+			}), &Request{
+				Payment: tc.payment,
+				Sender:  &account.Account{ID: tc.payment.From, CreatedAt: now.Add(-senderAge)},
+			})
+			require.NoError(t, err)
+			assert.Equal(t, &Assessment{Decision: tc.wantDecision, Rules: tc.wantRules}, got)
+		})
+	}
+}
+
+func TestNewRuleEngine(t *testing.T) {
+	e, err := NewRuleEngine(Config{})
+	require.NoError(t, err)
+	got, err := e.Evaluate(context.Background(), nil, &Request{
+		Payment: &account.Payment{From: 1, To: 2, Amount: "1000000"},
+		Sender:  &account.Account{ID: 1},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, DecisionAllow, got.Decision)
+
+	_, err = NewRuleEngine(Config{DenyAmount: "1,5"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,77 @@
+package snapshot
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// Period is the period of the snapshots: they are taken at midnight UTC.
+const Period = 24 * time.Hour
+
+// Storage saves the balance snapshots.
+type Storage interface {
+	CreateBalanceSnapshots(ctx context.Context, at time.Time) (int64, error)
+}
+
+// Job takes the snapshots of the account balances so that the historical balances
+// are computed from the latest snapshot instead of the whole payment history.
+// A snapshot is taken at the start of the current day, when the payments of the
+// previous day are settled, so checking more often than daily only retries a missed snapshot.
+//
+// The payments are stamped with their time before they are committed, and a snapshot sums only
+// the committed ones, so the snapshot for a midnight is taken when the lag after it is over.
+// The lag must be longer than the longest payment transaction: a payment stamped before the midnight
+// and committed after the snapshot is never counted by the historical balances.
+type Job struct {
+	storage  Storage
+	interval time.Duration
+	lag      time.Duration
+	logger   log.Logger
+
+	now func() time.Time
+}
+
+// NewJob creates a new snapshot job checking for a missing snapshot every interval,
+// the snapshot for a midnight is taken when the lag after it is over.
+func NewJob(s Storage, interval, lag time.Duration, logger log.Logger) *Job {
+	return &Job{
+		storage:  s,
+		interval: interval,
+		lag:      lag,
+		logger:   logger,
+		now:      time.Now,
+	}
+}
+
+// Run takes the snapshots until the context is canceled.
+func (j *Job) Run(ctx context.Context) error {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		j.runOnce(ctx)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (j *Job) runOnce(ctx context.Context) {
+	at := j.now().Add(-j.lag).UTC().Truncate(Period)
+	n, err := j.storage.CreateBalanceSnapshots(ctx, at)
+	if err != nil {
+		if ctx.Err() == nil {
+			level.Error(j.logger).Log("component", "snapshot", "msg", "failed to take balance snapshots", "at", at, "err", err)
+		}
+		return
+	}
+	if n > 0 {
+		level.Info(j.logger).Log("component", "snapshot", "msg", "balance snapshots are taken", "at", at, "accounts", n)
+	}
+}
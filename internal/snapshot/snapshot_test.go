@@ -0,0 +1,41 @@
+package snapshot
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+type storageMock func(ctx context.Context, at time.Time) (int64, error)
+
+func (m storageMock) CreateBalanceSnapshots(ctx context.Context, at time.Time) (int64, error) {
+	return m(ctx, at)
+}
+
+func TestJob_RunOnce(t *testing.T) {
+	var got []time.Time
+	j := NewJob(storageMock(func(ctx context.Context, at time.Time) (int64, error) {
+		got = append(got, at)
+		return 2, nil
+	}), time.Hour, 5*time.Minute, log.NewNopLogger())
+
+	for _, now := range []string{"2021-03-31T23:59:59+03:00", "2021-04-01T00:04:59Z", "2021-04-01T00:05:00Z", "2021-04-01T17:30:00Z"} {
+		tm, err := time.Parse(time.RFC3339, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		j.now = func() time.Time { return tm }
+		j.runOnce(context.Background())
+	}
+
+	// the snapshot for the midnight is taken when the lag after it is over.
+	assert.Equal(t, []time.Time{
+		time.Date(2021, 3, 31, 0, 0, 0, 0, time.UTC),
+		time.Date(2021, 3, 31, 0, 0, 0, 0, time.UTC),
+		time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC),
+	}, got)
+}
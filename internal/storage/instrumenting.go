@@ -10,62 +10,285 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 
 	"github.com/shkov/wallet-service/internal/account"
+	"github.com/shkov/wallet-service/internal/audit"
+	"github.com/shkov/wallet-service/internal/auth"
+	"github.com/shkov/wallet-service/internal/reconcile"
+	"github.com/shkov/wallet-service/internal/risk"
 )
 
-// instrumentingMiddleware wraps Storage and records metrics.
+// instrumentingMiddleware wraps TransactionalStorage and records metrics.
 type instrumentingMiddleware struct {
-	next      TransactionalStorage
+	*instrumentingStorage
+	next TransactionalStorage
+}
+
+// instrumentingStorage wraps Storage and records metrics.
+type instrumentingStorage struct {
+	next      Storage
 	histogram metrics.Histogram
 }
 
 func NewInstrumentingMiddleware(next TransactionalStorage, prefix string) TransactionalStorage {
 	return &instrumentingMiddleware{
+		instrumentingStorage: &instrumentingStorage{
+			next: next,
+			histogram: kitprometheus.NewHistogramFrom(
+				prometheus.HistogramOpts{
+					Name:    prefix + "_storage_queries",
+					Buckets: prometheus.ExponentialBuckets(0.01, 2, 7),
+				},
+				[]string{"method", "error"},
+			),
+		},
 		next: next,
-		histogram: kitprometheus.NewHistogramFrom(
-			prometheus.HistogramOpts{
-				Name:    prefix + "_storage_queries",
-				Buckets: prometheus.ExponentialBuckets(0.01, 2, 7),
-			},
-			[]string{"method", "error"},
-		),
 	}
 }
 
-func (mw *instrumentingMiddleware) GetAccount(ctx context.Context, id int64) (*account.Account, error) {
+func (mw *instrumentingStorage) GetAccount(ctx context.Context, id int64) (*account.Account, error) {
 	createdAt := time.Now()
 	out, err := mw.next.GetAccount(ctx, id)
 	mw.record(createdAt, "GetAccount", err)
 	return out, err
 }
 
-func (mw *instrumentingMiddleware) GetAccounts(ctx context.Context, ids []int64) ([]*account.Account, error) {
+func (mw *instrumentingStorage) GetAccounts(ctx context.Context, ids []int64) ([]*account.Account, error) {
 	createdAt := time.Now()
 	out, err := mw.next.GetAccounts(ctx, ids)
 	mw.record(createdAt, "GetAccounts", err)
 	return out, err
 }
 
-func (mw *instrumentingMiddleware) GetPayments(ctx context.Context, accountID int64) ([]*account.Payment, error) {
+func (mw *instrumentingStorage) ListAccounts(ctx context.Context, r *account.ListRequest, limit int) ([]*account.Account, error) {
+	createdAt := time.Now()
+	out, err := mw.next.ListAccounts(ctx, r, limit)
+	mw.record(createdAt, "ListAccounts", err)
+	return out, err
+}
+
+func (mw *instrumentingStorage) GetPayments(ctx context.Context, f *account.PaymentFilter) ([]*account.Payment, error) {
 	createdAt := time.Now()
-	out, err := mw.next.GetPayments(ctx, accountID)
+	out, err := mw.next.GetPayments(ctx, f)
 	mw.record(createdAt, "GetPayments", err)
 	return out, err
 }
 
-func (mw *instrumentingMiddleware) InsertPayment(ctx context.Context, p *account.Payment) error {
+func (mw *instrumentingStorage) StreamPayments(ctx context.Context, accountID int64, from, to time.Time, fn func(*account.Payment) error) error {
+	createdAt := time.Now()
+	err := mw.next.StreamPayments(ctx, accountID, from, to, fn)
+	mw.record(createdAt, "StreamPayments", err)
+	return err
+}
+
+func (mw *instrumentingStorage) GetAccountBalanceAt(ctx context.Context, accountID int64, at time.Time) (string, error) {
+	createdAt := time.Now()
+	out, err := mw.next.GetAccountBalanceAt(ctx, accountID, at)
+	mw.record(createdAt, "GetAccountBalanceAt", err)
+	return out, err
+}
+
+func (mw *instrumentingStorage) CreateBalanceSnapshots(ctx context.Context, at time.Time) (int64, error) {
+	createdAt := time.Now()
+	out, err := mw.next.CreateBalanceSnapshots(ctx, at)
+	mw.record(createdAt, "CreateBalanceSnapshots", err)
+	return out, err
+}
+
+func (mw *instrumentingStorage) GetPaymentByIdempotencyKey(ctx context.Context, from int64, key string) (*account.Payment, error) {
+	createdAt := time.Now()
+	out, err := mw.next.GetPaymentByIdempotencyKey(ctx, from, key)
+	mw.record(createdAt, "GetPaymentByIdempotencyKey", err)
+	return out, err
+}
+
+func (mw *instrumentingStorage) GetPayment(ctx context.Context, id int64) (*account.Payment, error) {
+	createdAt := time.Now()
+	out, err := mw.next.GetPayment(ctx, id)
+	mw.record(createdAt, "GetPayment", err)
+	return out, err
+}
+
+func (mw *instrumentingStorage) GetPaymentVelocity(ctx context.Context, accountID int64, since time.Time) (*risk.Velocity, error) {
+	createdAt := time.Now()
+	out, err := mw.next.GetPaymentVelocity(ctx, accountID, since)
+	mw.record(createdAt, "GetPaymentVelocity", err)
+	return out, err
+}
+
+func (mw *instrumentingStorage) InsertPayment(ctx context.Context, p *account.Payment) error {
 	createdAt := time.Now()
 	err := mw.next.InsertPayment(ctx, p)
 	mw.record(createdAt, "InsertPayment", err)
 	return err
 }
 
-func (mw *instrumentingMiddleware) ReplaceAccounts(ctx context.Context, aa []*account.Account) error {
+func (mw *instrumentingStorage) InsertPayments(ctx context.Context, pp []*account.Payment) error {
+	createdAt := time.Now()
+	err := mw.next.InsertPayments(ctx, pp)
+	mw.record(createdAt, "InsertPayments", err)
+	return err
+}
+
+func (mw *instrumentingStorage) UpdatePaymentStatus(ctx context.Context, p *account.Payment) error {
+	createdAt := time.Now()
+	err := mw.next.UpdatePaymentStatus(ctx, p)
+	mw.record(createdAt, "UpdatePaymentStatus", err)
+	return err
+}
+
+func (mw *instrumentingStorage) InsertAdjustment(ctx context.Context, a *account.Adjustment) error {
+	createdAt := time.Now()
+	err := mw.next.InsertAdjustment(ctx, a)
+	mw.record(createdAt, "InsertAdjustment", err)
+	return err
+}
+
+func (mw *instrumentingStorage) GetAdjustment(ctx context.Context, id int64) (*account.Adjustment, error) {
+	createdAt := time.Now()
+	out, err := mw.next.GetAdjustment(ctx, id)
+	mw.record(createdAt, "GetAdjustment", err)
+	return out, err
+}
+
+func (mw *instrumentingStorage) UpdateAdjustment(ctx context.Context, a *account.Adjustment) error {
+	createdAt := time.Now()
+	err := mw.next.UpdateAdjustment(ctx, a)
+	mw.record(createdAt, "UpdateAdjustment", err)
+	return err
+}
+
+func (mw *instrumentingStorage) GetAdjustments(ctx context.Context, accountID int64) ([]*account.Adjustment, error) {
+	createdAt := time.Now()
+	out, err := mw.next.GetAdjustments(ctx, accountID)
+	mw.record(createdAt, "GetAdjustments", err)
+	return out, err
+}
+
+func (mw *instrumentingStorage) InsertPayout(ctx context.Context, p *account.Payout) error {
+	createdAt := time.Now()
+	err := mw.next.InsertPayout(ctx, p)
+	mw.record(createdAt, "InsertPayout", err)
+	return err
+}
+
+func (mw *instrumentingStorage) GetPayouts(ctx context.Context, status string, limit int) ([]*account.Payout, error) {
+	createdAt := time.Now()
+	out, err := mw.next.GetPayouts(ctx, status, limit)
+	mw.record(createdAt, "GetPayouts", err)
+	return out, err
+}
+
+func (mw *instrumentingStorage) GetPayoutByPaymentID(ctx context.Context, paymentID int64) (*account.Payout, error) {
+	createdAt := time.Now()
+	out, err := mw.next.GetPayoutByPaymentID(ctx, paymentID)
+	mw.record(createdAt, "GetPayoutByPaymentID", err)
+	return out, err
+}
+
+func (mw *instrumentingStorage) UpdatePayout(ctx context.Context, p *account.Payout, from string) error {
+	createdAt := time.Now()
+	err := mw.next.UpdatePayout(ctx, p, from)
+	mw.record(createdAt, "UpdatePayout", err)
+	return err
+}
+
+func (mw *instrumentingStorage) InsertInterestAccruals(ctx context.Context, accruals []*account.InterestAccrual) (int64, error) {
+	createdAt := time.Now()
+	out, err := mw.next.InsertInterestAccruals(ctx, accruals)
+	mw.record(createdAt, "InsertInterestAccruals", err)
+	return out, err
+}
+
+func (mw *instrumentingStorage) GetUnpostedInterest(ctx context.Context, accountID int64, before time.Time) (string, error) {
+	createdAt := time.Now()
+	out, err := mw.next.GetUnpostedInterest(ctx, accountID, before)
+	mw.record(createdAt, "GetUnpostedInterest", err)
+	return out, err
+}
+
+func (mw *instrumentingStorage) SetInterestPayment(ctx context.Context, accountID int64, before time.Time, paymentID int64) error {
+	createdAt := time.Now()
+	err := mw.next.SetInterestPayment(ctx, accountID, before, paymentID)
+	mw.record(createdAt, "SetInterestPayment", err)
+	return err
+}
+
+func (mw *instrumentingStorage) IsInterestPosted(ctx context.Context, accountID int64, from, before time.Time) (bool, error) {
+	createdAt := time.Now()
+	out, err := mw.next.IsInterestPosted(ctx, accountID, from, before)
+	mw.record(createdAt, "IsInterestPosted", err)
+	return out, err
+}
+
+func (mw *instrumentingStorage) ReplaceAccounts(ctx context.Context, aa []*account.Account) error {
 	createdAt := time.Now()
 	err := mw.next.ReplaceAccounts(ctx, aa)
 	mw.record(createdAt, "ReplaceAccounts", err)
 	return err
 }
 
+func (mw *instrumentingStorage) UpsertAccounts(ctx context.Context, aa []*account.Account) error {
+	createdAt := time.Now()
+	err := mw.next.UpsertAccounts(ctx, aa)
+	mw.record(createdAt, "UpsertAccounts", err)
+	return err
+}
+
+func (mw *instrumentingStorage) UpdateAccountDetails(ctx context.Context, a *account.Account) error {
+	createdAt := time.Now()
+	err := mw.next.UpdateAccountDetails(ctx, a)
+	mw.record(createdAt, "UpdateAccountDetails", err)
+	return err
+}
+
+func (mw *instrumentingStorage) GetTotalBalances(ctx context.Context) (map[string]string, error) {
+	createdAt := time.Now()
+	out, err := mw.next.GetTotalBalances(ctx)
+	mw.record(createdAt, "GetTotalBalances", err)
+	return out, err
+}
+
+func (mw *instrumentingStorage) GetAPIKey(ctx context.Context, keyHash string) (*auth.APIKey, error) {
+	createdAt := time.Now()
+	out, err := mw.next.GetAPIKey(ctx, keyHash)
+	mw.record(createdAt, "GetAPIKey", err)
+	return out, err
+}
+
+func (mw *instrumentingStorage) GetBalanceDiscrepancies(ctx context.Context) ([]*reconcile.Discrepancy, error) {
+	createdAt := time.Now()
+	out, err := mw.next.GetBalanceDiscrepancies(ctx)
+	mw.record(createdAt, "GetBalanceDiscrepancies", err)
+	return out, err
+}
+
+func (mw *instrumentingStorage) AppendAuditEntries(ctx context.Context, entries []*audit.Entry) error {
+	createdAt := time.Now()
+	err := mw.next.AppendAuditEntries(ctx, entries)
+	mw.record(createdAt, "AppendAuditEntries", err)
+	return err
+}
+
+func (mw *instrumentingStorage) GetAuditEntries(ctx context.Context, afterSeq int64, limit int) ([]*audit.Entry, error) {
+	createdAt := time.Now()
+	out, err := mw.next.GetAuditEntries(ctx, afterSeq, limit)
+	mw.record(createdAt, "GetAuditEntries", err)
+	return out, err
+}
+
+func (mw *instrumentingStorage) GetDetailsChanges(ctx context.Context, since time.Time) ([]*audit.Entry, error) {
+	createdAt := time.Now()
+	out, err := mw.next.GetDetailsChanges(ctx, since)
+	mw.record(createdAt, "GetDetailsChanges", err)
+	return out, err
+}
+
+func (mw *instrumentingMiddleware) Ping(ctx context.Context) error {
+	createdAt := time.Now()
+	err := mw.next.Ping(ctx)
+	mw.record(createdAt, "Ping", err)
+	return err
+}
+
 func (mw *instrumentingMiddleware) Close() error {
 	createdAt := time.Now()
 	err := mw.next.Close()
@@ -76,14 +299,14 @@ func (mw *instrumentingMiddleware) Close() error {
 func (mw *instrumentingMiddleware) ExecTx(ctx context.Context, fn func(context.Context, Storage) error) error {
 	createdAt := time.Now()
 	fnWithMetrics := func(ctx context.Context, s Storage) error {
-		return fn(ctx, mw)
+		return fn(ctx, &instrumentingStorage{next: s, histogram: mw.histogram})
 	}
 	err := mw.next.ExecTx(ctx, fnWithMetrics)
 	mw.record(createdAt, "ExecTx", err)
 	return err
 }
 
-func (mw *instrumentingMiddleware) record(beginTime time.Time, method string, err error) {
+func (mw *instrumentingStorage) record(beginTime time.Time, method string, err error) {
 	labels := []string{"method", method, "error", strconv.FormatBool(err != nil)}
 	mw.histogram.With(labels...).Observe(time.Since(beginTime).Seconds())
 }
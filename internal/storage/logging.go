@@ -0,0 +1,313 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+
+	"github.com/shkov/wallet-service/internal/account"
+	"github.com/shkov/wallet-service/internal/audit"
+	"github.com/shkov/wallet-service/internal/auth"
+	"github.com/shkov/wallet-service/internal/reconcile"
+	"github.com/shkov/wallet-service/internal/requestid"
+	"github.com/shkov/wallet-service/internal/risk"
+)
+
+// loggingMiddleware wraps TransactionalStorage and logs errors.
+type loggingMiddleware struct {
+	*loggingStorage
+	next TransactionalStorage
+}
+
+// loggingStorage wraps Storage and logs errors.
+type loggingStorage struct {
+	next   Storage
+	logger log.Logger
+}
+
+// NewLoggingMiddleware creates a new logging middleware.
+func NewLoggingMiddleware(next TransactionalStorage, logger log.Logger) TransactionalStorage {
+	return &loggingMiddleware{
+		loggingStorage: &loggingStorage{
+			next:   next,
+			logger: logger,
+		},
+		next: next,
+	}
+}
+
+func (mw *loggingStorage) GetAccount(ctx context.Context, id int64) (*account.Account, error) {
+	startedAt := time.Now()
+	out, err := mw.next.GetAccount(ctx, id)
+	mw.log(ctx, startedAt, "GetAccount", err)
+	return out, err
+}
+
+func (mw *loggingStorage) GetAccounts(ctx context.Context, ids []int64) ([]*account.Account, error) {
+	startedAt := time.Now()
+	out, err := mw.next.GetAccounts(ctx, ids)
+	mw.log(ctx, startedAt, "GetAccounts", err)
+	return out, err
+}
+
+func (mw *loggingStorage) ListAccounts(ctx context.Context, r *account.ListRequest, limit int) ([]*account.Account, error) {
+	startedAt := time.Now()
+	out, err := mw.next.ListAccounts(ctx, r, limit)
+	mw.log(ctx, startedAt, "ListAccounts", err)
+	return out, err
+}
+
+func (mw *loggingStorage) GetPayments(ctx context.Context, f *account.PaymentFilter) ([]*account.Payment, error) {
+	startedAt := time.Now()
+	out, err := mw.next.GetPayments(ctx, f)
+	mw.log(ctx, startedAt, "GetPayments", err)
+	return out, err
+}
+
+func (mw *loggingStorage) StreamPayments(ctx context.Context, accountID int64, from, to time.Time, fn func(*account.Payment) error) error {
+	startedAt := time.Now()
+	err := mw.next.StreamPayments(ctx, accountID, from, to, fn)
+	mw.log(ctx, startedAt, "StreamPayments", err)
+	return err
+}
+
+func (mw *loggingStorage) GetAccountBalanceAt(ctx context.Context, accountID int64, at time.Time) (string, error) {
+	startedAt := time.Now()
+	out, err := mw.next.GetAccountBalanceAt(ctx, accountID, at)
+	mw.log(ctx, startedAt, "GetAccountBalanceAt", err)
+	return out, err
+}
+
+func (mw *loggingStorage) CreateBalanceSnapshots(ctx context.Context, at time.Time) (int64, error) {
+	startedAt := time.Now()
+	out, err := mw.next.CreateBalanceSnapshots(ctx, at)
+	mw.log(ctx, startedAt, "CreateBalanceSnapshots", err)
+	return out, err
+}
+
+func (mw *loggingStorage) GetPaymentByIdempotencyKey(ctx context.Context, from int64, key string) (*account.Payment, error) {
+	startedAt := time.Now()
+	out, err := mw.next.GetPaymentByIdempotencyKey(ctx, from, key)
+	mw.log(ctx, startedAt, "GetPaymentByIdempotencyKey", err)
+	return out, err
+}
+
+func (mw *loggingStorage) GetPayment(ctx context.Context, id int64) (*account.Payment, error) {
+	startedAt := time.Now()
+	out, err := mw.next.GetPayment(ctx, id)
+	mw.log(ctx, startedAt, "GetPayment", err)
+	return out, err
+}
+
+func (mw *loggingStorage) GetPaymentVelocity(ctx context.Context, accountID int64, since time.Time) (*risk.Velocity, error) {
+	startedAt := time.Now()
+	out, err := mw.next.GetPaymentVelocity(ctx, accountID, since)
+	mw.log(ctx, startedAt, "GetPaymentVelocity", err)
+	return out, err
+}
+
+func (mw *loggingStorage) InsertPayment(ctx context.Context, p *account.Payment) error {
+	startedAt := time.Now()
+	err := mw.next.InsertPayment(ctx, p)
+	mw.log(ctx, startedAt, "InsertPayment", err)
+	return err
+}
+
+func (mw *loggingStorage) InsertPayments(ctx context.Context, pp []*account.Payment) error {
+	startedAt := time.Now()
+	err := mw.next.InsertPayments(ctx, pp)
+	mw.log(ctx, startedAt, "InsertPayments", err)
+	return err
+}
+
+func (mw *loggingStorage) UpdatePaymentStatus(ctx context.Context, p *account.Payment) error {
+	startedAt := time.Now()
+	err := mw.next.UpdatePaymentStatus(ctx, p)
+	mw.log(ctx, startedAt, "UpdatePaymentStatus", err)
+	return err
+}
+
+func (mw *loggingStorage) InsertAdjustment(ctx context.Context, a *account.Adjustment) error {
+	startedAt := time.Now()
+	err := mw.next.InsertAdjustment(ctx, a)
+	mw.log(ctx, startedAt, "InsertAdjustment", err)
+	return err
+}
+
+func (mw *loggingStorage) GetAdjustment(ctx context.Context, id int64) (*account.Adjustment, error) {
+	startedAt := time.Now()
+	out, err := mw.next.GetAdjustment(ctx, id)
+	mw.log(ctx, startedAt, "GetAdjustment", err)
+	return out, err
+}
+
+func (mw *loggingStorage) UpdateAdjustment(ctx context.Context, a *account.Adjustment) error {
+	startedAt := time.Now()
+	err := mw.next.UpdateAdjustment(ctx, a)
+	mw.log(ctx, startedAt, "UpdateAdjustment", err)
+	return err
+}
+
+func (mw *loggingStorage) GetAdjustments(ctx context.Context, accountID int64) ([]*account.Adjustment, error) {
+	startedAt := time.Now()
+	out, err := mw.next.GetAdjustments(ctx, accountID)
+	mw.log(ctx, startedAt, "GetAdjustments", err)
+	return out, err
+}
+
+func (mw *loggingStorage) InsertPayout(ctx context.Context, p *account.Payout) error {
+	startedAt := time.Now()
+	err := mw.next.InsertPayout(ctx, p)
+	mw.log(ctx, startedAt, "InsertPayout", err)
+	return err
+}
+
+func (mw *loggingStorage) GetPayouts(ctx context.Context, status string, limit int) ([]*account.Payout, error) {
+	startedAt := time.Now()
+	out, err := mw.next.GetPayouts(ctx, status, limit)
+	mw.log(ctx, startedAt, "GetPayouts", err)
+	return out, err
+}
+
+func (mw *loggingStorage) GetPayoutByPaymentID(ctx context.Context, paymentID int64) (*account.Payout, error) {
+	startedAt := time.Now()
+	out, err := mw.next.GetPayoutByPaymentID(ctx, paymentID)
+	mw.log(ctx, startedAt, "GetPayoutByPaymentID", err)
+	return out, err
+}
+
+func (mw *loggingStorage) UpdatePayout(ctx context.Context, p *account.Payout, from string) error {
+	startedAt := time.Now()
+	err := mw.next.UpdatePayout(ctx, p, from)
+	mw.log(ctx, startedAt, "UpdatePayout", err)
+	return err
+}
+
+func (mw *loggingStorage) InsertInterestAccruals(ctx context.Context, accruals []*account.InterestAccrual) (int64, error) {
+	startedAt := time.Now()
+	out, err := mw.next.InsertInterestAccruals(ctx, accruals)
+	mw.log(ctx, startedAt, "InsertInterestAccruals", err)
+	return out, err
+}
+
+func (mw *loggingStorage) GetUnpostedInterest(ctx context.Context, accountID int64, before time.Time) (string, error) {
+	startedAt := time.Now()
+	out, err := mw.next.GetUnpostedInterest(ctx, accountID, before)
+	mw.log(ctx, startedAt, "GetUnpostedInterest", err)
+	return out, err
+}
+
+func (mw *loggingStorage) SetInterestPayment(ctx context.Context, accountID int64, before time.Time, paymentID int64) error {
+	startedAt := time.Now()
+	err := mw.next.SetInterestPayment(ctx, accountID, before, paymentID)
+	mw.log(ctx, startedAt, "SetInterestPayment", err)
+	return err
+}
+
+func (mw *loggingStorage) IsInterestPosted(ctx context.Context, accountID int64, from, before time.Time) (bool, error) {
+	startedAt := time.Now()
+	out, err := mw.next.IsInterestPosted(ctx, accountID, from, before)
+	mw.log(ctx, startedAt, "IsInterestPosted", err)
+	return out, err
+}
+
+func (mw *loggingStorage) ReplaceAccounts(ctx context.Context, aa []*account.Account) error {
+	startedAt := time.Now()
+	err := mw.next.ReplaceAccounts(ctx, aa)
+	mw.log(ctx, startedAt, "ReplaceAccounts", err)
+	return err
+}
+
+func (mw *loggingStorage) UpsertAccounts(ctx context.Context, aa []*account.Account) error {
+	startedAt := time.Now()
+	err := mw.next.UpsertAccounts(ctx, aa)
+	mw.log(ctx, startedAt, "UpsertAccounts", err)
+	return err
+}
+
+func (mw *loggingStorage) UpdateAccountDetails(ctx context.Context, a *account.Account) error {
+	startedAt := time.Now()
+	err := mw.next.UpdateAccountDetails(ctx, a)
+	mw.log(ctx, startedAt, "UpdateAccountDetails", err)
+	return err
+}
+
+func (mw *loggingStorage) GetTotalBalances(ctx context.Context) (map[string]string, error) {
+	startedAt := time.Now()
+	out, err := mw.next.GetTotalBalances(ctx)
+	mw.log(ctx, startedAt, "GetTotalBalances", err)
+	return out, err
+}
+
+func (mw *loggingStorage) GetAPIKey(ctx context.Context, keyHash string) (*auth.APIKey, error) {
+	startedAt := time.Now()
+	out, err := mw.next.GetAPIKey(ctx, keyHash)
+	mw.log(ctx, startedAt, "GetAPIKey", err)
+	return out, err
+}
+
+func (mw *loggingStorage) GetBalanceDiscrepancies(ctx context.Context) ([]*reconcile.Discrepancy, error) {
+	startedAt := time.Now()
+	out, err := mw.next.GetBalanceDiscrepancies(ctx)
+	mw.log(ctx, startedAt, "GetBalanceDiscrepancies", err)
+	return out, err
+}
+
+func (mw *loggingStorage) AppendAuditEntries(ctx context.Context, entries []*audit.Entry) error {
+	startedAt := time.Now()
+	err := mw.next.AppendAuditEntries(ctx, entries)
+	mw.log(ctx, startedAt, "AppendAuditEntries", err)
+	return err
+}
+
+func (mw *loggingStorage) GetAuditEntries(ctx context.Context, afterSeq int64, limit int) ([]*audit.Entry, error) {
+	startedAt := time.Now()
+	out, err := mw.next.GetAuditEntries(ctx, afterSeq, limit)
+	mw.log(ctx, startedAt, "GetAuditEntries", err)
+	return out, err
+}
+
+func (mw *loggingStorage) GetDetailsChanges(ctx context.Context, since time.Time) ([]*audit.Entry, error) {
+	startedAt := time.Now()
+	out, err := mw.next.GetDetailsChanges(ctx, since)
+	mw.log(ctx, startedAt, "GetDetailsChanges", err)
+	return out, err
+}
+
+func (mw *loggingMiddleware) Ping(ctx context.Context) error {
+	startedAt := time.Now()
+	err := mw.next.Ping(ctx)
+	mw.log(ctx, startedAt, "Ping", err)
+	return err
+}
+
+func (mw *loggingMiddleware) Close() error {
+	startedAt := time.Now()
+	err := mw.next.Close()
+	mw.log(context.Background(), startedAt, "Close", err)
+	return err
+}
+
+func (mw *loggingMiddleware) ExecTx(ctx context.Context, fn func(context.Context, Storage) error) error {
+	startedAt := time.Now()
+	fnWithLogging := func(ctx context.Context, s Storage) error {
+		return fn(ctx, &loggingStorage{next: s, logger: mw.logger})
+	}
+	err := mw.next.ExecTx(ctx, fnWithLogging)
+	mw.log(ctx, startedAt, "ExecTx", err)
+	return err
+}
+
+func (mw *loggingStorage) log(ctx context.Context, beginTime time.Time, method string, err error) {
+	if err != nil {
+		level.Error(mw.logger).Log(
+			"component", "storage",
+			"method", method,
+			"request_id", requestid.FromContext(ctx),
+			"err", err,
+			"took", time.Since(beginTime),
+		)
+	}
+}
@@ -3,22 +3,134 @@ package storage
 import (
 	"context"
 	"errors"
+	"time"
 
 	"github.com/go-pg/pg/v10"
 	"github.com/go-pg/pg/v10/orm"
 
 	"github.com/shkov/wallet-service/internal/account"
+	"github.com/shkov/wallet-service/internal/audit"
+	"github.com/shkov/wallet-service/internal/auth"
+	"github.com/shkov/wallet-service/internal/reconcile"
+	"github.com/shkov/wallet-service/internal/risk"
 )
 
 // Storage represents the wallet-service storage.
 type Storage interface {
 	GetAccount(ctx context.Context, id int64) (*account.Account, error)
+	// GetAccounts returns the accounts with the given ids locking them for update in the id order.
 	GetAccounts(ctx context.Context, ids []int64) ([]*account.Account, error)
-	GetPayments(ctx context.Context, accountID int64) ([]*account.Payment, error)
+	// ListAccounts returns up to limit accounts matching the request in its sort order, starting after its cursor.
+	ListAccounts(ctx context.Context, r *account.ListRequest, limit int) ([]*account.Account, error)
+	GetPayments(ctx context.Context, f *account.PaymentFilter) ([]*account.Payment, error)
+	// StreamPayments calls fn for every payment of the account created or settled in [from, to)
+	// in the order of creation, reading them from the database in batches.
+	StreamPayments(ctx context.Context, accountID int64, from, to time.Time, fn func(*account.Payment) error) error
+	// GetAccountBalanceAt returns the balance of the account before the given time,
+	// starting from the latest balance snapshot taken before it.
+	GetAccountBalanceAt(ctx context.Context, accountID int64, at time.Time) (string, error)
+	// CreateBalanceSnapshots saves the balances of all accounts before the given time,
+	// it returns the number of saved snapshots and skips the existing ones. Only the committed payments
+	// are counted, so the time must be older than the longest transaction stamping a payment.
+	CreateBalanceSnapshots(ctx context.Context, at time.Time) (int64, error)
+	GetPaymentByIdempotencyKey(ctx context.Context, from int64, key string) (*account.Payment, error)
+	GetPaymentVelocity(ctx context.Context, accountID int64, since time.Time) (*risk.Velocity, error)
+	// GetPayment returns the payment locking it for update.
+	GetPayment(ctx context.Context, id int64) (*account.Payment, error)
+	// InsertPayment saves the payment, it returns account.ErrExternalRefReused or account.ErrIdempotencyKeyTaken
+	// if the sender has another payment with the same external reference or idempotency key.
 	InsertPayment(ctx context.Context, p *account.Payment) error
+	// InsertPayments saves the payments without legs at once setting their ids.
+	InsertPayments(ctx context.Context, pp []*account.Payment) error
+	// UpdatePaymentStatus saves the final status of the pending payment,
+	// it returns account.ErrPaymentNotPending if the stored payment is not pending.
+	UpdatePaymentStatus(ctx context.Context, p *account.Payment) error
+	InsertAdjustment(ctx context.Context, a *account.Adjustment) error
+	// GetAdjustment returns the adjustment locking it for update.
+	GetAdjustment(ctx context.Context, id int64) (*account.Adjustment, error)
+	// UpdateAdjustment saves the review of the proposed adjustment,
+	// it returns account.ErrAdjustmentNotProposed if the stored adjustment is already reviewed.
+	UpdateAdjustment(ctx context.Context, a *account.Adjustment) error
+	// GetAdjustments returns the adjustments of the account in the order they are proposed.
+	GetAdjustments(ctx context.Context, accountID int64) ([]*account.Adjustment, error)
+	InsertPayout(ctx context.Context, p *account.Payout) error
+	// GetPayouts returns up to limit payouts with the given status in the order they are created.
+	GetPayouts(ctx context.Context, status string, limit int) ([]*account.Payout, error)
+	// GetPayoutByPaymentID returns the payout of the withdrawal payment locking it for update.
+	GetPayoutByPaymentID(ctx context.Context, paymentID int64) (*account.Payout, error)
+	// UpdatePayout saves the payout changed from the given status,
+	// it returns account.ErrUnexpectedPayoutStatus if the stored payout has another status.
+	UpdatePayout(ctx context.Context, p *account.Payout, from string) error
+	// InsertInterestAccruals saves the daily interest accruals skipping the ones already accrued,
+	// it returns the number of saved accruals.
+	InsertInterestAccruals(ctx context.Context, accruals []*account.InterestAccrual) (int64, error)
+	// GetUnpostedInterest returns the sum of the interest accrued by the account before the given day and not posted yet.
+	GetUnpostedInterest(ctx context.Context, accountID int64, before time.Time) (string, error)
+	// SetInterestPayment marks the interest accrued by the account before the given day and not posted yet
+	// as posted by the payment.
+	SetInterestPayment(ctx context.Context, accountID int64, before time.Time, paymentID int64) error
+	// IsInterestPosted reports whether the interest accrued by the account for a day in [from, before) is posted.
+	IsInterestPosted(ctx context.Context, accountID int64, from, before time.Time) (bool, error)
 	ReplaceAccounts(ctx context.Context, aa []*account.Account) error
+	// UpsertAccounts saves the accounts replacing the balances, details and versions of the existing ones.
+	UpsertAccounts(ctx context.Context, aa []*account.Account) error
+	// UpdateAccountDetails saves the details of the account updated from its previous version,
+	// it returns account.ErrVersionMismatch if the stored account has another version.
+	UpdateAccountDetails(ctx context.Context, a *account.Account) error
+	// GetTotalBalances returns the total balance of the accounts of every type.
+	GetTotalBalances(ctx context.Context) (map[string]string, error)
+	GetAPIKey(ctx context.Context, keyHash string) (*auth.APIKey, error)
+	// GetBalanceDiscrepancies returns the accounts whose balances differ from their initial balances plus payments.
+	GetBalanceDiscrepancies(ctx context.Context) ([]*reconcile.Discrepancy, error)
+	// AppendAuditEntries chains the entries to the audit log of their accounts, it must be called
+	// in the transaction that locks the accounts, it returns account.ErrVersionMismatch if another
+	// transaction has appended to the chain of an account.
+	AppendAuditEntries(ctx context.Context, entries []*audit.Entry) error
+	GetAuditEntries(ctx context.Context, afterSeq int64, limit int) ([]*audit.Entry, error)
+	// GetDetailsChanges returns the audit entries changing the account details created at or after the given time
+	// in the order they are appended.
+	GetDetailsChanges(ctx context.Context, since time.Time) ([]*audit.Entry, error)
 }
 
+// postingsCTE turns the initial balances and payments into postings: an account is credited
+// with its initial balance when it is created, every payment debits the sender when it is created
+// and credits the receiver or the receivers of its legs when it is completed, a cancelled or failed
+// payment refunds the sender. It mirrors account.Payment.Postings in the database.
+const postingsCTE = `
+postings AS (
+  SELECT id AS account_id, COALESCE(initial_balance, '0')::numeric AS amount, created_at FROM accounts
+  UNION ALL
+  SELECT to_account_id AS account_id, amount::numeric AS amount, settled_at AS created_at FROM payments
+  WHERE to_account_id IS NOT NULL AND status = 'completed'
+  UNION ALL
+  SELECT l.to_account_id AS account_id, l.amount::numeric AS amount, p.settled_at AS created_at
+  FROM payment_legs l JOIN payments p ON p.id = l.payment_id
+  WHERE p.status = 'completed'
+  UNION ALL
+  SELECT from_account_id AS account_id, -amount::numeric AS amount, created_at FROM payments
+  UNION ALL
+  SELECT from_account_id AS account_id, amount::numeric AS amount, settled_at AS created_at FROM payments
+  WHERE status IN ('cancelled', 'failed')
+)`
+
+// accountPayments matches the payments sent by the account, received by it or by one of its legs.
+const accountPayments = `(from_account_id = ?0 OR to_account_id = ?0 OR id IN (SELECT payment_id FROM payment_legs WHERE to_account_id = ?0))`
+
+// streamBatchSize is the number of payments StreamPayments reads at once.
+const streamBatchSize = 1000
+
+// uniqueViolation is the SQLSTATE of a unique constraint violation.
+const uniqueViolation = "23505"
+
+// paymentsExternalRefIndex makes the external references unique per sender.
+const paymentsExternalRefIndex = "payments_external_ref_idx"
+
+// paymentsIdempotencyKeyIndex makes the idempotency keys unique per sender.
+const paymentsIdempotencyKeyIndex = "payments_idempotency_key_idx"
+
+// auditLogAccountSeqIndex makes the positions of the entries unique in the chain of every account.
+const auditLogAccountSeqIndex = "audit_log_account_seq_idx"
+
 type storageImpl struct {
 	db orm.DB
 }
@@ -44,19 +156,108 @@ func (s *storageImpl) GetAccount(ctx context.Context, id int64) (*account.Accoun
 
 func (s *storageImpl) GetAccounts(ctx context.Context, ids []int64) ([]*account.Account, error) {
 	var accounts []*account.Account
-	err := s.db.ModelContext(ctx, &accounts).WhereIn(`id in (?)`, ids).Select()
+	err := s.db.ModelContext(ctx, &accounts).
+		WhereIn(`id in (?)`, ids).
+		Order(`id`).
+		For(`UPDATE`).
+		Select()
+	if err != nil {
+		return nil, err
+	}
+	return accounts, nil
+}
+
+func (s *storageImpl) ListAccounts(ctx context.Context, r *account.ListRequest, limit int) ([]*account.Account, error) {
+	accounts := make([]*account.Account, 0)
+	q := s.db.ModelContext(ctx, &accounts)
+
+	if r.Status != "" {
+		q.Where(`status = ?`, r.Status)
+	}
+	if r.Type != "" {
+		q.Where(`type = ?`, r.Type)
+	}
+	if r.OwnerRef != "" {
+		q.Where(`owner_ref = ?`, r.OwnerRef)
+	}
+	if r.MinBalance != "" {
+		q.Where(`balance::numeric >= ?::numeric`, r.MinBalance)
+	}
+	if r.MaxBalance != "" {
+		q.Where(`balance::numeric <= ?::numeric`, r.MaxBalance)
+	}
+	if !r.CreatedFrom.IsZero() {
+		q.Where(`created_at >= ?`, r.CreatedFrom)
+	}
+	if !r.CreatedTo.IsZero() {
+		q.Where(`created_at < ?`, r.CreatedTo)
+	}
+	if len(r.Labels) > 0 {
+		q.Where(`metadata @> ?`, r.Labels)
+	}
+	if r.IDs != nil {
+		if len(r.IDs) == 0 {
+			return accounts, nil
+		}
+		q.WhereIn(`id IN (?)`, r.IDs)
+	}
+
+	field, desc, err := account.ParseSort(r.Sort)
+	if err != nil {
+		return nil, err
+	}
+	// the sort key is compared with the id as a row, so that the pages are stable for the equal keys.
+	key, op, dir := `id`, `>`, `ASC`
+	switch field {
+	case account.SortByCreatedAt:
+		key = `created_at`
+	case account.SortByBalance:
+		key = `balance::numeric`
+	}
+	if desc {
+		op, dir = `<`, `DESC`
+	}
+
+	if c := r.After; c != nil {
+		switch field {
+		case account.SortByID:
+			q.Where(`id `+op+` ?`, c.ID)
+		case account.SortByCreatedAt:
+			createdAt, err := time.Parse(time.RFC3339Nano, c.Value)
+			if err != nil {
+				return nil, account.ErrInvalidCursor
+			}
+			q.Where(`(created_at, id) `+op+` (?, ?)`, createdAt, c.ID)
+		case account.SortByBalance:
+			q.Where(`(balance::numeric, id) `+op+` (?::numeric, ?)`, c.Value, c.ID)
+		}
+	}
+
+	if field == account.SortByID {
+		q.OrderExpr(`id ` + dir)
+	} else {
+		q.OrderExpr(key + ` ` + dir).OrderExpr(`id ` + dir)
+	}
+
+	err = q.Limit(limit).Select()
 	if err != nil {
 		return nil, err
 	}
 	return accounts, nil
 }
 
-func (s *storageImpl) GetPayments(ctx context.Context, accountID int64) ([]*account.Payment, error) {
+func (s *storageImpl) GetPayments(ctx context.Context, f *account.PaymentFilter) ([]*account.Payment, error) {
 	payments := make([]*account.Payment, 0)
-	err := s.db.ModelContext(ctx, &payments).
-		WhereOr("from_account_id = ?", accountID).
-		WhereOr("to_account_id = ?", accountID).
-		Select()
+	q := s.db.ModelContext(ctx, &payments).
+		Relation(`Legs`, orderLegs).
+		Where(accountPayments, f.AccountID)
+	if f.ExternalRef != "" {
+		q.Where(`external_ref = ?`, f.ExternalRef)
+	}
+	if len(f.Labels) > 0 {
+		q.Where(`metadata @> ?`, f.Labels)
+	}
+	err := q.Select()
 	if err != nil {
 		return nil, err
 	}
@@ -64,14 +265,330 @@ func (s *storageImpl) GetPayments(ctx context.Context, accountID int64) ([]*acco
 	return payments, nil
 }
 
+func (s *storageImpl) StreamPayments(ctx context.Context, accountID int64, from, to time.Time, fn func(*account.Payment) error) error {
+	var last *account.Payment
+	for {
+		var payments []*account.Payment
+		q := s.db.ModelContext(ctx, &payments).
+			Relation(`Legs`, orderLegs).
+			Where(accountPayments, accountID).
+			Where(`((created_at >= ?0 AND created_at < ?1) OR (settled_at >= ?0 AND settled_at < ?1))`, from, to)
+		if last != nil {
+			q.Where(`(created_at, id) > (?, ?)`, last.CreatedAt, last.ID)
+		}
+		err := q.Order(`created_at`, `id`).Limit(streamBatchSize).Select()
+		if err != nil {
+			return err
+		}
+
+		for _, p := range payments {
+			if err := fn(p); err != nil {
+				return err
+			}
+		}
+		if len(payments) < streamBatchSize {
+			return nil
+		}
+		last = payments[len(payments)-1]
+	}
+}
+
+// orderLegs loads the legs of the payments in the order they are given in the request.
+func orderLegs(q *orm.Query) (*orm.Query, error) {
+	return q.Order(`id`), nil
+}
+
+func (s *storageImpl) GetAccountBalanceAt(ctx context.Context, accountID int64, at time.Time) (string, error) {
+	var balance string
+	_, err := s.db.QueryOneContext(ctx, pg.Scan(&balance), `
+WITH `+postingsCTE+`,
+snapshot AS (
+  SELECT taken_at, balance::numeric AS balance FROM balance_snapshots
+  WHERE account_id = ?0 AND taken_at <= ?1
+  ORDER BY taken_at DESC
+  LIMIT 1
+)
+SELECT ((SELECT COALESCE(MAX(balance), 0) FROM snapshot) + COALESCE(SUM(amount), 0))::text
+FROM postings
+WHERE account_id = ?0 AND created_at < ?1
+  AND created_at >= COALESCE((SELECT taken_at FROM snapshot), '-infinity')`, accountID, at)
+	if err != nil {
+		return "", err
+	}
+	return balance, nil
+}
+
+func (s *storageImpl) CreateBalanceSnapshots(ctx context.Context, at time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `
+WITH `+postingsCTE+`
+INSERT INTO balance_snapshots (account_id, taken_at, balance)
+SELECT account_id, ?0, SUM(amount)::text FROM postings
+WHERE created_at < ?0
+GROUP BY account_id
+ON CONFLICT (account_id, taken_at) DO NOTHING`, at)
+	if err != nil {
+		return 0, err
+	}
+	return int64(res.RowsAffected()), nil
+}
+
+func (s *storageImpl) GetPaymentByIdempotencyKey(ctx context.Context, from int64, key string) (*account.Payment, error) {
+	p := &account.Payment{}
+	err := s.db.ModelContext(ctx, p).
+		Relation(`Legs`, orderLegs).
+		Where(`from_account_id = ?`, from).
+		Where(`idempotency_key = ?`, key).
+		Select()
+	if err != nil {
+		if errors.Is(err, pg.ErrNoRows) {
+			return nil, account.ErrPaymentNotFound
+		}
+		return nil, err
+	}
+	return p, nil
+}
+
+func (s *storageImpl) GetPaymentVelocity(ctx context.Context, accountID int64, since time.Time) (*risk.Velocity, error) {
+	v := &risk.Velocity{}
+	_, err := s.db.QueryOneContext(ctx, v, `
+SELECT count(*) AS count, COALESCE(SUM(amount::numeric), 0)::text AS amount FROM payments
+WHERE from_account_id = ? AND created_at >= ? AND status NOT IN (?, ?)`,
+		accountID, since, account.PaymentStatusCancelled, account.PaymentStatusFailed)
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func (s *storageImpl) GetPayment(ctx context.Context, id int64) (*account.Payment, error) {
+	p := &account.Payment{}
+	err := s.db.ModelContext(ctx, p).
+		Relation(`Legs`, orderLegs).
+		Where(`id = ?`, id).
+		For(`UPDATE`).
+		Select()
+	if err != nil {
+		if errors.Is(err, pg.ErrNoRows) {
+			return nil, account.ErrPaymentNotFound
+		}
+		return nil, err
+	}
+	return p, nil
+}
+
 func (s *storageImpl) InsertPayment(ctx context.Context, p *account.Payment) error {
 	_, err := s.db.ModelContext(ctx, p).Insert()
+	if err != nil {
+		var pgErr pg.Error
+		if errors.As(err, &pgErr) && pgErr.Field('C') == uniqueViolation {
+			switch pgErr.Field('n') {
+			case paymentsExternalRefIndex:
+				return account.ErrExternalRefReused
+			case paymentsIdempotencyKeyIndex:
+				return account.ErrIdempotencyKeyTaken
+			}
+		}
+		return err
+	}
+	if len(p.Legs) == 0 {
+		return nil
+	}
+
+	for _, l := range p.Legs {
+		l.PaymentID = p.ID
+	}
+	_, err = s.db.ModelContext(ctx, &p.Legs).Insert()
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *storageImpl) InsertPayments(ctx context.Context, pp []*account.Payment) error {
+	if len(pp) == 0 {
+		return nil
+	}
+	_, err := s.db.ModelContext(ctx, &pp).Insert()
+	if err != nil {
+		var pgErr pg.Error
+		if errors.As(err, &pgErr) && pgErr.Field('C') == uniqueViolation && pgErr.Field('n') == paymentsExternalRefIndex {
+			return account.ErrExternalRefReused
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *storageImpl) UpdatePaymentStatus(ctx context.Context, p *account.Payment) error {
+	res, err := s.db.ModelContext(ctx, p).
+		Set(`status = ?status`).
+		Set(`settled_at = ?settled_at`).
+		Where(`id = ?id`).
+		Where(`status = ?`, account.PaymentStatusPending).
+		Update()
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return account.ErrPaymentNotPending
+	}
+	return nil
+}
+
+func (s *storageImpl) InsertAdjustment(ctx context.Context, a *account.Adjustment) error {
+	_, err := s.db.ModelContext(ctx, a).Insert()
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *storageImpl) GetAdjustment(ctx context.Context, id int64) (*account.Adjustment, error) {
+	a := &account.Adjustment{}
+	err := s.db.ModelContext(ctx, a).
+		Where(`id = ?`, id).
+		For(`UPDATE`).
+		Select()
+	if err != nil {
+		if errors.Is(err, pg.ErrNoRows) {
+			return nil, account.ErrAdjustmentNotFound
+		}
+		return nil, err
+	}
+	return a, nil
+}
+
+func (s *storageImpl) UpdateAdjustment(ctx context.Context, a *account.Adjustment) error {
+	res, err := s.db.ModelContext(ctx, a).
+		Set(`status = ?status`).
+		Set(`reviewed_by = ?reviewed_by`).
+		Set(`reviewed_at = ?reviewed_at`).
+		Set(`payment_id = ?payment_id`).
+		Where(`id = ?id`).
+		Where(`status = ?`, account.AdjustmentStatusProposed).
+		Update()
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return account.ErrAdjustmentNotProposed
+	}
+	return nil
+}
+
+func (s *storageImpl) GetAdjustments(ctx context.Context, accountID int64) ([]*account.Adjustment, error) {
+	adjustments := make([]*account.Adjustment, 0)
+	err := s.db.ModelContext(ctx, &adjustments).
+		Where(`account_id = ?`, accountID).
+		Order(`id`).
+		Select()
+	if err != nil {
+		return nil, err
+	}
+	return adjustments, nil
+}
+
+func (s *storageImpl) InsertPayout(ctx context.Context, p *account.Payout) error {
+	_, err := s.db.ModelContext(ctx, p).Insert()
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *storageImpl) GetPayouts(ctx context.Context, status string, limit int) ([]*account.Payout, error) {
+	payouts := make([]*account.Payout, 0)
+	err := s.db.ModelContext(ctx, &payouts).
+		Where(`status = ?`, status).
+		Order(`id`).
+		Limit(limit).
+		Select()
+	if err != nil {
+		return nil, err
+	}
+	return payouts, nil
+}
+
+func (s *storageImpl) GetPayoutByPaymentID(ctx context.Context, paymentID int64) (*account.Payout, error) {
+	p := &account.Payout{}
+	err := s.db.ModelContext(ctx, p).
+		Where(`payment_id = ?`, paymentID).
+		For(`UPDATE`).
+		Select()
+	if err != nil {
+		if errors.Is(err, pg.ErrNoRows) {
+			return nil, account.ErrPayoutNotFound
+		}
+		return nil, err
+	}
+	return p, nil
+}
+
+func (s *storageImpl) UpdatePayout(ctx context.Context, p *account.Payout, from string) error {
+	res, err := s.db.ModelContext(ctx, p).
+		Set(`status = ?status`).
+		Set(`provider_ref = ?provider_ref`).
+		Set(`failure_reason = ?failure_reason`).
+		Set(`updated_at = ?updated_at`).
+		Where(`id = ?id`).
+		Where(`status = ?`, from).
+		Update()
 	if err != nil {
 		return err
 	}
+	if res.RowsAffected() == 0 {
+		return account.ErrUnexpectedPayoutStatus
+	}
 	return nil
 }
 
+func (s *storageImpl) InsertInterestAccruals(ctx context.Context, accruals []*account.InterestAccrual) (int64, error) {
+	if len(accruals) == 0 {
+		return 0, nil
+	}
+	res, err := s.db.ModelContext(ctx, &accruals).
+		OnConflict(`(account_id, day) DO NOTHING`).
+		Insert()
+	if err != nil {
+		return 0, err
+	}
+	return int64(res.RowsAffected()), nil
+}
+
+func (s *storageImpl) GetUnpostedInterest(ctx context.Context, accountID int64, before time.Time) (string, error) {
+	var amount string
+	_, err := s.db.QueryOneContext(ctx, pg.Scan(&amount), `
+SELECT COALESCE(SUM(amount::numeric), 0)::text FROM interest_accruals
+WHERE account_id = ? AND day < ? AND payment_id IS NULL`, accountID, before)
+	if err != nil {
+		return "", err
+	}
+	return amount, nil
+}
+
+func (s *storageImpl) SetInterestPayment(ctx context.Context, accountID int64, before time.Time, paymentID int64) error {
+	_, err := s.db.ExecContext(ctx, `
+UPDATE interest_accruals SET payment_id = ?
+WHERE account_id = ? AND day < ? AND payment_id IS NULL`, paymentID, accountID, before)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *storageImpl) IsInterestPosted(ctx context.Context, accountID int64, from, before time.Time) (bool, error) {
+	var posted bool
+	_, err := s.db.QueryOneContext(ctx, pg.Scan(&posted), `
+SELECT EXISTS (
+  SELECT 1 FROM interest_accruals
+  WHERE account_id = ? AND day >= ? AND day < ? AND payment_id IS NOT NULL
+)`, accountID, from, before)
+	if err != nil {
+		return false, err
+	}
+	return posted, nil
+}
+
 func (s *storageImpl) ReplaceAccounts(ctx context.Context, aa []*account.Account) error {
 	_, err := s.db.ModelContext(ctx, &aa).
 		OnConflict(`(id) do update`).
@@ -82,3 +599,153 @@ func (s *storageImpl) ReplaceAccounts(ctx context.Context, aa []*account.Account
 	}
 	return nil
 }
+
+func (s *storageImpl) UpsertAccounts(ctx context.Context, aa []*account.Account) error {
+	if len(aa) == 0 {
+		return nil
+	}
+	_, err := s.db.ModelContext(ctx, &aa).
+		OnConflict(`(id) do update`).
+		Set(`balance = excluded.balance`).
+		Set(`type = excluded.type`).
+		Set(`status = excluded.status`).
+		Set(`owner_ref = excluded.owner_ref`).
+		Set(`display_name = excluded.display_name`).
+		Set(`metadata = COALESCE(excluded.metadata, '{}')`).
+		Set(`version = excluded.version`).
+		Insert()
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *storageImpl) UpdateAccountDetails(ctx context.Context, a *account.Account) error {
+	res, err := s.db.ModelContext(ctx, a).
+		Set(`owner_ref = ?owner_ref`).
+		Set(`display_name = ?display_name`).
+		Set(`type = ?type`).
+		Set(`metadata = COALESCE(?metadata, '{}')`).
+		Set(`version = ?version`).
+		Where(`id = ?id`).
+		Where(`version = ?`, a.Version-1).
+		Update()
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return account.ErrVersionMismatch
+	}
+	return nil
+}
+
+func (s *storageImpl) GetTotalBalances(ctx context.Context) (map[string]string, error) {
+	var totals []struct {
+		Type    string
+		Balance string
+	}
+	_, err := s.db.QueryContext(ctx, &totals, `SELECT type, SUM(balance::numeric)::text AS balance FROM accounts GROUP BY type`)
+	if err != nil {
+		return nil, err
+	}
+	balances := make(map[string]string, len(totals))
+	for _, t := range totals {
+		balances[t.Type] = t.Balance
+	}
+	return balances, nil
+}
+
+func (s *storageImpl) GetAPIKey(ctx context.Context, keyHash string) (*auth.APIKey, error) {
+	k := &auth.APIKey{}
+	err := s.db.ModelContext(ctx, k).Where(`key_hash = ?`, keyHash).Select()
+	if err != nil {
+		if errors.Is(err, pg.ErrNoRows) {
+			return nil, auth.ErrKeyNotFound
+		}
+		return nil, err
+	}
+	return k, nil
+}
+
+func (s *storageImpl) GetBalanceDiscrepancies(ctx context.Context) ([]*reconcile.Discrepancy, error) {
+	dd := make([]*reconcile.Discrepancy, 0)
+	_, err := s.db.QueryContext(ctx, &dd, `
+WITH `+postingsCTE+`,
+expected AS (
+  SELECT a.id, a.balance::numeric AS balance, COALESCE(SUM(p.amount), 0) AS expected
+  FROM accounts a
+  LEFT JOIN postings p ON p.account_id = a.id
+  GROUP BY a.id
+)
+SELECT id AS account_id, balance::text, expected::text, (balance - expected)::text AS difference
+FROM expected
+WHERE balance <> expected
+ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	return dd, nil
+}
+
+func (s *storageImpl) AppendAuditEntries(ctx context.Context, entries []*audit.Entry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, 0, len(entries))
+	for _, e := range entries {
+		ids = append(ids, e.AccountID)
+	}
+
+	heads := make([]*audit.Entry, 0)
+	err := s.db.ModelContext(ctx, &heads).
+		DistinctOn(`account_id`).
+		Where(`account_id IN (?)`, pg.In(ids)).
+		Order(`account_id`, `account_seq DESC`).
+		Select()
+	if err != nil {
+		return err
+	}
+
+	last := make(map[int64]*audit.Entry, len(heads))
+	for _, e := range heads {
+		last[e.AccountID] = e
+	}
+	audit.Chain(last, entries)
+
+	_, err = s.db.ModelContext(ctx, &entries).Insert()
+	if err != nil {
+		var pgErr pg.Error
+		if errors.As(err, &pgErr) && pgErr.Field('C') == uniqueViolation && pgErr.Field('n') == auditLogAccountSeqIndex {
+			return account.ErrVersionMismatch
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *storageImpl) GetAuditEntries(ctx context.Context, afterSeq int64, limit int) ([]*audit.Entry, error) {
+	entries := make([]*audit.Entry, 0)
+	err := s.db.ModelContext(ctx, &entries).
+		Where(`seq > ?`, afterSeq).
+		Order(`seq`).
+		Limit(limit).
+		Select()
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (s *storageImpl) GetDetailsChanges(ctx context.Context, since time.Time) ([]*audit.Entry, error) {
+	entries := make([]*audit.Entry, 0)
+	err := s.db.ModelContext(ctx, &entries).
+		Where(`created_at >= ?`, since).
+		Where(`details IS NOT NULL`).
+		Order(`seq`).
+		Select()
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
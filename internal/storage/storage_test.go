@@ -1,5 +1,595 @@
 package storage
 
-// TODO: write integration tests that will use real database.
-// After implementating them, add postgresql startup into make test
-// to run these tests.
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/shkov/wallet-service/internal/account"
+	"github.com/shkov/wallet-service/internal/audit"
+	"github.com/shkov/wallet-service/internal/risk"
+)
+
+// newTestStorage connects to the database given by the TEST_POSTGRES_* variables and creates
+// the tables in a new schema dropped after the test. The test is skipped if TEST_POSTGRES_HOST is not set.
+func newTestStorage(t *testing.T) *transactionalStorage {
+	t.Helper()
+
+	host := os.Getenv("TEST_POSTGRES_HOST")
+	if host == "" {
+		t.Skip("TEST_POSTGRES_HOST is not set")
+	}
+	port := os.Getenv("TEST_POSTGRES_PORT")
+	if port == "" {
+		port = "5432"
+	}
+
+	schema := fmt.Sprintf("test_%d", time.Now().UnixNano())
+	conn := pg.Connect(&pg.Options{
+		Addr:     host + ":" + port,
+		User:     os.Getenv("TEST_POSTGRES_USER"),
+		Password: os.Getenv("TEST_POSTGRES_PASSWORD"),
+		Database: os.Getenv("TEST_POSTGRES_DATABASE"),
+		OnConnect: func(ctx context.Context, cn *pg.Conn) error {
+			_, err := cn.ExecContext(ctx, `SET search_path TO `+schema)
+			return err
+		},
+	})
+	t.Cleanup(func() {
+		_, err := conn.Exec(`DROP SCHEMA ` + schema + ` CASCADE`)
+		assert.NoError(t, err)
+		assert.NoError(t, conn.Close())
+	})
+
+	_, err := conn.Exec(`CREATE SCHEMA ` + schema)
+	require.NoError(t, err)
+
+	migration, err := ioutil.ReadFile("../../migrations/create_tables.sql")
+	require.NoError(t, err)
+	_, err = conn.Exec(string(migration))
+	require.NoError(t, err)
+
+	return &transactionalStorage{
+		storageImpl: newStorageImpl(conn),
+		conn:        conn,
+	}
+}
+
+func TestStorage_ListAccounts(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	createdAt := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	accounts := []*account.Account{
+		{ID: 1, Balance: "100.00", CreatedAt: createdAt, Status: account.StatusActive, Metadata: map[string]string{"tier": "gold"}},
+		{ID: 2, Balance: "20.00", CreatedAt: createdAt.Add(time.Hour), Status: account.StatusActive},
+		{ID: 3, Balance: "100.00", CreatedAt: createdAt.Add(2 * time.Hour), Status: account.StatusSuspended, Metadata: map[string]string{"tier": "gold", "region": "eu"}},
+		{ID: 4, Balance: "3.50", CreatedAt: createdAt.Add(3 * time.Hour), Status: account.StatusActive, Metadata: map[string]string{"tier": "silver"}},
+	}
+	require.NoError(t, s.ReplaceAccounts(ctx, accounts))
+
+	ids := func(aa []*account.Account) []int64 {
+		out := make([]int64, 0, len(aa))
+		for _, a := range aa {
+			out = append(out, a.ID)
+		}
+		return out
+	}
+
+	testCases := []struct {
+		name    string
+		request *account.ListRequest
+		want    []int64
+	}{
+		{
+			name:    "all",
+			request: &account.ListRequest{},
+			want:    []int64{1, 2, 3, 4},
+		},
+		{
+			name:    "status",
+			request: &account.ListRequest{Status: account.StatusSuspended},
+			want:    []int64{3},
+		},
+		{
+			name:    "balance range",
+			request: &account.ListRequest{MinBalance: "3.5", MaxBalance: "20"},
+			want:    []int64{2, 4},
+		},
+		{
+			name:    "created period",
+			request: &account.ListRequest{CreatedFrom: createdAt.Add(time.Hour), CreatedTo: createdAt.Add(3 * time.Hour)},
+			want:    []int64{2, 3},
+		},
+		{
+			name:    "labels",
+			request: &account.ListRequest{Labels: map[string]string{"tier": "gold"}},
+			want:    []int64{1, 3},
+		},
+		{
+			name:    "owned accounts",
+			request: &account.ListRequest{IDs: []int64{2, 4, 5}},
+			want:    []int64{2, 4},
+		},
+		{
+			name:    "no owned accounts",
+			request: &account.ListRequest{IDs: []int64{}},
+			want:    []int64{},
+		},
+		{
+			name:    "sort by balance descending",
+			request: &account.ListRequest{Sort: "-balance"},
+			want:    []int64{3, 1, 2, 4},
+		},
+		{
+			name:    "sort by balance after cursor",
+			request: &account.ListRequest{Sort: "balance", After: account.NewCursor("balance", accounts[1])},
+			want:    []int64{1, 3},
+		},
+		{
+			name:    "sort by creation descending after cursor",
+			request: &account.ListRequest{Sort: "-created_at", After: account.NewCursor("-created_at", accounts[2])},
+			want:    []int64{2, 1},
+		},
+		{
+			name:    "after id",
+			request: &account.ListRequest{After: account.NewCursor("", accounts[0])},
+			want:    []int64{2, 3, 4},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := s.ListAccounts(ctx, tc.request, 10)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, ids(got))
+		})
+	}
+
+	t.Run("limit", func(t *testing.T) {
+		got, err := s.ListAccounts(ctx, &account.ListRequest{Sort: "-id"}, 2)
+		require.NoError(t, err)
+		assert.Equal(t, []int64{4, 3}, ids(got))
+	})
+}
+
+func TestStorage_UpdateAccountDetails(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	a := account.Create(1, time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, s.ReplaceAccounts(ctx, []*account.Account{a}))
+
+	name := "Savings"
+	gold := "gold"
+	savings := account.TypeSavings
+	require.NoError(t, a.Update(&account.UpdateRequest{ID: 1, Version: 1, DisplayName: &name, Type: &savings, Metadata: map[string]*string{"tier": &gold}}))
+	require.NoError(t, s.UpdateAccountDetails(ctx, a))
+
+	got, err := s.GetAccount(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, "Savings", got.DisplayName)
+	assert.Equal(t, account.TypeSavings, got.Type)
+	assert.Equal(t, map[string]string{"tier": "gold"}, got.Metadata)
+	assert.Equal(t, int64(2), got.Version)
+
+	// the update made to the first version again is rejected.
+	stale := account.Create(1, a.CreatedAt)
+	require.NoError(t, stale.Update(&account.UpdateRequest{ID: 1, Version: 1, DisplayName: &name}))
+	assert.Equal(t, account.ErrVersionMismatch, s.UpdateAccountDetails(ctx, stale))
+
+	accounts, err := s.ListAccounts(ctx, &account.ListRequest{Labels: map[string]string{"tier": "gold"}}, 10)
+	require.NoError(t, err)
+	assert.Len(t, accounts, 1)
+}
+
+func TestStorage_UpsertAccounts(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	createdAt := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	existing := account.Create(1, createdAt)
+	require.NoError(t, s.ReplaceAccounts(ctx, []*account.Account{existing}))
+
+	imported := account.Create(2, createdAt)
+	imported.DisplayName = "Imported"
+	existing.Balance = "5"
+	existing.Status = account.StatusSuspended
+	existing.OwnerRef = "owner-1"
+	existing.Version = 2
+	require.NoError(t, s.UpsertAccounts(ctx, []*account.Account{existing, imported}))
+
+	got, err := s.GetAccount(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, "5", got.Balance)
+	assert.Equal(t, account.StatusSuspended, got.Status)
+	assert.Equal(t, "owner-1", got.OwnerRef)
+	assert.Equal(t, int64(2), got.Version)
+
+	got, err = s.GetAccount(ctx, 2)
+	require.NoError(t, err)
+	assert.Equal(t, "Imported", got.DisplayName)
+
+	payments := []*account.Payment{
+		{From: 1, To: 2, Amount: "1", CreatedAt: createdAt, ExternalRef: "opening:1"},
+		{From: 1, To: 2, Amount: "2", CreatedAt: createdAt, ExternalRef: "opening:2"},
+	}
+	require.NoError(t, s.InsertPayments(ctx, payments))
+	assert.NotZero(t, payments[0].ID)
+	assert.NotZero(t, payments[1].ID)
+	assert.Equal(t, account.ErrExternalRefReused, s.InsertPayments(ctx, []*account.Payment{
+		{From: 1, To: 2, Amount: "1", CreatedAt: createdAt, ExternalRef: "opening:1"},
+	}))
+}
+
+func TestStorage_InsertPayment_ExternalRef(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	createdAt := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, s.ReplaceAccounts(ctx, []*account.Account{
+		account.Create(1, createdAt),
+		account.Create(2, createdAt),
+		account.Create(3, createdAt),
+	}))
+
+	payment := func(from, to int64, ref string) *account.Payment {
+		return &account.Payment{
+			From:        from,
+			To:          to,
+			Amount:      "1",
+			CreatedAt:   createdAt,
+			ExternalRef: ref,
+			Metadata:    map[string]string{"channel": "web"},
+		}
+	}
+	require.NoError(t, s.InsertPayment(ctx, payment(1, 2, "order-1")))
+	require.NoError(t, s.InsertPayment(ctx, payment(3, 2, "order-1")))
+	require.NoError(t, s.InsertPayment(ctx, payment(1, 2, "")))
+	require.NoError(t, s.InsertPayment(ctx, payment(1, 3, "")))
+	assert.Equal(t, account.ErrExternalRefReused, s.InsertPayment(ctx, payment(1, 3, "order-1")))
+
+	keyed := payment(3, 2, "")
+	keyed.IdempotencyKey = "key-1"
+	require.NoError(t, s.InsertPayment(ctx, keyed))
+	keyed = payment(3, 2, "")
+	keyed.IdempotencyKey = "key-1"
+	assert.Equal(t, account.ErrIdempotencyKeyTaken, s.InsertPayment(ctx, keyed))
+
+	payments, err := s.GetPayments(ctx, &account.PaymentFilter{AccountID: 2, ExternalRef: "order-1"})
+	require.NoError(t, err)
+	assert.Len(t, payments, 2)
+
+	payments, err = s.GetPayments(ctx, &account.PaymentFilter{AccountID: 1, Labels: map[string]string{"channel": "web"}})
+	require.NoError(t, err)
+	assert.Len(t, payments, 3)
+}
+
+func TestStorage_SplitPayment(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	createdAt := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, s.ReplaceAccounts(ctx, []*account.Account{
+		account.Create(1, createdAt),
+		account.Create(2, createdAt),
+		account.Create(3, createdAt),
+	}))
+
+	p := (&account.PaymentRequest{
+		From:           1,
+		Amount:         "500",
+		IdempotencyKey: "split-1",
+		Legs:           []*account.PaymentLeg{{To: 3, Amount: "200"}, {To: 2, Amount: "300"}},
+	}).ToPayment(createdAt.Add(time.Hour))
+	require.NoError(t, s.InsertPayment(ctx, p))
+
+	got, err := s.GetPaymentByIdempotencyKey(ctx, 1, "split-1")
+	require.NoError(t, err)
+	assert.Equal(t, account.PaymentTypeSplit, got.Type)
+	require.Len(t, got.Legs, 2)
+	assert.Equal(t, int64(3), got.Legs[0].To)
+	assert.Equal(t, int64(2), got.Legs[1].To)
+
+	payments, err := s.GetPayments(ctx, &account.PaymentFilter{AccountID: 3})
+	require.NoError(t, err)
+	assert.Len(t, payments, 1)
+
+	var streamed []*account.Payment
+	require.NoError(t, s.StreamPayments(ctx, 2, createdAt, createdAt.Add(2*time.Hour), func(p *account.Payment) error {
+		streamed = append(streamed, p)
+		return nil
+	}))
+	require.Len(t, streamed, 1)
+	assert.Len(t, streamed[0].Legs, 2)
+
+	balance, err := s.GetAccountBalanceAt(ctx, 3, createdAt.Add(2*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, "1200", balance)
+}
+
+func TestStorage_BalanceSnapshots_LateCommit(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	createdAt := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, s.ReplaceAccounts(ctx, []*account.Account{
+		account.Create(1, createdAt),
+		account.Create(2, createdAt),
+	}))
+
+	at := createdAt.Add(24 * time.Hour)
+	payment := func(amount string, stampedAt time.Time) *account.Payment {
+		return (&account.PaymentRequest{From: 1, To: 2, Amount: amount}).ToPayment(stampedAt)
+	}
+	// the payment stamped before the snapshot time commits after the one stamped after it,
+	// but before the lag is over and the snapshot is taken.
+	require.NoError(t, s.InsertPayment(ctx, payment("50", at.Add(time.Second))))
+	require.NoError(t, s.ExecTx(ctx, func(ctx context.Context, tx Storage) error {
+		return tx.InsertPayment(ctx, payment("100", at.Add(-time.Second)))
+	}))
+
+	n, err := s.CreateBalanceSnapshots(ctx, at)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+
+	balance := func(id int64, at time.Time) string {
+		b, err := s.GetAccountBalanceAt(ctx, id, at)
+		require.NoError(t, err)
+		return b
+	}
+	assert.Equal(t, "900", balance(1, at))
+	assert.Equal(t, "1100", balance(2, at))
+	assert.Equal(t, "850", balance(1, at.Add(time.Hour)))
+	assert.Equal(t, "1150", balance(2, at.Add(time.Hour)))
+}
+
+func TestStorage_PendingPayment(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	createdAt := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, s.ReplaceAccounts(ctx, []*account.Account{
+		account.Create(1, createdAt),
+		account.Create(2, createdAt),
+	}))
+
+	pending := func(at time.Time) *account.Payment {
+		return (&account.PaymentRequest{From: 1, To: 2, Amount: "100", Pending: true}).ToPayment(at)
+	}
+	confirmed, cancelled := pending(createdAt.Add(time.Hour)), pending(createdAt.Add(time.Hour))
+	require.NoError(t, s.InsertPayment(ctx, confirmed))
+	require.NoError(t, s.InsertPayment(ctx, cancelled))
+
+	balance := func(id int64, at time.Time) string {
+		b, err := s.GetAccountBalanceAt(ctx, id, at)
+		require.NoError(t, err)
+		return b
+	}
+	// the sender is debited when the payments are created, the receiver is not credited yet.
+	assert.Equal(t, "800", balance(1, createdAt.Add(2*time.Hour)))
+	assert.Equal(t, "1000", balance(2, createdAt.Add(2*time.Hour)))
+
+	got, err := s.GetPayment(ctx, confirmed.ID)
+	require.NoError(t, err)
+	require.NoError(t, got.Confirm(createdAt.Add(3*time.Hour)))
+	require.NoError(t, s.UpdatePaymentStatus(ctx, got))
+	assert.Equal(t, account.ErrPaymentNotPending, s.UpdatePaymentStatus(ctx, got))
+
+	require.NoError(t, cancelled.Cancel(createdAt.Add(3*time.Hour)))
+	require.NoError(t, s.UpdatePaymentStatus(ctx, cancelled))
+
+	assert.Equal(t, "1000", balance(2, createdAt.Add(3*time.Hour)))
+	assert.Equal(t, "1100", balance(2, createdAt.Add(4*time.Hour)))
+	assert.Equal(t, "900", balance(1, createdAt.Add(4*time.Hour)))
+
+	// the payments created before the period and settled in it are streamed.
+	var streamed []int64
+	require.NoError(t, s.StreamPayments(ctx, 2, createdAt.Add(2*time.Hour), createdAt.Add(4*time.Hour), func(p *account.Payment) error {
+		streamed = append(streamed, p.ID)
+		return nil
+	}))
+	assert.Equal(t, []int64{confirmed.ID, cancelled.ID}, streamed)
+}
+
+func TestStorage_GetPaymentVelocity(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	createdAt := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, s.ReplaceAccounts(ctx, []*account.Account{
+		account.Create(1, createdAt),
+		account.Create(2, createdAt),
+	}))
+
+	for i, amount := range []string{"10", "20.5", "30"} {
+		p := (&account.PaymentRequest{From: 1, To: 2, Amount: amount, Pending: i == 2}).ToPayment(createdAt.Add(time.Duration(i) * time.Hour))
+		require.NoError(t, s.InsertPayment(ctx, p))
+		if i == 2 {
+			require.NoError(t, p.Cancel(createdAt.Add(3*time.Hour)))
+			require.NoError(t, s.UpdatePaymentStatus(ctx, p))
+		}
+	}
+
+	// the cancelled payment is not counted.
+	v, err := s.GetPaymentVelocity(ctx, 1, createdAt)
+	require.NoError(t, err)
+	assert.Equal(t, &risk.Velocity{Count: 2, Amount: "30.5"}, v)
+
+	v, err = s.GetPaymentVelocity(ctx, 1, createdAt.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, &risk.Velocity{Count: 1, Amount: "20.5"}, v)
+}
+
+func TestStorage_Adjustments(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	createdAt := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, s.ReplaceAccounts(ctx, []*account.Account{account.Create(1, createdAt)}))
+
+	request := &account.AdjustmentRequest{AccountID: 1, Direction: account.AdjustmentCredit, Amount: "10", Reason: "duplicate fee"}
+	approved, rejected := request.ToAdjustment("apikey:1", createdAt), request.ToAdjustment("apikey:1", createdAt.Add(time.Hour))
+	require.NoError(t, s.InsertAdjustment(ctx, approved))
+	require.NoError(t, s.InsertAdjustment(ctx, rejected))
+
+	_, err := s.GetAdjustment(ctx, rejected.ID+1)
+	assert.Equal(t, account.ErrAdjustmentNotFound, err)
+
+	got, err := s.GetAdjustment(ctx, approved.ID)
+	require.NoError(t, err)
+	assert.Equal(t, approved, got)
+	require.NoError(t, got.Approve("apikey:2", createdAt.Add(2*time.Hour)))
+	require.NoError(t, s.UpdateAdjustment(ctx, got))
+	assert.Equal(t, account.ErrAdjustmentNotProposed, s.UpdateAdjustment(ctx, got))
+
+	require.NoError(t, rejected.Reject("apikey:1", createdAt.Add(2*time.Hour)))
+	require.NoError(t, s.UpdateAdjustment(ctx, rejected))
+
+	history, err := s.GetAdjustments(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []*account.Adjustment{got, rejected}, history)
+}
+
+func TestStorage_Payouts(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	createdAt := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, s.ReplaceAccounts(ctx, []*account.Account{
+		account.Create(1, createdAt),
+		account.Create(account.SystemAccountPayouts, createdAt),
+	}))
+
+	withdrawal := func(amount string) *account.Payout {
+		r := (&account.FundsRequest{AccountID: 1, Amount: amount}).ToWithdrawalRequest()
+		r.Pending = true
+		p := r.ToPayment(createdAt)
+		require.NoError(t, s.InsertPayment(ctx, p))
+		payout := account.NewPayout(p, createdAt)
+		require.NoError(t, s.InsertPayout(ctx, payout))
+		return payout
+	}
+	submitted, requested := withdrawal("10"), withdrawal("20")
+
+	_, err := s.GetPayoutByPaymentID(ctx, requested.PaymentID+1)
+	assert.Equal(t, account.ErrPayoutNotFound, err)
+
+	got, err := s.GetPayoutByPaymentID(ctx, submitted.PaymentID)
+	require.NoError(t, err)
+	assert.Equal(t, submitted, got)
+	require.NoError(t, got.Submit("ref-1", createdAt.Add(time.Hour)))
+	require.NoError(t, s.UpdatePayout(ctx, got, account.PayoutStatusRequested))
+	assert.Equal(t, account.ErrUnexpectedPayoutStatus, s.UpdatePayout(ctx, got, account.PayoutStatusRequested))
+
+	payouts, err := s.GetPayouts(ctx, account.PayoutStatusRequested, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []*account.Payout{requested}, payouts)
+
+	payouts, err = s.GetPayouts(ctx, account.PayoutStatusSubmitted, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []*account.Payout{got}, payouts)
+}
+
+func TestStorage_InterestAccruals(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	createdAt := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, s.ReplaceAccounts(ctx, []*account.Account{
+		account.Create(1, createdAt),
+		account.Create(account.SystemAccountInterest, createdAt),
+	}))
+
+	accrual := func(day int, amount string) *account.InterestAccrual {
+		return &account.InterestAccrual{
+			AccountID: 1,
+			Day:       time.Date(2021, 3, day, 0, 0, 0, 0, time.UTC),
+			Balance:   "1000",
+			Rate:      "0.0365",
+			Amount:    amount,
+		}
+	}
+	n, err := s.InsertInterestAccruals(ctx, []*account.InterestAccrual{accrual(30, "0.10000000"), accrual(31, "0.12000000")})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+
+	// the accrued days are skipped.
+	n, err = s.InsertInterestAccruals(ctx, []*account.InterestAccrual{accrual(31, "0.5"), accrual(29, "0.01000000")})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+
+	before := time.Date(2021, 3, 31, 0, 0, 0, 0, time.UTC)
+	unposted, err := s.GetUnpostedInterest(ctx, 1, before)
+	require.NoError(t, err)
+	assert.Equal(t, "0.11000000", unposted)
+
+	p := account.NewInterestPosting(1, "0.11", createdAt, before)
+	require.NoError(t, s.InsertPayment(ctx, p))
+	require.NoError(t, s.SetInterestPayment(ctx, 1, before, p.ID))
+
+	unposted, err = s.GetUnpostedInterest(ctx, 1, before.Add(24*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, "0.12000000", unposted)
+
+	posted, err := s.IsInterestPosted(ctx, 1, createdAt, before)
+	require.NoError(t, err)
+	assert.True(t, posted)
+	posted, err = s.IsInterestPosted(ctx, 1, before, before.Add(24*time.Hour))
+	require.NoError(t, err)
+	assert.False(t, posted)
+}
+
+func TestStorage_GetTotalBalances(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	createdAt := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	user, funding := account.Create(1, createdAt), account.Create(account.SystemAccountFunding, createdAt)
+	user.Balance, funding.Balance = "1250.00", "-250.00"
+	require.NoError(t, s.ReplaceAccounts(ctx, []*account.Account{user, funding, account.Create(2, createdAt)}))
+
+	totals, err := s.GetTotalBalances(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{account.TypeUser: "2250.00", account.TypeFunding: "-250.00"}, totals)
+
+	got, err := s.ListAccounts(ctx, &account.ListRequest{Type: account.TypeFunding}, 10)
+	require.NoError(t, err)
+	if assert.Len(t, got, 1) {
+		assert.Equal(t, account.SystemAccountFunding, got[0].ID)
+	}
+}
+
+func TestStorage_AuditEntries(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	createdAt := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	newEntry := func(accountID int64) *audit.Entry {
+		return &audit.Entry{Action: audit.ActionUpdate, Actor: "apikey:1", AccountID: accountID, CreatedAt: createdAt}
+	}
+	first := []*audit.Entry{newEntry(1), newEntry(2), newEntry(1)}
+	require.NoError(t, s.ExecTx(ctx, func(ctx context.Context, tx Storage) error {
+		return tx.AppendAuditEntries(ctx, first)
+	}))
+	second := []*audit.Entry{newEntry(2), newEntry(3)}
+	require.NoError(t, s.ExecTx(ctx, func(ctx context.Context, tx Storage) error {
+		return tx.AppendAuditEntries(ctx, second)
+	}))
+
+	entries, err := s.GetAuditEntries(ctx, 0, 10)
+	require.NoError(t, err)
+	assert.Equal(t, append(first, second...), entries)
+
+	report, err := audit.Verify(ctx, s, 2)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), report.Entries)
+	assert.Empty(t, report.Breaks)
+}
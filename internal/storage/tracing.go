@@ -0,0 +1,312 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/shkov/wallet-service/internal/account"
+	"github.com/shkov/wallet-service/internal/audit"
+	"github.com/shkov/wallet-service/internal/auth"
+	"github.com/shkov/wallet-service/internal/reconcile"
+	"github.com/shkov/wallet-service/internal/risk"
+)
+
+const tracerName = "github.com/shkov/wallet-service/internal/storage"
+
+// tracingMiddleware wraps TransactionalStorage and records spans.
+type tracingMiddleware struct {
+	*tracingStorage
+	next TransactionalStorage
+}
+
+// tracingStorage wraps Storage and records spans.
+type tracingStorage struct {
+	next   Storage
+	tracer trace.Tracer
+}
+
+// NewTracingMiddleware creates a new tracing middleware.
+func NewTracingMiddleware(next TransactionalStorage) TransactionalStorage {
+	return &tracingMiddleware{
+		tracingStorage: &tracingStorage{
+			next:   next,
+			tracer: otel.Tracer(tracerName),
+		},
+		next: next,
+	}
+}
+
+func (mw *tracingStorage) GetAccount(ctx context.Context, id int64) (*account.Account, error) {
+	ctx, span := mw.start(ctx, "GetAccount", attribute.Int64("account.id", id))
+	out, err := mw.next.GetAccount(ctx, id)
+	finishSpan(span, err)
+	return out, err
+}
+
+func (mw *tracingStorage) GetAccounts(ctx context.Context, ids []int64) ([]*account.Account, error) {
+	ctx, span := mw.start(ctx, "GetAccounts", attribute.Int64Slice("account.ids", ids))
+	out, err := mw.next.GetAccounts(ctx, ids)
+	finishSpan(span, err)
+	return out, err
+}
+
+func (mw *tracingStorage) ListAccounts(ctx context.Context, r *account.ListRequest, limit int) ([]*account.Account, error) {
+	ctx, span := mw.start(ctx, "ListAccounts", attribute.Int("limit", limit))
+	out, err := mw.next.ListAccounts(ctx, r, limit)
+	finishSpan(span, err)
+	return out, err
+}
+
+func (mw *tracingStorage) GetPayments(ctx context.Context, f *account.PaymentFilter) ([]*account.Payment, error) {
+	ctx, span := mw.start(ctx, "GetPayments", attribute.Int64("account.id", f.AccountID))
+	out, err := mw.next.GetPayments(ctx, f)
+	finishSpan(span, err)
+	return out, err
+}
+
+func (mw *tracingStorage) StreamPayments(ctx context.Context, accountID int64, from, to time.Time, fn func(*account.Payment) error) error {
+	ctx, span := mw.start(ctx, "StreamPayments", attribute.Int64("account.id", accountID))
+	err := mw.next.StreamPayments(ctx, accountID, from, to, fn)
+	finishSpan(span, err)
+	return err
+}
+
+func (mw *tracingStorage) GetAccountBalanceAt(ctx context.Context, accountID int64, at time.Time) (string, error) {
+	ctx, span := mw.start(ctx, "GetAccountBalanceAt", attribute.Int64("account.id", accountID))
+	out, err := mw.next.GetAccountBalanceAt(ctx, accountID, at)
+	finishSpan(span, err)
+	return out, err
+}
+
+func (mw *tracingStorage) CreateBalanceSnapshots(ctx context.Context, at time.Time) (int64, error) {
+	ctx, span := mw.start(ctx, "CreateBalanceSnapshots")
+	out, err := mw.next.CreateBalanceSnapshots(ctx, at)
+	finishSpan(span, err)
+	return out, err
+}
+
+func (mw *tracingStorage) GetPaymentByIdempotencyKey(ctx context.Context, from int64, key string) (*account.Payment, error) {
+	ctx, span := mw.start(ctx, "GetPaymentByIdempotencyKey", attribute.Int64("payment.from", from))
+	out, err := mw.next.GetPaymentByIdempotencyKey(ctx, from, key)
+	finishSpan(span, err)
+	return out, err
+}
+
+func (mw *tracingStorage) GetPayment(ctx context.Context, id int64) (*account.Payment, error) {
+	ctx, span := mw.start(ctx, "GetPayment", attribute.Int64("payment.id", id))
+	out, err := mw.next.GetPayment(ctx, id)
+	finishSpan(span, err)
+	return out, err
+}
+
+func (mw *tracingStorage) GetPaymentVelocity(ctx context.Context, accountID int64, since time.Time) (*risk.Velocity, error) {
+	ctx, span := mw.start(ctx, "GetPaymentVelocity", attribute.Int64("account.id", accountID))
+	out, err := mw.next.GetPaymentVelocity(ctx, accountID, since)
+	finishSpan(span, err)
+	return out, err
+}
+
+func (mw *tracingStorage) InsertPayment(ctx context.Context, p *account.Payment) error {
+	ctx, span := mw.start(ctx, "InsertPayment")
+	err := mw.next.InsertPayment(ctx, p)
+	finishSpan(span, err)
+	return err
+}
+
+func (mw *tracingStorage) InsertPayments(ctx context.Context, pp []*account.Payment) error {
+	ctx, span := mw.start(ctx, "InsertPayments", attribute.Int("payments.count", len(pp)))
+	err := mw.next.InsertPayments(ctx, pp)
+	finishSpan(span, err)
+	return err
+}
+
+func (mw *tracingStorage) UpdatePaymentStatus(ctx context.Context, p *account.Payment) error {
+	ctx, span := mw.start(ctx, "UpdatePaymentStatus", attribute.Int64("payment.id", p.ID))
+	err := mw.next.UpdatePaymentStatus(ctx, p)
+	finishSpan(span, err)
+	return err
+}
+
+func (mw *tracingStorage) InsertAdjustment(ctx context.Context, a *account.Adjustment) error {
+	ctx, span := mw.start(ctx, "InsertAdjustment", attribute.Int64("account.id", a.AccountID))
+	err := mw.next.InsertAdjustment(ctx, a)
+	finishSpan(span, err)
+	return err
+}
+
+func (mw *tracingStorage) GetAdjustment(ctx context.Context, id int64) (*account.Adjustment, error) {
+	ctx, span := mw.start(ctx, "GetAdjustment", attribute.Int64("adjustment.id", id))
+	out, err := mw.next.GetAdjustment(ctx, id)
+	finishSpan(span, err)
+	return out, err
+}
+
+func (mw *tracingStorage) UpdateAdjustment(ctx context.Context, a *account.Adjustment) error {
+	ctx, span := mw.start(ctx, "UpdateAdjustment", attribute.Int64("adjustment.id", a.ID))
+	err := mw.next.UpdateAdjustment(ctx, a)
+	finishSpan(span, err)
+	return err
+}
+
+func (mw *tracingStorage) GetAdjustments(ctx context.Context, accountID int64) ([]*account.Adjustment, error) {
+	ctx, span := mw.start(ctx, "GetAdjustments", attribute.Int64("account.id", accountID))
+	out, err := mw.next.GetAdjustments(ctx, accountID)
+	finishSpan(span, err)
+	return out, err
+}
+
+func (mw *tracingStorage) InsertPayout(ctx context.Context, p *account.Payout) error {
+	ctx, span := mw.start(ctx, "InsertPayout", attribute.Int64("payment.id", p.PaymentID))
+	err := mw.next.InsertPayout(ctx, p)
+	finishSpan(span, err)
+	return err
+}
+
+func (mw *tracingStorage) GetPayouts(ctx context.Context, status string, limit int) ([]*account.Payout, error) {
+	ctx, span := mw.start(ctx, "GetPayouts", attribute.String("payout.status", status))
+	out, err := mw.next.GetPayouts(ctx, status, limit)
+	finishSpan(span, err)
+	return out, err
+}
+
+func (mw *tracingStorage) GetPayoutByPaymentID(ctx context.Context, paymentID int64) (*account.Payout, error) {
+	ctx, span := mw.start(ctx, "GetPayoutByPaymentID", attribute.Int64("payment.id", paymentID))
+	out, err := mw.next.GetPayoutByPaymentID(ctx, paymentID)
+	finishSpan(span, err)
+	return out, err
+}
+
+func (mw *tracingStorage) UpdatePayout(ctx context.Context, p *account.Payout, from string) error {
+	ctx, span := mw.start(ctx, "UpdatePayout", attribute.Int64("payout.id", p.ID))
+	err := mw.next.UpdatePayout(ctx, p, from)
+	finishSpan(span, err)
+	return err
+}
+
+func (mw *tracingStorage) InsertInterestAccruals(ctx context.Context, accruals []*account.InterestAccrual) (int64, error) {
+	ctx, span := mw.start(ctx, "InsertInterestAccruals", attribute.Int("interest.accruals", len(accruals)))
+	out, err := mw.next.InsertInterestAccruals(ctx, accruals)
+	finishSpan(span, err)
+	return out, err
+}
+
+func (mw *tracingStorage) GetUnpostedInterest(ctx context.Context, accountID int64, before time.Time) (string, error) {
+	ctx, span := mw.start(ctx, "GetUnpostedInterest", attribute.Int64("account.id", accountID))
+	out, err := mw.next.GetUnpostedInterest(ctx, accountID, before)
+	finishSpan(span, err)
+	return out, err
+}
+
+func (mw *tracingStorage) SetInterestPayment(ctx context.Context, accountID int64, before time.Time, paymentID int64) error {
+	ctx, span := mw.start(ctx, "SetInterestPayment", attribute.Int64("account.id", accountID), attribute.Int64("payment.id", paymentID))
+	err := mw.next.SetInterestPayment(ctx, accountID, before, paymentID)
+	finishSpan(span, err)
+	return err
+}
+
+func (mw *tracingStorage) IsInterestPosted(ctx context.Context, accountID int64, from, before time.Time) (bool, error) {
+	ctx, span := mw.start(ctx, "IsInterestPosted", attribute.Int64("account.id", accountID))
+	out, err := mw.next.IsInterestPosted(ctx, accountID, from, before)
+	finishSpan(span, err)
+	return out, err
+}
+
+func (mw *tracingStorage) ReplaceAccounts(ctx context.Context, aa []*account.Account) error {
+	ctx, span := mw.start(ctx, "ReplaceAccounts", attribute.Int("accounts.count", len(aa)))
+	err := mw.next.ReplaceAccounts(ctx, aa)
+	finishSpan(span, err)
+	return err
+}
+
+func (mw *tracingStorage) UpsertAccounts(ctx context.Context, aa []*account.Account) error {
+	ctx, span := mw.start(ctx, "UpsertAccounts", attribute.Int("accounts.count", len(aa)))
+	err := mw.next.UpsertAccounts(ctx, aa)
+	finishSpan(span, err)
+	return err
+}
+
+func (mw *tracingStorage) UpdateAccountDetails(ctx context.Context, a *account.Account) error {
+	ctx, span := mw.start(ctx, "UpdateAccountDetails", attribute.Int64("account.id", a.ID))
+	err := mw.next.UpdateAccountDetails(ctx, a)
+	finishSpan(span, err)
+	return err
+}
+
+func (mw *tracingStorage) GetTotalBalances(ctx context.Context) (map[string]string, error) {
+	ctx, span := mw.start(ctx, "GetTotalBalances")
+	out, err := mw.next.GetTotalBalances(ctx)
+	finishSpan(span, err)
+	return out, err
+}
+
+func (mw *tracingStorage) GetAPIKey(ctx context.Context, keyHash string) (*auth.APIKey, error) {
+	ctx, span := mw.start(ctx, "GetAPIKey")
+	out, err := mw.next.GetAPIKey(ctx, keyHash)
+	finishSpan(span, err)
+	return out, err
+}
+
+func (mw *tracingStorage) GetBalanceDiscrepancies(ctx context.Context) ([]*reconcile.Discrepancy, error) {
+	ctx, span := mw.start(ctx, "GetBalanceDiscrepancies")
+	out, err := mw.next.GetBalanceDiscrepancies(ctx)
+	finishSpan(span, err)
+	return out, err
+}
+
+func (mw *tracingStorage) AppendAuditEntries(ctx context.Context, entries []*audit.Entry) error {
+	ctx, span := mw.start(ctx, "AppendAuditEntries", attribute.Int("audit.entries", len(entries)))
+	err := mw.next.AppendAuditEntries(ctx, entries)
+	finishSpan(span, err)
+	return err
+}
+
+func (mw *tracingStorage) GetAuditEntries(ctx context.Context, afterSeq int64, limit int) ([]*audit.Entry, error) {
+	ctx, span := mw.start(ctx, "GetAuditEntries", attribute.Int64("audit.after_seq", afterSeq))
+	out, err := mw.next.GetAuditEntries(ctx, afterSeq, limit)
+	finishSpan(span, err)
+	return out, err
+}
+
+func (mw *tracingStorage) GetDetailsChanges(ctx context.Context, since time.Time) ([]*audit.Entry, error) {
+	ctx, span := mw.start(ctx, "GetDetailsChanges")
+	out, err := mw.next.GetDetailsChanges(ctx, since)
+	finishSpan(span, err)
+	return out, err
+}
+
+func (mw *tracingMiddleware) Ping(ctx context.Context) error {
+	return mw.next.Ping(ctx)
+}
+
+func (mw *tracingMiddleware) Close() error {
+	return mw.next.Close()
+}
+
+func (mw *tracingMiddleware) ExecTx(ctx context.Context, fn func(context.Context, Storage) error) error {
+	ctx, span := mw.start(ctx, "ExecTx")
+	fnWithTracing := func(ctx context.Context, s Storage) error {
+		return fn(ctx, &tracingStorage{next: s, tracer: mw.tracer})
+	}
+	err := mw.next.ExecTx(ctx, fnWithTracing)
+	finishSpan(span, err)
+	return err
+}
+
+func (mw *tracingStorage) start(ctx context.Context, method string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	attrs = append(attrs, semconv.DBSystemPostgreSQL, semconv.DBOperationKey.String(method))
+	return mw.tracer.Start(ctx, "Storage."+method, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+}
+
+func finishSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
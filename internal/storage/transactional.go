@@ -11,6 +11,7 @@ import (
 type TransactionalStorage interface {
 	Storage
 	ExecTx(ctx context.Context, fn func(context.Context, Storage) error) error
+	Ping(ctx context.Context) error
 	Close() error
 }
 
@@ -48,6 +49,11 @@ func NewTransactional(cfg Config) TransactionalStorage {
 	}
 }
 
+// Ping checks that the database is reachable.
+func (ts *transactionalStorage) Ping(ctx context.Context) error {
+	return ts.conn.Ping(ctx)
+}
+
 func (ts *transactionalStorage) Close() error {
 	return ts.conn.Close()
 }
@@ -0,0 +1,120 @@
+package walletservice
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/gorilla/mux"
+
+	"github.com/shkov/wallet-service/internal/requestid"
+)
+
+// accessLogEntry collects the request details that are known only to the handlers.
+type accessLogEntry struct {
+	mu         sync.Mutex
+	accountIDs []int64
+	// err fails the response after its status is written.
+	err error
+}
+
+type accessLogContextKey struct{}
+
+// logAccountIDs adds the account ids touched by the request to its access log entry.
+func logAccountIDs(ctx context.Context, ids ...int64) {
+	e, ok := ctx.Value(accessLogContextKey{}).(*accessLogEntry)
+	if !ok {
+		return
+	}
+	e.mu.Lock()
+	e.accountIDs = append(e.accountIDs, ids...)
+	e.mu.Unlock()
+}
+
+// logResponseError adds the first error failing the response after its status is written to its access log
+// entry, the entry is logged as an error.
+func logResponseError(ctx context.Context, err error) {
+	e, ok := ctx.Value(accessLogContextKey{}).(*accessLogEntry)
+	if !ok {
+		return
+	}
+	e.mu.Lock()
+	if e.err == nil {
+		e.err = err
+	}
+	e.mu.Unlock()
+}
+
+// statusRecorder records the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.code = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// newAccessLogHandler assigns a request id to every request and writes an access log entry for it.
+// A request id provided by the caller in the X-Request-ID header is kept, the id is echoed in the response.
+func newAccessLogHandler(router *mux.Router, logger log.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		startedAt := time.Now()
+
+		id := r.Header.Get(requestid.Header)
+		if !requestid.Valid(id) {
+			id = requestid.New()
+		}
+		w.Header().Set(requestid.Header, id)
+
+		entry := &accessLogEntry{}
+		ctx := requestid.NewContext(r.Context(), id)
+		ctx = context.WithValue(ctx, accessLogContextKey{}, entry)
+
+		rec := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+		router.ServeHTTP(rec, r.WithContext(ctx))
+
+		route := "unknown"
+		var match mux.RouteMatch
+		if router.Match(r, &match) && match.Route != nil {
+			route = match.Route.GetName()
+		}
+
+		entry.mu.Lock()
+		accountIDs, err := entry.accountIDs, entry.err
+		entry.mu.Unlock()
+
+		keyvals := []interface{}{
+			"msg", "request",
+			"request_id", id,
+			"method", r.Method,
+			"route", route,
+			"path", r.URL.Path,
+			"status", rec.code,
+			"took", time.Since(startedAt),
+			"account_ids", formatAccountIDs(accountIDs),
+			"remote_addr", r.RemoteAddr,
+		}
+		if err != nil {
+			level.Error(logger).Log(append(keyvals, "err", err)...)
+			return
+		}
+		level.Info(logger).Log(keyvals...)
+	})
+}
+
+func formatAccountIDs(ids []int64) string {
+	b := make([]byte, 0, len(ids)*8)
+	for i, id := range ids {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = strconv.AppendInt(b, id, 10)
+	}
+	return string(b)
+}
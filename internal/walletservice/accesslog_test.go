@@ -0,0 +1,82 @@
+package walletservice
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shkov/wallet-service/internal/account"
+	"github.com/shkov/wallet-service/internal/auth"
+	"github.com/shkov/wallet-service/internal/requestid"
+)
+
+func TestAccessLogHandler(t *testing.T) {
+	testCases := []struct {
+		name          string
+		requestID     string
+		wantRequestID string
+	}{
+		{
+			name:          "request id is kept",
+			requestID:     "5f0c6a3e-3b7c-4a1e-9c36-2b8f0f2d6a11",
+			wantRequestID: "5f0c6a3e-3b7c-4a1e-9c36-2b8f0f2d6a11",
+		},
+		{
+			name:          "invalid request id is replaced",
+			requestID:     "id with spaces",
+			wantRequestID: "",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var gotRequestID string
+			svc := &mockService{
+				onGetAccount: func(ctx context.Context, id int64) (*account.Account, error) {
+					gotRequestID = requestid.FromContext(ctx)
+					return makeAccount(t, nil), nil
+				},
+			}
+			var buf bytes.Buffer
+			server := httptest.NewServer(newAccessLogHandler(makeHandler(svc, testAuthenticator, newRateLimiter(RateLimitConfig{})), log.NewLogfmtLogger(&buf)))
+			defer server.Close()
+
+			req, err := http.NewRequest(http.MethodGet, server.URL+"/api/v1/accounts/1", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set(auth.APIKeyHeader, "full-access")
+			req.Header.Set(requestid.Header, tc.requestID)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if tc.wantRequestID == "" {
+				assert.True(t, requestid.Valid(gotRequestID))
+				assert.NotEqual(t, tc.requestID, gotRequestID)
+			} else {
+				assert.Equal(t, tc.wantRequestID, gotRequestID)
+			}
+			assert.Equal(t, gotRequestID, resp.Header.Get(requestid.Header))
+
+			logLine := buf.String()
+			for _, want := range []string{
+				"request_id=" + gotRequestID,
+				"method=GET",
+				"route=GetAccount",
+				"status=200",
+				"account_ids=1",
+			} {
+				assert.True(t, strings.Contains(logLine, want), "%q does not contain %q", logLine, want)
+			}
+		})
+	}
+}
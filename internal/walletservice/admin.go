@@ -0,0 +1,149 @@
+package walletservice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"reflect"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/shkov/wallet-service/internal/health"
+)
+
+const redacted = "REDACTED"
+
+// AdminServerConfig is an admin server configuration.
+type AdminServerConfig struct {
+	// Host is the interface the admin server listens on, all of them if empty. The admin endpoints
+	// are not authenticated, so it must not be reachable from outside the trusted network.
+	Host            string
+	Port            string
+	ShutdownTimeout time.Duration
+	// DrainDelay keeps the admin server up while the service is draining, see ServerConfig.
+	DrainDelay time.Duration
+	Health     *health.Registry
+	// LogLevel reports and switches the log level.
+	LogLevel http.Handler
+	// Config is the runtime configuration dumped by /config, fields tagged with
+	// secret:"true" are redacted.
+	Config interface{}
+}
+
+// AdminServer serves the operational endpoints: metrics, pprof, health checks,
+// the runtime configuration and the log level. It must not be exposed publicly.
+type AdminServer struct {
+	cfg *AdminServerConfig
+	srv *http.Server
+}
+
+// NewAdminServer creates a new admin server.
+func NewAdminServer(cfg AdminServerConfig) (*AdminServer, error) {
+	if cfg.Health == nil {
+		return nil, errors.New("must provide Health")
+	}
+	if cfg.LogLevel == nil {
+		return nil, errors.New("must provide LogLevel")
+	}
+
+	s := &AdminServer{
+		cfg: &cfg,
+		srv: &http.Server{
+			Handler: makeAdminHandler(&cfg),
+			Addr:    net.JoinHostPort(cfg.Host, cfg.Port),
+		},
+	}
+	return s, nil
+}
+
+// Serve starts the admin server and stops it when the provided context is canceled.
+func (s *AdminServer) Serve(ctx context.Context) error {
+	return serve(ctx, s.srv, s.cfg.ShutdownTimeout, func() {
+		s.cfg.Health.SetDraining()
+		time.Sleep(s.cfg.DrainDelay)
+	})
+}
+
+func makeAdminHandler(cfg *AdminServerConfig) http.Handler {
+	router := http.NewServeMux()
+	router.Handle("/metrics", promhttp.Handler())
+	router.Handle("/healthz", cfg.Health.LivenessHandler())
+	router.Handle("/readyz", cfg.Health.ReadinessHandler())
+	router.Handle("/loglevel", cfg.LogLevel)
+	router.Handle("/config", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(redactConfig(cfg.Config))
+	}))
+
+	router.Handle("/debug/pprof/", http.HandlerFunc(pprof.Index))
+	router.Handle("/debug/pprof/cmdline", http.HandlerFunc(pprof.Cmdline))
+	router.Handle("/debug/pprof/profile", http.HandlerFunc(pprof.Profile))
+	router.Handle("/debug/pprof/symbol", http.HandlerFunc(pprof.Symbol))
+	router.Handle("/debug/pprof/trace", http.HandlerFunc(pprof.Trace))
+
+	return router
+}
+
+// redactConfig converts the configuration struct into a map keyed by the envconfig names,
+// replacing the values of the non-empty fields tagged with secret:"true". The fields of
+// the embedded structs are included if the embedded struct type is exported.
+func redactConfig(cfg interface{}) map[string]interface{} {
+	out := make(map[string]interface{})
+	v := reflect.Indirect(reflect.ValueOf(cfg))
+	if v.Kind() != reflect.Struct {
+		return out
+	}
+
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			for name, value := range redactConfig(v.Field(i).Interface()) {
+				out[name] = value
+			}
+			continue
+		}
+		name := f.Tag.Get("envconfig")
+		if name == "" {
+			name = f.Name
+		}
+
+		value := v.Field(i)
+		switch {
+		case f.Tag.Get("secret") == "true":
+			if value.IsZero() {
+				out[name] = ""
+			} else {
+				out[name] = redacted
+			}
+		case value.Kind() == reflect.Map:
+			m := make(map[string]interface{}, value.Len())
+			iter := value.MapRange()
+			for iter.Next() {
+				m[fmt.Sprint(iter.Key().Interface())] = formatConfigValue(iter.Value())
+			}
+			out[name] = m
+		default:
+			out[name] = formatConfigValue(value)
+		}
+	}
+	return out
+}
+
+// formatConfigValue renders the values implementing fmt.Stringer, like time.Duration, as strings.
+func formatConfigValue(v reflect.Value) interface{} {
+	if s, ok := v.Interface().(fmt.Stringer); ok {
+		return s.String()
+	}
+	return v.Interface()
+}
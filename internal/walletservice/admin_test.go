@@ -0,0 +1,83 @@
+package walletservice
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/shkov/wallet-service/internal/health"
+	"github.com/shkov/wallet-service/internal/loglevel"
+	"github.com/shkov/wallet-service/internal/ratelimit"
+)
+
+type TestEmbeddedConfig struct {
+	Token string `envconfig:"TOKEN" secret:"true"`
+}
+
+type testAdminConfig struct {
+	TestEmbeddedConfig
+
+	Port        string                    `envconfig:"PORT"`
+	Timeout     time.Duration             `envconfig:"TIMEOUT"`
+	Routes      map[string]ratelimit.Rule `envconfig:"ROUTES"`
+	Password    string                    `envconfig:"PASSWORD" secret:"true"`
+	EmptySecret string                    `envconfig:"EMPTY_SECRET" secret:"true"`
+	NoTag       bool
+	unexported  string
+}
+
+func TestAdminHandler(t *testing.T) {
+	handler := makeAdminHandler(&AdminServerConfig{
+		Health:   health.NewRegistry(time.Second),
+		LogLevel: loglevel.NewLogger(log.NewNopLogger()).Handler(),
+		Config: testAdminConfig{
+			TestEmbeddedConfig: TestEmbeddedConfig{Token: "token"},
+
+			Port:       "8080",
+			Timeout:    time.Second,
+			Routes:     map[string]ratelimit.Rule{"ApplyPayment": {Limit: 60, Period: time.Minute}},
+			Password:   "secret",
+			NoTag:      true,
+			unexported: "hidden",
+		},
+	})
+
+	for _, path := range []string{"/metrics", "/healthz", "/readyz", "/loglevel", "/config", "/debug/pprof/", "/debug/pprof/heap"} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, http.StatusOK, rec.Code, path)
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/config", nil))
+	var got map[string]interface{}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&got))
+	assert.Equal(t, map[string]interface{}{
+		"TOKEN":        redacted,
+		"PORT":         "8080",
+		"TIMEOUT":      "1s",
+		"ROUTES":       map[string]interface{}{"ApplyPayment": "60/1m0s"},
+		"PASSWORD":     redacted,
+		"EMPTY_SECRET": "",
+		"NoTag":        true,
+	}, got)
+}
+
+func TestNewAdminServer_Addr(t *testing.T) {
+	srv, err := NewAdminServer(AdminServerConfig{
+		Host:     "127.0.0.1",
+		Port:     "8081",
+		Health:   health.NewRegistry(time.Second),
+		LogLevel: loglevel.NewLogger(log.NewNopLogger()).Handler(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "127.0.0.1:8081", srv.srv.Addr)
+}
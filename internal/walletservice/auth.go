@@ -0,0 +1,81 @@
+package walletservice
+
+import (
+	"context"
+	"errors"
+
+	"github.com/go-kit/kit/endpoint"
+
+	"github.com/shkov/wallet-service/internal/account"
+	"github.com/shkov/wallet-service/internal/auth"
+)
+
+// newAuthMiddleware authenticates the caller and requires the given scope.
+func newAuthMiddleware(authenticator auth.Authenticator, scope string) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			p, err := authenticator.Authenticate(ctx, auth.CredentialsFromContext(ctx))
+			if err != nil {
+				if errors.Is(err, auth.ErrNoCredentials) || errors.Is(err, auth.ErrInvalidCredentials) {
+					return nil, errUnauthorized("%v", err)
+				}
+				return nil, errInternal("failed to authenticate: %v", err)
+			}
+			if !p.HasScope(scope) {
+				return nil, errForbidden("scope %q is required", scope)
+			}
+			return next(auth.NewContext(ctx, p), request)
+		}
+	}
+}
+
+// newOwnerMiddleware requires the authenticated caller to own the account returned by accountID.
+func newOwnerMiddleware(accountID func(request interface{}) int64) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			p, ok := auth.FromContext(ctx)
+			if !ok {
+				return nil, errUnauthorized("%v", auth.ErrNoCredentials)
+			}
+			id := accountID(request)
+			if !p.OwnsAccount(id) {
+				return nil, errForbidden("account %d is not owned by %s", id, p.Subject)
+			}
+			return next(ctx, request)
+		}
+	}
+}
+
+// newConditionalScopeMiddleware requires the authenticated caller to have the given scope
+// for the requests the required function returns true for.
+func newConditionalScopeMiddleware(scope string, required func(request interface{}) bool) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			p, ok := auth.FromContext(ctx)
+			if !ok {
+				return nil, errUnauthorized("%v", auth.ErrNoCredentials)
+			}
+			if required(request) && !p.HasScope(scope) {
+				return nil, errForbidden("scope %q is required", scope)
+			}
+			return next(ctx, request)
+		}
+	}
+}
+
+// newOwnedAccountsMiddleware restricts the account listing to the accounts owned by the caller,
+// unless the caller is granted access to all accounts.
+func newOwnedAccountsMiddleware(listRequest func(request interface{}) *account.ListRequest) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			p, ok := auth.FromContext(ctx)
+			if !ok {
+				return nil, errUnauthorized("%v", auth.ErrNoCredentials)
+			}
+			if !p.HasScope(auth.ScopeAllAccounts) {
+				listRequest(request).IDs = append([]int64{}, p.AccountIDs...)
+			}
+			return next(ctx, request)
+		}
+	}
+}
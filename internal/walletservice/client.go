@@ -9,14 +9,48 @@ import (
 
 	"github.com/go-kit/kit/endpoint"
 	kithttp "github.com/go-kit/kit/transport/http"
+	"github.com/sony/gobreaker"
 
 	"github.com/shkov/wallet-service/internal/account"
+	"github.com/shkov/wallet-service/internal/auth"
+	"github.com/shkov/wallet-service/internal/requestid"
 )
 
 // ClientConfig is an Client configuration.
 type ClientConfig struct {
 	ServiceURL string
-	Timeout    time.Duration
+	// Timeout limits a single HTTP request.
+	Timeout time.Duration
+	// CallTimeout limits a whole call including retries, zero means no limit.
+	CallTimeout time.Duration
+
+	// APIKey or BearerToken is sent with every request to authenticate the client.
+	APIKey      string
+	BearerToken string
+	// Headers are added to every request.
+	Headers http.Header
+
+	Retry          RetryPolicy
+	CircuitBreaker CircuitBreakerConfig
+}
+
+// RetryPolicy configures retries of transient failures. Only idempotent calls
+// and payments with an idempotency key are retried.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts, zero or one disables retries.
+	MaxAttempts int
+	// Backoff is the delay before the first retry, it doubles with every next one.
+	Backoff time.Duration
+	// MaxBackoff caps the delay between retries, zero means no cap.
+	MaxBackoff time.Duration
+}
+
+// CircuitBreakerConfig configures the circuit breaker shared by all calls of the client.
+type CircuitBreakerConfig struct {
+	// ConsecutiveFailures opens the breaker, zero disables the breaker.
+	ConsecutiveFailures uint32
+	// OpenTimeout is how long the breaker stays open before letting a trial call through.
+	OpenTimeout time.Duration
 }
 
 func (cfg ClientConfig) validate() error {
@@ -26,14 +60,33 @@ func (cfg ClientConfig) validate() error {
 	if cfg.Timeout <= 0 {
 		return errors.New("invalid Timeout")
 	}
+	if cfg.CallTimeout < 0 {
+		return errors.New("invalid CallTimeout")
+	}
+	if cfg.Retry.MaxAttempts < 0 || cfg.Retry.Backoff < 0 || cfg.Retry.MaxBackoff < 0 {
+		return errors.New("invalid Retry")
+	}
+	if cfg.CircuitBreaker.OpenTimeout < 0 {
+		return errors.New("invalid CircuitBreaker")
+	}
 	return nil
 }
 
 // Client is a wallet-service client.
 type client struct {
-	getPaymentsEndpoint  endpoint.Endpoint
-	getAccountEndpoint   endpoint.Endpoint
-	applyPaymentEndpoint endpoint.Endpoint
+	getPaymentsEndpoint   endpoint.Endpoint
+	getAccountEndpoint    endpoint.Endpoint
+	listAccountsEndpoint  endpoint.Endpoint
+	updateAccountEndpoint endpoint.Endpoint
+	applyPaymentEndpoint  endpoint.Endpoint
+	settlePaymentEndpoint endpoint.Endpoint
+	getStatementEndpoint  endpoint.Endpoint
+	depositEndpoint       endpoint.Endpoint
+	withdrawEndpoint      endpoint.Endpoint
+
+	proposeAdjustmentEndpoint endpoint.Endpoint
+	reviewAdjustmentEndpoint  endpoint.Endpoint
+	getAdjustmentsEndpoint    endpoint.Endpoint
 }
 
 // NewClient creates a new client.
@@ -52,30 +105,139 @@ func NewClient(cfg ClientConfig) (Service, error) {
 		kithttp.SetClient(&http.Client{
 			Timeout: cfg.Timeout,
 		}),
+		kithttp.ClientBefore(auth.ContextToHTTP(auth.Credentials{
+			APIKey:      cfg.APIKey,
+			BearerToken: cfg.BearerToken,
+		})),
+		kithttp.ClientBefore(setHeaders(cfg.Headers)),
+		kithttp.ClientBefore(injectTraceContext),
+		kithttp.ClientBefore(setRequestID),
+	}
+
+	// middlewares wraps a client endpoint with the call policies, retryable tells whether a request may be retried.
+	breaker := newCircuitBreakerMiddleware(cfg.CircuitBreaker)
+	middlewares := func(retryable func(request interface{}) bool) endpoint.Middleware {
+		return endpoint.Chain(
+			newCallTimeoutMiddleware(cfg.CallTimeout),
+			newRetryMiddleware(cfg.Retry, retryable),
+			breaker,
+			transportErrorMiddleware,
+		)
+	}
+	idempotent := func(request interface{}) bool {
+		return true
 	}
 
+	fundsRetryable := func(request interface{}) bool {
+		return request.(fundsRequest).fundsRequest.IdempotencyKey != ""
+	}
+
+	// the statement is read after the endpoint returns, so its call is not limited by CallTimeout.
+	statementOptions := append(options[:len(options):len(options)], kithttp.BufferedStream(true))
+
 	c := &client{
-		getAccountEndpoint: kithttp.NewClient(
+		getAccountEndpoint: middlewares(idempotent)(kithttp.NewClient(
 			http.MethodGet,
 			baseURL,
 			encodeGetAccountRequest,
 			decodeGetAccountResponse,
 			options...,
-		).Endpoint(),
-		getPaymentsEndpoint: kithttp.NewClient(
+		).Endpoint()),
+		listAccountsEndpoint: middlewares(idempotent)(kithttp.NewClient(
+			http.MethodGet,
+			baseURL,
+			encodeListAccountsRequest,
+			decodeListAccountsResponse,
+			options...,
+		).Endpoint()),
+		// a retried update would fail as the first attempt may have changed the version.
+		updateAccountEndpoint: middlewares(func(request interface{}) bool {
+			return false
+		})(kithttp.NewClient(
+			http.MethodPatch,
+			baseURL,
+			encodeUpdateAccountRequest,
+			decodeGetAccountResponse,
+			options...,
+		).Endpoint()),
+		getPaymentsEndpoint: middlewares(idempotent)(kithttp.NewClient(
 			http.MethodGet,
 			baseURL,
 			encodeGetPaymentsRequest,
 			decodeGetPaymentsResponse,
 			options...,
-		).Endpoint(),
-		applyPaymentEndpoint: kithttp.NewClient(
+		).Endpoint()),
+		applyPaymentEndpoint: middlewares(func(request interface{}) bool {
+			return request.(applyPaymentRequest).paymentRequest.IdempotencyKey != ""
+		})(kithttp.NewClient(
 			http.MethodPost,
 			baseURL,
 			encodeApplyPaymentRequest,
 			decodeApplyPaymentResponse,
 			options...,
-		).Endpoint(),
+		).Endpoint()),
+		// a retried confirmation or cancellation would fail as the first attempt may have settled the payment.
+		settlePaymentEndpoint: middlewares(func(request interface{}) bool {
+			return false
+		})(kithttp.NewClient(
+			http.MethodPost,
+			baseURL,
+			encodeSettlePaymentRequest,
+			decodeApplyPaymentResponse,
+			options...,
+		).Endpoint()),
+		getStatementEndpoint: endpoint.Chain(
+			newRetryMiddleware(cfg.Retry, idempotent),
+			breaker,
+			transportErrorMiddleware,
+		)(kithttp.NewClient(
+			http.MethodGet,
+			baseURL,
+			encodeGetStatementRequest,
+			decodeGetStatementResponse,
+			statementOptions...,
+		).Endpoint()),
+		depositEndpoint: middlewares(fundsRetryable)(kithttp.NewClient(
+			http.MethodPost,
+			baseURL,
+			encodeDepositRequest,
+			decodeApplyPaymentResponse,
+			options...,
+		).Endpoint()),
+		withdrawEndpoint: middlewares(fundsRetryable)(kithttp.NewClient(
+			http.MethodPost,
+			baseURL,
+			encodeWithdrawalRequest,
+			decodeApplyPaymentResponse,
+			options...,
+		).Endpoint()),
+		// a retried proposal would propose the adjustment twice.
+		proposeAdjustmentEndpoint: middlewares(func(request interface{}) bool {
+			return false
+		})(kithttp.NewClient(
+			http.MethodPost,
+			baseURL,
+			encodeProposeAdjustmentRequest,
+			decodeAdjustmentResponse,
+			options...,
+		).Endpoint()),
+		// a retried review would fail as the first attempt may have reviewed the adjustment.
+		reviewAdjustmentEndpoint: middlewares(func(request interface{}) bool {
+			return false
+		})(kithttp.NewClient(
+			http.MethodPost,
+			baseURL,
+			encodeReviewAdjustmentRequest,
+			decodeAdjustmentResponse,
+			options...,
+		).Endpoint()),
+		getAdjustmentsEndpoint: middlewares(idempotent)(kithttp.NewClient(
+			http.MethodGet,
+			baseURL,
+			encodeGetAdjustmentsRequest,
+			decodeGetAdjustmentsResponse,
+			options...,
+		).Endpoint()),
 	}
 
 	return c, nil
@@ -89,8 +251,24 @@ func (c *client) ApplyPayment(ctx context.Context, p *account.PaymentRequest) (*
 	return response.(applyPaymentResponse).payment, nil
 }
 
-func (c *client) GetPayments(ctx context.Context, accountID int64) ([]*account.Payment, error) {
-	response, err := c.getPaymentsEndpoint(ctx, getPaymentsRequest{accountID: accountID})
+func (c *client) ConfirmPayment(ctx context.Context, id int64) (*account.Payment, error) {
+	response, err := c.settlePaymentEndpoint(ctx, settlePaymentRequest{id: id, action: settleActionConfirm})
+	if err != nil {
+		return nil, err
+	}
+	return response.(applyPaymentResponse).payment, nil
+}
+
+func (c *client) CancelPayment(ctx context.Context, id int64) (*account.Payment, error) {
+	response, err := c.settlePaymentEndpoint(ctx, settlePaymentRequest{id: id, action: settleActionCancel})
+	if err != nil {
+		return nil, err
+	}
+	return response.(applyPaymentResponse).payment, nil
+}
+
+func (c *client) GetPayments(ctx context.Context, f *account.PaymentFilter) ([]*account.Payment, error) {
+	response, err := c.getPaymentsEndpoint(ctx, getPaymentsRequest{filter: f})
 	if err != nil {
 		return nil, err
 	}
@@ -104,3 +282,200 @@ func (c *client) GetAccount(ctx context.Context, id int64) (*account.Account, er
 	}
 	return response.(getAccountResponse).account, nil
 }
+
+func (c *client) GetAccountBalanceAt(ctx context.Context, id int64, at time.Time) (*account.Account, error) {
+	response, err := c.getAccountEndpoint(ctx, getAccountRequest{id: id, asOf: at})
+	if err != nil {
+		return nil, err
+	}
+	return response.(getAccountResponse).account, nil
+}
+
+func (c *client) ListAccounts(ctx context.Context, r *account.ListRequest) (*account.Page, error) {
+	response, err := c.listAccountsEndpoint(ctx, listAccountsRequest{listRequest: r})
+	if err != nil {
+		return nil, err
+	}
+	return response.(listAccountsResponse).page, nil
+}
+
+func (c *client) UpdateAccount(ctx context.Context, r *account.UpdateRequest) (*account.Account, error) {
+	response, err := c.updateAccountEndpoint(ctx, updateAccountRequest{updateRequest: r})
+	if err != nil {
+		return nil, err
+	}
+	return response.(getAccountResponse).account, nil
+}
+
+func (c *client) GetStatement(ctx context.Context, r *account.StatementRequest, w account.StatementWriter) error {
+	response, err := c.getStatementEndpoint(ctx, getStatementRequest{statementRequest: r})
+	if err != nil {
+		return err
+	}
+	body := response.(getStatementResponse).body
+	defer body.Close()
+	return decodeStatement(body, w)
+}
+
+func (c *client) Deposit(ctx context.Context, r *account.FundsRequest) (*account.Payment, error) {
+	response, err := c.depositEndpoint(ctx, fundsRequest{fundsRequest: r})
+	if err != nil {
+		return nil, err
+	}
+	return response.(applyPaymentResponse).payment, nil
+}
+
+func (c *client) Withdraw(ctx context.Context, r *account.FundsRequest) (*account.Payment, error) {
+	response, err := c.withdrawEndpoint(ctx, fundsRequest{fundsRequest: r})
+	if err != nil {
+		return nil, err
+	}
+	return response.(applyPaymentResponse).payment, nil
+}
+
+func (c *client) ProposeAdjustment(ctx context.Context, r *account.AdjustmentRequest) (*account.Adjustment, error) {
+	response, err := c.proposeAdjustmentEndpoint(ctx, proposeAdjustmentRequest{adjustmentRequest: r})
+	if err != nil {
+		return nil, err
+	}
+	return response.(adjustmentResponse).adjustment, nil
+}
+
+func (c *client) ApproveAdjustment(ctx context.Context, id int64) (*account.Adjustment, error) {
+	response, err := c.reviewAdjustmentEndpoint(ctx, reviewAdjustmentRequest{id: id, action: reviewActionApprove})
+	if err != nil {
+		return nil, err
+	}
+	return response.(adjustmentResponse).adjustment, nil
+}
+
+func (c *client) RejectAdjustment(ctx context.Context, id int64) (*account.Adjustment, error) {
+	response, err := c.reviewAdjustmentEndpoint(ctx, reviewAdjustmentRequest{id: id, action: reviewActionReject})
+	if err != nil {
+		return nil, err
+	}
+	return response.(adjustmentResponse).adjustment, nil
+}
+
+func (c *client) GetAdjustments(ctx context.Context, accountID int64) ([]*account.Adjustment, error) {
+	response, err := c.getAdjustmentsEndpoint(ctx, getAdjustmentsRequest{accountID: accountID})
+	if err != nil {
+		return nil, err
+	}
+	return response.(getAdjustmentsResponse).adjustments, nil
+}
+
+func setHeaders(headers http.Header) kithttp.RequestFunc {
+	return func(ctx context.Context, r *http.Request) context.Context {
+		for key, values := range headers {
+			for _, v := range values {
+				r.Header.Add(key, v)
+			}
+		}
+		return ctx
+	}
+}
+
+// setRequestID propagates the request id of the call to the service.
+func setRequestID(ctx context.Context, r *http.Request) context.Context {
+	if id := requestid.FromContext(ctx); id != "" {
+		r.Header.Set(requestid.Header, id)
+	}
+	return ctx
+}
+
+// newCallTimeoutMiddleware limits the whole call with the given timeout.
+func newCallTimeoutMiddleware(timeout time.Duration) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		if timeout == 0 {
+			return next
+		}
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			return next(ctx, request)
+		}
+	}
+}
+
+// newRetryMiddleware retries transient failures of the retryable requests with exponential backoff.
+func newRetryMiddleware(policy RetryPolicy, retryable func(request interface{}) bool) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		if policy.MaxAttempts <= 1 {
+			return next
+		}
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			if !retryable(request) {
+				return next(ctx, request)
+			}
+
+			backoff := policy.Backoff
+			for attempt := 1; ; attempt++ {
+				response, err := next(ctx, request)
+				if err == nil || attempt >= policy.MaxAttempts || !errors.Is(err, ErrTransient) {
+					return response, err
+				}
+
+				timer := time.NewTimer(backoff)
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return nil, err
+				case <-timer.C:
+				}
+
+				backoff *= 2
+				if policy.MaxBackoff > 0 && backoff > policy.MaxBackoff {
+					backoff = policy.MaxBackoff
+				}
+			}
+		}
+	}
+}
+
+// newCircuitBreakerMiddleware stops calling the service after consecutive transient failures.
+func newCircuitBreakerMiddleware(cfg CircuitBreakerConfig) endpoint.Middleware {
+	if cfg.ConsecutiveFailures == 0 {
+		return func(next endpoint.Endpoint) endpoint.Endpoint {
+			return next
+		}
+	}
+
+	cb := gobreaker.NewCircuitBreaker(gobreaker.Settings{
+		Name:    "walletservice",
+		Timeout: cfg.OpenTimeout,
+		ReadyToTrip: func(counts gobreaker.Counts) bool {
+			return counts.ConsecutiveFailures >= cfg.ConsecutiveFailures
+		},
+		IsSuccessful: func(err error) bool {
+			return !errors.Is(err, ErrTransient)
+		},
+	})
+
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			response, err := cb.Execute(func() (interface{}, error) {
+				return next(ctx, request)
+			})
+			if errors.Is(err, gobreaker.ErrOpenState) || errors.Is(err, gobreaker.ErrTooManyRequests) {
+				return nil, &transportError{err: err}
+			}
+			return response, err
+		}
+	}
+}
+
+// transportErrorMiddleware marks failures to get a response from the service as transient.
+func transportErrorMiddleware(next endpoint.Endpoint) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		response, err := next(ctx, request)
+		if err == nil {
+			return response, nil
+		}
+		var e *serviceError
+		if errors.As(err, &e) || ctx.Err() != nil {
+			return nil, err
+		}
+		return nil, &transportError{err: err}
+	}
+}
@@ -0,0 +1,159 @@
+package walletservice
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shkov/wallet-service/internal/account"
+)
+
+func TestClientRetry(t *testing.T) {
+	testCases := []struct {
+		name         string
+		call         func(c Service) error
+		wantAttempts int32
+	}{
+		{
+			name: "idempotent call is retried",
+			call: func(c Service) error {
+				_, err := c.GetAccount(context.Background(), 1)
+				return err
+			},
+			wantAttempts: 3,
+		},
+		{
+			name: "payment without idempotency key is not retried",
+			call: func(c Service) error {
+				_, err := c.ApplyPayment(context.Background(), makePaymentRequest(t, nil))
+				return err
+			},
+			wantAttempts: 1,
+		},
+		{
+			name: "payment with idempotency key is retried",
+			call: func(c Service) error {
+				_, err := c.ApplyPayment(context.Background(), makePaymentRequest(t, func(pr *account.PaymentRequest) {
+					pr.IdempotencyKey = "key"
+				}))
+				return err
+			},
+			wantAttempts: 3,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var attempts int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&attempts, 1)
+				errInternal("kek some err occurs").(*serviceError).Encode(w)
+			}))
+			defer server.Close()
+
+			c, err := NewClient(ClientConfig{
+				ServiceURL: server.URL,
+				Timeout:    time.Second,
+				Retry: RetryPolicy{
+					MaxAttempts: 3,
+					Backoff:     time.Millisecond,
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			gotErr := tc.call(c)
+			assert.True(t, errors.Is(gotErr, ErrTransient))
+			assert.Equal(t, tc.wantAttempts, atomic.LoadInt32(&attempts))
+		})
+	}
+}
+
+func TestClientCircuitBreaker(t *testing.T) {
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	c, err := NewClient(ClientConfig{
+		ServiceURL: server.URL,
+		Timeout:    time.Second,
+		CircuitBreaker: CircuitBreakerConfig{
+			ConsecutiveFailures: 2,
+			OpenTimeout:         time.Minute,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		_, gotErr := c.GetAccount(context.Background(), 1)
+		assert.True(t, errors.Is(gotErr, ErrTransient))
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&attempts))
+}
+
+func TestClientErrors(t *testing.T) {
+	server, client, svc := initTransportTest(t)
+	defer server.Close()
+
+	testCases := []struct {
+		name    string
+		err     error
+		wantErr error
+	}{
+		{
+			name:    "not found",
+			err:     errNotFound("account 1: %w", account.ErrNotFound),
+			wantErr: ErrNotFound,
+		},
+		{
+			name:    "validation",
+			err:     errBadRequest("provided account id 1 is invalid: %w", account.ErrMustBePositive),
+			wantErr: ErrInvalidRequest,
+		},
+		{
+			name:    "transient",
+			err:     errInternal("kek some err occurs"),
+			wantErr: ErrTransient,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			svc.onGetAccount = func(ctx context.Context, id int64) (*account.Account, error) {
+				return nil, tc.err
+			}
+			_, gotErr := client.GetAccount(context.Background(), 1)
+			assert.True(t, errors.Is(gotErr, tc.wantErr))
+		})
+	}
+
+	// a disabled feature is not retried.
+	svc.onGetAccount = func(ctx context.Context, id int64) (*account.Account, error) {
+		return nil, errNotImplemented("failed to withdraw: %w", account.ErrWithdrawalsDisabled)
+	}
+	_, gotErr := client.GetAccount(context.Background(), 1)
+	assert.False(t, errors.Is(gotErr, ErrTransient))
+	assert.True(t, errors.Is(gotErr, account.ErrWithdrawalsDisabled))
+
+	unreachable, err := NewClient(ClientConfig{
+		ServiceURL: "http://127.0.0.1:1",
+		Timeout:    time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, gotErr = unreachable.GetAccount(context.Background(), 1)
+	assert.True(t, errors.Is(gotErr, ErrTransient))
+}
@@ -2,13 +2,153 @@ package walletservice
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+
+	"github.com/shkov/wallet-service/internal/account"
+)
+
+// problemContentType is the content type of RFC 7807 error responses.
+const problemContentType = "application/problem+json"
+
+// problemTypePrefix prefixes the error code in the problem type URI.
+const problemTypePrefix = "urn:problem:wallet-service:"
+
+// domainErrors maps domain errors to stable error codes and the request fields they are related to.
+var domainErrors = []struct {
+	err   error
+	code  string
+	field string
+}{
+	{err: account.ErrNotFound, code: "account_not_found"},
+	{err: account.ErrNotEnoughFunds, code: "insufficient_funds"},
+	{err: account.ErrMismatchPayment, code: "payment_mismatch"},
+	{err: account.ErrNotPositiveAmount, code: "invalid_amount", field: "Amount"},
+	{err: account.ErrAccountFromMustBePositive, code: "invalid_account_id", field: "From"},
+	{err: account.ErrAccountToMustBePositive, code: "invalid_account_id", field: "To"},
+	{err: account.ErrMustBePositive, code: "invalid_account_id"},
+	{err: account.ErrFromAndToMustBeDifferent, code: "same_account", field: "To"},
+	{err: account.ErrIdempotencyKeyTooLong, code: "invalid_idempotency_key", field: "IdempotencyKey"},
+	{err: account.ErrIdempotencyKeyReused, code: "idempotency_key_reused"},
+	{err: account.ErrInvalidStatementPeriod, code: "invalid_period", field: "to"},
+	{err: account.ErrBalanceTimeInFuture, code: "invalid_as_of", field: "as_of"},
+	{err: account.ErrAccountNotActive, code: "account_not_active"},
+	{err: account.ErrUnknownStatus, code: "invalid_status", field: "status"},
+	{err: account.ErrInvalidBalanceRange, code: "invalid_balance_range", field: "min_balance"},
+	{err: account.ErrInvalidCreatedRange, code: "invalid_period", field: "created_to"},
+	{err: account.ErrUnknownSort, code: "invalid_sort", field: "sort"},
+	{err: account.ErrInvalidLimit, code: "invalid_limit", field: "limit"},
+	{err: account.ErrInvalidCursor, code: "invalid_cursor", field: "cursor"},
+	{err: account.ErrOwnerRefTooLong, code: "invalid_owner_ref", field: "OwnerRef"},
+	{err: account.ErrDisplayNameTooLong, code: "invalid_display_name", field: "DisplayName"},
+	{err: account.ErrInvalidMetadata, code: "invalid_metadata", field: "Metadata"},
+	{err: account.ErrVersionMismatch, code: "version_mismatch"},
+	{err: account.ErrInvalidDescription, code: "invalid_description", field: "Description"},
+	{err: account.ErrInvalidExternalRef, code: "invalid_external_ref", field: "ExternalRef"},
+	{err: account.ErrReservedExternalRef, code: "reserved_external_ref", field: "ExternalRef"},
+	{err: account.ErrExternalRefReused, code: "external_ref_reused"},
+	{err: account.ErrSplitPaymentWithTo, code: "split_payment_with_to", field: "To"},
+	{err: account.ErrTooManyLegs, code: "too_many_legs", field: "Legs"},
+	{err: account.ErrDuplicateLegReceiver, code: "duplicate_leg_receiver", field: "Legs"},
+	{err: account.ErrLegsSumMismatch, code: "legs_sum_mismatch", field: "Legs"},
+	{err: account.ErrPaymentNotFound, code: "payment_not_found"},
+	{err: account.ErrPaymentNotPending, code: "payment_not_pending"},
+	{err: account.ErrPaymentIDMustBePositive, code: "invalid_payment_id"},
+	{err: account.ErrPaymentDenied, code: "payment_denied"},
+	{err: account.ErrAdjustmentNotFound, code: "adjustment_not_found"},
+	{err: account.ErrAdjustmentNotProposed, code: "adjustment_not_proposed"},
+	{err: account.ErrAdjustmentSelfReview, code: "adjustment_self_review"},
+	{err: account.ErrUnknownAdjustmentDirection, code: "invalid_direction", field: "Direction"},
+	{err: account.ErrInvalidAdjustmentReason, code: "invalid_reason", field: "Reason"},
+	{err: account.ErrAdjustmentIDMustBePositive, code: "invalid_adjustment_id"},
+	{err: account.ErrUnknownType, code: "invalid_type", field: "type"},
+	{err: account.ErrInvalidFee, code: "invalid_fee", field: "Fee"},
+	{err: account.ErrPayoutNotFound, code: "payout_not_found"},
+	{err: account.ErrUnexpectedPayoutStatus, code: "unexpected_payout_status"},
+	{err: account.ErrPayoutInProgress, code: "payout_in_progress"},
+	{err: account.ErrNotUserType, code: "invalid_type", field: "type"},
+	{err: account.ErrBalanceCapExceeded, code: "balance_cap_exceeded"},
+	{err: account.ErrBelowMinBalance, code: "below_min_balance"},
+	{err: account.ErrWithdrawalsDisabled, code: "withdrawals_disabled"},
+	{err: account.ErrAccountClosed, code: "account_closed"},
+	{err: account.ErrInvalidBalancePolicy, code: "invalid_balance_policy"},
+	{err: account.ErrInvalidInterestRate, code: "invalid_interest_rate"},
+	{err: account.ErrUnknownDayCount, code: "invalid_day_count"},
+	{err: account.ErrInvalidOpeningBalance, code: "invalid_opening_balance", field: "OpeningBalance"},
+	{err: account.ErrDuplicateImportRow, code: "duplicate_import_row", field: "ID"},
+}
+
+// statusCodes are the error codes used when an error is not caused by a domain error.
+var statusCodes = map[int]string{
+	http.StatusBadRequest:           "bad_request",
+	http.StatusUnauthorized:         "unauthorized",
+	http.StatusForbidden:            "forbidden",
+	http.StatusNotFound:             "not_found",
+	http.StatusConflict:             "conflict",
+	http.StatusPreconditionFailed:   "precondition_failed",
+	http.StatusPreconditionRequired: "precondition_required",
+	http.StatusTooManyRequests:      "rate_limited",
+	http.StatusInternalServerError:  "internal",
+	http.StatusNotImplemented:       "not_implemented",
+}
+
+// Classes of errors returned by the client, check them with errors.Is.
+var (
+	ErrNotFound       = errors.New("not found")
+	ErrInvalidRequest = errors.New("invalid request")
+	ErrUnauthorized   = errors.New("unauthorized")
+	ErrTransient      = errors.New("transient failure")
 )
 
 type serviceError struct {
-	code    int
-	Message string `json:"error"`
+	code          int
+	Code          string
+	Message       string
+	InvalidParams []invalidParam
+	err           error
+}
+
+// invalidParam describes a request field that failed validation.
+type invalidParam struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// problem is an RFC 7807 problem details object.
+type problem struct {
+	Type          string         `json:"type"`
+	Title         string         `json:"title"`
+	Status        int            `json:"status"`
+	Detail        string         `json:"detail,omitempty"`
+	Code          string         `json:"code"`
+	InvalidParams []invalidParam `json:"invalid_params,omitempty"`
+}
+
+// newServiceError creates a service error, a domain error wrapped with %w determines its code.
+func newServiceError(code int, format string, v ...interface{}) *serviceError {
+	err := fmt.Errorf(format, v...)
+	e := &serviceError{
+		code:    code,
+		Code:    statusCodes[code],
+		Message: err.Error(),
+	}
+	cause := errors.Unwrap(err)
+	if cause == nil {
+		return e
+	}
+	for _, de := range domainErrors {
+		if !errors.Is(cause, de.err) {
+			continue
+		}
+		e.Code = de.code
+		e.err = de.err
+		if de.field != "" {
+			e.InvalidParams = []invalidParam{{Name: de.field, Reason: de.err.Error()}}
+		}
+		break
+	}
+	return e
 }
 
 // Error returns a string representation of the error.
@@ -16,46 +156,137 @@ func (e *serviceError) Error() string {
 	return fmt.Sprintf("status %d: %s", e.code, e.Message)
 }
 
-// Encode encodes the error using the given HTTP response writer.
+// Unwrap returns the domain error the service error is caused by, if any.
+func (e *serviceError) Unwrap() error {
+	return e.err
+}
+
+// Is reports whether the error belongs to the given class of errors.
+func (e *serviceError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.code == http.StatusNotFound
+	case ErrInvalidRequest:
+		return e.code == http.StatusBadRequest || e.code == http.StatusConflict ||
+			e.code == http.StatusPreconditionFailed || e.code == http.StatusPreconditionRequired
+	case ErrUnauthorized:
+		return e.code == http.StatusUnauthorized || e.code == http.StatusForbidden
+	case ErrTransient:
+		return e.code == http.StatusTooManyRequests ||
+			e.code >= http.StatusInternalServerError && e.code != http.StatusNotImplemented
+	}
+	return false
+}
+
+// Encode encodes the error as problem details using the given HTTP response writer.
 func (e *serviceError) Encode(w http.ResponseWriter) {
-	w.Header().Set("Content-Type", "application/json")
+	code := e.Code
+	if code == "" {
+		code = statusCodes[http.StatusInternalServerError]
+	}
+	w.Header().Set("Content-Type", problemContentType)
 	w.WriteHeader(e.code)
-	json.NewEncoder(w).Encode(e)
+	json.NewEncoder(w).Encode(problem{
+		Type:          problemTypePrefix + code,
+		Title:         http.StatusText(e.code),
+		Status:        e.code,
+		Detail:        e.Message,
+		Code:          code,
+		InvalidParams: e.InvalidParams,
+	})
 }
 
 // Decode decodes the error from the given HTTP response.
 func (e *serviceError) Decode(r *http.Response) {
 	e.code = r.StatusCode
-	var res struct {
-		Error string `json:"error"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&res); err == nil && res.Error != "" {
-		e.Message = res.Error
-	} else {
+	var res problem
+	if err := json.NewDecoder(r.Body).Decode(&res); err != nil || res.Code == "" {
+		e.Code = statusCodes[r.StatusCode]
 		e.Message = http.StatusText(r.StatusCode)
+		return
+	}
+
+	e.Code = res.Code
+	e.Message = res.Detail
+	e.InvalidParams = res.InvalidParams
+	for _, de := range domainErrors {
+		if de.code != res.Code {
+			continue
+		}
+		if de.field != "" && (len(res.InvalidParams) == 0 || res.InvalidParams[0].Name != de.field) {
+			continue
+		}
+		e.err = de.err
+		break
 	}
 }
 
+// transportError is a failure to get a response from the service.
+type transportError struct {
+	err error
+}
+
+// Error returns a string representation of the error.
+func (e *transportError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *transportError) Unwrap() error {
+	return e.err
+}
+
+// Is reports whether the error belongs to the given class of errors.
+func (e *transportError) Is(target error) bool {
+	return target == ErrTransient
+}
+
 // ErrBadRequest creates a BadRequest service error.
 func errBadRequest(format string, v ...interface{}) error {
-	return &serviceError{
-		code:    http.StatusBadRequest,
-		Message: fmt.Sprintf(format, v...),
-	}
+	return newServiceError(http.StatusBadRequest, format, v...)
+}
+
+// ErrUnauthorized creates an Unauthorized service error.
+func errUnauthorized(format string, v ...interface{}) error {
+	return newServiceError(http.StatusUnauthorized, format, v...)
+}
+
+// ErrForbidden creates a Forbidden service error.
+func errForbidden(format string, v ...interface{}) error {
+	return newServiceError(http.StatusForbidden, format, v...)
+}
+
+// ErrTooManyRequests creates a TooManyRequests service error.
+func errTooManyRequests(format string, v ...interface{}) error {
+	return newServiceError(http.StatusTooManyRequests, format, v...)
 }
 
 // ErrNotFound creates a NotFound service error.
 func errNotFound(format string, v ...interface{}) error {
-	return &serviceError{
-		code:    http.StatusNotFound,
-		Message: fmt.Sprintf(format, v...),
-	}
+	return newServiceError(http.StatusNotFound, format, v...)
+}
+
+// ErrConflict creates a Conflict service error.
+func errConflict(format string, v ...interface{}) error {
+	return newServiceError(http.StatusConflict, format, v...)
+}
+
+// ErrPreconditionFailed creates a PreconditionFailed service error.
+func errPreconditionFailed(format string, v ...interface{}) error {
+	return newServiceError(http.StatusPreconditionFailed, format, v...)
+}
+
+// ErrPreconditionRequired creates a PreconditionRequired service error.
+func errPreconditionRequired(format string, v ...interface{}) error {
+	return newServiceError(http.StatusPreconditionRequired, format, v...)
 }
 
 // ErrInternal creates an Internal service error.
 func errInternal(format string, v ...interface{}) error {
-	return &serviceError{
-		code:    http.StatusInternalServerError,
-		Message: fmt.Sprintf(format, v...),
-	}
+	return newServiceError(http.StatusInternalServerError, format, v...)
+}
+
+// ErrNotImplemented creates a NotImplemented service error.
+func errNotImplemented(format string, v ...interface{}) error {
+	return newServiceError(http.StatusNotImplemented, format, v...)
 }
@@ -0,0 +1,180 @@
+package walletservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/go-kit/kit/log"
+
+	"github.com/shkov/wallet-service/internal/account"
+	"github.com/shkov/wallet-service/internal/audit"
+	"github.com/shkov/wallet-service/internal/storage"
+)
+
+// AccountImporterConfig is an account importer configuration.
+type AccountImporterConfig struct {
+	Logger  log.Logger
+	Storage storage.TransactionalStorage
+	// BalancePolicies bound the opening balances of the imported accounts.
+	BalancePolicies map[string]*account.BalancePolicy
+}
+
+// ImportResult is the result of importing a batch of accounts.
+type ImportResult struct {
+	// Created is the number of the new accounts.
+	Created int
+	// Updated is the number of the existing accounts whose details are replaced by the ones set by the rows.
+	Updated int
+	// Rejected are the rows not imported in the row order, the other rows of the batch are imported.
+	Rejected []*ImportRejection
+}
+
+// ImportRejection is a row rejected by the import.
+type ImportRejection struct {
+	// Row is the index of the row in the batch.
+	Row int
+	Err error
+}
+
+// AccountImporter imports the accounts from another system. A new account is credited with its opening
+// balance by the payment from SystemAccountOpeningBalances, so its balance matches its payments, while
+// the existing accounts keep their balances and get the details of the rows.
+type AccountImporter struct {
+	svc *serviceImpl
+}
+
+// NewAccountImporter creates a new account importer.
+func NewAccountImporter(cfg AccountImporterConfig) (*AccountImporter, error) {
+	if cfg.Storage == nil {
+		return nil, errors.New("must provide Storage")
+	}
+
+	return &AccountImporter{
+		svc: &serviceImpl{
+			logger:   cfg.Logger,
+			storage:  cfg.Storage,
+			policies: cfg.BalancePolicies,
+			now:      time.Now,
+		},
+	}, nil
+}
+
+// Import imports the batch of rows in a single transaction. The invalid rows, the rows whose opening balance
+// or type change breaks the balance policy and the rows reopening a closed account are rejected without failing
+// the batch, an error fails the whole batch.
+// The accounts are locked in the id order, and a row repeating the account of a previous row is rejected.
+func (im *AccountImporter) Import(ctx context.Context, rows []*account.ImportRow) (*ImportResult, error) {
+	var result *ImportResult
+	txFn := func(ctx context.Context, storage storage.Storage) error {
+		result = &ImportResult{}
+		valid := make([]int, 0, len(rows))
+		ids := []int64{account.SystemAccountOpeningBalances}
+		seen := make(map[int64]bool, len(rows))
+		for n, r := range rows {
+			err := account.ValidateImportRow(r)
+			if err == nil && seen[r.ID] {
+				err = account.ErrDuplicateImportRow
+			}
+			if err != nil {
+				result.Rejected = append(result.Rejected, &ImportRejection{Row: n, Err: err})
+				continue
+			}
+			seen[r.ID] = true
+			valid = append(valid, n)
+			ids = append(ids, r.ID)
+		}
+		if len(valid) == 0 {
+			return nil
+		}
+
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		existing, err := storage.GetAccounts(ctx, ids)
+		if err != nil {
+			return fmt.Errorf("failed to get accounts: %w", err)
+		}
+		stored := make(map[int64]*account.Account, len(existing))
+		for _, a := range existing {
+			stored[a.ID] = a
+		}
+
+		now := im.svc.now()
+		system, ok := stored[account.SystemAccountOpeningBalances]
+		if !ok {
+			system = account.Create(account.SystemAccountOpeningBalances, now)
+		}
+		changed := []*account.Account{system}
+		var payments []*account.Payment
+		var entries []*audit.Entry
+		// paymentEntries are the audit entries of the payments, they get the payment ids when the payments are saved.
+		var paymentEntries [][2]*audit.Entry
+		for _, n := range valid {
+			r := rows[n]
+			if a, ok := stored[r.ID]; ok {
+				before := *a
+				if err := r.ApplyTo(a, im.svc.policies); err != nil {
+					result.Rejected = append(result.Rejected, &ImportRejection{Row: n, Err: err})
+					continue
+				}
+				changed = append(changed, a)
+				entry := im.svc.newAuditEntry(ctx, audit.ActionImport, a, a.Balance, 0)
+				entry.Details = updateDetails(&before, a)
+				entries = append(entries, entry)
+				result.Updated++
+				continue
+			}
+
+			a, payment, err := r.ToAccount(now, im.svc.policies)
+			if err != nil {
+				result.Rejected = append(result.Rejected, &ImportRejection{Row: n, Err: err})
+				continue
+			}
+			changed = append(changed, a)
+			result.Created++
+			if payment == nil {
+				entries = append(entries, im.svc.newAuditEntry(ctx, audit.ActionImport, a, "0", 0))
+				continue
+			}
+
+			balance := system.Balance
+			if err = system.ApplyPayment(payment); err != nil {
+				return fmt.Errorf("failed to apply opening balance of account %d: %w", r.ID, err)
+			}
+			payments = append(payments, payment)
+			pe := [2]*audit.Entry{
+				im.svc.newAuditEntry(ctx, audit.ActionImport, system, balance, 0),
+				im.svc.newAuditEntry(ctx, audit.ActionImport, a, "0", 0),
+			}
+			paymentEntries = append(paymentEntries, pe)
+			entries = append(entries, pe[:]...)
+		}
+
+		err = storage.UpsertAccounts(ctx, changed)
+		if err != nil {
+			return fmt.Errorf("failed to upsert accounts: %w", err)
+		}
+		err = storage.InsertPayments(ctx, payments)
+		if err != nil {
+			return fmt.Errorf("failed to insert payments: %w", err)
+		}
+		for k, p := range payments {
+			for _, e := range paymentEntries[k] {
+				e.PaymentID = p.ID
+			}
+		}
+		err = storage.AppendAuditEntries(ctx, entries)
+		if err != nil {
+			return fmt.Errorf("failed to append audit entries: %w", err)
+		}
+		return nil
+	}
+
+	err := im.svc.storage.ExecTx(ctx, txFn)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(result.Rejected, func(i, j int) bool { return result.Rejected[i].Row < result.Rejected[j].Row })
+	return result, nil
+}
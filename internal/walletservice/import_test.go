@@ -0,0 +1,135 @@
+package walletservice
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/shkov/wallet-service/internal/account"
+	"github.com/shkov/wallet-service/internal/audit"
+	"github.com/shkov/wallet-service/internal/storage"
+)
+
+func TestAccountImporter_Import(t *testing.T) {
+	now := parseTime(t, "2021-03-01T10:00:00Z")
+	rows := []*account.ImportRow{
+		{ID: 1, DisplayName: "Existing", OpeningBalance: "50"},
+		{ID: 2, Status: account.StatusSuspended, OpeningBalance: "100"},
+		{ID: 3, Type: account.TypeEMoney, OpeningBalance: "300"},
+		{ID: 4, Type: account.TypeFees},
+		{ID: 5},
+		{ID: 2, OpeningBalance: "1"},
+		{ID: 6, Status: account.StatusActive},
+		{ID: 7, DisplayName: "Renamed"},
+	}
+
+	var upserted []*account.Account
+	var payments []*account.Payment
+	var entries []*audit.Entry
+	mock := &storageMock{
+		onGetAccounts: func(ctx context.Context, ids []int64) ([]*account.Account, error) {
+			assert.Equal(t, []int64{account.SystemAccountOpeningBalances, 1, 2, 3, 5, 6, 7}, ids)
+			return []*account.Account{
+				makeAccount(t, func(a *account.Account) { a.Version = 1 }),
+				makeAccount(t, func(a *account.Account) { a.ID, a.Status = 6, account.StatusClosed }),
+				makeAccount(t, func(a *account.Account) { a.ID, a.Type, a.Status = 7, account.TypeSavings, account.StatusSuspended }),
+			}, nil
+		},
+		onUpsertAccounts: func(ctx context.Context, aa []*account.Account) error {
+			upserted = aa
+			return nil
+		},
+		onInsertPayments: func(ctx context.Context, pp []*account.Payment) error {
+			for i, p := range pp {
+				p.ID = int64(i + 1)
+			}
+			payments = pp
+			return nil
+		},
+		onAppendAudit: func(ctx context.Context, ee []*audit.Entry) error {
+			entries = ee
+			return nil
+		},
+	}
+	mock.onExecTx = func(ctx context.Context, fn func(context.Context, storage.Storage) error) error {
+		return fn(ctx, mock)
+	}
+
+	im, err := NewAccountImporter(AccountImporterConfig{
+		Logger:  log.NewNopLogger(),
+		Storage: mock,
+		BalancePolicies: map[string]*account.BalancePolicy{
+			account.TypeEMoney: {Max: "250"},
+		},
+	})
+	require.NoError(t, err)
+	im.svc.now = func() time.Time { return now }
+
+	got, err := im.Import(context.Background(), rows)
+	require.NoError(t, err)
+	assert.Equal(t, 2, got.Created)
+	assert.Equal(t, 2, got.Updated)
+	require.Len(t, got.Rejected, 4)
+	assert.Equal(t, 2, got.Rejected[0].Row)
+	assert.True(t, errors.Is(got.Rejected[0].Err, account.ErrBalanceCapExceeded))
+	assert.Equal(t, 3, got.Rejected[1].Row)
+	assert.True(t, errors.Is(got.Rejected[1].Err, account.ErrNotUserType))
+	assert.Equal(t, 5, got.Rejected[2].Row)
+	assert.True(t, errors.Is(got.Rejected[2].Err, account.ErrDuplicateImportRow))
+	assert.Equal(t, 6, got.Rejected[3].Row)
+	assert.True(t, errors.Is(got.Rejected[3].Err, account.ErrAccountClosed))
+
+	// the existing account keeps its balance, the new ones are credited from the system account.
+	require.Len(t, upserted, 5)
+	assert.Equal(t, account.SystemAccountOpeningBalances, upserted[0].ID)
+	assert.Equal(t, "-100.00", upserted[0].Balance)
+	assert.Equal(t, "1000", upserted[1].Balance)
+	assert.Equal(t, "Existing", upserted[1].DisplayName)
+	assert.Equal(t, int64(2), upserted[1].Version)
+	assert.Equal(t, "100.00", upserted[2].Balance)
+	assert.Equal(t, "0", upserted[2].InitialBalance)
+	assert.Equal(t, account.StatusSuspended, upserted[2].Status)
+	assert.Equal(t, "0", upserted[3].Balance)
+	// the empty columns leave the details of the existing account unchanged.
+	assert.Equal(t, "Renamed", upserted[4].DisplayName)
+	assert.Equal(t, account.TypeSavings, upserted[4].Type)
+	assert.Equal(t, account.StatusSuspended, upserted[4].Status)
+
+	require.Len(t, payments, 1)
+	assert.Equal(t, "opening:2", payments[0].ExternalRef)
+
+	require.Len(t, entries, 5)
+	for _, e := range entries {
+		assert.Equal(t, audit.ActionImport, e.Action)
+	}
+	assert.Equal(t, int64(0), entries[0].PaymentID)
+	assert.Equal(t, int64(1), entries[1].PaymentID)
+	assert.Equal(t, "-100.00", entries[1].BalanceAfter)
+	assert.Equal(t, int64(1), entries[2].PaymentID)
+	assert.Equal(t, "100.00", entries[2].BalanceAfter)
+}
+
+func TestAccountImporter_Import_Error(t *testing.T) {
+	mock := &storageMock{
+		onGetAccounts: func(ctx context.Context, ids []int64) ([]*account.Account, error) {
+			return nil, nil
+		},
+		onUpsertAccounts: func(ctx context.Context, aa []*account.Account) error {
+			return errors.New("connection reset")
+		},
+	}
+	mock.onExecTx = func(ctx context.Context, fn func(context.Context, storage.Storage) error) error {
+		return fn(ctx, mock)
+	}
+
+	im, err := NewAccountImporter(AccountImporterConfig{Logger: log.NewNopLogger(), Storage: mock})
+	require.NoError(t, err)
+
+	_, err = im.Import(context.Background(), []*account.ImportRow{{ID: 1, OpeningBalance: "10"}})
+	assert.Error(t, err)
+}
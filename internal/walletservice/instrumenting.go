@@ -2,46 +2,72 @@ package walletservice
 
 import (
 	"context"
+	"errors"
+	"net/http"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/go-kit/kit/metrics"
 	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/shopspring/decimal"
 
 	"github.com/shkov/wallet-service/internal/account"
+	"github.com/shkov/wallet-service/internal/risk"
+	"github.com/shkov/wallet-service/internal/storage"
+)
+
+// payment outcomes besides the final statuses of the payments.
+const (
+	outcomeRejected = "rejected"
+	outcomeError    = "error"
 )
 
 // instrumentingMiddleware wraps the given Service and records metrics.
 type instrumentingMiddleware struct {
 	next      Service
 	histogram metrics.Histogram
+
+	payments         *paymentMetrics
+	paymentsInFlight metrics.Gauge
 }
 
 // NewInstrumentingMiddleware creates a new instrumenting middleware.
 func NewInstrumentingMiddleware(next Service, prefix string) Service {
 	return &instrumentingMiddleware{
 		next: next,
-		histogram: kitprometheus.NewHistogramFrom(
+		histogram: kitprometheus.NewHistogram(registerCollector(prometheus.NewHistogramVec(
 			prometheus.HistogramOpts{
 				Name:    prefix + "_queries",
 				Buckets: prometheus.ExponentialBuckets(0.025, 2, 8),
 			},
 			[]string{"method", "error"},
-		),
+		)).(*prometheus.HistogramVec)),
+		payments: newPaymentMetrics(prefix),
+		paymentsInFlight: kitprometheus.NewGauge(registerCollector(prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: prefix + "_payments_in_flight",
+				Help: "Number of payments being applied.",
+			},
+			[]string{},
+		)).(*prometheus.GaugeVec)),
 	}
 }
 
 func (mw *instrumentingMiddleware) ApplyPayment(ctx context.Context, p *account.PaymentRequest) (*account.Payment, error) {
 	startedAt := time.Now()
+	mw.paymentsInFlight.Add(1)
 	out, err := mw.next.ApplyPayment(ctx, p)
+	mw.paymentsInFlight.Add(-1)
 	mw.record(ctx, startedAt, "ApplyPayment", err)
+	mw.recordPayment(startedAt, out, err)
 	return out, err
 }
 
-func (mw *instrumentingMiddleware) GetPayments(ctx context.Context, accountID int64) ([]*account.Payment, error) {
+func (mw *instrumentingMiddleware) GetPayments(ctx context.Context, f *account.PaymentFilter) ([]*account.Payment, error) {
 	startedAt := time.Now()
-	out, err := mw.next.GetPayments(ctx, accountID)
+	out, err := mw.next.GetPayments(ctx, f)
 	mw.record(ctx, startedAt, "GetPayments", err)
 	return out, err
 }
@@ -53,9 +79,328 @@ func (mw *instrumentingMiddleware) GetAccount(ctx context.Context, id int64) (*a
 	return out, err
 }
 
+func (mw *instrumentingMiddleware) GetAccountBalanceAt(ctx context.Context, id int64, at time.Time) (*account.Account, error) {
+	startedAt := time.Now()
+	out, err := mw.next.GetAccountBalanceAt(ctx, id, at)
+	mw.record(ctx, startedAt, "GetAccountBalanceAt", err)
+	return out, err
+}
+
+func (mw *instrumentingMiddleware) ListAccounts(ctx context.Context, r *account.ListRequest) (*account.Page, error) {
+	startedAt := time.Now()
+	out, err := mw.next.ListAccounts(ctx, r)
+	mw.record(ctx, startedAt, "ListAccounts", err)
+	return out, err
+}
+
+func (mw *instrumentingMiddleware) UpdateAccount(ctx context.Context, r *account.UpdateRequest) (*account.Account, error) {
+	startedAt := time.Now()
+	out, err := mw.next.UpdateAccount(ctx, r)
+	mw.record(ctx, startedAt, "UpdateAccount", err)
+	return out, err
+}
+
+func (mw *instrumentingMiddleware) ConfirmPayment(ctx context.Context, id int64) (*account.Payment, error) {
+	startedAt := time.Now()
+	out, err := mw.next.ConfirmPayment(ctx, id)
+	mw.record(ctx, startedAt, "ConfirmPayment", err)
+	mw.payments.record(out, err)
+	return out, err
+}
+
+func (mw *instrumentingMiddleware) CancelPayment(ctx context.Context, id int64) (*account.Payment, error) {
+	startedAt := time.Now()
+	out, err := mw.next.CancelPayment(ctx, id)
+	mw.record(ctx, startedAt, "CancelPayment", err)
+	mw.payments.record(out, err)
+	return out, err
+}
+
+func (mw *instrumentingMiddleware) GetStatement(ctx context.Context, r *account.StatementRequest, w account.StatementWriter) error {
+	startedAt := time.Now()
+	err := mw.next.GetStatement(ctx, r, w)
+	mw.record(ctx, startedAt, "GetStatement", err)
+	return err
+}
+
+func (mw *instrumentingMiddleware) Deposit(ctx context.Context, r *account.FundsRequest) (*account.Payment, error) {
+	startedAt := time.Now()
+	mw.paymentsInFlight.Add(1)
+	out, err := mw.next.Deposit(ctx, r)
+	mw.paymentsInFlight.Add(-1)
+	mw.record(ctx, startedAt, "Deposit", err)
+	mw.recordPayment(startedAt, out, err)
+	return out, err
+}
+
+func (mw *instrumentingMiddleware) Withdraw(ctx context.Context, r *account.FundsRequest) (*account.Payment, error) {
+	startedAt := time.Now()
+	mw.paymentsInFlight.Add(1)
+	out, err := mw.next.Withdraw(ctx, r)
+	mw.paymentsInFlight.Add(-1)
+	mw.record(ctx, startedAt, "Withdraw", err)
+	mw.recordPayment(startedAt, out, err)
+	return out, err
+}
+
+func (mw *instrumentingMiddleware) ProposeAdjustment(ctx context.Context, r *account.AdjustmentRequest) (*account.Adjustment, error) {
+	startedAt := time.Now()
+	out, err := mw.next.ProposeAdjustment(ctx, r)
+	mw.record(ctx, startedAt, "ProposeAdjustment", err)
+	return out, err
+}
+
+func (mw *instrumentingMiddleware) ApproveAdjustment(ctx context.Context, id int64) (*account.Adjustment, error) {
+	startedAt := time.Now()
+	out, err := mw.next.ApproveAdjustment(ctx, id)
+	mw.record(ctx, startedAt, "ApproveAdjustment", err)
+	mw.payments.recordAdjustment(out, err)
+	return out, err
+}
+
+func (mw *instrumentingMiddleware) RejectAdjustment(ctx context.Context, id int64) (*account.Adjustment, error) {
+	startedAt := time.Now()
+	out, err := mw.next.RejectAdjustment(ctx, id)
+	mw.record(ctx, startedAt, "RejectAdjustment", err)
+	return out, err
+}
+
+func (mw *instrumentingMiddleware) GetAdjustments(ctx context.Context, accountID int64) ([]*account.Adjustment, error) {
+	startedAt := time.Now()
+	out, err := mw.next.GetAdjustments(ctx, accountID)
+	mw.record(ctx, startedAt, "GetAdjustments", err)
+	return out, err
+}
+
 func (mw *instrumentingMiddleware) record(ctx context.Context, beginTime time.Time, method string, err error) {
 	mw.histogram.With(
 		"method", method,
 		"error", strconv.FormatBool(err != nil),
 	).Observe(time.Since(beginTime).Seconds())
 }
+
+// recordPayment records the payment applied by the request, the payment returned again for the reused
+// idempotency key was created before the request and is already counted.
+func (mw *instrumentingMiddleware) recordPayment(startedAt time.Time, p *account.Payment, err error) {
+	if err == nil && p.CreatedAt.Before(startedAt) {
+		return
+	}
+	mw.payments.record(p, err)
+}
+
+// paymentMetrics counts the payments by their final status when they get it, and the requests failed to
+// move money as rejected for client errors or as error otherwise.
+type paymentMetrics struct {
+	payments metrics.Counter
+	volume   metrics.Counter
+	amount   metrics.Histogram
+	rejected metrics.Counter
+}
+
+// newPaymentMetrics creates the payment metrics, the service and the payout worker share them
+// as both settle the payments.
+func newPaymentMetrics(prefix string) *paymentMetrics {
+	return &paymentMetrics{
+		payments: kitprometheus.NewCounter(registerCollector(prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: prefix + "_payments_total",
+				Help: "Number of payments by final status and of failed payment requests.",
+			},
+			[]string{"outcome"},
+		)).(*prometheus.CounterVec)),
+		volume: kitprometheus.NewCounter(registerCollector(prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: prefix + "_payments_volume_total",
+				Help: "Sum of payment amounts by final status.",
+			},
+			[]string{"outcome"},
+		)).(*prometheus.CounterVec)),
+		amount: kitprometheus.NewSummary(registerCollector(prometheus.NewSummaryVec(
+			prometheus.SummaryOpts{
+				Name:       prefix + "_payment_amount",
+				Help:       "Distribution of payment amounts by final status.",
+				Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+			},
+			[]string{"outcome"},
+		)).(*prometheus.SummaryVec)),
+		rejected: kitprometheus.NewCounter(registerCollector(prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: prefix + "_payments_rejected_total",
+				Help: "Number of rejected payment requests by reason.",
+			},
+			[]string{"reason"},
+		)).(*prometheus.CounterVec)),
+	}
+}
+
+// registerCollector registers the collector returning the one already registered under its name if any.
+func registerCollector(c prometheus.Collector) prometheus.Collector {
+	err := prometheus.Register(c)
+	if err == nil {
+		return c
+	}
+	var registered prometheus.AlreadyRegisteredError
+	if errors.As(err, &registered) {
+		return registered.ExistingCollector
+	}
+	panic(err)
+}
+
+// record records the payment by its status, a pending payment is counted when it is settled.
+func (m *paymentMetrics) record(p *account.Payment, err error) {
+	if err != nil {
+		m.recordError(err)
+		return
+	}
+	if p.Status == account.PaymentStatusPending {
+		return
+	}
+	m.recordAmount(p.Status, p.Amount)
+}
+
+// recordAdjustment records the payment applying the approved adjustment.
+func (m *paymentMetrics) recordAdjustment(a *account.Adjustment, err error) {
+	if err != nil {
+		m.recordError(err)
+		return
+	}
+	m.recordAmount(account.PaymentStatusCompleted, a.Amount)
+}
+
+// recordError records the request failed to move money, client errors are counted as rejections.
+func (m *paymentMetrics) recordError(err error) {
+	outcome := outcomeError
+	var e *serviceError
+	if errors.As(err, &e) && e.code < http.StatusInternalServerError {
+		outcome = outcomeRejected
+		m.rejected.With("reason", e.Code).Add(1)
+	}
+	m.payments.With("outcome", outcome).Add(1)
+}
+
+func (m *paymentMetrics) recordAmount(outcome, amount string) {
+	m.payments.With("outcome", outcome).Add(1)
+
+	value, err := decimal.NewFromString(amount)
+	if err != nil || !value.IsPositive() {
+		return
+	}
+	f, _ := value.Float64()
+	m.volume.With("outcome", outcome).Add(f)
+	m.amount.With("outcome", outcome).Observe(f)
+}
+
+// instrumentingRiskEngine wraps the given RiskEngine and counts its decisions and fired rules.
+type instrumentingRiskEngine struct {
+	next      RiskEngine
+	decisions metrics.Counter
+	rules     metrics.Counter
+}
+
+// newInstrumentingRiskEngine creates a new instrumenting risk engine.
+func newInstrumentingRiskEngine(next RiskEngine, prefix string) RiskEngine {
+	return &instrumentingRiskEngine{
+		next: next,
+		decisions: kitprometheus.NewCounter(registerCollector(prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: prefix + "_risk_decisions_total",
+				Help: "Number of screened payments by risk decision.",
+			},
+			[]string{"decision"},
+		)).(*prometheus.CounterVec)),
+		rules: kitprometheus.NewCounter(registerCollector(prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: prefix + "_risk_rules_total",
+				Help: "Number of screened payments by fired risk rule.",
+			},
+			[]string{"rule"},
+		)).(*prometheus.CounterVec)),
+	}
+}
+
+func (e *instrumentingRiskEngine) Evaluate(ctx context.Context, s risk.Storage, r *risk.Request) (*risk.Assessment, error) {
+	out, err := e.next.Evaluate(ctx, s, r)
+	if err != nil {
+		return nil, err
+	}
+	e.decisions.With("decision", out.Decision).Add(1)
+	for _, rule := range out.Rules {
+		e.rules.With("rule", rule).Add(1)
+	}
+	return out, nil
+}
+
+// balanceCollector exports the total balance of the accounts by their type. The totals sum all the accounts,
+// so they are queried on a scrape only when the ones of the previous query are older than the ttl.
+// The balances of the system accounts explain the total balance of the user accounts.
+type balanceCollector struct {
+	storage storage.Storage
+	timeout time.Duration
+	ttl     time.Duration
+	desc    *prometheus.Desc
+
+	mu        sync.Mutex
+	totals    map[string]string
+	queriedAt time.Time
+	now       func() time.Time
+}
+
+// newBalanceCollector creates a new collector of the total balance under management.
+func newBalanceCollector(s storage.Storage, prefix string, timeout, ttl time.Duration) prometheus.Collector {
+	return &balanceCollector{
+		storage: s,
+		timeout: timeout,
+		ttl:     ttl,
+		desc: prometheus.NewDesc(
+			prefix+"_balance_total",
+			"Total balance of the accounts by type.",
+			[]string{"type"},
+			nil,
+		),
+		now: time.Now,
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *balanceCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+// Collect implements prometheus.Collector.
+func (c *balanceCollector) Collect(ch chan<- prometheus.Metric) {
+	totals, err := c.getTotals()
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(c.desc, err)
+		return
+	}
+	for accountType, total := range totals {
+		value, err := decimal.NewFromString(total)
+		if err != nil {
+			ch <- prometheus.NewInvalidMetric(c.desc, err)
+			continue
+		}
+		f, _ := value.Float64()
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, f, accountType)
+	}
+}
+
+// getTotals returns the totals of the previous query if they are not older than the ttl,
+// the concurrent scrapes wait for a single query.
+func (c *balanceCollector) getTotals() (map[string]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if c.totals != nil && now.Sub(c.queriedAt) < c.ttl {
+		return c.totals, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	totals, err := c.storage.GetTotalBalances(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.totals, c.queriedAt = totals, now
+	return totals, nil
+}
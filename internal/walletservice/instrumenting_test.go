@@ -0,0 +1,192 @@
+package walletservice
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/shkov/wallet-service/internal/account"
+	"github.com/shkov/wallet-service/internal/health"
+)
+
+// metricMock records the values added to a metric by its label values.
+type metricMock struct {
+	labelValues []string
+	values      map[string]float64
+}
+
+func newMetricMock() *metricMock {
+	return &metricMock{values: make(map[string]float64)}
+}
+
+func (m *metricMock) With(labelValues ...string) metrics.Counter {
+	return &metricMock{labelValues: append(m.labelValues, labelValues...), values: m.values}
+}
+
+func (m *metricMock) Add(delta float64) {
+	m.values[m.key()] += delta
+}
+
+func (m *metricMock) key() string {
+	var key string
+	for i := 1; i < len(m.labelValues); i += 2 {
+		key += m.labelValues[i]
+	}
+	return key
+}
+
+type histogramMock struct {
+	*metricMock
+}
+
+func (m *histogramMock) With(labelValues ...string) metrics.Histogram {
+	return &histogramMock{metricMock: m.metricMock.With(labelValues...).(*metricMock)}
+}
+
+func (m *histogramMock) Observe(value float64) {
+	m.metricMock.Add(value)
+}
+
+func TestInstrumentingMiddleware_RecordPayment(t *testing.T) {
+	startedAt := parseTime(t, "2001-01-02T11:22:33+03:00")
+
+	testCases := []struct {
+		name         string
+		payment      *account.Payment
+		err          error
+		wantPayments map[string]float64
+		wantVolume   map[string]float64
+		wantRejected map[string]float64
+	}{
+		{
+			name:         "completed",
+			payment:      makePayment(t, nil),
+			err:          nil,
+			wantPayments: map[string]float64{account.PaymentStatusCompleted: 1},
+			wantVolume:   map[string]float64{account.PaymentStatusCompleted: 500},
+			wantRejected: map[string]float64{},
+		},
+		{
+			name: "cancelled",
+			payment: makePayment(t, func(p *account.Payment) {
+				p.Status = account.PaymentStatusCancelled
+			}),
+			err:          nil,
+			wantPayments: map[string]float64{account.PaymentStatusCancelled: 1},
+			wantVolume:   map[string]float64{account.PaymentStatusCancelled: 500},
+			wantRejected: map[string]float64{},
+		},
+		{
+			name: "pending",
+			payment: makePayment(t, func(p *account.Payment) {
+				p.Status, p.SettledAt = account.PaymentStatusPending, nil
+			}),
+			err:          nil,
+			wantPayments: map[string]float64{},
+			wantVolume:   map[string]float64{},
+			wantRejected: map[string]float64{},
+		},
+		{
+			name: "idempotent replay",
+			payment: makePayment(t, func(p *account.Payment) {
+				p.CreatedAt = startedAt.Add(-time.Minute)
+			}),
+			err:          nil,
+			wantPayments: map[string]float64{},
+			wantVolume:   map[string]float64{},
+			wantRejected: map[string]float64{},
+		},
+		{
+			name:         "rejected",
+			payment:      nil,
+			err:          errBadRequest("failed to apply payment to the sender: %w", account.ErrNotEnoughFunds),
+			wantPayments: map[string]float64{outcomeRejected: 1},
+			wantVolume:   map[string]float64{},
+			wantRejected: map[string]float64{"insufficient_funds": 1},
+		},
+		{
+			name:         "error",
+			payment:      nil,
+			err:          errInternal("kek some err occurs"),
+			wantPayments: map[string]float64{outcomeError: 1},
+			wantVolume:   map[string]float64{},
+			wantRejected: map[string]float64{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			payments, volume, rejected := newMetricMock(), newMetricMock(), newMetricMock()
+			mw := &instrumentingMiddleware{
+				payments: &paymentMetrics{
+					payments: payments,
+					volume:   volume,
+					amount:   &histogramMock{metricMock: newMetricMock()},
+					rejected: rejected,
+				},
+			}
+
+			mw.recordPayment(startedAt, tc.payment, tc.err)
+			assert.Equal(t, tc.wantPayments, payments.values)
+			assert.Equal(t, tc.wantVolume, volume.values)
+			assert.Equal(t, tc.wantRejected, rejected.values)
+		})
+	}
+}
+
+func TestNewPaymentMetrics_Shared(t *testing.T) {
+	assert.NotPanics(t, func() {
+		newPaymentMetrics("test_shared")
+		newPaymentMetrics("test_shared")
+	})
+}
+
+func TestBalanceCollector(t *testing.T) {
+	var queries int
+	mock := &storageMock{
+		onGetTotalBalances: func(ctx context.Context) (map[string]string, error) {
+			queries++
+			return map[string]string{account.TypeUser: "1500.50", account.TypeFunding: "-500.50"}, nil
+		},
+	}
+	c := newBalanceCollector(mock, "test", time.Second, time.Minute).(*balanceCollector)
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+
+	want := `
+# HELP test_balance_total Total balance of the accounts by type.
+# TYPE test_balance_total gauge
+test_balance_total{type="funding"} -500.5
+test_balance_total{type="user"} 1500.5
+`
+	assert.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(want)))
+	assert.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(want)))
+	assert.Equal(t, 1, queries)
+
+	// the totals are queried again when they are older than the ttl.
+	now = now.Add(time.Minute)
+	assert.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(want)))
+	assert.Equal(t, 2, queries)
+}
+
+func TestNewServer_Twice(t *testing.T) {
+	cfg := ServerConfig{
+		Logger:       log.NewNopLogger(),
+		Storage:      &storageMock{},
+		MetricPrefix: "test_server",
+		Health:       health.NewRegistry(time.Second),
+	}
+	assert.NotPanics(t, func() {
+		_, err := NewServer(cfg)
+		require.NoError(t, err)
+		_, err = NewServer(cfg)
+		require.NoError(t, err)
+	})
+}
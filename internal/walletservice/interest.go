@@ -0,0 +1,379 @@
+package walletservice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/shopspring/decimal"
+
+	"github.com/shkov/wallet-service/internal/account"
+	"github.com/shkov/wallet-service/internal/audit"
+	"github.com/shkov/wallet-service/internal/storage"
+)
+
+// day is the period of the interest accrual, the days start at midnight UTC.
+const day = 24 * time.Hour
+
+// InterestAccruerConfig is an interest accruer configuration.
+type InterestAccruerConfig struct {
+	Logger  log.Logger
+	Storage storage.TransactionalStorage
+	// Rates are the annual interest rates of the account types accruing interest, e.g. 0.05 for 5%.
+	Rates map[string]string
+	// DayCount is the day count convention of the rates.
+	DayCount string
+	// Interval is the period of checking for a finished day to accrue the interest for.
+	Interval time.Duration
+	// BatchSize limits the accounts read at once.
+	BatchSize int
+	// BalancePolicies bound the balances of the accounts the interest is posted to.
+	BalancePolicies map[string]*account.BalancePolicy
+}
+
+// InterestReport is the result of the interest accrual for a day.
+type InterestReport struct {
+	Day time.Time
+	// Accrued is the number of the accounts the interest is accrued for, the days already accrued are skipped.
+	Accrued int64
+	// Posted is the number of the accounts the monthly interest is posted to.
+	Posted int64
+	// Failed is the number of the accounts the monthly interest failed to be posted to,
+	// their interest is posted at the end of the next month.
+	Failed int64
+}
+
+// InterestAccruer accrues the daily interest on the balances of the active accounts of the types with
+// an interest rate, and posts the interest accrued for a month to the accounts on its last day as the payment
+// from SystemAccountInterest. The interest is accrued on the balance at the end of the day, so a day is accrued
+// when it is over.
+type InterestAccruer struct {
+	svc       *serviceImpl
+	rates     map[string]*account.InterestRate
+	types     []string
+	interval  time.Duration
+	batchSize int
+
+	// accrued is the last day accrued by Run.
+	accrued time.Time
+}
+
+// NewInterestAccruer creates a new interest accruer.
+func NewInterestAccruer(cfg InterestAccruerConfig) (*InterestAccruer, error) {
+	if cfg.BatchSize <= 0 {
+		return nil, errors.New("BatchSize must be positive")
+	}
+
+	rates := make(map[string]*account.InterestRate, len(cfg.Rates))
+	types := make([]string, 0, len(cfg.Rates))
+	for accountType, annual := range cfg.Rates {
+		if err := account.ValidateUserType(accountType); err != nil {
+			return nil, fmt.Errorf("account type %q: %w", accountType, err)
+		}
+		rate, err := account.NewInterestRate(annual, cfg.DayCount)
+		if err != nil {
+			return nil, fmt.Errorf("interest rate of %q: %w", accountType, err)
+		}
+		rates[accountType] = rate
+		types = append(types, accountType)
+	}
+	sort.Strings(types)
+
+	return &InterestAccruer{
+		svc: &serviceImpl{
+			logger:   cfg.Logger,
+			storage:  cfg.Storage,
+			policies: cfg.BalancePolicies,
+			now:      time.Now,
+		},
+		rates:     rates,
+		types:     types,
+		interval:  cfg.Interval,
+		batchSize: cfg.BatchSize,
+	}, nil
+}
+
+// Run accrues the interest for every finished day until the context is canceled.
+func (a *InterestAccruer) Run(ctx context.Context) error {
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+
+	for {
+		a.runOnce(ctx)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (a *InterestAccruer) runOnce(ctx context.Context) {
+	yesterday := a.svc.now().UTC().Truncate(day).Add(-day)
+	if !yesterday.After(a.accrued) {
+		return
+	}
+
+	report, err := a.Accrue(ctx, yesterday)
+	if err != nil {
+		if ctx.Err() == nil {
+			level.Error(a.svc.logger).Log("component", "interest", "msg", "failed to accrue interest", "day", yesterday, "err", err)
+		}
+		return
+	}
+	a.accrued = yesterday
+	level.Info(a.svc.logger).Log("component", "interest", "msg", "interest is accrued", "day", yesterday,
+		"accrued", report.Accrued, "posted", report.Posted, "failed", report.Failed)
+}
+
+// Accrue accrues the interest for the finished day and posts the monthly interest if it is the last day
+// of the month. The day is truncated to its start in UTC. Accruing the day again only posts the interest
+// not posted yet, so the missed days are backfilled by accruing them. The accounts accrue by the type and
+// status they had at the end of the day.
+func (a *InterestAccruer) Accrue(ctx context.Context, d time.Time) (*InterestReport, error) {
+	d = d.UTC().Truncate(day)
+	end := d.Add(day)
+	if end.After(a.svc.now()) {
+		return nil, fmt.Errorf("day %s: %w", d.Format("2006-01-02"), account.ErrInterestDayNotOver)
+	}
+
+	changed, err := a.detailsAt(ctx, end)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &InterestReport{Day: d}
+	for _, accountType := range a.types {
+		r := &account.ListRequest{Type: accountType, Status: account.StatusActive, CreatedTo: end, Sort: account.SortByID}
+		err = a.accrueListed(ctx, r, d, report, func(acc *account.Account) string {
+			if _, ok := changed[acc.ID]; ok {
+				return ""
+			}
+			return accountType
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(changed) == 0 {
+		return report, nil
+	}
+	ids := make([]int64, 0, len(changed))
+	for id := range changed {
+		ids = append(ids, id)
+	}
+	r := &account.ListRequest{IDs: ids, CreatedTo: end, Sort: account.SortByID}
+	err = a.accrueListed(ctx, r, d, report, func(acc *account.Account) string {
+		details := changed[acc.ID]
+		accountType, status := acc.Type, acc.Status
+		if t, ok := details["Type"]; ok {
+			accountType = t
+		}
+		if s, ok := details["Status"]; ok {
+			status = s
+		}
+		if status != account.StatusActive {
+			return ""
+		}
+		return accountType
+	})
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// accrueListed accrues the interest for the day of the listed accounts by the rates of the types returned by typeOf,
+// the accounts of the types without a rate are skipped.
+func (a *InterestAccruer) accrueListed(ctx context.Context, r *account.ListRequest, d time.Time, report *InterestReport,
+	typeOf func(*account.Account) string) error {
+	for {
+		accounts, err := a.svc.storage.ListAccounts(ctx, r, a.batchSize)
+		if err != nil {
+			return fmt.Errorf("failed to list accounts: %w", err)
+		}
+
+		byType := make(map[string][]*account.Account)
+		for _, acc := range accounts {
+			accountType := typeOf(acc)
+			if _, ok := a.rates[accountType]; ok {
+				byType[accountType] = append(byType[accountType], acc)
+			}
+		}
+		for _, accountType := range a.types {
+			if len(byType[accountType]) == 0 {
+				continue
+			}
+			n, err := a.accrue(ctx, byType[accountType], d, a.rates[accountType])
+			if err != nil {
+				return err
+			}
+			report.Accrued += n
+		}
+
+		if account.IsMonthEnd(d) {
+			for _, accountType := range a.types {
+				for _, acc := range byType[accountType] {
+					posted, err := a.svc.postInterest(ctx, acc.ID, d)
+					if err != nil {
+						report.Failed++
+						level.Error(a.svc.logger).Log("component", "interest", "msg", "failed to post interest",
+							"account_id", acc.ID, "day", d, "err", err)
+						continue
+					}
+					if posted {
+						report.Posted++
+					}
+				}
+			}
+		}
+
+		if len(accounts) < a.batchSize {
+			return nil
+		}
+		r.After = account.NewCursor(account.SortByID, accounts[len(accounts)-1])
+	}
+}
+
+// detailsAt returns the type and status the accounts changed at or after the given time had at that time by account id,
+// they are the previous values of the first changes of the fields recorded in the audit log.
+func (a *InterestAccruer) detailsAt(ctx context.Context, t time.Time) (map[int64]map[string]string, error) {
+	entries, err := a.svc.storage.GetDetailsChanges(ctx, t)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get details changes: %w", err)
+	}
+
+	changed := make(map[int64]map[string]string)
+	for _, e := range entries {
+		var changes map[string]detailsChange
+		if err = json.Unmarshal([]byte(e.Details), &changes); err != nil {
+			return nil, fmt.Errorf("failed to parse details of audit entry %d: %w", e.Seq, err)
+		}
+		for _, field := range []string{"Type", "Status"} {
+			c, ok := changes[field]
+			if !ok {
+				continue
+			}
+			details, ok := changed[e.AccountID]
+			if !ok {
+				details = make(map[string]string)
+				changed[e.AccountID] = details
+			}
+			if _, ok = details[field]; !ok {
+				details[field], _ = c.From.(string)
+			}
+		}
+	}
+	return changed, nil
+}
+
+// accrue saves the interest accrued by the accounts on their balances at the end of the day.
+func (a *InterestAccruer) accrue(ctx context.Context, accounts []*account.Account, d time.Time, rate *account.InterestRate) (int64, error) {
+	accruals := make([]*account.InterestAccrual, 0, len(accounts))
+	for _, acc := range accounts {
+		balance, err := a.svc.storage.GetAccountBalanceAt(ctx, acc.ID, d.Add(day))
+		if err != nil {
+			return 0, fmt.Errorf("failed to get balance of account %d: %w", acc.ID, err)
+		}
+		amount, err := rate.Daily(balance, d)
+		if err != nil {
+			return 0, fmt.Errorf("failed to accrue interest of account %d: %w", acc.ID, err)
+		}
+		accruals = append(accruals, &account.InterestAccrual{
+			AccountID: acc.ID,
+			Day:       d,
+			Balance:   balance,
+			Rate:      rate.Annual.String(),
+			Amount:    amount,
+		})
+	}
+
+	n, err := a.svc.storage.InsertInterestAccruals(ctx, accruals)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert interest accruals: %w", err)
+	}
+	return n, nil
+}
+
+// postInterest pays the interest accrued by the account until the end of the last day of the month and not posted
+// yet from SystemAccountInterest. The interest less than a cent is left to the next month.
+func (s *serviceImpl) postInterest(ctx context.Context, accountID int64, monthEnd time.Time) (bool, error) {
+	posted := false
+	txFn := func(ctx context.Context, storage storage.Storage) error {
+		accounts, err := s.getAccounts(ctx, storage, []int64{account.SystemAccountInterest, accountID})
+		if err != nil {
+			return fmt.Errorf("failed to get accounts: %w", err)
+		}
+
+		// the interest accrued by backfilling a month already posted is left to the next month.
+		month := time.Date(monthEnd.Year(), monthEnd.Month(), 1, 0, 0, 0, 0, time.UTC)
+		before := monthEnd.Add(day)
+		done, err := storage.IsInterestPosted(ctx, accountID, month, before)
+		if err != nil {
+			return fmt.Errorf("failed to check posted interest: %w", err)
+		}
+		if done {
+			return nil
+		}
+
+		accrued, err := storage.GetUnpostedInterest(ctx, accountID, before)
+		if err != nil {
+			return fmt.Errorf("failed to get unposted interest: %w", err)
+		}
+		amount, err := account.RoundInterest(accrued)
+		if err != nil {
+			return err
+		}
+		if !decimal.RequireFromString(amount).IsPositive() {
+			return nil
+		}
+
+		payment := account.NewInterestPosting(accountID, amount, month, s.now())
+		balances := make([]string, len(accounts))
+		for i, a := range accounts {
+			balances[i] = a.Balance
+			err = a.ApplyPayment(payment)
+			if err != nil {
+				return fmt.Errorf("failed to apply interest: %w", err)
+			}
+		}
+
+		err = storage.ReplaceAccounts(ctx, accounts)
+		if err != nil {
+			return fmt.Errorf("failed to replace accounts: %w", err)
+		}
+		err = storage.InsertPayment(ctx, payment)
+		if err != nil {
+			return fmt.Errorf("failed to insert payment: %w", err)
+		}
+		err = storage.SetInterestPayment(ctx, accountID, before, payment.ID)
+		if err != nil {
+			return fmt.Errorf("failed to set interest payment: %w", err)
+		}
+
+		entries := make([]*audit.Entry, 0, len(accounts))
+		for i, a := range accounts {
+			entries = append(entries, s.newAuditEntry(ctx, audit.ActionInterest, a, balances[i], payment.ID))
+		}
+		err = storage.AppendAuditEntries(ctx, entries)
+		if err != nil {
+			return fmt.Errorf("failed to append audit entries: %w", err)
+		}
+
+		posted = true
+		return nil
+	}
+
+	err := s.storage.ExecTx(ctx, txFn)
+	if err != nil {
+		return false, err
+	}
+	return posted, nil
+}
@@ -0,0 +1,283 @@
+package walletservice
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/shkov/wallet-service/internal/account"
+	"github.com/shkov/wallet-service/internal/audit"
+	"github.com/shkov/wallet-service/internal/storage"
+)
+
+func TestInterestAccruer_Accrue(t *testing.T) {
+	testCases := []struct {
+		name         string
+		day          string
+		unposted     string
+		posted       bool
+		wantReport   *InterestReport
+		wantBalances []string
+		wantErr      error
+	}{
+		{
+			name:       "accrual",
+			day:        "2021-03-30T00:00:00Z",
+			wantReport: &InterestReport{Accrued: 1},
+		},
+		{
+			name:         "monthly posting",
+			day:          "2021-03-31T00:00:00Z",
+			unposted:     "3.10000000",
+			wantReport:   &InterestReport{Accrued: 1, Posted: 1},
+			wantBalances: []string{"-3.10", "1003.10"},
+		},
+		{
+			name:       "backfill of a posted month is left to the next month",
+			day:        "2021-03-31T00:00:00Z",
+			unposted:   "0.10000000",
+			posted:     true,
+			wantReport: &InterestReport{Accrued: 1},
+		},
+		{
+			name:       "less than a cent is left to the next month",
+			day:        "2021-03-31T00:00:00Z",
+			unposted:   "0.00400000",
+			wantReport: &InterestReport{Accrued: 1},
+		},
+		{
+			name:    "day is not over",
+			day:     "2021-04-01T00:00:00Z",
+			wantErr: account.ErrInterestDayNotOver,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			now := parseTime(t, "2021-04-01T01:00:00Z")
+			day := parseTime(t, tc.day)
+			end := day.Add(24 * time.Hour)
+
+			var accruals []*account.InterestAccrual
+			var balances []string
+			var posting *account.Payment
+			mock := &storageMock{
+				onGetChanges: func(ctx context.Context, since time.Time) ([]*audit.Entry, error) {
+					assert.Equal(t, end, since)
+					return nil, nil
+				},
+				onListAccounts: func(ctx context.Context, r *account.ListRequest, limit int) ([]*account.Account, error) {
+					assert.Equal(t, account.TypeSavings, r.Type)
+					assert.Equal(t, account.StatusActive, r.Status)
+					assert.Equal(t, end, r.CreatedTo)
+					return []*account.Account{makeAccount(t, func(a *account.Account) { a.Type = account.TypeSavings })}, nil
+				},
+				onGetBalanceAt: func(ctx context.Context, accountID int64, at time.Time) (string, error) {
+					assert.Equal(t, end, at)
+					return "1000", nil
+				},
+				onInsertAccruals: func(ctx context.Context, aa []*account.InterestAccrual) (int64, error) {
+					accruals = aa
+					return int64(len(aa)), nil
+				},
+				onGetAccounts: func(ctx context.Context, ids []int64) ([]*account.Account, error) {
+					assert.Equal(t, []int64{account.SystemAccountInterest, 1}, ids)
+					return []*account.Account{makeAccount(t, nil)}, nil
+				},
+				onIsInterestPosted: func(ctx context.Context, accountID int64, from, before time.Time) (bool, error) {
+					assert.Equal(t, parseTime(t, "2021-03-01T00:00:00Z"), from)
+					assert.Equal(t, end, before)
+					return tc.posted, nil
+				},
+				onGetUnposted: func(ctx context.Context, accountID int64, before time.Time) (string, error) {
+					assert.Equal(t, end, before)
+					return tc.unposted, nil
+				},
+				onReplaceAccounts: func(ctx context.Context, aa []*account.Account) error {
+					for _, a := range aa {
+						balances = append(balances, a.Balance)
+					}
+					return nil
+				},
+				onInsertPayment: func(ctx context.Context, p *account.Payment) error {
+					p.ID = 9
+					posting = p
+					return nil
+				},
+				onSetInterestPaid: func(ctx context.Context, accountID int64, before time.Time, paymentID int64) error {
+					assert.Equal(t, int64(1), accountID)
+					assert.Equal(t, end, before)
+					assert.Equal(t, int64(9), paymentID)
+					return nil
+				},
+				onAppendAudit: func(ctx context.Context, entries []*audit.Entry) error {
+					for _, e := range entries {
+						assert.Equal(t, audit.ActionInterest, e.Action)
+						assert.Equal(t, systemActor, e.Actor)
+					}
+					return nil
+				},
+			}
+			mock.onExecTx = func(ctx context.Context, fn func(context.Context, storage.Storage) error) error {
+				return fn(ctx, mock)
+			}
+
+			a, err := NewInterestAccruer(InterestAccruerConfig{
+				Logger:    log.NewNopLogger(),
+				Storage:   mock,
+				Rates:     map[string]string{account.TypeSavings: "0.0365"},
+				DayCount:  account.DayCountActual365,
+				BatchSize: 10,
+			})
+			require.NoError(t, err)
+			a.svc.now = func() time.Time { return now }
+
+			got, err := a.Accrue(context.Background(), day)
+			if tc.wantErr != nil {
+				assert.True(t, errors.Is(err, tc.wantErr))
+				return
+			}
+			require.NoError(t, err)
+			tc.wantReport.Day = day
+			assert.Equal(t, tc.wantReport, got)
+			assert.Equal(t, []*account.InterestAccrual{{
+				AccountID: 1,
+				Day:       day,
+				Balance:   "1000",
+				Rate:      "0.0365",
+				Amount:    "0.10000000",
+			}}, accruals)
+
+			assert.Equal(t, tc.wantBalances, balances)
+			if tc.wantReport.Posted == 0 {
+				assert.Nil(t, posting)
+				return
+			}
+			want := account.NewInterestPosting(1, "3.10", parseTime(t, "2021-03-01T00:00:00Z"), now)
+			want.ID = 9
+			assert.Equal(t, want, posting)
+		})
+	}
+}
+
+// TestInterestAccruer_Accrue_ChangedDetails checks that the accounts accrue by the type and status they had on the day:
+// 1 became savings and 2 was suspended after the day, 3 changed only its name and 4 was suspended on the day.
+func TestInterestAccruer_Accrue_ChangedDetails(t *testing.T) {
+	day := parseTime(t, "2021-03-15T00:00:00Z")
+	end := day.Add(24 * time.Hour)
+	changes := []*audit.Entry{
+		{Seq: 1, AccountID: 1, Details: `{"Type":{"from":"checking","to":"savings"}}`},
+		{Seq: 2, AccountID: 2, Details: `{"Type":{"from":"savings","to":"checking"}}`},
+		{Seq: 3, AccountID: 2, Details: `{"Status":{"from":"active","to":"suspended"}}`},
+		{Seq: 4, AccountID: 3, Details: `{"DisplayName":{"from":"","to":"Savings"}}`},
+		{Seq: 5, AccountID: 4, Details: `{"Status":{"from":"suspended","to":"active"}}`},
+	}
+	accounts := map[int64]*account.Account{
+		1: makeAccount(t, func(a *account.Account) { a.ID, a.Type = 1, account.TypeSavings }),
+		2: makeAccount(t, func(a *account.Account) { a.ID, a.Type, a.Status = 2, "checking", account.StatusSuspended }),
+		3: makeAccount(t, func(a *account.Account) { a.ID, a.Type = 3, account.TypeSavings }),
+		4: makeAccount(t, func(a *account.Account) { a.ID, a.Type = 4, account.TypeSavings }),
+		5: makeAccount(t, func(a *account.Account) { a.ID, a.Type = 5, account.TypeSavings }),
+	}
+
+	var accrued []int64
+	mock := &storageMock{
+		onGetChanges: func(ctx context.Context, since time.Time) ([]*audit.Entry, error) {
+			assert.Equal(t, end, since)
+			return changes, nil
+		},
+		onListAccounts: func(ctx context.Context, r *account.ListRequest, limit int) ([]*account.Account, error) {
+			assert.Equal(t, end, r.CreatedTo)
+			if r.IDs == nil {
+				assert.Equal(t, account.TypeSavings, r.Type)
+				return []*account.Account{accounts[1], accounts[3], accounts[4], accounts[5]}, nil
+			}
+			assert.ElementsMatch(t, []int64{1, 2, 4}, r.IDs)
+			return []*account.Account{accounts[1], accounts[2], accounts[4]}, nil
+		},
+		onGetBalanceAt: func(ctx context.Context, accountID int64, at time.Time) (string, error) {
+			return "1000", nil
+		},
+		onInsertAccruals: func(ctx context.Context, aa []*account.InterestAccrual) (int64, error) {
+			for _, a := range aa {
+				accrued = append(accrued, a.AccountID)
+			}
+			return int64(len(aa)), nil
+		},
+	}
+
+	a, err := NewInterestAccruer(InterestAccruerConfig{
+		Logger:    log.NewNopLogger(),
+		Storage:   mock,
+		Rates:     map[string]string{account.TypeSavings: "0.0365"},
+		DayCount:  account.DayCountActual365,
+		BatchSize: 10,
+	})
+	require.NoError(t, err)
+	a.svc.now = func() time.Time { return parseTime(t, "2021-04-01T01:00:00Z") }
+
+	got, err := a.Accrue(context.Background(), day)
+	require.NoError(t, err)
+	assert.Equal(t, &InterestReport{Day: day, Accrued: 3}, got)
+	assert.Equal(t, []int64{3, 5, 2}, accrued)
+}
+
+func TestInterestAccruer_RunOnce(t *testing.T) {
+	var days []time.Time
+	mock := &storageMock{
+		onGetChanges: func(ctx context.Context, since time.Time) ([]*audit.Entry, error) {
+			return nil, nil
+		},
+		onListAccounts: func(ctx context.Context, r *account.ListRequest, limit int) ([]*account.Account, error) {
+			days = append(days, r.CreatedTo.Add(-24*time.Hour))
+			return nil, nil
+		},
+		onInsertAccruals: func(ctx context.Context, aa []*account.InterestAccrual) (int64, error) {
+			return 0, nil
+		},
+	}
+
+	a, err := NewInterestAccruer(InterestAccruerConfig{
+		Logger:    log.NewNopLogger(),
+		Storage:   mock,
+		Rates:     map[string]string{account.TypeSavings: "0.02"},
+		DayCount:  account.DayCountActual360,
+		Interval:  time.Hour,
+		BatchSize: 10,
+	})
+	require.NoError(t, err)
+
+	// the finished day is accrued once.
+	for _, now := range []string{"2021-04-01T23:00:00+03:00", "2021-04-02T00:30:00Z", "2021-04-02T10:00:00Z", "2021-04-03T00:00:00Z"} {
+		tm := parseTime(t, now)
+		a.svc.now = func() time.Time { return tm }
+		a.runOnce(context.Background())
+	}
+
+	assert.Equal(t, []time.Time{
+		parseTime(t, "2021-03-31T00:00:00Z"),
+		parseTime(t, "2021-04-01T00:00:00Z"),
+		parseTime(t, "2021-04-02T00:00:00Z"),
+	}, days)
+}
+
+func TestNewInterestAccruer(t *testing.T) {
+	_, err := NewInterestAccruer(InterestAccruerConfig{
+		Rates:     map[string]string{account.TypeFees: "0.01"},
+		DayCount:  account.DayCountActual365,
+		BatchSize: 10,
+	})
+	assert.True(t, errors.Is(err, account.ErrNotUserType))
+
+	_, err = NewInterestAccruer(InterestAccruerConfig{
+		Rates:     map[string]string{account.TypeSavings: "0.01"},
+		DayCount:  "30/360",
+		BatchSize: 10,
+	})
+	assert.True(t, errors.Is(err, account.ErrUnknownDayCount))
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/go-kit/kit/log/level"
 
 	"github.com/shkov/wallet-service/internal/account"
+	"github.com/shkov/wallet-service/internal/requestid"
 )
 
 // loggingMiddleware wraps the given Service and logs errors.
@@ -30,9 +31,58 @@ func (mw *loggingMiddleware) ApplyPayment(ctx context.Context, p *account.Paymen
 	return out, err
 }
 
-func (mw *loggingMiddleware) GetPayments(ctx context.Context, accountID int64) ([]*account.Payment, error) {
+func (mw *loggingMiddleware) GetStatement(ctx context.Context, r *account.StatementRequest, w account.StatementWriter) error {
 	startedAt := time.Now()
-	out, err := mw.next.GetPayments(ctx, accountID)
+	err := mw.next.GetStatement(ctx, r, w)
+	mw.log(ctx, startedAt, "GetStatement", err)
+	return err
+}
+
+func (mw *loggingMiddleware) Deposit(ctx context.Context, r *account.FundsRequest) (*account.Payment, error) {
+	startedAt := time.Now()
+	out, err := mw.next.Deposit(ctx, r)
+	mw.log(ctx, startedAt, "Deposit", err)
+	return out, err
+}
+
+func (mw *loggingMiddleware) Withdraw(ctx context.Context, r *account.FundsRequest) (*account.Payment, error) {
+	startedAt := time.Now()
+	out, err := mw.next.Withdraw(ctx, r)
+	mw.log(ctx, startedAt, "Withdraw", err)
+	return out, err
+}
+
+func (mw *loggingMiddleware) ProposeAdjustment(ctx context.Context, r *account.AdjustmentRequest) (*account.Adjustment, error) {
+	startedAt := time.Now()
+	out, err := mw.next.ProposeAdjustment(ctx, r)
+	mw.log(ctx, startedAt, "ProposeAdjustment", err)
+	return out, err
+}
+
+func (mw *loggingMiddleware) ApproveAdjustment(ctx context.Context, id int64) (*account.Adjustment, error) {
+	startedAt := time.Now()
+	out, err := mw.next.ApproveAdjustment(ctx, id)
+	mw.log(ctx, startedAt, "ApproveAdjustment", err)
+	return out, err
+}
+
+func (mw *loggingMiddleware) RejectAdjustment(ctx context.Context, id int64) (*account.Adjustment, error) {
+	startedAt := time.Now()
+	out, err := mw.next.RejectAdjustment(ctx, id)
+	mw.log(ctx, startedAt, "RejectAdjustment", err)
+	return out, err
+}
+
+func (mw *loggingMiddleware) GetAdjustments(ctx context.Context, accountID int64) ([]*account.Adjustment, error) {
+	startedAt := time.Now()
+	out, err := mw.next.GetAdjustments(ctx, accountID)
+	mw.log(ctx, startedAt, "GetAdjustments", err)
+	return out, err
+}
+
+func (mw *loggingMiddleware) GetPayments(ctx context.Context, f *account.PaymentFilter) ([]*account.Payment, error) {
+	startedAt := time.Now()
+	out, err := mw.next.GetPayments(ctx, f)
 	mw.log(ctx, startedAt, "GetPayments", err)
 	return out, err
 }
@@ -44,8 +94,48 @@ func (mw *loggingMiddleware) GetAccount(ctx context.Context, id int64) (*account
 	return out, err
 }
 
+func (mw *loggingMiddleware) GetAccountBalanceAt(ctx context.Context, id int64, at time.Time) (*account.Account, error) {
+	startedAt := time.Now()
+	out, err := mw.next.GetAccountBalanceAt(ctx, id, at)
+	mw.log(ctx, startedAt, "GetAccountBalanceAt", err)
+	return out, err
+}
+
+func (mw *loggingMiddleware) ListAccounts(ctx context.Context, r *account.ListRequest) (*account.Page, error) {
+	startedAt := time.Now()
+	out, err := mw.next.ListAccounts(ctx, r)
+	mw.log(ctx, startedAt, "ListAccounts", err)
+	return out, err
+}
+
+func (mw *loggingMiddleware) UpdateAccount(ctx context.Context, r *account.UpdateRequest) (*account.Account, error) {
+	startedAt := time.Now()
+	out, err := mw.next.UpdateAccount(ctx, r)
+	mw.log(ctx, startedAt, "UpdateAccount", err)
+	return out, err
+}
+
+func (mw *loggingMiddleware) ConfirmPayment(ctx context.Context, id int64) (*account.Payment, error) {
+	startedAt := time.Now()
+	out, err := mw.next.ConfirmPayment(ctx, id)
+	mw.log(ctx, startedAt, "ConfirmPayment", err)
+	return out, err
+}
+
+func (mw *loggingMiddleware) CancelPayment(ctx context.Context, id int64) (*account.Payment, error) {
+	startedAt := time.Now()
+	out, err := mw.next.CancelPayment(ctx, id)
+	mw.log(ctx, startedAt, "CancelPayment", err)
+	return out, err
+}
+
 func (mw *loggingMiddleware) log(ctx context.Context, beginTime time.Time, method string, err error) {
 	if err != nil {
-		level.Error(mw.logger).Log("method", method, "err", err, "took", time.Since(beginTime))
+		level.Error(mw.logger).Log(
+			"method", method,
+			"request_id", requestid.FromContext(ctx),
+			"err", err,
+			"took", time.Since(beginTime),
+		)
 	}
 }
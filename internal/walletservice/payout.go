@@ -0,0 +1,220 @@
+package walletservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+
+	"github.com/shkov/wallet-service/internal/account"
+	"github.com/shkov/wallet-service/internal/storage"
+)
+
+// PayoutProvider pays the withdrawals out through a payment rail.
+type PayoutProvider interface {
+	// Submit submits the payout and returns its reference in the provider. It must be idempotent
+	// by the payout id as a payout is submitted again if saving its submission fails.
+	Submit(ctx context.Context, p *account.Payout) (string, error)
+	// Status returns the status of the submitted payout: account.PayoutStatusSubmitted while it is
+	// in progress, account.PayoutStatusSettled or account.PayoutStatusFailed with the failure reason.
+	Status(ctx context.Context, p *account.Payout) (status, reason string, err error)
+}
+
+// PayoutWorkerConfig is a payout worker configuration.
+type PayoutWorkerConfig struct {
+	Logger   log.Logger
+	Storage  storage.TransactionalStorage
+	Provider PayoutProvider
+	// Interval is the period of submitting the requested payouts and checking the submitted ones.
+	Interval time.Duration
+	// BatchSize limits the payouts handled in every status at once.
+	BatchSize int
+	// MetricPrefix enables the payment metrics of the settled withdrawals, shared with the service.
+	MetricPrefix string
+}
+
+// PayoutWorker drives the payouts through the provider: it submits the requested payouts and settles
+// the withdrawals of the submitted ones when the provider settles or fails them. A failed payout
+// fails its withdrawal returning the withdrawn amount and the fee to the account.
+type PayoutWorker struct {
+	svc       *serviceImpl
+	provider  PayoutProvider
+	interval  time.Duration
+	batchSize int
+	payments  *paymentMetrics
+}
+
+// NewPayoutWorker creates a new payout worker.
+func NewPayoutWorker(cfg PayoutWorkerConfig) (*PayoutWorker, error) {
+	if cfg.Provider == nil {
+		return nil, errors.New("must provide Provider")
+	}
+	if cfg.Interval <= 0 {
+		return nil, errors.New("Interval must be positive")
+	}
+	if cfg.BatchSize <= 0 {
+		return nil, errors.New("BatchSize must be positive")
+	}
+
+	var payments *paymentMetrics
+	if cfg.MetricPrefix != "" {
+		payments = newPaymentMetrics(cfg.MetricPrefix)
+	}
+
+	return &PayoutWorker{
+		svc: &serviceImpl{
+			logger:  cfg.Logger,
+			storage: cfg.Storage,
+			now:     time.Now,
+		},
+		provider:  cfg.Provider,
+		interval:  cfg.Interval,
+		batchSize: cfg.BatchSize,
+		payments:  payments,
+	}, nil
+}
+
+// Run drives the payouts until the context is canceled.
+func (w *PayoutWorker) Run(ctx context.Context) error {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		w.runOnce(ctx)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *PayoutWorker) runOnce(ctx context.Context) {
+	w.submit(ctx)
+	w.check(ctx)
+}
+
+// submit submits the requested payouts, the ones failed to submit are retried on the next run.
+func (w *PayoutWorker) submit(ctx context.Context) {
+	payouts, err := w.svc.storage.GetPayouts(ctx, account.PayoutStatusRequested, w.batchSize)
+	if err != nil {
+		w.logError(ctx, "failed to get requested payouts", err)
+		return
+	}
+
+	for _, p := range payouts {
+		ref, err := w.provider.Submit(ctx, p)
+		if err != nil {
+			w.logError(ctx, "failed to submit payout", err, "payout_id", p.ID)
+			continue
+		}
+		err = p.Submit(ref, w.svc.now())
+		if err == nil {
+			err = w.svc.storage.UpdatePayout(ctx, p, account.PayoutStatusRequested)
+		}
+		if err != nil {
+			w.logError(ctx, "failed to save payout submission", err, "payout_id", p.ID)
+		}
+	}
+}
+
+// check settles the withdrawals of the submitted payouts finished by the provider.
+func (w *PayoutWorker) check(ctx context.Context) {
+	payouts, err := w.svc.storage.GetPayouts(ctx, account.PayoutStatusSubmitted, w.batchSize)
+	if err != nil {
+		w.logError(ctx, "failed to get submitted payouts", err)
+		return
+	}
+
+	for _, p := range payouts {
+		status, reason, err := w.provider.Status(ctx, p)
+		if err != nil {
+			w.logError(ctx, "failed to get payout status", err, "payout_id", p.ID)
+			continue
+		}
+
+		var finish func(*account.Payout, time.Time) error
+		switch status {
+		case account.PayoutStatusSubmitted:
+			continue
+		case account.PayoutStatusSettled:
+			finish = func(p *account.Payout, at time.Time) error { return p.Settle(at) }
+		case account.PayoutStatusFailed:
+			finish = func(p *account.Payout, at time.Time) error { return p.Fail(reason, at) }
+		default:
+			w.logError(ctx, "unknown payout status", fmt.Errorf("status %q", status), "payout_id", p.ID)
+			continue
+		}
+
+		payment, err := w.svc.finishPayout(ctx, p.PaymentID, finish)
+		if err != nil {
+			w.logError(ctx, "failed to finish payout", err, "payout_id", p.ID)
+			continue
+		}
+		if w.payments != nil {
+			w.payments.record(payment, nil)
+		}
+		level.Info(w.svc.logger).Log("component", "payout", "msg", "payout is finished", "payout_id", p.ID, "status", status)
+	}
+}
+
+func (w *PayoutWorker) logError(ctx context.Context, msg string, err error, keyvals ...interface{}) {
+	if ctx.Err() != nil {
+		return
+	}
+	keyvals = append([]interface{}{"component", "payout", "msg", msg, "err", err}, keyvals...)
+	level.Error(w.svc.logger).Log(keyvals...)
+}
+
+// finishPayout gives the submitted payout of the withdrawal its final status with the finish function and
+// settles the withdrawal: a settled payout completes it, a failed one fails it refunding the account.
+func (s *serviceImpl) finishPayout(ctx context.Context, paymentID int64, finish func(*account.Payout, time.Time) error) (*account.Payment, error) {
+	var payment *account.Payment
+	txFn := func(ctx context.Context, storage storage.Storage) error {
+		p, err := storage.GetPayment(ctx, paymentID)
+		if err != nil {
+			return fmt.Errorf("failed to get payment: %w", err)
+		}
+		payout, err := storage.GetPayoutByPaymentID(ctx, paymentID)
+		if err != nil {
+			return fmt.Errorf("failed to get payout: %w", err)
+		}
+
+		from := payout.Status
+		err = finish(payout, s.now())
+		if err != nil {
+			return fmt.Errorf("failed to finish payout %d: %w", payout.ID, err)
+		}
+		if payout.Status == account.PayoutStatusSettled {
+			err = p.Confirm(s.now())
+		} else {
+			err = p.Fail(s.now())
+		}
+		if err != nil {
+			return fmt.Errorf("failed to settle payment %d: %w", paymentID, err)
+		}
+
+		err = storage.UpdatePayout(ctx, payout, from)
+		if err != nil {
+			return fmt.Errorf("failed to update payout: %w", err)
+		}
+		err = s.settleAccounts(ctx, storage, p)
+		if err != nil {
+			return err
+		}
+
+		payment = p
+		return nil
+	}
+
+	err := s.storage.ExecTx(ctx, txFn)
+	if err != nil {
+		return nil, err
+	}
+
+	return payment, nil
+}
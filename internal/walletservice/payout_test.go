@@ -0,0 +1,148 @@
+package walletservice
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/shkov/wallet-service/internal/account"
+	"github.com/shkov/wallet-service/internal/audit"
+	"github.com/shkov/wallet-service/internal/payout"
+	"github.com/shkov/wallet-service/internal/storage"
+)
+
+func TestPayoutWorker_RunOnce(t *testing.T) {
+	now := parseTime(t, "2001-01-03T10:00:00Z")
+	withdrawal := func(id int64, amount string) *account.Payment {
+		return makePayment(t, func(p *account.Payment) {
+			p.ID = id
+			p.To = account.SystemAccountPayouts
+			p.Amount = amount
+			p.Type = account.PaymentTypeWithdrawal
+			p.Status = account.PaymentStatusPending
+			p.SettledAt = nil
+		})
+	}
+
+	payments := map[int64]*account.Payment{
+		1: withdrawal(1, "100"),
+		2: withdrawal(2, "200"),
+		3: withdrawal(3, "13"),
+	}
+	// the payouts are stored by their payment ids.
+	payouts := map[int64]*account.Payout{
+		1: {ID: 1, PaymentID: 1, AccountID: 1, Amount: "100", Status: account.PayoutStatusRequested},
+		2: {ID: 2, PaymentID: 2, AccountID: 1, Amount: "200", Status: account.PayoutStatusSubmitted, ProviderRef: "fake-2"},
+		3: {ID: 3, PaymentID: 3, AccountID: 1, Amount: "13", Status: account.PayoutStatusSubmitted, ProviderRef: "fake-3"},
+	}
+	var balances []string
+	var actions []string
+
+	mock := &storageMock{
+		onGetPayouts: func(ctx context.Context, status string, limit int) ([]*account.Payout, error) {
+			var out []*account.Payout
+			for _, p := range payouts {
+				if p.Status == status {
+					cp := *p
+					out = append(out, &cp)
+				}
+			}
+			sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+			return out, nil
+		},
+		onGetPayout: func(ctx context.Context, paymentID int64) (*account.Payout, error) {
+			cp := *payouts[paymentID]
+			return &cp, nil
+		},
+		onUpdatePayout: func(ctx context.Context, p *account.Payout, from string) error {
+			if payouts[p.PaymentID].Status != from {
+				return account.ErrUnexpectedPayoutStatus
+			}
+			cp := *p
+			payouts[p.PaymentID] = &cp
+			return nil
+		},
+		onGetPayment: func(ctx context.Context, id int64) (*account.Payment, error) {
+			return payments[id], nil
+		},
+		onGetAccounts: func(ctx context.Context, ids []int64) ([]*account.Account, error) {
+			if ids[0] == 1 {
+				return []*account.Account{makeAccount(t, nil)}, nil
+			}
+			// the payouts system account is created on the first settled payout.
+			return nil, nil
+		},
+		onReplaceAccounts: func(ctx context.Context, aa []*account.Account) error {
+			for _, a := range aa {
+				balances = append(balances, a.Balance)
+			}
+			return nil
+		},
+		onUpdateStatus: func(ctx context.Context, p *account.Payment) error {
+			return nil
+		},
+		onAppendAudit: func(ctx context.Context, entries []*audit.Entry) error {
+			for _, e := range entries {
+				assert.Equal(t, systemActor, e.Actor)
+				actions = append(actions, e.Action)
+			}
+			return nil
+		},
+	}
+	mock.onExecTx = func(ctx context.Context, fn func(context.Context, storage.Storage) error) error {
+		return fn(ctx, mock)
+	}
+
+	provider, err := payout.NewFakeProvider("13")
+	require.NoError(t, err)
+	w, err := NewPayoutWorker(PayoutWorkerConfig{
+		Logger:    log.NewNopLogger(),
+		Storage:   mock,
+		Provider:  provider,
+		Interval:  time.Minute,
+		BatchSize: 10,
+	})
+	require.NoError(t, err)
+	w.svc.now = func() time.Time { return now }
+
+	w.runOnce(context.Background())
+
+	// the requested payout is submitted and settled by the provider in the same run.
+	assert.Equal(t, account.PayoutStatusSettled, payouts[1].Status)
+	assert.Equal(t, "fake-1", payouts[1].ProviderRef)
+	assert.Equal(t, account.PayoutStatusSettled, payouts[2].Status)
+	assert.Equal(t, account.PayoutStatusFailed, payouts[3].Status)
+	assert.NotEmpty(t, payouts[3].FailureReason)
+	assert.Equal(t, now, payouts[3].UpdatedAt)
+
+	assert.Equal(t, account.PaymentStatusCompleted, payments[1].Status)
+	assert.Equal(t, account.PaymentStatusCompleted, payments[2].Status)
+	assert.Equal(t, account.PaymentStatusFailed, payments[3].Status)
+	assert.Equal(t, &now, payments[3].SettledAt)
+
+	// the settled payouts credit the payouts system account, the failed one refunds the account.
+	assert.Equal(t, []string{"100.00", "200.00", "1013.00"}, balances)
+	assert.Equal(t, []string{audit.ActionPayment, audit.ActionPayment, audit.ActionRefund}, actions)
+
+	// nothing is left to do.
+	balances = nil
+	w.runOnce(context.Background())
+	assert.Nil(t, balances)
+}
+
+func TestNewPayoutWorker(t *testing.T) {
+	provider, err := payout.NewFakeProvider("")
+	require.NoError(t, err)
+
+	_, err = NewPayoutWorker(PayoutWorkerConfig{Interval: time.Minute, BatchSize: 10})
+	assert.Error(t, err)
+	_, err = NewPayoutWorker(PayoutWorkerConfig{Provider: provider, BatchSize: 10})
+	assert.Error(t, err)
+	_, err = NewPayoutWorker(PayoutWorkerConfig{Provider: provider, Interval: time.Minute})
+	assert.Error(t, err)
+}
@@ -0,0 +1,154 @@
+package walletservice
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/go-kit/kit/endpoint"
+
+	"github.com/shkov/wallet-service/internal/auth"
+	"github.com/shkov/wallet-service/internal/ratelimit"
+)
+
+// RateLimitConfig is a per-client rate limiting configuration.
+type RateLimitConfig struct {
+	// Default is applied to the routes not listed in Routes. Zero value disables rate limiting.
+	Default ratelimit.Rule
+	// Routes overrides the default rule by route name, e.g. "ApplyPayment".
+	Routes map[string]ratelimit.Rule
+	// Unauthenticated limits the requests failing to authenticate by client IP. Zero value disables it.
+	Unauthenticated ratelimit.Rule
+}
+
+// rateLimiter holds a limiter per route for the authenticated principals and a limiter of
+// the unauthenticated requests by client IP.
+type rateLimiter struct {
+	defaultLimiter *ratelimit.Limiter
+	routeLimiters  map[string]*ratelimit.Limiter
+	ipLimiter      *ratelimit.Limiter
+}
+
+func newRateLimiter(cfg RateLimitConfig) *rateLimiter {
+	rl := &rateLimiter{
+		routeLimiters: make(map[string]*ratelimit.Limiter, len(cfg.Routes)),
+	}
+	if cfg.Default.Limit > 0 {
+		rl.defaultLimiter = ratelimit.NewLimiter(cfg.Default)
+	}
+	for route, rule := range cfg.Routes {
+		rl.routeLimiters[route] = ratelimit.NewLimiter(rule)
+	}
+	if cfg.Unauthenticated.Limit > 0 {
+		rl.ipLimiter = ratelimit.NewLimiter(cfg.Unauthenticated)
+	}
+	return rl
+}
+
+// limiter returns the limiter of the route, nil if the route is not limited.
+func (rl *rateLimiter) limiter(route string) *ratelimit.Limiter {
+	if l, ok := rl.routeLimiters[route]; ok {
+		return l
+	}
+	return rl.defaultLimiter
+}
+
+// httpMiddleware limits the unauthenticated requests by client IP, so random credentials don't get their own
+// limits. A client IP out of its limit is rejected before the credentials are checked, the request is charged
+// to it only after it fails to authenticate, so the authenticated requests are limited only by their principals.
+func (rl *rateLimiter) httpMiddleware(next http.Handler) http.Handler {
+	if rl.ipLimiter == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := httpClientKey(r)
+		if res := rl.ipLimiter.Peek(key); !res.Allowed {
+			encodeError(r.Context(), &ratelimit.Error{Result: res}, w)
+			return
+		}
+
+		state := &rateLimitState{}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), rateLimitStateKey{}, state)))
+		if !state.authenticated {
+			rl.ipLimiter.Allow(key)
+		}
+	})
+}
+
+// endpointMiddleware applies the limits of the route to the authenticated principal, so transports other
+// than HTTP share the same limits. It must be chained after the auth middleware. The request is reported
+// as authenticated and the result to the rate limit headers of the HTTP response when the context carries
+// the rate limit state.
+func (rl *rateLimiter) endpointMiddleware(route string) endpoint.Middleware {
+	l := rl.limiter(route)
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			p, ok := auth.FromContext(ctx)
+			if !ok {
+				return nil, errUnauthorized("%v", auth.ErrNoCredentials)
+			}
+			state, _ := ctx.Value(rateLimitStateKey{}).(*rateLimitState)
+			if state != nil {
+				state.authenticated = true
+			}
+			if l == nil {
+				return next(ctx, request)
+			}
+
+			res := l.Allow("subject:" + p.Subject)
+			if !res.Allowed {
+				return nil, &ratelimit.Error{Result: res}
+			}
+			if state != nil {
+				state.result = res
+			}
+			return next(ctx, request)
+		}
+	}
+}
+
+// rateLimitState is shared by the rate limit middlewares of a request.
+type rateLimitState struct {
+	// authenticated is set when the request reaches the limits of its principal.
+	authenticated bool
+	// result is the result of the limit of the principal.
+	result ratelimit.Result
+}
+
+type rateLimitStateKey struct{}
+
+// rateLimitStateToContext puts the rate limit state to the context unless the http middleware has put it.
+func rateLimitStateToContext(ctx context.Context, r *http.Request) context.Context {
+	if _, ok := ctx.Value(rateLimitStateKey{}).(*rateLimitState); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, rateLimitStateKey{}, &rateLimitState{})
+}
+
+// setRateLimitResultHeaders sets the rate limit headers of the principal.
+func setRateLimitResultHeaders(ctx context.Context, w http.ResponseWriter) context.Context {
+	if state, ok := ctx.Value(rateLimitStateKey{}).(*rateLimitState); ok && state.result.Limit > 0 {
+		setRateLimitHeaders(w.Header(), state.result)
+	}
+	return ctx
+}
+
+// httpClientKey returns the client IP of the request.
+func httpClientKey(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+func setRateLimitHeaders(h http.Header, res ratelimit.Result) {
+	h.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	h.Set("RateLimit-Reset", strconv.FormatInt(int64(math.Ceil(res.Reset.Seconds())), 10))
+	if !res.Allowed {
+		h.Set("Retry-After", strconv.FormatInt(int64(math.Ceil(res.RetryAfter.Seconds())), 10))
+	}
+}
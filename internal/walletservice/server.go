@@ -2,17 +2,19 @@ package walletservice
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
-	"net/http/pprof"
 	"time"
 
 	"github.com/go-kit/kit/endpoint"
 	"github.com/go-kit/kit/log"
 	kithttp "github.com/go-kit/kit/transport/http"
 	"github.com/gorilla/mux"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 
+	"github.com/shkov/wallet-service/internal/account"
+	"github.com/shkov/wallet-service/internal/auth"
+	"github.com/shkov/wallet-service/internal/health"
 	"github.com/shkov/wallet-service/internal/storage"
 )
 
@@ -24,27 +26,58 @@ type ServerConfig struct {
 	ReadTimeout     time.Duration
 	WriteTimeout    time.Duration
 	ShutdownTimeout time.Duration
-	MetricPrefix    string
+	// DrainDelay is how long the server keeps serving with failing readiness before shutting down,
+	// so that load balancers stop routing requests to it.
+	DrainDelay   time.Duration
+	MetricPrefix string
+	// BalanceMetricsTTL is how long the total balances are cached for the scrapes.
+	BalanceMetricsTTL time.Duration
+	// Health decides the readiness of the service, it is shared with the admin server.
+	Health    *health.Registry
+	JWTSecret string
+	RateLimit RateLimitConfig
+	// Risk screens the payments before they are applied, nil allows all of them.
+	Risk RiskEngine
+	// BalancePolicies bound the balances of the accounts of the types.
+	BalancePolicies map[string]*account.BalancePolicy
+	// Payouts enables the withdrawals, they must be paid out by a running payout worker.
+	Payouts bool
 }
 
 // Server is a wallet-service server.
 type Server struct {
-	cfg *ServerConfig
-	srv *http.Server
+	cfg    *ServerConfig
+	srv    *http.Server
+	health *health.Registry
 }
 
 // NewServer creates a new server.
 func NewServer(cfg ServerConfig) (*Server, error) {
+	if cfg.Health == nil {
+		return nil, errors.New("must provide Health")
+	}
+
+	var riskEngine RiskEngine
+	if cfg.Risk != nil {
+		riskEngine = newInstrumentingRiskEngine(cfg.Risk, cfg.MetricPrefix)
+	}
+
 	var svc Service
-	svc = newService(cfg.Logger, cfg.Storage)
+	svc = newService(cfg.Logger, cfg.Storage, riskEngine, cfg.BalancePolicies, cfg.Payouts)
 	svc = NewLoggingMiddleware(svc, cfg.Logger)
 	svc = NewInstrumentingMiddleware(svc, cfg.MetricPrefix)
+	svc = NewTracingMiddleware(svc)
+
+	registerCollector(newBalanceCollector(cfg.Storage, cfg.MetricPrefix, cfg.ReadTimeout, cfg.BalanceMetricsTTL))
+
+	authenticator := auth.NewAuthenticator(cfg.Storage, []byte(cfg.JWTSecret))
+
+	limiter := newRateLimiter(cfg.RateLimit)
+	apiHandler := makeHandler(svc, authenticator, limiter)
+	apiHandler.Use(limiter.httpMiddleware)
 
 	router := http.NewServeMux()
-	router.Handle("/metrics", promhttp.Handler())
-	router.Handle("/debug/pprof/profile", http.HandlerFunc(pprof.Profile))
-	router.Handle("/debug/pprof/heap", pprof.Handler("heap"))
-	router.Handle("/api/v1/", makeHandler(svc))
+	router.Handle("/api/v1/", newAccessLogHandler(apiHandler, cfg.Logger))
 
 	srv := &http.Server{
 		Handler:      router,
@@ -54,17 +87,28 @@ func NewServer(cfg ServerConfig) (*Server, error) {
 	}
 
 	s := &Server{
-		cfg: &cfg,
-		srv: srv,
+		cfg:    &cfg,
+		srv:    srv,
+		health: cfg.Health,
 	}
 	return s, nil
 }
 
 // Serve starts HTTP server and stops it when the provided context is canceled.
+// On cancellation readiness starts failing, and the server is shut down after DrainDelay.
 func (s *Server) Serve(ctx context.Context) error {
+	return serve(ctx, s.srv, s.cfg.ShutdownTimeout, func() {
+		s.health.SetDraining()
+		time.Sleep(s.cfg.DrainDelay)
+	})
+}
+
+// serve starts srv and shuts it down when the provided context is canceled,
+// beforeShutdown is called before the shutdown.
+func serve(ctx context.Context, srv *http.Server, shutdownTimeout time.Duration, beforeShutdown func()) error {
 	errChan := make(chan error, 1)
 	go func() {
-		errChan <- s.srv.ListenAndServe()
+		errChan <- srv.ListenAndServe()
 	}()
 
 	select {
@@ -72,50 +116,191 @@ func (s *Server) Serve(ctx context.Context) error {
 		return err
 
 	case <-ctx.Done():
-		ctxShutdown, cancel := context.WithTimeout(context.Background(), s.cfg.ShutdownTimeout)
+		beforeShutdown()
+
+		ctxShutdown, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
 		defer cancel()
-		if err := s.srv.Shutdown(ctxShutdown); err != nil {
+		if err := srv.Shutdown(ctxShutdown); err != nil {
 			return fmt.Errorf("failed to shutdown server: %w", err)
 		}
 		return nil
 	}
 }
 
-func makeHandler(svc Service) http.Handler {
+func makeHandler(svc Service, authenticator auth.Authenticator, limiter *rateLimiter) *mux.Router {
 	opts := []kithttp.ServerOption{
+		kithttp.ServerBefore(startHTTPSpan),
+		kithttp.ServerFinalizer(finishHTTPSpan),
+		kithttp.ServerBefore(auth.HTTPToContext),
+		kithttp.ServerBefore(rateLimitStateToContext),
+		kithttp.ServerAfter(setRateLimitResultHeaders),
 		kithttp.ServerErrorEncoder(encodeError),
 	}
 
+	// authenticate authenticates the caller requiring the scope and applies the limits of the route to it.
+	authenticate := func(route, scope string) endpoint.Middleware {
+		return endpoint.Chain(newAuthMiddleware(authenticator, scope), limiter.endpointMiddleware(route))
+	}
+
 	router := mux.NewRouter()
 
-	router.Path("/api/v1/accounts/{id}").Methods(http.MethodGet).Handler(kithttp.NewServer(
-		makeGetAccountEndpoint(svc),
+	router.Path("/api/v1/accounts").Methods(http.MethodGet).Name("ListAccounts").Handler(kithttp.NewServer(
+		endpoint.Chain(
+			authenticate("ListAccounts", auth.ScopeAccountsRead),
+			newOwnedAccountsMiddleware(func(request interface{}) *account.ListRequest {
+				return request.(listAccountsRequest).listRequest
+			}),
+		)(makeListAccountsEndpoint(svc)),
+		decodeListAccountsRequest,
+		encodeListAccountsResponse,
+		opts...,
+	))
+
+	router.Path("/api/v1/accounts/{id}").Methods(http.MethodGet).Name("GetAccount").Handler(kithttp.NewServer(
+		endpoint.Chain(
+			authenticate("GetAccount", auth.ScopeAccountsRead),
+			newOwnerMiddleware(func(request interface{}) int64 {
+				return request.(getAccountRequest).id
+			}),
+		)(makeGetAccountEndpoint(svc)),
 		decodeGetAccountRequest,
 		encodeGetAccountResponse,
 		opts...,
 	))
 
-	router.Path("/api/v1/payments/{account_id}").Methods(http.MethodGet).Handler(kithttp.NewServer(
-		makeGetPaymentsEndpoint(svc),
+	router.Path("/api/v1/accounts/{id}").Methods(http.MethodPatch).Name("UpdateAccount").Handler(kithttp.NewServer(
+		endpoint.Chain(
+			authenticate("UpdateAccount", auth.ScopeAccountsWrite),
+			newOwnerMiddleware(func(request interface{}) int64 {
+				return request.(updateAccountRequest).updateRequest.ID
+			}),
+			newConditionalScopeMiddleware(auth.ScopeAccountTypes, func(request interface{}) bool {
+				return request.(updateAccountRequest).updateRequest.Type != nil
+			}),
+		)(makeUpdateAccountEndpoint(svc)),
+		decodeUpdateAccountRequest,
+		encodeGetAccountResponse,
+		opts...,
+	))
+
+	router.Path("/api/v1/accounts/{id}/statement").Methods(http.MethodGet).Name("GetStatement").Handler(kithttp.NewServer(
+		endpoint.Chain(
+			authenticate("GetStatement", auth.ScopePaymentsRead),
+			newOwnerMiddleware(func(request interface{}) int64 {
+				return request.(getStatementRequest).statementRequest.AccountID
+			}),
+		)(makeGetStatementEndpoint(svc)),
+		decodeGetStatementRequest,
+		encodeGetStatementResponse,
+		opts...,
+	))
+
+	router.Path("/api/v1/payments/{account_id}").Methods(http.MethodGet).Name("GetPayments").Handler(kithttp.NewServer(
+		endpoint.Chain(
+			authenticate("GetPayments", auth.ScopePaymentsRead),
+			newOwnerMiddleware(func(request interface{}) int64 {
+				return request.(getPaymentsRequest).filter.AccountID
+			}),
+		)(makeGetPaymentsEndpoint(svc)),
 		decodeGetPaymentsRequest,
 		encodeGetPaymentsResponse,
 		opts...,
 	))
 
-	router.Path("/api/v1/payments").Methods(http.MethodPost).Handler(kithttp.NewServer(
-		makeApplyPaymentEndpoint(svc),
+	router.Path("/api/v1/payments").Methods(http.MethodPost).Name("ApplyPayment").Handler(kithttp.NewServer(
+		endpoint.Chain(
+			authenticate("ApplyPayment", auth.ScopePaymentsWrite),
+			newOwnerMiddleware(func(request interface{}) int64 {
+				return request.(applyPaymentRequest).paymentRequest.From
+			}),
+		)(makeApplyPaymentEndpoint(svc)),
 		decodeApplyPaymentRequest,
 		encodeApplyPaymentResponse,
 		opts...,
 	))
 
+	router.Path("/api/v1/payments/{id}/{action:confirm|cancel}").Methods(http.MethodPost).Name("SettlePayment").Handler(kithttp.NewServer(
+		authenticate("SettlePayment", auth.ScopePaymentsReview)(makeSettlePaymentEndpoint(svc)),
+		decodeSettlePaymentRequest,
+		encodeApplyPaymentResponse,
+		opts...,
+	))
+
+	router.Path("/api/v1/deposits").Methods(http.MethodPost).Name("Deposit").Handler(kithttp.NewServer(
+		authenticate("Deposit", auth.ScopeDeposits)(makeDepositEndpoint(svc)),
+		decodeFundsRequest,
+		encodeApplyPaymentResponse,
+		opts...,
+	))
+
+	router.Path("/api/v1/withdrawals").Methods(http.MethodPost).Name("Withdraw").Handler(kithttp.NewServer(
+		endpoint.Chain(
+			authenticate("Withdraw", auth.ScopePaymentsWrite),
+			newOwnerMiddleware(func(request interface{}) int64 {
+				return request.(fundsRequest).fundsRequest.AccountID
+			}),
+		)(makeWithdrawEndpoint(svc)),
+		decodeFundsRequest,
+		encodeApplyPaymentResponse,
+		opts...,
+	))
+
+	router.Path("/api/v1/admin/accounts/{account_id}/adjustments").Methods(http.MethodPost).Name("ProposeAdjustment").Handler(kithttp.NewServer(
+		authenticate("ProposeAdjustment", auth.ScopeAdjustments)(makeProposeAdjustmentEndpoint(svc)),
+		decodeProposeAdjustmentRequest,
+		encodeAdjustmentResponse,
+		opts...,
+	))
+
+	router.Path("/api/v1/admin/accounts/{account_id}/adjustments").Methods(http.MethodGet).Name("GetAdjustments").Handler(kithttp.NewServer(
+		authenticate("GetAdjustments", auth.ScopeAdjustments)(makeGetAdjustmentsEndpoint(svc)),
+		decodeGetAdjustmentsRequest,
+		encodeGetAdjustmentsResponse,
+		opts...,
+	))
+
+	router.Path("/api/v1/admin/adjustments/{id}/{action:approve|reject}").Methods(http.MethodPost).Name("ReviewAdjustment").Handler(kithttp.NewServer(
+		authenticate("ReviewAdjustment", auth.ScopeAdjustments)(makeReviewAdjustmentEndpoint(svc)),
+		decodeReviewAdjustmentRequest,
+		encodeAdjustmentResponse,
+		opts...,
+	))
+
 	return router
 }
 
 func makeGetAccountEndpoint(svc Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(getAccountRequest)
-		resp, err := svc.GetAccount(ctx, req.id)
+		var resp *account.Account
+		var err error
+		if req.asOf.IsZero() {
+			resp, err = svc.GetAccount(ctx, req.id)
+		} else {
+			resp, err = svc.GetAccountBalanceAt(ctx, req.id, req.asOf)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return getAccountResponse{account: resp, past: !req.asOf.IsZero()}, nil
+	}
+}
+
+func makeListAccountsEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(listAccountsRequest)
+		resp, err := svc.ListAccounts(ctx, req.listRequest)
+		if err != nil {
+			return nil, err
+		}
+		return listAccountsResponse{page: resp}, nil
+	}
+}
+
+func makeUpdateAccountEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(updateAccountRequest)
+		resp, err := svc.UpdateAccount(ctx, req.updateRequest)
 		if err != nil {
 			return nil, err
 		}
@@ -126,7 +311,7 @@ func makeGetAccountEndpoint(svc Service) endpoint.Endpoint {
 func makeGetPaymentsEndpoint(svc Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(getPaymentsRequest)
-		resp, err := svc.GetPayments(ctx, req.accountID)
+		resp, err := svc.GetPayments(ctx, req.filter)
 		if err != nil {
 			return nil, err
 		}
@@ -134,6 +319,18 @@ func makeGetPaymentsEndpoint(svc Service) endpoint.Endpoint {
 	}
 }
 
+func makeGetStatementEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(getStatementRequest)
+		return getStatementResponse{
+			format: req.format,
+			write: func(w account.StatementWriter) error {
+				return svc.GetStatement(ctx, req.statementRequest, w)
+			},
+		}, nil
+	}
+}
+
 func makeApplyPaymentEndpoint(svc Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(applyPaymentRequest)
@@ -144,3 +341,81 @@ func makeApplyPaymentEndpoint(svc Service) endpoint.Endpoint {
 		return applyPaymentResponse{payment: resp}, nil
 	}
 }
+
+func makeSettlePaymentEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(settlePaymentRequest)
+		var resp *account.Payment
+		var err error
+		if req.action == settleActionConfirm {
+			resp, err = svc.ConfirmPayment(ctx, req.id)
+		} else {
+			resp, err = svc.CancelPayment(ctx, req.id)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return applyPaymentResponse{payment: resp}, nil
+	}
+}
+
+func makeDepositEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(fundsRequest)
+		resp, err := svc.Deposit(ctx, req.fundsRequest)
+		if err != nil {
+			return nil, err
+		}
+		return applyPaymentResponse{payment: resp}, nil
+	}
+}
+
+func makeWithdrawEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(fundsRequest)
+		resp, err := svc.Withdraw(ctx, req.fundsRequest)
+		if err != nil {
+			return nil, err
+		}
+		return applyPaymentResponse{payment: resp}, nil
+	}
+}
+
+func makeProposeAdjustmentEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(proposeAdjustmentRequest)
+		resp, err := svc.ProposeAdjustment(ctx, req.adjustmentRequest)
+		if err != nil {
+			return nil, err
+		}
+		return adjustmentResponse{adjustment: resp}, nil
+	}
+}
+
+func makeReviewAdjustmentEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(reviewAdjustmentRequest)
+		var resp *account.Adjustment
+		var err error
+		if req.action == reviewActionApprove {
+			resp, err = svc.ApproveAdjustment(ctx, req.id)
+		} else {
+			resp, err = svc.RejectAdjustment(ctx, req.id)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return adjustmentResponse{adjustment: resp}, nil
+	}
+}
+
+func makeGetAdjustmentsEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(getAdjustmentsRequest)
+		resp, err := svc.GetAdjustments(ctx, req.accountID)
+		if err != nil {
+			return nil, err
+		}
+		return getAdjustmentsResponse{adjustments: resp}, nil
+	}
+}
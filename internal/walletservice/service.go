@@ -2,33 +2,72 @@ package walletservice
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"reflect"
+	"sort"
 	"time"
 
 	"github.com/go-kit/kit/log"
+	"github.com/shopspring/decimal"
 
 	"github.com/shkov/wallet-service/internal/account"
+	"github.com/shkov/wallet-service/internal/audit"
+	"github.com/shkov/wallet-service/internal/auth"
+	"github.com/shkov/wallet-service/internal/requestid"
+	"github.com/shkov/wallet-service/internal/risk"
 	"github.com/shkov/wallet-service/internal/storage"
 )
 
 // Service provides wallet-service functionality.
 type Service interface {
 	ApplyPayment(ctx context.Context, p *account.PaymentRequest) (*account.Payment, error)
-	GetPayments(ctx context.Context, accountID int64) ([]*account.Payment, error)
+	GetPayments(ctx context.Context, f *account.PaymentFilter) ([]*account.Payment, error)
 	GetAccount(ctx context.Context, id int64) (*account.Account, error)
+	GetAccountBalanceAt(ctx context.Context, id int64, at time.Time) (*account.Account, error)
+	ListAccounts(ctx context.Context, r *account.ListRequest) (*account.Page, error)
+	UpdateAccount(ctx context.Context, r *account.UpdateRequest) (*account.Account, error)
+	ConfirmPayment(ctx context.Context, id int64) (*account.Payment, error)
+	CancelPayment(ctx context.Context, id int64) (*account.Payment, error)
+	GetStatement(ctx context.Context, r *account.StatementRequest, w account.StatementWriter) error
+	Deposit(ctx context.Context, r *account.FundsRequest) (*account.Payment, error)
+	Withdraw(ctx context.Context, r *account.FundsRequest) (*account.Payment, error)
+	ProposeAdjustment(ctx context.Context, r *account.AdjustmentRequest) (*account.Adjustment, error)
+	ApproveAdjustment(ctx context.Context, id int64) (*account.Adjustment, error)
+	RejectAdjustment(ctx context.Context, id int64) (*account.Adjustment, error)
+	GetAdjustments(ctx context.Context, accountID int64) ([]*account.Adjustment, error)
 }
 
+// RiskEngine screens the payments before they are applied, the payment history is read from the given storage
+// so that it sees the payments of the same transaction.
+type RiskEngine interface {
+	Evaluate(ctx context.Context, s risk.Storage, r *risk.Request) (*risk.Assessment, error)
+}
+
+// systemActor is the audit actor of the changes made without an authenticated caller.
+const systemActor = "system"
+
 type serviceImpl struct {
 	logger  log.Logger
 	storage storage.TransactionalStorage
+	// risk screens the payments, nil allows all of them.
+	risk RiskEngine
+	// policies are the balance policies of the account types.
+	policies map[string]*account.BalancePolicy
+	// payouts tells whether the withdrawals are paid out, they are rejected otherwise.
+	payouts bool
 
 	now func() time.Time
 }
 
-func newService(logger log.Logger, storage storage.TransactionalStorage) Service {
+func newService(logger log.Logger, storage storage.TransactionalStorage, riskEngine RiskEngine,
+	policies map[string]*account.BalancePolicy, payouts bool) Service {
 	return &serviceImpl{
-		logger:  logger,
-		storage: storage,
+		logger:   logger,
+		storage:  storage,
+		risk:     riskEngine,
+		policies: policies,
+		payouts:  payouts,
 		now: func() time.Time {
 			return time.Now()
 		},
@@ -39,86 +78,380 @@ func newService(logger log.Logger, storage storage.TransactionalStorage) Service
 func (s *serviceImpl) ApplyPayment(ctx context.Context, r *account.PaymentRequest) (*account.Payment, error) {
 	err := account.ValidatePaymentRequest(r)
 	if err != nil {
-		return nil, errBadRequest("payment is invalid: %v", err)
+		return nil, errBadRequest("payment is invalid: %w", err)
+	}
+
+	return s.applyPayment(ctx, r, r.ToPayment(s.now()), nil)
+}
+
+// Deposit credits the account with the money deposited from the outside, paid from the funding system account.
+func (s *serviceImpl) Deposit(ctx context.Context, r *account.FundsRequest) (*account.Payment, error) {
+	err := account.ValidateDepositRequest(r)
+	if err != nil {
+		return nil, errBadRequest("deposit is invalid: %w", err)
+	}
+
+	pr := r.ToDepositRequest()
+	payment := pr.ToPayment(s.now())
+	payment.Type = account.PaymentTypeDeposit
+	return s.applyPayment(ctx, pr, payment, nil)
+}
+
+// Withdraw debits the account with the money paid out to the payouts system account and its fee.
+// The withdrawal is pending until its payout is settled or failed by the payout provider, so withdrawals
+// are rejected when there is no provider to pay them out.
+func (s *serviceImpl) Withdraw(ctx context.Context, r *account.FundsRequest) (*account.Payment, error) {
+	if !s.payouts {
+		return nil, errNotImplemented("failed to withdraw: %w", account.ErrWithdrawalsDisabled)
+	}
+
+	err := account.ValidateWithdrawalRequest(r)
+	if err != nil {
+		return nil, errBadRequest("withdrawal is invalid: %w", err)
 	}
 
-	payment := r.ToPayment(s.now())
+	pr := r.ToWithdrawalRequest()
+	pr.Pending = true
+	payment := pr.ToPayment(s.now())
+	payment.Type = account.PaymentTypeWithdrawal
+	return s.applyPayment(ctx, pr, payment, s.requestPayout)
+}
+
+// requestPayout creates the payout of the withdrawal, the payout of the withdrawal sent to review waits for it.
+func (s *serviceImpl) requestPayout(ctx context.Context, storage storage.Storage, p *account.Payment) error {
+	if p.Status != account.PaymentStatusPending {
+		return nil
+	}
+	payout := account.NewPayout(p, s.now())
+	if p.RiskDecision == risk.DecisionReview {
+		payout.Status = account.PayoutStatusReview
+	}
+	err := storage.InsertPayout(ctx, payout)
+	if err != nil {
+		return errInternal("failed to insert payout: %v", err)
+	}
+	return nil
+}
 
+// applyPayment applies the payment created by the request to the accounts, onApplied is called
+// in the same transaction after the new payment is saved. A repeated request with the same
+// idempotency key returns the original payment.
+func (s *serviceImpl) applyPayment(ctx context.Context, r *account.PaymentRequest, payment *account.Payment,
+	onApplied func(context.Context, storage.Storage, *account.Payment) error) (*account.Payment, error) {
 	txFn := func(ctx context.Context, storage storage.Storage) error {
-		fromAccount, toAccount, err := s.getAccountsByPayment(ctx, payment)
+		if payment.IdempotencyKey != "" {
+			existing, err := storage.GetPaymentByIdempotencyKey(ctx, payment.From, payment.IdempotencyKey)
+			switch {
+			case err == nil:
+				if !existing.Matches(r) {
+					return errConflict("failed to apply payment: %w", account.ErrIdempotencyKeyReused)
+				}
+				payment = existing
+				return nil
+			case !errors.Is(err, account.ErrPaymentNotFound):
+				return errInternal("failed to get payment by idempotency key: %v", err)
+			}
+		}
+
+		accounts, err := s.getAccountsByPayment(ctx, storage, payment)
 		if err != nil {
 			return errInternal("failed to get accounts: %v", err)
 		}
 
-		err = fromAccount.ApplyPayment(payment)
+		err = s.screenPayment(ctx, storage, payment, accounts[0])
 		if err != nil {
-			return errBadRequest("failed to apply payment to the sender: %v", err)
+			return errInternal("failed to screen payment: %v", err)
 		}
+		denied := payment.RiskDecision == risk.DecisionDeny
 
-		err = toAccount.ApplyPayment(payment)
-		if err != nil {
-			return errBadRequest("failed to apply payment to the receiver: %v", err)
+		balances := make([]string, len(accounts))
+		for i, a := range accounts {
+			balances[i] = a.Balance
+			// the denied payment is recorded without changing the balances.
+			if denied {
+				continue
+			}
+			err = a.ApplyPayment(payment)
+			if err != nil && a.ID == payment.From {
+				return errBadRequest("failed to apply payment to the sender: %w", err)
+			}
+			if err != nil {
+				return errBadRequest("failed to apply payment to the receiver: %w", err)
+			}
 		}
 
-		err = s.storage.ReplaceAccounts(ctx, []*account.Account{fromAccount, toAccount})
+		err = storage.ReplaceAccounts(ctx, accounts)
 		if err != nil {
 			return errInternal("failed to replace accounts: %v", err)
 		}
 
-		err = s.storage.InsertPayment(ctx, payment)
+		err = storage.InsertPayment(ctx, payment)
+		if errors.Is(err, account.ErrExternalRefReused) {
+			return errConflict("failed to apply payment: %w", err)
+		}
+		if errors.Is(err, account.ErrIdempotencyKeyTaken) {
+			return err
+		}
 		if err != nil {
 			return errInternal("failed to insert payment: %v", err)
 		}
 
+		if onApplied != nil {
+			err = onApplied(ctx, storage, payment)
+			if err != nil {
+				return err
+			}
+		}
+
+		entries := make([]*audit.Entry, 0, len(accounts))
+		for i, a := range accounts {
+			// the receivers of a pending payment are not credited yet.
+			if a.Balance == balances[i] {
+				continue
+			}
+			entries = append(entries, s.newAuditEntry(ctx, audit.ActionPayment, a, balances[i], payment.ID))
+		}
+		err = storage.AppendAuditEntries(ctx, entries)
+		if err != nil {
+			return errInternal("failed to append audit entries: %v", err)
+		}
+
 		return nil
 	}
 
-	err = s.storage.ExecTx(ctx, txFn)
+	err := s.storage.ExecTx(ctx, txFn)
+	if errors.Is(err, account.ErrIdempotencyKeyTaken) {
+		// the concurrent request with the same idempotency key inserted its payment first.
+		payment, err = s.storage.GetPaymentByIdempotencyKey(ctx, payment.From, payment.IdempotencyKey)
+		if err != nil {
+			return nil, errInternal("failed to get payment by idempotency key: %v", err)
+		}
+		if !payment.Matches(r) {
+			return nil, errConflict("failed to apply payment: %w", account.ErrIdempotencyKeyReused)
+		}
+	}
 	if err != nil {
 		return nil, err
 	}
 
+	if payment.RiskDecision == risk.DecisionDeny {
+		return nil, errForbidden("failed to apply payment %d: %w", payment.ID, account.ErrPaymentDenied)
+	}
+
 	return payment, nil
 }
 
+// screenPayment records the decision of the risk engine with the payment: a reviewed payment is created
+// pending until the review confirms or cancels it, a denied one fails. The deposits are not screened.
+func (s *serviceImpl) screenPayment(ctx context.Context, storage storage.Storage, p *account.Payment, sender *account.Account) error {
+	if s.risk == nil || account.IsSystemAccount(sender.ID) {
+		return nil
+	}
+
+	assessment, err := s.risk.Evaluate(ctx, storage, &risk.Request{Payment: p, Sender: sender})
+	if err != nil {
+		return err
+	}
+
+	p.RiskDecision, p.RiskRules = assessment.Decision, assessment.Rules
+	switch assessment.Decision {
+	case risk.DecisionReview:
+		if p.Status == account.PaymentStatusCompleted {
+			p.Status, p.SettledAt = account.PaymentStatusPending, nil
+		}
+	case risk.DecisionDeny:
+		p.Status, p.SettledAt = account.PaymentStatusFailed, &p.CreatedAt
+	}
+	return nil
+}
+
 // getAccountsByPayment tries to get accounts from the storage, if they were not found it creates a new ones.
-func (s *serviceImpl) getAccountsByPayment(ctx context.Context, p *account.Payment) (*account.Account, *account.Account, error) {
-	accounts, err := s.storage.GetAccounts(ctx, []int64{p.From, p.To})
+// The accounts are returned in the order of the payment: the sender first, then the receivers.
+func (s *serviceImpl) getAccountsByPayment(ctx context.Context, storage storage.Storage, p *account.Payment) ([]*account.Account, error) {
+	return s.getAccounts(ctx, storage, p.AccountIDs())
+}
+
+// getAccounts returns the accounts in the given order creating the missing ones with the balance policies
+// of their types, they are locked in the id order so that concurrent payments don't deadlock.
+func (s *serviceImpl) getAccounts(ctx context.Context, storage storage.Storage, ids []int64) ([]*account.Account, error) {
+	sorted := make([]int64, len(ids))
+	copy(sorted, ids)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	accounts, err := storage.GetAccounts(ctx, sorted)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
-	var fromAccount, toAccount *account.Account
+	byID := make(map[int64]*account.Account, len(accounts))
 	for _, a := range accounts {
-		if a.ID == p.From {
-			fromAccount = a
+		byID[a.ID] = a
+	}
+
+	// TODO: add an ability to create accounts, now new ones have a default balance.
+
+	out := make([]*account.Account, 0, len(ids))
+	for _, id := range ids {
+		a, ok := byID[id]
+		if !ok {
+			a = account.Create(id, s.now())
+		}
+		a.Policy = s.policies[a.Type]
+		out = append(out, a)
+	}
+
+	return out, nil
+}
+
+// ConfirmPayment completes the pending payment crediting its receivers.
+func (s *serviceImpl) ConfirmPayment(ctx context.Context, id int64) (*account.Payment, error) {
+	return s.settlePayment(ctx, id, (*account.Payment).Confirm)
+}
+
+// CancelPayment cancels the pending payment returning the reserved amount to the sender.
+func (s *serviceImpl) CancelPayment(ctx context.Context, id int64) (*account.Payment, error) {
+	return s.settlePayment(ctx, id, (*account.Payment).Cancel)
+}
+
+// settlePayment gives the pending payment its final status with the settle function: a completed payment
+// credits the receivers, a cancelled or failed one refunds the sender. A withdrawal is settled by its payout,
+// so only the one waiting for the review is settled: the confirmation requests its payout.
+func (s *serviceImpl) settlePayment(ctx context.Context, id int64, settle func(*account.Payment, time.Time) error) (*account.Payment, error) {
+	err := account.ValidatePaymentID(id)
+	if err != nil {
+		return nil, errBadRequest("provided payment id %d is invalid: %w", id, err)
+	}
+
+	var payment *account.Payment
+	txFn := func(ctx context.Context, storage storage.Storage) error {
+		p, err := storage.GetPayment(ctx, id)
+		if errors.Is(err, account.ErrPaymentNotFound) {
+			return errNotFound("payment %d: %w", id, err)
+		}
+		if err != nil {
+			return errInternal("failed to get payment: %v", err)
+		}
+
+		var payout *account.Payout
+		if p.Type == account.PaymentTypeWithdrawal && p.Status == account.PaymentStatusPending {
+			payout, err = storage.GetPayoutByPaymentID(ctx, id)
+			if err != nil {
+				return errInternal("failed to get payout: %v", err)
+			}
+			if payout.Status != account.PayoutStatusReview {
+				return errConflict("failed to settle payment %d: %w", id, account.ErrPayoutInProgress)
+			}
+		}
+
+		err = settle(p, s.now())
+		if err != nil {
+			return errConflict("failed to settle payment %d: %w", id, err)
+		}
+
+		if payout != nil {
+			err = s.reviewPayout(ctx, storage, p, payout)
+			if err != nil {
+				return err
+			}
 		}
-		if a.ID == p.To {
-			toAccount = a
+		if p.Status != account.PaymentStatusPending {
+			err = s.settleAccounts(ctx, storage, p)
+			if err != nil {
+				return err
+			}
 		}
+
+		payment = p
+		return nil
 	}
 
-	// TODO: add an ability to create accounts, now new ones have a default balance.
+	err = s.storage.ExecTx(ctx, txFn)
+	if err != nil {
+		return nil, err
+	}
+
+	return payment, nil
+}
+
+// reviewPayout releases the payout of the confirmed withdrawal keeping the withdrawal pending,
+// or fails the payout of the cancelled one.
+func (s *serviceImpl) reviewPayout(ctx context.Context, storage storage.Storage, p *account.Payment, payout *account.Payout) error {
+	var err error
+	if p.Status == account.PaymentStatusCompleted {
+		p.Status, p.SettledAt = account.PaymentStatusPending, nil
+		err = payout.Release(s.now())
+	} else {
+		err = payout.Fail("withdrawal is cancelled by the review", s.now())
+	}
+	if err != nil {
+		return errConflict("failed to review payout of payment %d: %w", p.ID, err)
+	}
+
+	err = storage.UpdatePayout(ctx, payout, account.PayoutStatusReview)
+	if errors.Is(err, account.ErrUnexpectedPayoutStatus) {
+		return errConflict("failed to review payout of payment %d: %w", p.ID, err)
+	}
+	if err != nil {
+		return errInternal("failed to update payout: %v", err)
+	}
+	return nil
+}
 
-	if fromAccount == nil {
-		fromAccount = account.Create(p.From, s.now())
+// settleAccounts applies the final status of the payment to the accounts and saves it: a completed payment
+// credits the receivers, a cancelled or failed one refunds the sender.
+func (s *serviceImpl) settleAccounts(ctx context.Context, storage storage.Storage, p *account.Payment) error {
+	completed := p.Status == account.PaymentStatusCompleted
+	ids, action := []int64{p.From}, audit.ActionRefund
+	if completed {
+		ids, action = p.AccountIDs()[1:], audit.ActionPayment
+	}
+	accounts, err := s.getAccounts(ctx, storage, ids)
+	if err != nil {
+		return errInternal("failed to get accounts: %v", err)
 	}
 
-	if toAccount == nil {
-		toAccount = account.Create(p.To, s.now())
+	entries := make([]*audit.Entry, 0, len(accounts))
+	for _, a := range accounts {
+		balance := a.Balance
+		if completed {
+			err = a.ApplyPayment(p)
+		} else {
+			err = a.RefundPayment(p)
+		}
+		if err != nil {
+			return errBadRequest("failed to settle payment %d: %w", p.ID, err)
+		}
+		entries = append(entries, s.newAuditEntry(ctx, action, a, balance, p.ID))
 	}
 
-	return fromAccount, toAccount, nil
+	err = storage.ReplaceAccounts(ctx, accounts)
+	if err != nil {
+		return errInternal("failed to replace accounts: %v", err)
+	}
+
+	err = storage.UpdatePaymentStatus(ctx, p)
+	if errors.Is(err, account.ErrPaymentNotPending) {
+		return errConflict("failed to settle payment %d: %w", p.ID, err)
+	}
+	if err != nil {
+		return errInternal("failed to update payment status: %v", err)
+	}
+
+	err = storage.AppendAuditEntries(ctx, entries)
+	if err != nil {
+		return errInternal("failed to append audit entries: %v", err)
+	}
+	return nil
 }
 
-// GetPayments returns all payments by the account id.
-func (s *serviceImpl) GetPayments(ctx context.Context, accountID int64) ([]*account.Payment, error) {
-	err := account.ValidateAccountID(accountID)
+// GetPayments returns the payments of the account matching the filter.
+func (s *serviceImpl) GetPayments(ctx context.Context, f *account.PaymentFilter) ([]*account.Payment, error) {
+	err := account.ValidatePaymentFilter(f)
 	if err != nil {
-		return nil, errBadRequest("provided account id is invalid: %d", accountID)
+		return nil, errBadRequest("payment filter is invalid: %w", err)
 	}
 
-	payments, err := s.storage.GetPayments(ctx, accountID)
+	payments, err := s.storage.GetPayments(ctx, f)
 	if err != nil {
 		return nil, errInternal("failed to get payments from the storage: %v", err)
 	}
@@ -130,16 +463,372 @@ func (s *serviceImpl) GetPayments(ctx context.Context, accountID int64) ([]*acco
 func (s *serviceImpl) GetAccount(ctx context.Context, id int64) (*account.Account, error) {
 	err := account.ValidateAccountID(id)
 	if err != nil {
-		return nil, errBadRequest("provided account id is invalid: %d", id)
+		return nil, errBadRequest("provided account id %d is invalid: %w", id, err)
 	}
 
 	a, err := s.storage.GetAccount(ctx, id)
 	if err != nil {
 		if errors.Is(err, account.ErrNotFound) {
-			return nil, errNotFound("account %d is not found", id)
+			return nil, errNotFound("account %d: %w", id, err)
 		}
 		return nil, errInternal("failed to get account from the storage: %v", err)
 	}
 
 	return a, nil
 }
+
+// GetAccountBalanceAt returns an account by the given id with its balance at the given time.
+func (s *serviceImpl) GetAccountBalanceAt(ctx context.Context, id int64, at time.Time) (*account.Account, error) {
+	if at.After(s.now()) {
+		return nil, errBadRequest("balance time %v is invalid: %w", at, account.ErrBalanceTimeInFuture)
+	}
+
+	a, err := s.GetAccount(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if at.Before(a.CreatedAt) {
+		return nil, errNotFound("account %d at %v: %w", id, at, account.ErrNotFound)
+	}
+
+	balance, err := s.storage.GetAccountBalanceAt(ctx, id, at)
+	if err != nil {
+		return nil, errInternal("failed to get account balance: %v", err)
+	}
+	d, err := decimal.NewFromString(balance)
+	if err != nil {
+		return nil, errInternal("failed to parse account balance: %v", err)
+	}
+	a.Balance = d.StringFixed(2)
+
+	return a, nil
+}
+
+// ListAccounts returns a page of the accounts matching the request.
+func (s *serviceImpl) ListAccounts(ctx context.Context, r *account.ListRequest) (*account.Page, error) {
+	err := account.ValidateListRequest(r)
+	if err != nil {
+		return nil, errBadRequest("list request is invalid: %w", err)
+	}
+
+	limit := r.Limit
+	if limit == 0 {
+		limit = account.DefaultListLimit
+	}
+
+	// one more account is read to know if there is the next page.
+	accounts, err := s.storage.ListAccounts(ctx, r, limit+1)
+	if err != nil {
+		return nil, errInternal("failed to list accounts from the storage: %v", err)
+	}
+
+	page := &account.Page{Accounts: accounts}
+	if len(accounts) > limit {
+		page.Accounts = accounts[:limit]
+		page.Next = account.NewCursor(r.Sort, page.Accounts[limit-1])
+	}
+
+	return page, nil
+}
+
+// UpdateAccount changes the details of the account if it is not changed since the requested version.
+func (s *serviceImpl) UpdateAccount(ctx context.Context, r *account.UpdateRequest) (*account.Account, error) {
+	err := account.ValidateUpdateRequest(r)
+	if err != nil {
+		return nil, errBadRequest("update request is invalid: %w", err)
+	}
+
+	var a *account.Account
+	txFn := func(ctx context.Context, storage storage.Storage) error {
+		a, err = storage.GetAccount(ctx, r.ID)
+		if err != nil {
+			if errors.Is(err, account.ErrNotFound) {
+				return errNotFound("account %d: %w", r.ID, err)
+			}
+			return errInternal("failed to get account from the storage: %v", err)
+		}
+
+		before := *a
+		err = a.Update(r)
+		if errors.Is(err, account.ErrVersionMismatch) {
+			return errPreconditionFailed("account %d has version %d: %w", r.ID, a.Version, err)
+		}
+		if err != nil {
+			return errBadRequest("failed to update account: %w", err)
+		}
+
+		err = storage.UpdateAccountDetails(ctx, a)
+		if errors.Is(err, account.ErrVersionMismatch) {
+			return errPreconditionFailed("account %d: %w", r.ID, err)
+		}
+		if err != nil {
+			return errInternal("failed to update account details: %v", err)
+		}
+
+		entry := s.newAuditEntry(ctx, audit.ActionUpdate, a, a.Balance, 0)
+		entry.Details = updateDetails(&before, a)
+		err = storage.AppendAuditEntries(ctx, []*audit.Entry{entry})
+		if err != nil {
+			return errInternal("failed to append audit entries: %v", err)
+		}
+		return nil
+	}
+
+	err = s.storage.ExecTx(ctx, txFn)
+	if err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// GetStatement writes the statement of the account for the requested period, streaming its payments from the storage.
+func (s *serviceImpl) GetStatement(ctx context.Context, r *account.StatementRequest, w account.StatementWriter) error {
+	err := account.ValidateStatementRequest(r)
+	if err != nil {
+		return errBadRequest("statement request is invalid: %w", err)
+	}
+
+	a, err := s.GetAccount(ctx, r.AccountID)
+	if err != nil {
+		return err
+	}
+
+	statement := &account.Statement{
+		AccountID: r.AccountID,
+		From:      r.From,
+		To:        r.To,
+	}
+	if statement.To.IsZero() {
+		statement.To = s.now()
+	}
+
+	// the period starting before the account is created starts with its creation and the initial balance.
+	if !statement.From.After(a.CreatedAt) && a.CreatedAt.Before(statement.To) {
+		statement.From = a.CreatedAt
+		statement.OpeningBalance = a.InitialBalance
+		if statement.OpeningBalance == "" {
+			statement.OpeningBalance = "0"
+		}
+	} else {
+		statement.OpeningBalance, err = s.storage.GetAccountBalanceAt(ctx, r.AccountID, statement.From)
+		if err != nil {
+			return errInternal("failed to get opening balance: %v", err)
+		}
+	}
+	balance, err := decimal.NewFromString(statement.OpeningBalance)
+	if err != nil {
+		return errInternal("failed to parse opening balance: %v", err)
+	}
+	statement.OpeningBalance = balance.StringFixed(2)
+
+	err = w.Begin(statement)
+	if err != nil {
+		return err
+	}
+
+	err = s.storage.StreamPayments(ctx, r.AccountID, statement.From, statement.To, func(p *account.Payment) error {
+		var entry *account.StatementEntry
+		entry, balance, err = account.NewStatementEntry(statement, p, balance)
+		if err != nil {
+			return err
+		}
+		return w.Entry(entry)
+	})
+	if err != nil {
+		return errInternal("failed to stream payments: %v", err)
+	}
+
+	statement.ClosingBalance = balance.StringFixed(2)
+	return w.End(statement)
+}
+
+// ProposeAdjustment records the adjustment of the account balance proposed by the caller,
+// it is applied when another admin approves it.
+func (s *serviceImpl) ProposeAdjustment(ctx context.Context, r *account.AdjustmentRequest) (*account.Adjustment, error) {
+	err := account.ValidateAdjustmentRequest(r)
+	if err != nil {
+		return nil, errBadRequest("adjustment is invalid: %w", err)
+	}
+
+	_, err = s.GetAccount(ctx, r.AccountID)
+	if err != nil {
+		return nil, err
+	}
+
+	adjustment := r.ToAdjustment(actor(ctx), s.now())
+	err = s.storage.InsertAdjustment(ctx, adjustment)
+	if err != nil {
+		return nil, errInternal("failed to insert adjustment: %v", err)
+	}
+
+	return adjustment, nil
+}
+
+// ApproveAdjustment applies the proposed adjustment as a payment from or to the adjustments system account.
+func (s *serviceImpl) ApproveAdjustment(ctx context.Context, id int64) (*account.Adjustment, error) {
+	return s.reviewAdjustment(ctx, id, (*account.Adjustment).Approve)
+}
+
+// RejectAdjustment rejects the proposed adjustment leaving the balance unchanged.
+func (s *serviceImpl) RejectAdjustment(ctx context.Context, id int64) (*account.Adjustment, error) {
+	return s.reviewAdjustment(ctx, id, (*account.Adjustment).Reject)
+}
+
+// reviewAdjustment reviews the proposed adjustment by the caller with the review function,
+// an approved adjustment is applied in the same transaction.
+func (s *serviceImpl) reviewAdjustment(ctx context.Context, id int64, review func(*account.Adjustment, string, time.Time) error) (*account.Adjustment, error) {
+	err := account.ValidateAdjustmentID(id)
+	if err != nil {
+		return nil, errBadRequest("provided adjustment id %d is invalid: %w", id, err)
+	}
+
+	var adjustment *account.Adjustment
+	txFn := func(ctx context.Context, storage storage.Storage) error {
+		a, err := storage.GetAdjustment(ctx, id)
+		if errors.Is(err, account.ErrAdjustmentNotFound) {
+			return errNotFound("adjustment %d: %w", id, err)
+		}
+		if err != nil {
+			return errInternal("failed to get adjustment: %v", err)
+		}
+
+		err = review(a, actor(ctx), s.now())
+		if errors.Is(err, account.ErrAdjustmentSelfReview) {
+			return errForbidden("failed to review adjustment %d: %w", id, err)
+		}
+		if err != nil {
+			return errConflict("failed to review adjustment %d: %w", id, err)
+		}
+
+		if a.Status == account.AdjustmentStatusApplied {
+			err = s.applyAdjustment(ctx, storage, a)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = storage.UpdateAdjustment(ctx, a)
+		if errors.Is(err, account.ErrAdjustmentNotProposed) {
+			return errConflict("failed to review adjustment %d: %w", id, err)
+		}
+		if err != nil {
+			return errInternal("failed to update adjustment: %v", err)
+		}
+
+		adjustment = a
+		return nil
+	}
+
+	err = s.storage.ExecTx(ctx, txFn)
+	if err != nil {
+		return nil, err
+	}
+
+	return adjustment, nil
+}
+
+// applyAdjustment makes the payment of the approved adjustment.
+func (s *serviceImpl) applyAdjustment(ctx context.Context, storage storage.Storage, a *account.Adjustment) error {
+	payment := a.ToPayment(s.now())
+
+	accounts, err := s.getAccountsByPayment(ctx, storage, payment)
+	if err != nil {
+		return errInternal("failed to get accounts: %v", err)
+	}
+
+	balances := make([]string, len(accounts))
+	for i, acc := range accounts {
+		balances[i] = acc.Balance
+		err = acc.ApplyPayment(payment)
+		if err != nil {
+			return errBadRequest("failed to apply adjustment %d: %w", a.ID, err)
+		}
+	}
+
+	err = storage.ReplaceAccounts(ctx, accounts)
+	if err != nil {
+		return errInternal("failed to replace accounts: %v", err)
+	}
+
+	err = storage.InsertPayment(ctx, payment)
+	if err != nil {
+		return errInternal("failed to insert payment: %v", err)
+	}
+	a.PaymentID = payment.ID
+
+	entries := make([]*audit.Entry, 0, len(accounts))
+	for i, acc := range accounts {
+		entries = append(entries, s.newAuditEntry(ctx, audit.ActionAdjustment, acc, balances[i], payment.ID))
+	}
+	err = storage.AppendAuditEntries(ctx, entries)
+	if err != nil {
+		return errInternal("failed to append audit entries: %v", err)
+	}
+	return nil
+}
+
+// GetAdjustments returns the history of the adjustments of the account.
+func (s *serviceImpl) GetAdjustments(ctx context.Context, accountID int64) ([]*account.Adjustment, error) {
+	err := account.ValidateAccountID(accountID)
+	if err != nil {
+		return nil, errBadRequest("provided account id %d is invalid: %w", accountID, err)
+	}
+
+	adjustments, err := s.storage.GetAdjustments(ctx, accountID)
+	if err != nil {
+		return nil, errInternal("failed to get adjustments from the storage: %v", err)
+	}
+
+	return adjustments, nil
+}
+
+// actor returns the subject of the caller of the request.
+func actor(ctx context.Context) string {
+	if p, ok := auth.FromContext(ctx); ok {
+		return p.Subject
+	}
+	return systemActor
+}
+
+// detailsChange is a change of an account detail recorded in the audit log.
+type detailsChange struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// updateDetails describes the account details changed by the update as JSON keyed by the changed field.
+func updateDetails(before, after *account.Account) string {
+	changes := make(map[string]detailsChange)
+	if before.OwnerRef != after.OwnerRef {
+		changes["OwnerRef"] = detailsChange{From: before.OwnerRef, To: after.OwnerRef}
+	}
+	if before.DisplayName != after.DisplayName {
+		changes["DisplayName"] = detailsChange{From: before.DisplayName, To: after.DisplayName}
+	}
+	if before.Type != after.Type {
+		changes["Type"] = detailsChange{From: before.Type, To: after.Type}
+	}
+	if before.Status != after.Status {
+		changes["Status"] = detailsChange{From: before.Status, To: after.Status}
+	}
+	if !reflect.DeepEqual(before.Metadata, after.Metadata) {
+		changes["Metadata"] = detailsChange{From: before.Metadata, To: after.Metadata}
+	}
+	b, _ := json.Marshal(changes)
+	return string(b)
+}
+
+// newAuditEntry records the change of the account balance made by the caller of the request.
+func (s *serviceImpl) newAuditEntry(ctx context.Context, action string, a *account.Account, balanceBefore string, paymentID int64) *audit.Entry {
+	return &audit.Entry{
+		Action:        action,
+		Actor:         actor(ctx),
+		RequestID:     requestid.FromContext(ctx),
+		AccountID:     a.ID,
+		PaymentID:     paymentID,
+		BalanceBefore: balanceBefore,
+		BalanceAfter:  a.Balance,
+		CreatedAt:     s.now(),
+	}
+}
@@ -9,17 +9,52 @@ import (
 	"github.com/stretchr/testify/assert"
 
 	"github.com/shkov/wallet-service/internal/account"
+	"github.com/shkov/wallet-service/internal/audit"
+	"github.com/shkov/wallet-service/internal/auth"
+	"github.com/shkov/wallet-service/internal/reconcile"
+	"github.com/shkov/wallet-service/internal/requestid"
+	"github.com/shkov/wallet-service/internal/risk"
 	"github.com/shkov/wallet-service/internal/storage"
 )
 
 type storageMock struct {
-	onGetAccount      func(ctx context.Context, id int64) (*account.Account, error)
-	onGetAccounts     func(ctx context.Context, ids []int64) ([]*account.Account, error)
-	onGetPayments     func(ctx context.Context, accountID int64) ([]*account.Payment, error)
-	onInsertPayment   func(ctx context.Context, p *account.Payment) error
-	onReplaceAccounts func(ctx context.Context, aa []*account.Account) error
-	onClose           func() error
-	onExecTx          func(ctx context.Context, fn func(context.Context, storage.Storage) error) error
+	onGetAccount       func(ctx context.Context, id int64) (*account.Account, error)
+	onGetAccounts      func(ctx context.Context, ids []int64) ([]*account.Account, error)
+	onListAccounts     func(ctx context.Context, r *account.ListRequest, limit int) ([]*account.Account, error)
+	onGetPayments      func(ctx context.Context, f *account.PaymentFilter) ([]*account.Payment, error)
+	onGetPaymentByKey  func(ctx context.Context, from int64, key string) (*account.Payment, error)
+	onGetPayment       func(ctx context.Context, id int64) (*account.Payment, error)
+	onGetVelocity      func(ctx context.Context, accountID int64, since time.Time) (*risk.Velocity, error)
+	onInsertPayment    func(ctx context.Context, p *account.Payment) error
+	onUpdateStatus     func(ctx context.Context, p *account.Payment) error
+	onInsertAdjustment func(ctx context.Context, a *account.Adjustment) error
+	onGetAdjustment    func(ctx context.Context, id int64) (*account.Adjustment, error)
+	onUpdateAdjustment func(ctx context.Context, a *account.Adjustment) error
+	onGetAdjustments   func(ctx context.Context, accountID int64) ([]*account.Adjustment, error)
+	onInsertPayout     func(ctx context.Context, p *account.Payout) error
+	onGetPayouts       func(ctx context.Context, status string, limit int) ([]*account.Payout, error)
+	onGetPayout        func(ctx context.Context, paymentID int64) (*account.Payout, error)
+	onUpdatePayout     func(ctx context.Context, p *account.Payout, from string) error
+	onInsertAccruals   func(ctx context.Context, accruals []*account.InterestAccrual) (int64, error)
+	onGetUnposted      func(ctx context.Context, accountID int64, before time.Time) (string, error)
+	onSetInterestPaid  func(ctx context.Context, accountID int64, before time.Time, paymentID int64) error
+	onIsInterestPosted func(ctx context.Context, accountID int64, from, before time.Time) (bool, error)
+	onReplaceAccounts  func(ctx context.Context, aa []*account.Account) error
+	onUpsertAccounts   func(ctx context.Context, aa []*account.Account) error
+	onInsertPayments   func(ctx context.Context, pp []*account.Payment) error
+	onUpdateDetails    func(ctx context.Context, a *account.Account) error
+	onGetTotalBalances func(ctx context.Context) (map[string]string, error)
+	onGetAPIKey        func(ctx context.Context, keyHash string) (*auth.APIKey, error)
+	onStreamPayments   func(ctx context.Context, accountID int64, from, to time.Time, fn func(*account.Payment) error) error
+	onGetBalanceAt     func(ctx context.Context, accountID int64, at time.Time) (string, error)
+	onCreateSnapshots  func(ctx context.Context, at time.Time) (int64, error)
+	onGetDiscrepancies func(ctx context.Context) ([]*reconcile.Discrepancy, error)
+	onAppendAudit      func(ctx context.Context, entries []*audit.Entry) error
+	onGetAudit         func(ctx context.Context, afterSeq int64, limit int) ([]*audit.Entry, error)
+	onGetChanges       func(ctx context.Context, since time.Time) ([]*audit.Entry, error)
+	onPing             func(ctx context.Context) error
+	onClose            func() error
+	onExecTx           func(ctx context.Context, fn func(context.Context, storage.Storage) error) error
 }
 
 func (m *storageMock) GetAccount(ctx context.Context, id int64) (*account.Account, error) {
@@ -30,22 +65,142 @@ func (m *storageMock) GetAccounts(ctx context.Context, ids []int64) ([]*account.
 	return m.onGetAccounts(ctx, ids)
 }
 
-func (m *storageMock) GetPayments(ctx context.Context, accountID int64) ([]*account.Payment, error) {
-	return m.onGetPayments(ctx, accountID)
+func (m *storageMock) ListAccounts(ctx context.Context, r *account.ListRequest, limit int) ([]*account.Account, error) {
+	return m.onListAccounts(ctx, r, limit)
+}
+
+func (m *storageMock) GetPayments(ctx context.Context, f *account.PaymentFilter) ([]*account.Payment, error) {
+	return m.onGetPayments(ctx, f)
+}
+
+func (m *storageMock) GetPaymentByIdempotencyKey(ctx context.Context, from int64, key string) (*account.Payment, error) {
+	return m.onGetPaymentByKey(ctx, from, key)
 }
 
 func (m *storageMock) InsertPayment(ctx context.Context, p *account.Payment) error {
 	return m.onInsertPayment(ctx, p)
 }
 
+func (m *storageMock) GetPayment(ctx context.Context, id int64) (*account.Payment, error) {
+	return m.onGetPayment(ctx, id)
+}
+
+func (m *storageMock) GetPaymentVelocity(ctx context.Context, accountID int64, since time.Time) (*risk.Velocity, error) {
+	return m.onGetVelocity(ctx, accountID, since)
+}
+
+func (m *storageMock) UpdatePaymentStatus(ctx context.Context, p *account.Payment) error {
+	return m.onUpdateStatus(ctx, p)
+}
+
+func (m *storageMock) InsertAdjustment(ctx context.Context, a *account.Adjustment) error {
+	return m.onInsertAdjustment(ctx, a)
+}
+
+func (m *storageMock) GetAdjustment(ctx context.Context, id int64) (*account.Adjustment, error) {
+	return m.onGetAdjustment(ctx, id)
+}
+
+func (m *storageMock) UpdateAdjustment(ctx context.Context, a *account.Adjustment) error {
+	return m.onUpdateAdjustment(ctx, a)
+}
+
+func (m *storageMock) GetAdjustments(ctx context.Context, accountID int64) ([]*account.Adjustment, error) {
+	return m.onGetAdjustments(ctx, accountID)
+}
+
+func (m *storageMock) InsertPayout(ctx context.Context, p *account.Payout) error {
+	return m.onInsertPayout(ctx, p)
+}
+
+func (m *storageMock) GetPayouts(ctx context.Context, status string, limit int) ([]*account.Payout, error) {
+	return m.onGetPayouts(ctx, status, limit)
+}
+
+func (m *storageMock) GetPayoutByPaymentID(ctx context.Context, paymentID int64) (*account.Payout, error) {
+	return m.onGetPayout(ctx, paymentID)
+}
+
+func (m *storageMock) UpdatePayout(ctx context.Context, p *account.Payout, from string) error {
+	return m.onUpdatePayout(ctx, p, from)
+}
+
+func (m *storageMock) InsertInterestAccruals(ctx context.Context, accruals []*account.InterestAccrual) (int64, error) {
+	return m.onInsertAccruals(ctx, accruals)
+}
+
+func (m *storageMock) GetUnpostedInterest(ctx context.Context, accountID int64, before time.Time) (string, error) {
+	return m.onGetUnposted(ctx, accountID, before)
+}
+
+func (m *storageMock) SetInterestPayment(ctx context.Context, accountID int64, before time.Time, paymentID int64) error {
+	return m.onSetInterestPaid(ctx, accountID, before, paymentID)
+}
+
+func (m *storageMock) IsInterestPosted(ctx context.Context, accountID int64, from, before time.Time) (bool, error) {
+	return m.onIsInterestPosted(ctx, accountID, from, before)
+}
+
 func (m *storageMock) ReplaceAccounts(ctx context.Context, aa []*account.Account) error {
 	return m.onReplaceAccounts(ctx, aa)
 }
 
+func (m *storageMock) UpsertAccounts(ctx context.Context, aa []*account.Account) error {
+	return m.onUpsertAccounts(ctx, aa)
+}
+
+func (m *storageMock) InsertPayments(ctx context.Context, pp []*account.Payment) error {
+	return m.onInsertPayments(ctx, pp)
+}
+
+func (m *storageMock) UpdateAccountDetails(ctx context.Context, a *account.Account) error {
+	return m.onUpdateDetails(ctx, a)
+}
+
+func (m *storageMock) GetTotalBalances(ctx context.Context) (map[string]string, error) {
+	return m.onGetTotalBalances(ctx)
+}
+
+func (m *storageMock) GetAPIKey(ctx context.Context, keyHash string) (*auth.APIKey, error) {
+	return m.onGetAPIKey(ctx, keyHash)
+}
+
 func (m *storageMock) Close() error {
 	return m.onClose()
 }
 
+func (m *storageMock) StreamPayments(ctx context.Context, accountID int64, from, to time.Time, fn func(*account.Payment) error) error {
+	return m.onStreamPayments(ctx, accountID, from, to, fn)
+}
+
+func (m *storageMock) GetAccountBalanceAt(ctx context.Context, accountID int64, at time.Time) (string, error) {
+	return m.onGetBalanceAt(ctx, accountID, at)
+}
+
+func (m *storageMock) CreateBalanceSnapshots(ctx context.Context, at time.Time) (int64, error) {
+	return m.onCreateSnapshots(ctx, at)
+}
+
+func (m *storageMock) GetBalanceDiscrepancies(ctx context.Context) ([]*reconcile.Discrepancy, error) {
+	return m.onGetDiscrepancies(ctx)
+}
+
+func (m *storageMock) AppendAuditEntries(ctx context.Context, entries []*audit.Entry) error {
+	return m.onAppendAudit(ctx, entries)
+}
+
+func (m *storageMock) GetAuditEntries(ctx context.Context, afterSeq int64, limit int) ([]*audit.Entry, error) {
+	return m.onGetAudit(ctx, afterSeq, limit)
+}
+
+func (m *storageMock) GetDetailsChanges(ctx context.Context, since time.Time) ([]*audit.Entry, error) {
+	return m.onGetChanges(ctx, since)
+}
+
+func (m *storageMock) Ping(ctx context.Context) error {
+	return m.onPing(ctx)
+}
+
 func (m *storageMock) ExecTx(ctx context.Context, fn func(context.Context, storage.Storage) error) error {
 	return m.onExecTx(ctx, fn)
 }
@@ -107,6 +262,33 @@ func TestService_ApplyPayment(t *testing.T) {
 					}
 					return nil
 				},
+
+				onAppendAudit: func(ctx context.Context, got []*audit.Entry) error {
+					want := []*audit.Entry{
+						{
+							Action:        audit.ActionPayment,
+							Actor:         "apikey:1",
+							RequestID:     "req-1",
+							AccountID:     1,
+							BalanceBefore: "1000",
+							BalanceAfter:  "500.00",
+							CreatedAt:     parseTime(t, "2001-01-02T11:22:33+03:00"),
+						},
+						{
+							Action:        audit.ActionPayment,
+							Actor:         "apikey:1",
+							RequestID:     "req-1",
+							AccountID:     2,
+							BalanceBefore: "1000",
+							BalanceAfter:  "1500.00",
+							CreatedAt:     parseTime(t, "2001-01-02T11:22:33+03:00"),
+						},
+					}
+					if ok := assert.Equal(t, want, got); !ok {
+						t.Fatal()
+					}
+					return nil
+				},
 			}
 
 			mock.onExecTx = func(ctx context.Context, fn func(context.Context, storage.Storage) error) error {
@@ -121,7 +303,9 @@ func TestService_ApplyPayment(t *testing.T) {
 				},
 			}
 
-			gotResp, gotErr := svc.ApplyPayment(context.Background(), tc.paymentRequest)
+			ctx := auth.NewContext(context.Background(), &auth.Principal{Subject: "apikey:1"})
+			ctx = requestid.NewContext(ctx, "req-1")
+			gotResp, gotErr := svc.ApplyPayment(ctx, tc.paymentRequest)
 			assert.Equal(t, tc.wantPayment, gotResp)
 			if tc.wantErr == nil && gotErr != nil {
 				t.Fatalf("unexpected error: %v", gotErr)
@@ -133,48 +317,1352 @@ func TestService_ApplyPayment(t *testing.T) {
 	}
 }
 
-func makePayment(t *testing.T, fn func(*account.Payment)) *account.Payment {
-	p := &account.Payment{
-		ID:        0,
-		From:      1,
-		To:        2,
-		Amount:    "500",
-		CreatedAt: parseTime(t, "2001-01-02T11:22:33+03:00"),
+func TestService_ApplyPayment_IdempotencyKey(t *testing.T) {
+	existing := makePayment(t, func(p *account.Payment) {
+		p.ID = 10
+		p.IdempotencyKey = "key"
+	})
+
+	testCases := []struct {
+		name           string
+		paymentRequest *account.PaymentRequest
+		wantPayment    *account.Payment
+		wantErr        error
+	}{
+		{
+			name: "replay returns the original payment",
+			paymentRequest: makePaymentRequest(t, func(pr *account.PaymentRequest) {
+				pr.IdempotencyKey = "key"
+			}),
+			wantPayment: existing,
+			wantErr:     nil,
+		},
+		{
+			name: "key is reused with another payment",
+			paymentRequest: makePaymentRequest(t, func(pr *account.PaymentRequest) {
+				pr.IdempotencyKey = "key"
+				pr.Amount = "1"
+			}),
+			wantPayment: nil,
+			wantErr:     errConflict("failed to apply payment: %w", account.ErrIdempotencyKeyReused),
+		},
 	}
-	if fn != nil {
-		fn(p)
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mock := &storageMock{
+				onGetPaymentByKey: func(ctx context.Context, from int64, key string) (*account.Payment, error) {
+					assert.Equal(t, int64(1), from)
+					assert.Equal(t, "key", key)
+					return existing, nil
+				},
+			}
+			mock.onExecTx = func(ctx context.Context, fn func(context.Context, storage.Storage) error) error {
+				return fn(ctx, mock)
+			}
+
+			svc := &serviceImpl{
+				logger:  log.NewNopLogger(),
+				storage: mock,
+				now: func() time.Time {
+					return parseTime(t, "2001-01-02T11:22:33+03:00")
+				},
+			}
+
+			gotResp, gotErr := svc.ApplyPayment(context.Background(), tc.paymentRequest)
+			assert.Equal(t, tc.wantPayment, gotResp)
+			assert.Equal(t, tc.wantErr, gotErr)
+		})
 	}
-	return p
 }
 
-func makeAccount(t *testing.T, fn func(*account.Account)) *account.Account {
-	a := &account.Account{
-		ID:        1,
-		Balance:   "1000",
-		CreatedAt: parseTime(t, "2001-01-02T11:22:33+03:00"),
+func TestService_ApplyPayment_IdempotencyKeyRace(t *testing.T) {
+	existing := makePayment(t, func(p *account.Payment) {
+		p.ID = 10
+		p.IdempotencyKey = "key"
+	})
+
+	testCases := []struct {
+		name        string
+		amount      string
+		wantPayment *account.Payment
+		wantErr     error
+	}{
+		{
+			name:        "concurrent replay returns the payment inserted first",
+			amount:      existing.Amount,
+			wantPayment: existing,
+		},
+		{
+			name:    "concurrent request with another payment",
+			amount:  "1",
+			wantErr: errConflict("failed to apply payment: %w", account.ErrIdempotencyKeyReused),
+		},
 	}
-	if fn != nil {
-		fn(a)
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			inserted := false
+			mock := &storageMock{
+				onGetPaymentByKey: func(ctx context.Context, from int64, key string) (*account.Payment, error) {
+					// the concurrent payment is visible only after this transaction is rolled back.
+					if !inserted {
+						return nil, account.ErrPaymentNotFound
+					}
+					return existing, nil
+				},
+				onGetAccounts: func(ctx context.Context, ids []int64) ([]*account.Account, error) {
+					return []*account.Account{
+						makeAccount(t, nil),
+						makeAccount(t, func(a *account.Account) { a.ID = 2 }),
+					}, nil
+				},
+				onReplaceAccounts: func(ctx context.Context, aa []*account.Account) error {
+					return nil
+				},
+				onInsertPayment: func(ctx context.Context, p *account.Payment) error {
+					inserted = true
+					return account.ErrIdempotencyKeyTaken
+				},
+			}
+			mock.onExecTx = func(ctx context.Context, fn func(context.Context, storage.Storage) error) error {
+				return fn(ctx, mock)
+			}
+
+			svc := &serviceImpl{
+				logger:  log.NewNopLogger(),
+				storage: mock,
+				now: func() time.Time {
+					return parseTime(t, "2001-01-02T11:22:33+03:00")
+				},
+			}
+
+			gotResp, gotErr := svc.ApplyPayment(context.Background(), makePaymentRequest(t, func(pr *account.PaymentRequest) {
+				pr.IdempotencyKey = "key"
+				pr.Amount = tc.amount
+			}))
+			assert.Equal(t, tc.wantPayment, gotResp)
+			assert.Equal(t, tc.wantErr, gotErr)
+		})
 	}
-	return a
 }
 
-func makePaymentRequest(t *testing.T, fn func(*account.PaymentRequest)) *account.PaymentRequest {
-	pr := &account.PaymentRequest{
-		From:   1,
-		To:     2,
-		Amount: "500",
+func TestService_ApplyPayment_ExternalRefReused(t *testing.T) {
+	mock := &storageMock{
+		onGetAccounts: func(ctx context.Context, ids []int64) ([]*account.Account, error) {
+			return []*account.Account{
+				makeAccount(t, nil),
+				makeAccount(t, func(a *account.Account) { a.ID = 2 }),
+			}, nil
+		},
+		onReplaceAccounts: func(ctx context.Context, aa []*account.Account) error {
+			return nil
+		},
+		onInsertPayment: func(ctx context.Context, p *account.Payment) error {
+			assert.Equal(t, "order-42", p.ExternalRef)
+			return account.ErrExternalRefReused
+		},
 	}
-	if fn != nil {
-		fn(pr)
+	mock.onExecTx = func(ctx context.Context, fn func(context.Context, storage.Storage) error) error {
+		return fn(ctx, mock)
 	}
-	return pr
+
+	svc := &serviceImpl{
+		logger:  log.NewNopLogger(),
+		storage: mock,
+		now: func() time.Time {
+			return parseTime(t, "2001-01-02T11:22:33+03:00")
+		},
+	}
+
+	_, gotErr := svc.ApplyPayment(context.Background(), makePaymentRequest(t, func(pr *account.PaymentRequest) {
+		pr.ExternalRef = "order-42"
+	}))
+	assert.Equal(t, errConflict("failed to apply payment: %w", account.ErrExternalRefReused), gotErr)
 }
 
-func parseTime(t *testing.T, timestr string) time.Time {
-	tm, err := time.Parse(time.RFC3339, timestr)
-	if err != nil {
-		t.Fatalf("parseTime: failed to parse %q: %v", timestr, err)
+func TestService_ApplyPayment_Split(t *testing.T) {
+	request := makePaymentRequest(t, func(pr *account.PaymentRequest) {
+		pr.To = 0
+		pr.Legs = []*account.PaymentLeg{{To: 3, Amount: "200"}, {To: 2, Amount: "300"}}
+	})
+	wantPayment := makePayment(t, func(p *account.Payment) {
+		p.To = 0
+		p.Type = account.PaymentTypeSplit
+		p.Legs = []*account.PaymentLeg{{To: 3, Amount: "200"}, {To: 2, Amount: "300"}}
+	})
+
+	mock := &storageMock{
+		onGetAccounts: func(ctx context.Context, got []int64) ([]*account.Account, error) {
+			assert.Equal(t, []int64{1, 2, 3}, got)
+			return []*account.Account{
+				makeAccount(t, nil),
+				makeAccount(t, func(a *account.Account) { a.ID = 2 }),
+			}, nil
+		},
+		onReplaceAccounts: func(ctx context.Context, got []*account.Account) error {
+			want := []*account.Account{
+				makeAccount(t, func(a *account.Account) { a.Balance = "500.00" }),
+				func() *account.Account {
+					a := account.Create(3, parseTime(t, "2001-01-02T11:22:33+03:00"))
+					a.Balance = "1200.00"
+					return a
+				}(),
+				makeAccount(t, func(a *account.Account) {
+					a.ID = 2
+					a.Balance = "1300.00"
+				}),
+			}
+			assert.Equal(t, want, got)
+			return nil
+		},
+		onInsertPayment: func(ctx context.Context, got *account.Payment) error {
+			assert.Equal(t, wantPayment, got)
+			return nil
+		},
+		onAppendAudit: func(ctx context.Context, got []*audit.Entry) error {
+			ids := make([]int64, 0, len(got))
+			for _, e := range got {
+				ids = append(ids, e.AccountID)
+			}
+			assert.Equal(t, []int64{1, 3, 2}, ids)
+			return nil
+		},
+	}
+	mock.onExecTx = func(ctx context.Context, fn func(context.Context, storage.Storage) error) error {
+		return fn(ctx, mock)
+	}
+
+	svc := &serviceImpl{
+		logger:  log.NewNopLogger(),
+		storage: mock,
+		now: func() time.Time {
+			return parseTime(t, "2001-01-02T11:22:33+03:00")
+		},
+	}
+
+	gotResp, gotErr := svc.ApplyPayment(context.Background(), request)
+	assert.NoError(t, gotErr)
+	assert.Equal(t, wantPayment, gotResp)
+}
+
+func TestService_DepositAndWithdraw(t *testing.T) {
+	now := parseTime(t, "2001-01-02T11:22:33+03:00")
+	systemAccount := func(id int64, balance string) *account.Account {
+		a := account.Create(id, now)
+		a.Balance = balance
+		return a
+	}
+
+	testCases := []struct {
+		name         string
+		call         func(svc Service) (*account.Payment, error)
+		wantType     string
+		wantStatus   string
+		wantAccounts []*account.Account
+		// wantPayout is the amount of the payout requested for the withdrawal.
+		wantPayout string
+	}{
+		{
+			name: "deposit",
+			call: func(svc Service) (*account.Payment, error) {
+				return svc.Deposit(context.Background(), &account.FundsRequest{AccountID: 1, Amount: "250"})
+			},
+			wantType:   account.PaymentTypeDeposit,
+			wantStatus: account.PaymentStatusCompleted,
+			wantAccounts: []*account.Account{
+				systemAccount(account.SystemAccountFunding, "-250.00"),
+				makeAccount(t, func(a *account.Account) { a.Balance = "1250.00" }),
+			},
+		},
+		{
+			name: "withdrawal",
+			call: func(svc Service) (*account.Payment, error) {
+				return svc.Withdraw(context.Background(), &account.FundsRequest{AccountID: 1, Amount: "250"})
+			},
+			wantType:   account.PaymentTypeWithdrawal,
+			wantStatus: account.PaymentStatusPending,
+			wantAccounts: []*account.Account{
+				makeAccount(t, func(a *account.Account) { a.Balance = "750.00" }),
+				systemAccount(account.SystemAccountPayouts, "0"),
+			},
+			wantPayout: "250",
+		},
+		{
+			name: "withdrawal with fee",
+			call: func(svc Service) (*account.Payment, error) {
+				return svc.Withdraw(context.Background(), &account.FundsRequest{AccountID: 1, Amount: "250", Fee: "2.5"})
+			},
+			wantType:   account.PaymentTypeWithdrawal,
+			wantStatus: account.PaymentStatusPending,
+			wantAccounts: []*account.Account{
+				makeAccount(t, func(a *account.Account) { a.Balance = "747.50" }),
+				systemAccount(account.SystemAccountPayouts, "0"),
+				systemAccount(account.SystemAccountFees, "0"),
+			},
+			wantPayout: "250",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var payout *account.Payout
+			mock := &storageMock{
+				onGetAccounts: func(ctx context.Context, ids []int64) ([]*account.Account, error) {
+					// the system accounts are created on the first payment.
+					return []*account.Account{makeAccount(t, nil)}, nil
+				},
+				onReplaceAccounts: func(ctx context.Context, got []*account.Account) error {
+					assert.Equal(t, tc.wantAccounts, got)
+					return nil
+				},
+				onInsertPayment: func(ctx context.Context, p *account.Payment) error {
+					return nil
+				},
+				onInsertPayout: func(ctx context.Context, p *account.Payout) error {
+					payout = p
+					return nil
+				},
+				onAppendAudit: func(ctx context.Context, entries []*audit.Entry) error {
+					return nil
+				},
+			}
+			mock.onExecTx = func(ctx context.Context, fn func(context.Context, storage.Storage) error) error {
+				return fn(ctx, mock)
+			}
+
+			// the deposits are not screened as they are sent by a system account.
+			svc := &serviceImpl{
+				logger:  log.NewNopLogger(),
+				storage: mock,
+				risk: riskEngineFunc(func(ctx context.Context, s risk.Storage, r *risk.Request) (*risk.Assessment, error) {
+					assert.Equal(t, int64(1), r.Sender.ID)
+					return &risk.Assessment{Decision: risk.DecisionAllow}, nil
+				}),
+				payouts: true,
+				now:     func() time.Time { return now },
+			}
+
+			got, err := tc.call(svc)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.wantType, got.Type)
+			assert.Equal(t, tc.wantStatus, got.Status)
+			if tc.wantPayout == "" {
+				assert.Nil(t, payout)
+				return
+			}
+			if assert.NotNil(t, payout) {
+				assert.Equal(t, tc.wantPayout, payout.Amount)
+				assert.Equal(t, account.PayoutStatusRequested, payout.Status)
+				assert.Equal(t, int64(1), payout.AccountID)
+			}
+		})
+	}
+}
+
+func TestService_Withdraw_PayoutsDisabled(t *testing.T) {
+	svc := &serviceImpl{
+		logger:  log.NewNopLogger(),
+		storage: &storageMock{},
+		now:     time.Now,
+	}
+
+	_, err := svc.Withdraw(context.Background(), &account.FundsRequest{AccountID: 1, Amount: "250"})
+	assert.Equal(t, errNotImplemented("failed to withdraw: %w", account.ErrWithdrawalsDisabled), err)
+}
+
+func TestService_ApplyPayment_Pending(t *testing.T) {
+	var replaced []*account.Account
+	mock := &storageMock{
+		onGetAccounts: func(ctx context.Context, ids []int64) ([]*account.Account, error) {
+			return []*account.Account{
+				makeAccount(t, nil),
+				makeAccount(t, func(a *account.Account) { a.ID = 2 }),
+			}, nil
+		},
+		onReplaceAccounts: func(ctx context.Context, aa []*account.Account) error {
+			replaced = aa
+			return nil
+		},
+		onInsertPayment: func(ctx context.Context, p *account.Payment) error {
+			return nil
+		},
+		onAppendAudit: func(ctx context.Context, entries []*audit.Entry) error {
+			// only the sender's balance is changed.
+			assert.Len(t, entries, 1)
+			return nil
+		},
+	}
+	mock.onExecTx = func(ctx context.Context, fn func(context.Context, storage.Storage) error) error {
+		return fn(ctx, mock)
+	}
+
+	svc := &serviceImpl{
+		logger:  log.NewNopLogger(),
+		storage: mock,
+		now: func() time.Time {
+			return parseTime(t, "2001-01-02T11:22:33+03:00")
+		},
+	}
+
+	got, err := svc.ApplyPayment(context.Background(), makePaymentRequest(t, func(pr *account.PaymentRequest) {
+		pr.Pending = true
+	}))
+	assert.NoError(t, err)
+	assert.Equal(t, account.PaymentStatusPending, got.Status)
+	assert.Nil(t, got.SettledAt)
+	if assert.Len(t, replaced, 2) {
+		assert.Equal(t, "500.00", replaced[0].Balance)
+		assert.Equal(t, "1000", replaced[1].Balance)
+	}
+}
+
+func TestService_ApplyPayment_BalancePolicy(t *testing.T) {
+	policies := map[string]*account.BalancePolicy{
+		account.TypeSavings: {Min: "600"},
+		account.TypeEMoney:  {Max: "1200"},
+	}
+
+	testCases := []struct {
+		name         string
+		senderType   string
+		receiverType string
+		wantErr      error
+	}{
+		{
+			name:         "no policies",
+			senderType:   account.TypeUser,
+			receiverType: account.TypeUser,
+		},
+		{
+			name:         "sender below minimum balance",
+			senderType:   account.TypeSavings,
+			receiverType: account.TypeUser,
+			wantErr:      errBadRequest("failed to apply payment to the sender: %w", account.ErrBelowMinBalance),
+		},
+		{
+			name:         "receiver over balance cap",
+			senderType:   account.TypeUser,
+			receiverType: account.TypeEMoney,
+			wantErr:      errBadRequest("failed to apply payment to the receiver: %w", account.ErrBalanceCapExceeded),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mock := &storageMock{
+				onGetAccounts: func(ctx context.Context, ids []int64) ([]*account.Account, error) {
+					return []*account.Account{
+						makeAccount(t, func(a *account.Account) { a.Type = tc.senderType }),
+						makeAccount(t, func(a *account.Account) { a.ID = 2; a.Type = tc.receiverType }),
+					}, nil
+				},
+				onReplaceAccounts: func(ctx context.Context, aa []*account.Account) error {
+					return nil
+				},
+				onInsertPayment: func(ctx context.Context, p *account.Payment) error {
+					return nil
+				},
+				onAppendAudit: func(ctx context.Context, entries []*audit.Entry) error {
+					return nil
+				},
+			}
+			mock.onExecTx = func(ctx context.Context, fn func(context.Context, storage.Storage) error) error {
+				return fn(ctx, mock)
+			}
+
+			svc := &serviceImpl{
+				logger:   log.NewNopLogger(),
+				storage:  mock,
+				policies: policies,
+				now: func() time.Time {
+					return parseTime(t, "2001-01-02T11:22:33+03:00")
+				},
+			}
+
+			_, err := svc.ApplyPayment(context.Background(), makePaymentRequest(t, nil))
+			assert.Equal(t, tc.wantErr, err)
+		})
+	}
+}
+
+type riskEngineFunc func(ctx context.Context, s risk.Storage, r *risk.Request) (*risk.Assessment, error)
+
+func (f riskEngineFunc) Evaluate(ctx context.Context, s risk.Storage, r *risk.Request) (*risk.Assessment, error) {
+	return f(ctx, s, r)
+}
+
+func TestService_ApplyPayment_Risk(t *testing.T) {
+	createdAt := parseTime(t, "2001-01-02T11:22:33+03:00")
+
+	testCases := []struct {
+		name         string
+		assessment   *risk.Assessment
+		wantStatus   string
+		wantBalances []string
+		wantErr      error
+	}{
+		{
+			name:         "allowed",
+			assessment:   &risk.Assessment{Decision: risk.DecisionAllow},
+			wantStatus:   account.PaymentStatusCompleted,
+			wantBalances: []string{"500.00", "1500.00"},
+		},
+		{
+			name:         "reviewed",
+			assessment:   &risk.Assessment{Decision: risk.DecisionReview, Rules: []string{risk.RuleReviewAmount}},
+			wantStatus:   account.PaymentStatusPending,
+			wantBalances: []string{"500.00", "1000"},
+		},
+		{
+			name:         "denied",
+			assessment:   &risk.Assessment{Decision: risk.DecisionDeny, Rules: []string{risk.RuleBlockedAccount}},
+			wantStatus:   account.PaymentStatusFailed,
+			wantBalances: []string{"1000", "1000"},
+			wantErr:      errForbidden("failed to apply payment %d: %w", int64(5), account.ErrPaymentDenied),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var inserted *account.Payment
+			mock := &storageMock{
+				onGetAccounts: func(ctx context.Context, ids []int64) ([]*account.Account, error) {
+					return []*account.Account{
+						makeAccount(t, nil),
+						makeAccount(t, func(a *account.Account) { a.ID = 2 }),
+					}, nil
+				},
+				onReplaceAccounts: func(ctx context.Context, aa []*account.Account) error {
+					var balances []string
+					for _, a := range aa {
+						balances = append(balances, a.Balance)
+					}
+					assert.Equal(t, tc.wantBalances, balances)
+					return nil
+				},
+				onInsertPayment: func(ctx context.Context, p *account.Payment) error {
+					p.ID = 5
+					inserted = p
+					return nil
+				},
+				onAppendAudit: func(ctx context.Context, entries []*audit.Entry) error {
+					return nil
+				},
+			}
+			mock.onExecTx = func(ctx context.Context, fn func(context.Context, storage.Storage) error) error {
+				return fn(ctx, mock)
+			}
+
+			svc := &serviceImpl{
+				logger:  log.NewNopLogger(),
+				storage: mock,
+				risk: riskEngineFunc(func(ctx context.Context, s risk.Storage, r *risk.Request) (*risk.Assessment, error) {
+					assert.Equal(t, int64(1), r.Sender.ID)
+					assert.Equal(t, "500", r.Payment.Amount)
+					return tc.assessment, nil
+				}),
+				now: func() time.Time {
+					return createdAt
+				},
+			}
+
+			got, err := svc.ApplyPayment(context.Background(), makePaymentRequest(t, nil))
+			assert.Equal(t, tc.wantErr, err)
+			if assert.NotNil(t, inserted) {
+				assert.Equal(t, tc.wantStatus, inserted.Status)
+				assert.Equal(t, tc.assessment.Decision, inserted.RiskDecision)
+				assert.Equal(t, tc.assessment.Rules, inserted.RiskRules)
+			}
+			if tc.wantErr == nil {
+				assert.Equal(t, inserted, got)
+			}
+		})
+	}
+}
+
+func TestService_SettlePayment(t *testing.T) {
+	now := parseTime(t, "2001-01-03T10:00:00Z")
+	pending := func() *account.Payment {
+		return makePayment(t, func(p *account.Payment) {
+			p.ID = 7
+			p.Status = account.PaymentStatusPending
+			p.SettledAt = nil
+		})
+	}
+
+	testCases := []struct {
+		name         string
+		settle       func(svc Service) (*account.Payment, error)
+		stored       *account.Payment
+		wantAccounts []*account.Account
+		wantAction   string
+		wantStatus   string
+		wantErr      error
+	}{
+		{
+			name: "confirm credits the receiver",
+			settle: func(svc Service) (*account.Payment, error) {
+				return svc.ConfirmPayment(context.Background(), 7)
+			},
+			stored:       pending(),
+			wantAccounts: []*account.Account{makeAccount(t, func(a *account.Account) { a.ID = 2; a.Balance = "1500.00" })},
+			wantAction:   audit.ActionPayment,
+			wantStatus:   account.PaymentStatusCompleted,
+		},
+		{
+			name: "cancel refunds the sender",
+			settle: func(svc Service) (*account.Payment, error) {
+				return svc.CancelPayment(context.Background(), 7)
+			},
+			stored:       pending(),
+			wantAccounts: []*account.Account{makeAccount(t, func(a *account.Account) { a.Balance = "1500.00" })},
+			wantAction:   audit.ActionRefund,
+			wantStatus:   account.PaymentStatusCancelled,
+		},
+		{
+			name: "completed payment",
+			settle: func(svc Service) (*account.Payment, error) {
+				return svc.CancelPayment(context.Background(), 7)
+			},
+			stored:  makePayment(t, func(p *account.Payment) { p.ID = 7 }),
+			wantErr: errConflict("failed to settle payment %d: %w", int64(7), account.ErrPaymentNotPending),
+		},
+		{
+			name: "unknown payment",
+			settle: func(svc Service) (*account.Payment, error) {
+				return svc.ConfirmPayment(context.Background(), 7)
+			},
+			wantErr: errNotFound("payment %d: %w", int64(7), account.ErrPaymentNotFound),
+		},
+		{
+			name: "invalid id",
+			settle: func(svc Service) (*account.Payment, error) {
+				return svc.ConfirmPayment(context.Background(), 0)
+			},
+			wantErr: errBadRequest("provided payment id %d is invalid: %w", int64(0), account.ErrPaymentIDMustBePositive),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var updated *account.Payment
+			mock := &storageMock{
+				onGetPayment: func(ctx context.Context, id int64) (*account.Payment, error) {
+					if tc.stored == nil {
+						return nil, account.ErrPaymentNotFound
+					}
+					return tc.stored, nil
+				},
+				onGetAccounts: func(ctx context.Context, ids []int64) ([]*account.Account, error) {
+					var out []*account.Account
+					for _, id := range ids {
+						out = append(out, makeAccount(t, func(a *account.Account) { a.ID = id }))
+					}
+					return out, nil
+				},
+				onReplaceAccounts: func(ctx context.Context, got []*account.Account) error {
+					assert.Equal(t, tc.wantAccounts, got)
+					return nil
+				},
+				onUpdateStatus: func(ctx context.Context, p *account.Payment) error {
+					updated = p
+					return nil
+				},
+				onAppendAudit: func(ctx context.Context, entries []*audit.Entry) error {
+					if assert.Len(t, entries, 1) {
+						assert.Equal(t, tc.wantAction, entries[0].Action)
+					}
+					return nil
+				},
+			}
+			mock.onExecTx = func(ctx context.Context, fn func(context.Context, storage.Storage) error) error {
+				return fn(ctx, mock)
+			}
+
+			svc := &serviceImpl{
+				logger:  log.NewNopLogger(),
+				storage: mock,
+				now:     func() time.Time { return now },
+			}
+
+			got, err := tc.settle(svc)
+			assert.Equal(t, tc.wantErr, err)
+			if tc.wantErr != nil {
+				assert.Nil(t, updated)
+				return
+			}
+			assert.Equal(t, tc.wantStatus, got.Status)
+			assert.Equal(t, &now, got.SettledAt)
+			assert.Equal(t, got, updated)
+		})
+	}
+}
+
+func TestService_SettlePayment_Withdrawal(t *testing.T) {
+	now := parseTime(t, "2001-01-03T10:00:00Z")
+
+	testCases := []struct {
+		name             string
+		settle           func(svc Service) (*account.Payment, error)
+		payoutStatus     string
+		wantStatus       string
+		wantPayoutStatus string
+		wantBalances     []string
+		wantErr          error
+	}{
+		{
+			name: "confirm requests the payout",
+			settle: func(svc Service) (*account.Payment, error) {
+				return svc.ConfirmPayment(context.Background(), 7)
+			},
+			payoutStatus:     account.PayoutStatusReview,
+			wantStatus:       account.PaymentStatusPending,
+			wantPayoutStatus: account.PayoutStatusRequested,
+		},
+		{
+			name: "cancel fails the payout",
+			settle: func(svc Service) (*account.Payment, error) {
+				return svc.CancelPayment(context.Background(), 7)
+			},
+			payoutStatus:     account.PayoutStatusReview,
+			wantStatus:       account.PaymentStatusCancelled,
+			wantPayoutStatus: account.PayoutStatusFailed,
+			wantBalances:     []string{"1500.00"},
+		},
+		{
+			name: "payout in progress",
+			settle: func(svc Service) (*account.Payment, error) {
+				return svc.CancelPayment(context.Background(), 7)
+			},
+			payoutStatus:     account.PayoutStatusSubmitted,
+			wantPayoutStatus: account.PayoutStatusSubmitted,
+			wantErr:          errConflict("failed to settle payment %d: %w", int64(7), account.ErrPayoutInProgress),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			payment := makePayment(t, func(p *account.Payment) {
+				p.ID = 7
+				p.To = account.SystemAccountPayouts
+				p.Type = account.PaymentTypeWithdrawal
+				p.Status = account.PaymentStatusPending
+				p.SettledAt = nil
+			})
+			payout := &account.Payout{ID: 3, PaymentID: 7, AccountID: 1, Amount: "500", Status: tc.payoutStatus}
+
+			var balances []string
+			mock := &storageMock{
+				onGetPayment: func(ctx context.Context, id int64) (*account.Payment, error) {
+					return payment, nil
+				},
+				onGetPayout: func(ctx context.Context, paymentID int64) (*account.Payout, error) {
+					assert.Equal(t, int64(7), paymentID)
+					return payout, nil
+				},
+				onUpdatePayout: func(ctx context.Context, p *account.Payout, from string) error {
+					assert.Equal(t, account.PayoutStatusReview, from)
+					return nil
+				},
+				onGetAccounts: func(ctx context.Context, ids []int64) ([]*account.Account, error) {
+					return []*account.Account{makeAccount(t, nil)}, nil
+				},
+				onReplaceAccounts: func(ctx context.Context, aa []*account.Account) error {
+					for _, a := range aa {
+						balances = append(balances, a.Balance)
+					}
+					return nil
+				},
+				onUpdateStatus: func(ctx context.Context, p *account.Payment) error {
+					return nil
+				},
+				onAppendAudit: func(ctx context.Context, entries []*audit.Entry) error {
+					return nil
+				},
+			}
+			mock.onExecTx = func(ctx context.Context, fn func(context.Context, storage.Storage) error) error {
+				return fn(ctx, mock)
+			}
+
+			svc := &serviceImpl{
+				logger:  log.NewNopLogger(),
+				storage: mock,
+				now:     func() time.Time { return now },
+			}
+
+			got, err := tc.settle(svc)
+			assert.Equal(t, tc.wantErr, err)
+			assert.Equal(t, tc.wantPayoutStatus, payout.Status)
+			assert.Equal(t, tc.wantBalances, balances)
+			if tc.wantErr == nil {
+				assert.Equal(t, tc.wantStatus, got.Status)
+			}
+		})
+	}
+}
+
+func TestService_ReviewAdjustment(t *testing.T) {
+	now := parseTime(t, "2001-01-03T10:00:00Z")
+	proposed := func(direction string) *account.Adjustment {
+		return &account.Adjustment{
+			ID:         3,
+			AccountID:  1,
+			Direction:  direction,
+			Amount:     "200",
+			Reason:     "duplicate fee",
+			Status:     account.AdjustmentStatusProposed,
+			ProposedBy: "apikey:1",
+			ProposedAt: now.Add(-time.Hour),
+		}
+	}
+	reviewer := auth.NewContext(context.Background(), &auth.Principal{Subject: "apikey:2"})
+	proposer := auth.NewContext(context.Background(), &auth.Principal{Subject: "apikey:1"})
+
+	testCases := []struct {
+		name         string
+		review       func(svc Service) (*account.Adjustment, error)
+		stored       *account.Adjustment
+		wantAccounts []*account.Account
+		wantStatus   string
+		wantErr      error
+	}{
+		{
+			name: "approved credit",
+			review: func(svc Service) (*account.Adjustment, error) {
+				return svc.ApproveAdjustment(reviewer, 3)
+			},
+			stored: proposed(account.AdjustmentCredit),
+			wantAccounts: []*account.Account{
+				makeAccount(t, func(a *account.Account) {
+					a.ID = account.SystemAccountAdjustments
+					a.Balance = "-200.00"
+					a.InitialBalance = "0"
+					a.CreatedAt = now
+					a.Status = account.StatusActive
+					a.Version = 1
+					a.Type = account.TypeAdjustments
+				}),
+				makeAccount(t, func(a *account.Account) { a.Balance = "1200.00" }),
+			},
+			wantStatus: account.AdjustmentStatusApplied,
+		},
+		{
+			name: "approved debit",
+			review: func(svc Service) (*account.Adjustment, error) {
+				return svc.ApproveAdjustment(reviewer, 3)
+			},
+			stored: proposed(account.AdjustmentDebit),
+			wantAccounts: []*account.Account{
+				makeAccount(t, func(a *account.Account) { a.Balance = "800.00" }),
+				makeAccount(t, func(a *account.Account) {
+					a.ID = account.SystemAccountAdjustments
+					a.Balance = "200.00"
+					a.InitialBalance = "0"
+					a.CreatedAt = now
+					a.Status = account.StatusActive
+					a.Version = 1
+					a.Type = account.TypeAdjustments
+				}),
+			},
+			wantStatus: account.AdjustmentStatusApplied,
+		},
+		{
+			name: "rejected",
+			review: func(svc Service) (*account.Adjustment, error) {
+				return svc.RejectAdjustment(reviewer, 3)
+			},
+			stored:     proposed(account.AdjustmentCredit),
+			wantStatus: account.AdjustmentStatusRejected,
+		},
+		{
+			name: "approved by the proposer",
+			review: func(svc Service) (*account.Adjustment, error) {
+				return svc.ApproveAdjustment(proposer, 3)
+			},
+			stored:  proposed(account.AdjustmentCredit),
+			wantErr: errForbidden("failed to review adjustment %d: %w", int64(3), account.ErrAdjustmentSelfReview),
+		},
+		{
+			name: "already reviewed",
+			review: func(svc Service) (*account.Adjustment, error) {
+				return svc.ApproveAdjustment(reviewer, 3)
+			},
+			stored: func() *account.Adjustment {
+				a := proposed(account.AdjustmentCredit)
+				a.Status = account.AdjustmentStatusRejected
+				return a
+			}(),
+			wantErr: errConflict("failed to review adjustment %d: %w", int64(3), account.ErrAdjustmentNotProposed),
+		},
+		{
+			name: "unknown adjustment",
+			review: func(svc Service) (*account.Adjustment, error) {
+				return svc.ApproveAdjustment(reviewer, 3)
+			},
+			wantErr: errNotFound("adjustment %d: %w", int64(3), account.ErrAdjustmentNotFound),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var updated *account.Adjustment
+			var payment *account.Payment
+			mock := &storageMock{
+				onGetAdjustment: func(ctx context.Context, id int64) (*account.Adjustment, error) {
+					if tc.stored == nil {
+						return nil, account.ErrAdjustmentNotFound
+					}
+					return tc.stored, nil
+				},
+				onGetAccounts: func(ctx context.Context, ids []int64) ([]*account.Account, error) {
+					// the system account is created on the first adjustment.
+					return []*account.Account{makeAccount(t, nil)}, nil
+				},
+				onReplaceAccounts: func(ctx context.Context, got []*account.Account) error {
+					assert.Equal(t, tc.wantAccounts, got)
+					return nil
+				},
+				onInsertPayment: func(ctx context.Context, p *account.Payment) error {
+					p.ID = 9
+					payment = p
+					return nil
+				},
+				onAppendAudit: func(ctx context.Context, entries []*audit.Entry) error {
+					if assert.Len(t, entries, 2) {
+						for _, e := range entries {
+							assert.Equal(t, audit.ActionAdjustment, e.Action)
+							assert.Equal(t, "apikey:2", e.Actor)
+							assert.Equal(t, int64(9), e.PaymentID)
+						}
+					}
+					return nil
+				},
+				onUpdateAdjustment: func(ctx context.Context, a *account.Adjustment) error {
+					updated = a
+					return nil
+				},
+			}
+			mock.onExecTx = func(ctx context.Context, fn func(context.Context, storage.Storage) error) error {
+				return fn(ctx, mock)
+			}
+
+			svc := &serviceImpl{
+				logger:  log.NewNopLogger(),
+				storage: mock,
+				now:     func() time.Time { return now },
+			}
+
+			got, err := tc.review(svc)
+			assert.Equal(t, tc.wantErr, err)
+			if tc.wantErr != nil {
+				assert.Nil(t, updated)
+				return
+			}
+			assert.Equal(t, tc.wantStatus, got.Status)
+			assert.Equal(t, "apikey:2", got.ReviewedBy)
+			assert.Equal(t, got, updated)
+			if tc.wantStatus != account.AdjustmentStatusApplied {
+				assert.Nil(t, payment)
+				return
+			}
+			assert.Equal(t, int64(9), got.PaymentID)
+			assert.Equal(t, account.PaymentTypeAdjustment, payment.Type)
+		})
+	}
+}
+
+func makePayment(t *testing.T, fn func(*account.Payment)) *account.Payment {
+	p := &account.Payment{
+		ID:        0,
+		From:      1,
+		To:        2,
+		Amount:    "500",
+		CreatedAt: parseTime(t, "2001-01-02T11:22:33+03:00"),
+		Type:      account.PaymentTypeTransfer,
+	}
+	p.Status = account.PaymentStatusCompleted
+	p.SettledAt = &p.CreatedAt
+	if fn != nil {
+		fn(p)
+	}
+	return p
+}
+
+func makeAccount(t *testing.T, fn func(*account.Account)) *account.Account {
+	a := &account.Account{
+		ID:        1,
+		Balance:   "1000",
+		CreatedAt: parseTime(t, "2001-01-02T11:22:33+03:00"),
+	}
+	if fn != nil {
+		fn(a)
+	}
+	return a
+}
+
+func makePaymentRequest(t *testing.T, fn func(*account.PaymentRequest)) *account.PaymentRequest {
+	pr := &account.PaymentRequest{
+		From:   1,
+		To:     2,
+		Amount: "500",
+	}
+	if fn != nil {
+		fn(pr)
+	}
+	return pr
+}
+
+func parseTime(t *testing.T, timestr string) time.Time {
+	tm, err := time.Parse(time.RFC3339, timestr)
+	if err != nil {
+		t.Fatalf("parseTime: failed to parse %q: %v", timestr, err)
+	}
+	return tm
+}
+
+// statementRecorder records the statement written by the service.
+type statementRecorder struct {
+	begin   *account.Statement
+	entries []*account.StatementEntry
+	end     *account.Statement
+}
+
+func (r *statementRecorder) Begin(s *account.Statement) error {
+	c := *s
+	r.begin = &c
+	return nil
+}
+
+func (r *statementRecorder) Entry(e *account.StatementEntry) error {
+	r.entries = append(r.entries, e)
+	return nil
+}
+
+func (r *statementRecorder) End(s *account.Statement) error {
+	c := *s
+	r.end = &c
+	return nil
+}
+
+func TestService_GetStatement(t *testing.T) {
+	from := parseTime(t, "2001-01-01T00:00:00Z")
+	now := parseTime(t, "2001-01-02T11:22:33+03:00")
+	payments := []*account.Payment{
+		makePayment(t, func(p *account.Payment) { p.ID = 1; p.Amount = "100"; p.CreatedAt = from.Add(time.Hour) }),
+		makePayment(t, func(p *account.Payment) {
+			p.ID = 2
+			p.From, p.To = 2, 1
+			p.Amount = "25.5"
+			p.CreatedAt = from.Add(2 * time.Hour)
+		}),
+	}
+
+	testCases := []struct {
+		name        string
+		request     *account.StatementRequest
+		createdAt   time.Time
+		payments    []*account.Payment
+		wantRecord  *statementRecorder
+		wantErr     error
+		wantBalance time.Time
+	}{
+		{
+			name:        "normal response",
+			request:     &account.StatementRequest{AccountID: 1, From: from},
+			createdAt:   from.Add(-24 * time.Hour),
+			payments:    payments,
+			wantBalance: from,
+			wantRecord: &statementRecorder{
+				begin: &account.Statement{AccountID: 1, From: from, To: now, OpeningBalance: "1000.00"},
+				entries: []*account.StatementEntry{
+					{Payment: payments[0], Amount: "-100.00", Balance: "900.00"},
+					{Payment: payments[1], Amount: "25.50", Balance: "925.50"},
+				},
+				end: &account.Statement{AccountID: 1, From: from, To: now, OpeningBalance: "1000.00", ClosingBalance: "925.50"},
+			},
+		},
+		{
+			name:      "no payments since created",
+			request:   &account.StatementRequest{AccountID: 1},
+			createdAt: from,
+			wantRecord: &statementRecorder{
+				begin: &account.Statement{AccountID: 1, From: from, To: now, OpeningBalance: "1000.00"},
+				end:   &account.Statement{AccountID: 1, From: from, To: now, OpeningBalance: "1000.00", ClosingBalance: "1000.00"},
+			},
+		},
+		{
+			name:       "invalid period",
+			request:    &account.StatementRequest{AccountID: 1, From: now, To: from},
+			wantRecord: &statementRecorder{},
+			wantErr:    errBadRequest("statement request is invalid: %w", account.ErrInvalidStatementPeriod),
+		},
+		{
+			name:       "unknown account",
+			request:    &account.StatementRequest{AccountID: 3},
+			wantRecord: &statementRecorder{},
+			wantErr:    errNotFound("account %d: %w", 3, account.ErrNotFound),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mock := &storageMock{
+				onGetAccount: func(ctx context.Context, id int64) (*account.Account, error) {
+					if id != 1 {
+						return nil, account.ErrNotFound
+					}
+					return makeAccount(t, func(a *account.Account) {
+						a.CreatedAt = tc.createdAt
+						a.InitialBalance = "1000"
+					}), nil
+				},
+				onGetBalanceAt: func(ctx context.Context, accountID int64, at time.Time) (string, error) {
+					assert.Equal(t, tc.wantBalance, at)
+					return "1000", nil
+				},
+				onStreamPayments: func(ctx context.Context, accountID int64, gotFrom, gotTo time.Time, fn func(*account.Payment) error) error {
+					assert.Equal(t, from, gotFrom)
+					assert.Equal(t, now, gotTo)
+					for _, p := range tc.payments {
+						if err := fn(p); err != nil {
+							return err
+						}
+					}
+					return nil
+				},
+			}
+
+			svc := &serviceImpl{
+				logger:  log.NewNopLogger(),
+				storage: mock,
+				now: func() time.Time {
+					return now
+				},
+			}
+
+			rec := &statementRecorder{}
+			gotErr := svc.GetStatement(context.Background(), tc.request, rec)
+			assert.Equal(t, tc.wantErr, gotErr)
+			assert.Equal(t, tc.wantRecord, rec)
+		})
+	}
+}
+
+func TestService_GetAccountBalanceAt(t *testing.T) {
+	createdAt := parseTime(t, "2001-01-02T11:22:33+03:00")
+	asOf := parseTime(t, "2001-03-31T23:59:59Z")
+	now := parseTime(t, "2001-04-02T00:00:00Z")
+
+	testCases := []struct {
+		name     string
+		id       int64
+		at       time.Time
+		response *account.Account
+		err      error
+	}{
+		{
+			name:     "normal response",
+			id:       1,
+			at:       asOf,
+			response: makeAccount(t, func(a *account.Account) { a.Balance = "925.50" }),
+		},
+		{
+			name: "before account is created",
+			id:   1,
+			at:   createdAt.Add(-time.Second),
+			err:  errNotFound("account %d at %v: %w", int64(1), createdAt.Add(-time.Second), account.ErrNotFound),
+		},
+		{
+			name: "in the future",
+			id:   1,
+			at:   now.Add(time.Second),
+			err:  errBadRequest("balance time %v is invalid: %w", now.Add(time.Second), account.ErrBalanceTimeInFuture),
+		},
+		{
+			name: "unknown account",
+			id:   3,
+			at:   asOf,
+			err:  errNotFound("account %d: %w", 3, account.ErrNotFound),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mock := &storageMock{
+				onGetAccount: func(ctx context.Context, id int64) (*account.Account, error) {
+					if id != 1 {
+						return nil, account.ErrNotFound
+					}
+					return makeAccount(t, nil), nil
+				},
+				onGetBalanceAt: func(ctx context.Context, accountID int64, at time.Time) (string, error) {
+					assert.Equal(t, tc.id, accountID)
+					assert.Equal(t, tc.at, at)
+					return "925.5", nil
+				},
+			}
+
+			svc := &serviceImpl{
+				logger:  log.NewNopLogger(),
+				storage: mock,
+				now: func() time.Time {
+					return now
+				},
+			}
+
+			gotResp, gotErr := svc.GetAccountBalanceAt(context.Background(), tc.id, tc.at)
+			assert.Equal(t, tc.err, gotErr)
+			assert.Equal(t, tc.response, gotResp)
+		})
+	}
+}
+
+func TestService_ListAccounts(t *testing.T) {
+	accounts := []*account.Account{
+		makeAccount(t, func(a *account.Account) { a.ID = 1 }),
+		makeAccount(t, func(a *account.Account) { a.ID = 2 }),
+		makeAccount(t, func(a *account.Account) { a.ID = 3 }),
+	}
+
+	testCases := []struct {
+		name      string
+		request   *account.ListRequest
+		wantLimit int
+		response  *account.Page
+		err       error
+	}{
+		{
+			name:      "next page",
+			request:   &account.ListRequest{Sort: "-created_at", Limit: 2},
+			wantLimit: 3,
+			response: &account.Page{
+				Accounts: accounts[:2],
+				Next:     account.NewCursor("-created_at", accounts[1]),
+			},
+		},
+		{
+			name:      "last page",
+			request:   &account.ListRequest{},
+			wantLimit: account.DefaultListLimit + 1,
+			response:  &account.Page{Accounts: accounts},
+		},
+		{
+			name:    "invalid request",
+			request: &account.ListRequest{Status: "frozen"},
+			err:     errBadRequest("list request is invalid: %w", account.ErrUnknownStatus),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mock := &storageMock{
+				onListAccounts: func(ctx context.Context, r *account.ListRequest, limit int) ([]*account.Account, error) {
+					assert.Equal(t, tc.request, r)
+					assert.Equal(t, tc.wantLimit, limit)
+					return accounts, nil
+				},
+			}
+
+			svc := &serviceImpl{
+				logger:  log.NewNopLogger(),
+				storage: mock,
+			}
+
+			gotResp, gotErr := svc.ListAccounts(context.Background(), tc.request)
+			assert.Equal(t, tc.err, gotErr)
+			assert.Equal(t, tc.response, gotResp)
+		})
+	}
+}
+
+func TestService_UpdateAccount(t *testing.T) {
+	name, savings := "Savings", account.TypeSavings
+
+	testCases := []struct {
+		name          string
+		request       *account.UpdateRequest
+		storedVersion int64
+		updateErr     error
+		response      *account.Account
+		// wantDetails are the details of the audit entry of the update.
+		wantDetails string
+		err         error
+	}{
+		{
+			name:          "normal response",
+			request:       &account.UpdateRequest{ID: 1, Version: 1, DisplayName: &name},
+			storedVersion: 1,
+			response:      makeAccount(t, func(a *account.Account) { a.DisplayName = name; a.Version = 2 }),
+			wantDetails:   `{"DisplayName":{"from":"","to":"Savings"}}`,
+		},
+		{
+			name:          "type change",
+			request:       &account.UpdateRequest{ID: 1, Version: 1, Type: &savings},
+			storedVersion: 1,
+			response:      makeAccount(t, func(a *account.Account) { a.Type = savings; a.Version = 2 }),
+			wantDetails:   `{"Type":{"from":"","to":"savings"}}`,
+		},
+		{
+			name:          "stale version",
+			request:       &account.UpdateRequest{ID: 1, Version: 1, DisplayName: &name},
+			storedVersion: 2,
+			err:           errPreconditionFailed("account %d has version %d: %w", int64(1), int64(2), account.ErrVersionMismatch),
+		},
+		{
+			name:          "concurrent update",
+			request:       &account.UpdateRequest{ID: 1, Version: 1, DisplayName: &name},
+			storedVersion: 1,
+			updateErr:     account.ErrVersionMismatch,
+			err:           errPreconditionFailed("account %d: %w", int64(1), account.ErrVersionMismatch),
+		},
+		{
+			name:    "unknown account",
+			request: &account.UpdateRequest{ID: 3, Version: 1},
+			err:     errNotFound("account %d: %w", int64(3), account.ErrNotFound),
+		},
+		{
+			name:    "invalid request",
+			request: &account.UpdateRequest{ID: 1, Metadata: map[string]*string{"": nil}},
+			err:     errBadRequest("update request is invalid: %w", account.ErrInvalidMetadata),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mock := &storageMock{
+				onGetAccount: func(ctx context.Context, id int64) (*account.Account, error) {
+					if id != 1 {
+						return nil, account.ErrNotFound
+					}
+					return makeAccount(t, func(a *account.Account) { a.Version = tc.storedVersion }), nil
+				},
+				onUpdateDetails: func(ctx context.Context, a *account.Account) error {
+					assert.Equal(t, tc.storedVersion+1, a.Version)
+					return tc.updateErr
+				},
+				onAppendAudit: func(ctx context.Context, entries []*audit.Entry) error {
+					if assert.Len(t, entries, 1) {
+						assert.Equal(t, audit.ActionUpdate, entries[0].Action)
+						assert.Equal(t, entries[0].BalanceBefore, entries[0].BalanceAfter)
+						assert.Equal(t, tc.wantDetails, entries[0].Details)
+					}
+					return nil
+				},
+			}
+			mock.onExecTx = func(ctx context.Context, fn func(context.Context, storage.Storage) error) error {
+				return fn(ctx, mock)
+			}
+
+			svc := &serviceImpl{
+				logger:  log.NewNopLogger(),
+				storage: mock,
+				now:     time.Now,
+			}
+
+			gotResp, gotErr := svc.UpdateAccount(context.Background(), tc.request)
+			assert.Equal(t, tc.err, gotErr)
+			assert.Equal(t, tc.response, gotResp)
+		})
 	}
-	return tm
 }
@@ -0,0 +1,237 @@
+package walletservice
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/shkov/wallet-service/internal/account"
+)
+
+// Statement formats.
+const (
+	statementFormatCSV   = "csv"
+	statementFormatJSONL = "jsonl"
+)
+
+var statementContentTypes = map[string]string{
+	statementFormatCSV:   "text/csv; charset=utf-8",
+	statementFormatJSONL: "application/x-ndjson",
+}
+
+// Statement line types.
+const (
+	statementLineOpening = "opening"
+	statementLinePayment = "payment"
+	statementLineClosing = "closing"
+	// statementLineError ends the statement failed after it is partly sent instead of the closing balance.
+	statementLineError = "error"
+)
+
+var errStatementTruncated = errors.New("statement is truncated")
+
+// statementLine is a line of the JSON Lines statement.
+type statementLine struct {
+	Type      string
+	AccountID int64            `json:",omitempty"`
+	From      *time.Time       `json:",omitempty"`
+	To        *time.Time       `json:",omitempty"`
+	Payment   *account.Payment `json:",omitempty"`
+	Amount    string           `json:",omitempty"`
+	Balance   string
+	Error     string `json:",omitempty"`
+}
+
+// statementEncoder writes the statement to the HTTP response as it is streamed by the service.
+type statementEncoder struct {
+	w      http.ResponseWriter
+	format string
+	buf    *bufio.Writer
+	csv    *csv.Writer
+	json   *json.Encoder
+	// sent tells that a part of the statement is written to the response, so its status can't be changed.
+	sent bool
+}
+
+func newStatementEncoder(w http.ResponseWriter, format string) *statementEncoder {
+	e := &statementEncoder{
+		w:      w,
+		format: format,
+	}
+	e.buf = bufio.NewWriter(responseWriterFunc(func(p []byte) (int, error) {
+		e.sent = true
+		return e.w.Write(p)
+	}))
+	e.csv = csv.NewWriter(e.buf)
+	e.json = json.NewEncoder(e.buf)
+	return e
+}
+
+// responseWriterFunc writes the buffered statement to the response.
+type responseWriterFunc func(p []byte) (int, error)
+
+func (f responseWriterFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+func (e *statementEncoder) Begin(s *account.Statement) error {
+	e.w.Header().Set("Content-Type", statementContentTypes[e.format])
+	e.w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="statement-%d.%s"`, s.AccountID, e.format))
+
+	if e.format == statementFormatCSV {
+		_ = e.csv.Write([]string{"Type", "Time", "PaymentID", "From", "To", "Amount", "Balance"})
+		return e.writeCSV(statementLineOpening, formatStatementTime(s.From), "", "", "", "", s.OpeningBalance)
+	}
+	return e.json.Encode(statementLine{
+		Type:      statementLineOpening,
+		AccountID: s.AccountID,
+		From:      statementTime(s.From),
+		To:        statementTime(s.To),
+		Balance:   s.OpeningBalance,
+	})
+}
+
+func (e *statementEncoder) Entry(entry *account.StatementEntry) error {
+	p := entry.Payment
+	if e.format == statementFormatCSV {
+		return e.writeCSV(
+			statementLinePayment,
+			formatStatementTime(p.CreatedAt),
+			strconv.FormatInt(p.ID, 10),
+			strconv.FormatInt(p.From, 10),
+			strconv.FormatInt(p.To, 10),
+			entry.Amount,
+			entry.Balance,
+		)
+	}
+	return e.json.Encode(statementLine{
+		Type:    statementLinePayment,
+		Payment: p,
+		Amount:  entry.Amount,
+		Balance: entry.Balance,
+	})
+}
+
+func (e *statementEncoder) End(s *account.Statement) error {
+	var err error
+	if e.format == statementFormatCSV {
+		err = e.writeCSV(statementLineClosing, formatStatementTime(s.To), "", "", "", "", s.ClosingBalance)
+	} else {
+		err = e.json.Encode(statementLine{
+			Type:      statementLineClosing,
+			AccountID: s.AccountID,
+			From:      statementTime(s.From),
+			To:        statementTime(s.To),
+			Balance:   s.ClosingBalance,
+		})
+	}
+	if err != nil {
+		return err
+	}
+	return e.buf.Flush()
+}
+
+// Discard drops the statement not sent yet, so that the response gets the error instead.
+func (e *statementEncoder) Discard() {
+	e.buf.Reset(nil)
+	e.w.Header().Del("Content-Disposition")
+}
+
+// Fail ends the partly sent statement with the error line, so that the client doesn't take it for a complete one.
+// The line tells only that the statement is truncated, the cause is logged.
+func (e *statementEncoder) Fail() error {
+	var err error
+	if e.format == statementFormatCSV {
+		err = e.writeCSV(statementLineError, "", "", "", "", "", "")
+	} else {
+		err = e.json.Encode(statementLine{Type: statementLineError, Error: errStatementTruncated.Error()})
+	}
+	if err != nil {
+		return err
+	}
+	return e.buf.Flush()
+}
+
+func (e *statementEncoder) writeCSV(record ...string) error {
+	_ = e.csv.Write(record)
+	e.csv.Flush()
+	return e.csv.Error()
+}
+
+// statementTime omits the zero start of the statement period.
+func statementTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func formatStatementTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// parseStatementTime parses the statement period bound given as a date or RFC 3339 time.
+func parseStatementTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// decodeStatement reads the JSON Lines statement and passes it to w.
+func decodeStatement(r io.Reader, w account.StatementWriter) error {
+	dec := json.NewDecoder(r)
+	var statement *account.Statement
+	for {
+		var line statementLine
+		err := dec.Decode(&line)
+		if errors.Is(err, io.EOF) {
+			return errStatementTruncated
+		}
+		if err != nil {
+			return fmt.Errorf("failed to decode statement: %w", err)
+		}
+
+		switch line.Type {
+		case statementLineOpening:
+			statement = &account.Statement{AccountID: line.AccountID, OpeningBalance: line.Balance}
+			if line.From != nil {
+				statement.From = *line.From
+			}
+			if line.To != nil {
+				statement.To = *line.To
+			}
+			err = w.Begin(statement)
+		case statementLinePayment:
+			if statement == nil {
+				return fmt.Errorf("failed to decode statement: payment before opening balance")
+			}
+			err = w.Entry(&account.StatementEntry{Payment: line.Payment, Amount: line.Amount, Balance: line.Balance})
+		case statementLineClosing:
+			if statement == nil {
+				return fmt.Errorf("failed to decode statement: closing before opening balance")
+			}
+			statement.ClosingBalance = line.Balance
+			return w.End(statement)
+		case statementLineError:
+			return fmt.Errorf("failed to read statement: %w", errStatementTruncated)
+		default:
+			return fmt.Errorf("failed to decode statement: unknown line type %q", line.Type)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package walletservice
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/shkov/wallet-service/internal/account"
+)
+
+const tracerName = "github.com/shkov/wallet-service/internal/walletservice"
+
+// tracingMiddleware wraps the given Service and records spans.
+type tracingMiddleware struct {
+	next   Service
+	tracer trace.Tracer
+}
+
+// NewTracingMiddleware creates a new tracing middleware.
+func NewTracingMiddleware(next Service) Service {
+	return &tracingMiddleware{
+		next:   next,
+		tracer: otel.Tracer(tracerName),
+	}
+}
+
+func (mw *tracingMiddleware) ApplyPayment(ctx context.Context, p *account.PaymentRequest) (*account.Payment, error) {
+	ctx, span := mw.tracer.Start(ctx, "Service.ApplyPayment", trace.WithAttributes(
+		attribute.Int64("payment.from", p.From),
+		attribute.Int64("payment.to", p.To),
+		attribute.Int("payment.legs", len(p.Legs)),
+	))
+	out, err := mw.next.ApplyPayment(ctx, p)
+	if out != nil {
+		span.SetAttributes(attribute.Int64("payment.id", out.ID))
+	}
+	finishSpan(span, err)
+	return out, err
+}
+
+func (mw *tracingMiddleware) GetPayments(ctx context.Context, f *account.PaymentFilter) ([]*account.Payment, error) {
+	ctx, span := mw.tracer.Start(ctx, "Service.GetPayments", trace.WithAttributes(
+		attribute.Int64("account.id", f.AccountID),
+	))
+	out, err := mw.next.GetPayments(ctx, f)
+	finishSpan(span, err)
+	return out, err
+}
+
+func (mw *tracingMiddleware) GetAccount(ctx context.Context, id int64) (*account.Account, error) {
+	ctx, span := mw.tracer.Start(ctx, "Service.GetAccount", trace.WithAttributes(
+		attribute.Int64("account.id", id),
+	))
+	out, err := mw.next.GetAccount(ctx, id)
+	finishSpan(span, err)
+	return out, err
+}
+
+func (mw *tracingMiddleware) GetAccountBalanceAt(ctx context.Context, id int64, at time.Time) (*account.Account, error) {
+	ctx, span := mw.tracer.Start(ctx, "Service.GetAccountBalanceAt", trace.WithAttributes(
+		attribute.Int64("account.id", id),
+		attribute.String("balance.as_of", at.UTC().Format(time.RFC3339)),
+	))
+	out, err := mw.next.GetAccountBalanceAt(ctx, id, at)
+	finishSpan(span, err)
+	return out, err
+}
+
+func (mw *tracingMiddleware) ListAccounts(ctx context.Context, r *account.ListRequest) (*account.Page, error) {
+	ctx, span := mw.tracer.Start(ctx, "Service.ListAccounts", trace.WithAttributes(
+		attribute.String("accounts.sort", r.Sort),
+		attribute.Int("accounts.limit", r.Limit),
+	))
+	out, err := mw.next.ListAccounts(ctx, r)
+	finishSpan(span, err)
+	return out, err
+}
+
+func (mw *tracingMiddleware) UpdateAccount(ctx context.Context, r *account.UpdateRequest) (*account.Account, error) {
+	ctx, span := mw.tracer.Start(ctx, "Service.UpdateAccount", trace.WithAttributes(
+		attribute.Int64("account.id", r.ID),
+		attribute.Int64("account.version", r.Version),
+	))
+	out, err := mw.next.UpdateAccount(ctx, r)
+	finishSpan(span, err)
+	return out, err
+}
+
+func (mw *tracingMiddleware) ConfirmPayment(ctx context.Context, id int64) (*account.Payment, error) {
+	ctx, span := mw.tracer.Start(ctx, "Service.ConfirmPayment", trace.WithAttributes(
+		attribute.Int64("payment.id", id),
+	))
+	out, err := mw.next.ConfirmPayment(ctx, id)
+	finishSpan(span, err)
+	return out, err
+}
+
+func (mw *tracingMiddleware) CancelPayment(ctx context.Context, id int64) (*account.Payment, error) {
+	ctx, span := mw.tracer.Start(ctx, "Service.CancelPayment", trace.WithAttributes(
+		attribute.Int64("payment.id", id),
+	))
+	out, err := mw.next.CancelPayment(ctx, id)
+	finishSpan(span, err)
+	return out, err
+}
+
+func (mw *tracingMiddleware) GetStatement(ctx context.Context, r *account.StatementRequest, w account.StatementWriter) error {
+	ctx, span := mw.tracer.Start(ctx, "Service.GetStatement", trace.WithAttributes(
+		attribute.Int64("account.id", r.AccountID),
+	))
+	err := mw.next.GetStatement(ctx, r, w)
+	finishSpan(span, err)
+	return err
+}
+
+func (mw *tracingMiddleware) Deposit(ctx context.Context, r *account.FundsRequest) (*account.Payment, error) {
+	ctx, span := mw.tracer.Start(ctx, "Service.Deposit", trace.WithAttributes(
+		attribute.Int64("account.id", r.AccountID),
+	))
+	out, err := mw.next.Deposit(ctx, r)
+	finishSpan(span, err)
+	return out, err
+}
+
+func (mw *tracingMiddleware) Withdraw(ctx context.Context, r *account.FundsRequest) (*account.Payment, error) {
+	ctx, span := mw.tracer.Start(ctx, "Service.Withdraw", trace.WithAttributes(
+		attribute.Int64("account.id", r.AccountID),
+	))
+	out, err := mw.next.Withdraw(ctx, r)
+	finishSpan(span, err)
+	return out, err
+}
+
+func (mw *tracingMiddleware) ProposeAdjustment(ctx context.Context, r *account.AdjustmentRequest) (*account.Adjustment, error) {
+	ctx, span := mw.tracer.Start(ctx, "Service.ProposeAdjustment", trace.WithAttributes(
+		attribute.Int64("account.id", r.AccountID),
+		attribute.String("adjustment.direction", r.Direction),
+	))
+	out, err := mw.next.ProposeAdjustment(ctx, r)
+	finishSpan(span, err)
+	return out, err
+}
+
+func (mw *tracingMiddleware) ApproveAdjustment(ctx context.Context, id int64) (*account.Adjustment, error) {
+	ctx, span := mw.tracer.Start(ctx, "Service.ApproveAdjustment", trace.WithAttributes(
+		attribute.Int64("adjustment.id", id),
+	))
+	out, err := mw.next.ApproveAdjustment(ctx, id)
+	finishSpan(span, err)
+	return out, err
+}
+
+func (mw *tracingMiddleware) RejectAdjustment(ctx context.Context, id int64) (*account.Adjustment, error) {
+	ctx, span := mw.tracer.Start(ctx, "Service.RejectAdjustment", trace.WithAttributes(
+		attribute.Int64("adjustment.id", id),
+	))
+	out, err := mw.next.RejectAdjustment(ctx, id)
+	finishSpan(span, err)
+	return out, err
+}
+
+func (mw *tracingMiddleware) GetAdjustments(ctx context.Context, accountID int64) ([]*account.Adjustment, error) {
+	ctx, span := mw.tracer.Start(ctx, "Service.GetAdjustments", trace.WithAttributes(
+		attribute.Int64("account.id", accountID),
+	))
+	out, err := mw.next.GetAdjustments(ctx, accountID)
+	finishSpan(span, err)
+	return out, err
+}
+
+func finishSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// startHTTPSpan extracts the trace context of the caller and starts the server span of the request.
+func startHTTPSpan(ctx context.Context, r *http.Request) context.Context {
+	route := r.URL.Path
+	if cr := mux.CurrentRoute(r); cr != nil {
+		if tmpl, err := cr.GetPathTemplate(); err == nil {
+			route = tmpl
+		}
+	}
+
+	ctx = otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(r.Header))
+	ctx, _ = otel.Tracer(tracerName).Start(ctx, r.Method+" "+route,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(semconv.HTTPServerAttributesFromHTTPRequest("walletservice", route, r)...),
+	)
+	return ctx
+}
+
+// finishHTTPSpan ends the server span started by startHTTPSpan.
+func finishHTTPSpan(ctx context.Context, code int, r *http.Request) {
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(semconv.HTTPAttributesFromHTTPStatusCode(code)...)
+	span.SetStatus(semconv.SpanStatusFromHTTPStatusCodeAndSpanKind(code, trace.SpanKindServer))
+	span.End()
+}
+
+// injectTraceContext propagates the trace context of the call to the service.
+func injectTraceContext(ctx context.Context, r *http.Request) context.Context {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(r.Header))
+	return ctx
+}
@@ -4,13 +4,19 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/shkov/wallet-service/internal/account"
+	"github.com/shkov/wallet-service/internal/ratelimit"
 )
 
 type applyPaymentRequest struct {
@@ -37,6 +43,7 @@ func decodeApplyPaymentRequest(ctx context.Context, r *http.Request) (interface{
 	if err := json.NewDecoder(r.Body).Decode(paymentRequest); err != nil {
 		return nil, errBadRequest("failed to decode json request: %v", err)
 	}
+	logAccountIDs(ctx, paymentRequest.From, paymentRequest.To)
 	return applyPaymentRequest{paymentRequest: paymentRequest}, nil
 }
 
@@ -60,17 +67,203 @@ func decodeApplyPaymentResponse(ctx context.Context, r *http.Response) (interfac
 	return applyPaymentResponse{payment: payment}, nil
 }
 
+type fundsRequest struct {
+	fundsRequest *account.FundsRequest
+}
+
+func encodeDepositRequest(ctx context.Context, r *http.Request, request interface{}) error {
+	return encodeFundsRequest(r, "/api/v1/deposits", request.(fundsRequest))
+}
+
+func encodeWithdrawalRequest(ctx context.Context, r *http.Request, request interface{}) error {
+	return encodeFundsRequest(r, "/api/v1/withdrawals", request.(fundsRequest))
+}
+
+func encodeFundsRequest(r *http.Request, path string, req fundsRequest) error {
+	r.URL.Path = path
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(req.fundsRequest); err != nil {
+		return err
+	}
+	r.Body = ioutil.NopCloser(&buf)
+	return nil
+}
+
+func decodeFundsRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	req := &account.FundsRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return nil, errBadRequest("failed to decode json request: %v", err)
+	}
+	logAccountIDs(ctx, req.AccountID)
+	return fundsRequest{fundsRequest: req}, nil
+}
+
+// Actions settling a pending payment, they are the last element of the request path.
+const (
+	settleActionConfirm = "confirm"
+	settleActionCancel  = "cancel"
+)
+
+type settlePaymentRequest struct {
+	id     int64
+	action string
+}
+
+func encodeSettlePaymentRequest(ctx context.Context, r *http.Request, request interface{}) error {
+	req := request.(settlePaymentRequest)
+	r.URL.Path = "/api/v1/payments/" + strconv.FormatInt(req.id, 10) + "/" + req.action
+	return nil
+}
+
+func decodeSettlePaymentRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		return nil, errBadRequest("failed to parse payment id: %v", err)
+	}
+	return settlePaymentRequest{id: id, action: mux.Vars(r)["action"]}, nil
+}
+
+type proposeAdjustmentRequest struct {
+	adjustmentRequest *account.AdjustmentRequest
+}
+
+type adjustmentResponse struct {
+	adjustment *account.Adjustment
+}
+
+func encodeProposeAdjustmentRequest(ctx context.Context, r *http.Request, request interface{}) error {
+	req := request.(proposeAdjustmentRequest)
+	r.URL.Path = "/api/v1/admin/accounts/" + strconv.FormatInt(req.adjustmentRequest.AccountID, 10) + "/adjustments"
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(req.adjustmentRequest); err != nil {
+		return err
+	}
+	r.Body = ioutil.NopCloser(&buf)
+	return nil
+}
+
+func decodeProposeAdjustmentRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	accountID, err := strconv.ParseInt(mux.Vars(r)["account_id"], 10, 64)
+	if err != nil {
+		return nil, errBadRequest("failed to parse account id: %v", err)
+	}
+	logAccountIDs(ctx, accountID)
+
+	adjustmentRequest := &account.AdjustmentRequest{}
+	if err := json.NewDecoder(r.Body).Decode(adjustmentRequest); err != nil {
+		return nil, errBadRequest("failed to decode json request: %v", err)
+	}
+	adjustmentRequest.AccountID = accountID
+	return proposeAdjustmentRequest{adjustmentRequest: adjustmentRequest}, nil
+}
+
+func encodeAdjustmentResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	resp := response.(adjustmentResponse)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp.adjustment); err != nil {
+		return errInternal("failed to encode json response: %v", err)
+	}
+	return nil
+}
+
+func decodeAdjustmentResponse(ctx context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode != http.StatusOK {
+		return nil, decodeError(r)
+	}
+	adjustment := &account.Adjustment{}
+	if err := json.NewDecoder(r.Body).Decode(adjustment); err != nil {
+		return nil, fmt.Errorf("failed to decode json response: %w", err)
+	}
+	return adjustmentResponse{adjustment: adjustment}, nil
+}
+
+// Actions reviewing a proposed adjustment, they are the last element of the request path.
+const (
+	reviewActionApprove = "approve"
+	reviewActionReject  = "reject"
+)
+
+type reviewAdjustmentRequest struct {
+	id     int64
+	action string
+}
+
+func encodeReviewAdjustmentRequest(ctx context.Context, r *http.Request, request interface{}) error {
+	req := request.(reviewAdjustmentRequest)
+	r.URL.Path = "/api/v1/admin/adjustments/" + strconv.FormatInt(req.id, 10) + "/" + req.action
+	return nil
+}
+
+func decodeReviewAdjustmentRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		return nil, errBadRequest("failed to parse adjustment id: %v", err)
+	}
+	return reviewAdjustmentRequest{id: id, action: mux.Vars(r)["action"]}, nil
+}
+
+type getAdjustmentsRequest struct {
+	accountID int64
+}
+
+type getAdjustmentsResponse struct {
+	adjustments []*account.Adjustment
+}
+
+func encodeGetAdjustmentsRequest(ctx context.Context, r *http.Request, request interface{}) error {
+	req := request.(getAdjustmentsRequest)
+	r.URL.Path = "/api/v1/admin/accounts/" + strconv.FormatInt(req.accountID, 10) + "/adjustments"
+	return nil
+}
+
+func decodeGetAdjustmentsRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	accountID, err := strconv.ParseInt(mux.Vars(r)["account_id"], 10, 64)
+	if err != nil {
+		return nil, errBadRequest("failed to parse account id: %v", err)
+	}
+	logAccountIDs(ctx, accountID)
+	return getAdjustmentsRequest{accountID: accountID}, nil
+}
+
+func encodeGetAdjustmentsResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	resp := response.(getAdjustmentsResponse)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp.adjustments); err != nil {
+		return errInternal("failed to encode json response: %v", err)
+	}
+	return nil
+}
+
+func decodeGetAdjustmentsResponse(ctx context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode != http.StatusOK {
+		return nil, decodeError(r)
+	}
+	resp := getAdjustmentsResponse{}
+	if err := json.NewDecoder(r.Body).Decode(&resp.adjustments); err != nil {
+		return nil, fmt.Errorf("failed to decode json response: %w", err)
+	}
+	return resp, nil
+}
+
 type getAccountRequest struct {
 	id int64
+	// asOf asks for the balance at the given time, zero means the current balance.
+	asOf time.Time
 }
 
 type getAccountResponse struct {
 	account *account.Account
+	// past tells that the account has the balance at a past time, it gets no ETag as its details
+	// may have changed since.
+	past bool
 }
 
 func encodeGetAccountRequest(ctx context.Context, r *http.Request, request interface{}) error {
 	req := request.(getAccountRequest)
 	r.URL.Path = "/api/v1/accounts/" + strconv.FormatInt(req.id, 10)
+	if !req.asOf.IsZero() {
+		r.URL.RawQuery = url.Values{"as_of": {req.asOf.Format(time.RFC3339Nano)}}.Encode()
+	}
 	return nil
 }
 
@@ -79,12 +272,24 @@ func decodeGetAccountRequest(ctx context.Context, r *http.Request) (interface{},
 	if err != nil {
 		return nil, errBadRequest("failed to parse account id: %v", err)
 	}
-	return getAccountRequest{id: id}, nil
+	logAccountIDs(ctx, id)
+
+	var asOf time.Time
+	if s := r.URL.Query().Get("as_of"); s != "" {
+		asOf, err = time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return nil, errBadRequest("failed to parse as_of: %v", err)
+		}
+	}
+	return getAccountRequest{id: id, asOf: asOf}, nil
 }
 
 func encodeGetAccountResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
 	resp := response.(getAccountResponse)
 	w.Header().Set("Content-Type", "application/json")
+	if !resp.past {
+		w.Header().Set("ETag", formatETag(resp.account.Version))
+	}
 	if err := json.NewEncoder(w).Encode(resp.account); err != nil {
 		return errInternal("failed to encode json response: %v", err)
 	}
@@ -102,8 +307,199 @@ func decodeGetAccountResponse(ctx context.Context, r *http.Response) (interface{
 	return resp, nil
 }
 
+type updateAccountRequest struct {
+	updateRequest *account.UpdateRequest
+}
+
+func encodeUpdateAccountRequest(ctx context.Context, r *http.Request, request interface{}) error {
+	req := request.(updateAccountRequest).updateRequest
+	r.URL.Path = "/api/v1/accounts/" + strconv.FormatInt(req.ID, 10)
+	r.Header.Set("Content-Type", "application/merge-patch+json")
+	r.Header.Set("If-Match", formatETag(req.Version))
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(req); err != nil {
+		return err
+	}
+	r.Body = ioutil.NopCloser(&buf)
+	return nil
+}
+
+func decodeUpdateAccountRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		return nil, errBadRequest("failed to parse account id: %v", err)
+	}
+	logAccountIDs(ctx, id)
+
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		return nil, errPreconditionRequired("If-Match header with the account ETag is required")
+	}
+	version, ok := parseETag(ifMatch)
+	if !ok {
+		return nil, errPreconditionFailed("If-Match %s: %w", ifMatch, account.ErrVersionMismatch)
+	}
+
+	updateRequest := &account.UpdateRequest{}
+	if err := json.NewDecoder(r.Body).Decode(updateRequest); err != nil {
+		return nil, errBadRequest("failed to decode json request: %v", err)
+	}
+	updateRequest.ID = id
+	updateRequest.Version = version
+	return updateAccountRequest{updateRequest: updateRequest}, nil
+}
+
+// formatETag returns the ETag of the account version. It is weak as the version covers only the details
+// of the account, its balance changes without changing the version.
+func formatETag(version int64) string {
+	return `W/"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// parseETag returns the account version of the ETag, weak or strong.
+func parseETag(etag string) (int64, bool) {
+	etag = strings.TrimPrefix(etag, "W/")
+	if len(etag) < 2 || etag[0] != '"' || etag[len(etag)-1] != '"' {
+		return 0, false
+	}
+	version, err := strconv.ParseInt(etag[1:len(etag)-1], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return version, true
+}
+
+type listAccountsRequest struct {
+	listRequest *account.ListRequest
+}
+
+type listAccountsResponse struct {
+	page *account.Page
+}
+
+func encodeListAccountsRequest(ctx context.Context, r *http.Request, request interface{}) error {
+	req := request.(listAccountsRequest).listRequest
+	r.URL.Path = "/api/v1/accounts"
+	q := url.Values{}
+	setQuery := func(key, value string) {
+		if value != "" {
+			q.Set(key, value)
+		}
+	}
+	setQuery("status", req.Status)
+	setQuery("type", req.Type)
+	setQuery("owner_ref", req.OwnerRef)
+	setQuery("min_balance", req.MinBalance)
+	setQuery("max_balance", req.MaxBalance)
+	if !req.CreatedFrom.IsZero() {
+		q.Set("created_from", req.CreatedFrom.Format(time.RFC3339Nano))
+	}
+	if !req.CreatedTo.IsZero() {
+		q.Set("created_to", req.CreatedTo.Format(time.RFC3339Nano))
+	}
+	encodeLabels(q, req.Labels)
+	setQuery("sort", req.Sort)
+	if req.Limit != 0 {
+		q.Set("limit", strconv.Itoa(req.Limit))
+	}
+	if req.After != nil {
+		cursor, err := req.After.MarshalText()
+		if err != nil {
+			return err
+		}
+		q.Set("cursor", string(cursor))
+	}
+	r.URL.RawQuery = q.Encode()
+	return nil
+}
+
+func decodeListAccountsRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	q := r.URL.Query()
+	req := &account.ListRequest{
+		Status:     q.Get("status"),
+		Type:       q.Get("type"),
+		OwnerRef:   q.Get("owner_ref"),
+		MinBalance: q.Get("min_balance"),
+		MaxBalance: q.Get("max_balance"),
+		Sort:       q.Get("sort"),
+	}
+
+	var err error
+	req.CreatedFrom, err = parseStatementTime(q.Get("created_from"))
+	if err != nil {
+		return nil, errBadRequest("failed to parse created_from: %v", err)
+	}
+	req.CreatedTo, err = parseStatementTime(q.Get("created_to"))
+	if err != nil {
+		return nil, errBadRequest("failed to parse created_to: %v", err)
+	}
+
+	req.Labels, err = decodeLabels(q["label"])
+	if err != nil {
+		return nil, err
+	}
+
+	if s := q.Get("limit"); s != "" {
+		req.Limit, err = strconv.Atoi(s)
+		if err != nil {
+			return nil, errBadRequest("failed to parse limit: %v", err)
+		}
+	}
+
+	if s := q.Get("cursor"); s != "" {
+		req.After = &account.Cursor{}
+		if err := req.After.UnmarshalText([]byte(s)); err != nil {
+			return nil, errBadRequest("failed to parse cursor: %w", err)
+		}
+	}
+
+	return listAccountsRequest{listRequest: req}, nil
+}
+
+// encodeLabels adds the metadata labels filter to the query as the key:value pairs.
+func encodeLabels(q url.Values, labels map[string]string) {
+	for key, value := range labels {
+		q.Add("label", key+":"+value)
+	}
+}
+
+// decodeLabels parses the key:value pairs of the metadata labels filter.
+func decodeLabels(values []string) (map[string]string, error) {
+	var labels map[string]string
+	for _, label := range values {
+		i := strings.IndexByte(label, ':')
+		if i <= 0 {
+			return nil, errBadRequest("label %q must be key:value", label)
+		}
+		if labels == nil {
+			labels = make(map[string]string)
+		}
+		labels[label[:i]] = label[i+1:]
+	}
+	return labels, nil
+}
+
+func encodeListAccountsResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	resp := response.(listAccountsResponse)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp.page); err != nil {
+		return errInternal("failed to encode json response: %v", err)
+	}
+	return nil
+}
+
+func decodeListAccountsResponse(ctx context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode != http.StatusOK {
+		return nil, decodeError(r)
+	}
+	resp := listAccountsResponse{}
+	if err := json.NewDecoder(r.Body).Decode(&resp.page); err != nil {
+		return nil, fmt.Errorf("failed to decode json response: %w", err)
+	}
+	return resp, nil
+}
+
 type getPaymentsRequest struct {
-	accountID int64
+	filter *account.PaymentFilter
 }
 
 type getPaymentsResponse struct {
@@ -112,7 +508,13 @@ type getPaymentsResponse struct {
 
 func encodeGetPaymentsRequest(ctx context.Context, r *http.Request, request interface{}) error {
 	req := request.(getPaymentsRequest)
-	r.URL.Path = "/api/v1/payments/" + strconv.FormatInt(req.accountID, 10)
+	r.URL.Path = "/api/v1/payments/" + strconv.FormatInt(req.filter.AccountID, 10)
+	q := url.Values{}
+	if req.filter.ExternalRef != "" {
+		q.Set("external_ref", req.filter.ExternalRef)
+	}
+	encodeLabels(q, req.filter.Labels)
+	r.URL.RawQuery = q.Encode()
 	return nil
 }
 
@@ -121,7 +523,18 @@ func decodeGetPaymentsRequest(ctx context.Context, r *http.Request) (interface{}
 	if err != nil {
 		return nil, errBadRequest("failed to parse account id: %v", err)
 	}
-	return getPaymentsRequest{accountID: accountID}, nil
+	logAccountIDs(ctx, accountID)
+
+	q := r.URL.Query()
+	labels, err := decodeLabels(q["label"])
+	if err != nil {
+		return nil, err
+	}
+	return getPaymentsRequest{filter: &account.PaymentFilter{
+		AccountID:   accountID,
+		ExternalRef: q.Get("external_ref"),
+		Labels:      labels,
+	}}, nil
 }
 
 func encodeGetPaymentsResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
@@ -144,13 +557,106 @@ func decodeGetPaymentsResponse(ctx context.Context, r *http.Response) (interface
 	return resp, nil
 }
 
+type getStatementRequest struct {
+	statementRequest *account.StatementRequest
+	format           string
+}
+
+// getStatementResponse defers reading the statement until the response is written, so that it is streamed.
+type getStatementResponse struct {
+	format string
+	write  func(w account.StatementWriter) error
+	// body is the statement read by the client.
+	body io.ReadCloser
+}
+
+func encodeGetStatementRequest(ctx context.Context, r *http.Request, request interface{}) error {
+	req := request.(getStatementRequest)
+	r.URL.Path = "/api/v1/accounts/" + strconv.FormatInt(req.statementRequest.AccountID, 10) + "/statement"
+	q := url.Values{}
+	q.Set("format", statementFormatJSONL)
+	if !req.statementRequest.From.IsZero() {
+		q.Set("from", req.statementRequest.From.Format(time.RFC3339))
+	}
+	if !req.statementRequest.To.IsZero() {
+		q.Set("to", req.statementRequest.To.Format(time.RFC3339))
+	}
+	r.URL.RawQuery = q.Encode()
+	return nil
+}
+
+func decodeGetStatementRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		return nil, errBadRequest("failed to parse account id: %v", err)
+	}
+	logAccountIDs(ctx, id)
+
+	q := r.URL.Query()
+	format := q.Get("format")
+	if format == "" {
+		format = statementFormatCSV
+	}
+	if _, ok := statementContentTypes[format]; !ok {
+		return nil, errBadRequest("unknown statement format %q", format)
+	}
+	from, err := parseStatementTime(q.Get("from"))
+	if err != nil {
+		return nil, errBadRequest("failed to parse from: %v", err)
+	}
+	to, err := parseStatementTime(q.Get("to"))
+	if err != nil {
+		return nil, errBadRequest("failed to parse to: %v", err)
+	}
+
+	return getStatementRequest{
+		statementRequest: &account.StatementRequest{AccountID: id, From: from, To: to},
+		format:           format,
+	}, nil
+}
+
+func encodeGetStatementResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	resp := response.(getStatementResponse)
+	enc := newStatementEncoder(w, resp.format)
+	err := resp.write(enc)
+	if err == nil {
+		return nil
+	}
+	if !enc.sent {
+		enc.Discard()
+		encodeError(ctx, err, w)
+		return nil
+	}
+
+	// the status of the partly sent statement is already written, so it is ended with the error line.
+	logResponseError(ctx, err)
+	if err = enc.Fail(); err != nil {
+		logResponseError(ctx, err)
+	}
+	return nil
+}
+
+func decodeGetStatementResponse(ctx context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode != http.StatusOK {
+		defer r.Body.Close()
+		return nil, decodeError(r)
+	}
+	return getStatementResponse{body: r.Body}, nil
+}
+
 func encodeError(ctx context.Context, err error, w http.ResponseWriter) {
+	var limitErr *ratelimit.Error
+	if errors.As(err, &limitErr) {
+		setRateLimitHeaders(w.Header(), limitErr.Result)
+		err = errTooManyRequests("%v", limitErr)
+	}
+
 	e, ok := err.(*serviceError)
 	if !ok {
-		e = &serviceError{
-			code:    http.StatusInternalServerError,
-			Message: err.Error(),
-		}
+		e = newServiceError(http.StatusInternalServerError, "%v", err)
+	}
+	if e.code == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="wallet-service"`)
 	}
 	e.Encode(w)
 }
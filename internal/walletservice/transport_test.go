@@ -1,47 +1,169 @@
 package walletservice
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/go-kit/kit/log"
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/shkov/wallet-service/internal/account"
+	"github.com/shkov/wallet-service/internal/auth"
+	"github.com/shkov/wallet-service/internal/ratelimit"
+	"github.com/shkov/wallet-service/internal/requestid"
 )
 
 type mockService struct {
 	onApplyPayment func(ctx context.Context, p *account.PaymentRequest) (*account.Payment, error)
-	onGetPayments  func(ctx context.Context, accountID int64) ([]*account.Payment, error)
+	onGetPayments  func(ctx context.Context, f *account.PaymentFilter) ([]*account.Payment, error)
 	onGetAccount   func(ctx context.Context, id int64) (*account.Account, error)
+	onGetBalanceAt func(ctx context.Context, id int64, at time.Time) (*account.Account, error)
+	onListAccounts func(ctx context.Context, r *account.ListRequest) (*account.Page, error)
+	onUpdate       func(ctx context.Context, r *account.UpdateRequest) (*account.Account, error)
+	onGetStatement func(ctx context.Context, r *account.StatementRequest, w account.StatementWriter) error
+	onConfirm      func(ctx context.Context, id int64) (*account.Payment, error)
+	onCancel       func(ctx context.Context, id int64) (*account.Payment, error)
+	onPropose      func(ctx context.Context, r *account.AdjustmentRequest) (*account.Adjustment, error)
+	onApprove      func(ctx context.Context, id int64) (*account.Adjustment, error)
+	onReject       func(ctx context.Context, id int64) (*account.Adjustment, error)
+	onAdjustments  func(ctx context.Context, accountID int64) ([]*account.Adjustment, error)
+	onDeposit      func(ctx context.Context, r *account.FundsRequest) (*account.Payment, error)
+	onWithdraw     func(ctx context.Context, r *account.FundsRequest) (*account.Payment, error)
 }
 
 func (m *mockService) ApplyPayment(ctx context.Context, p *account.PaymentRequest) (*account.Payment, error) {
 	return m.onApplyPayment(ctx, p)
 }
 
-func (m *mockService) GetPayments(ctx context.Context, accountID int64) ([]*account.Payment, error) {
-	return m.onGetPayments(ctx, accountID)
+func (m *mockService) GetPayments(ctx context.Context, f *account.PaymentFilter) ([]*account.Payment, error) {
+	return m.onGetPayments(ctx, f)
 }
 
 func (m *mockService) GetAccount(ctx context.Context, id int64) (*account.Account, error) {
 	return m.onGetAccount(ctx, id)
 }
 
+func (m *mockService) GetAccountBalanceAt(ctx context.Context, id int64, at time.Time) (*account.Account, error) {
+	return m.onGetBalanceAt(ctx, id, at)
+}
+
+func (m *mockService) ListAccounts(ctx context.Context, r *account.ListRequest) (*account.Page, error) {
+	return m.onListAccounts(ctx, r)
+}
+
+func (m *mockService) UpdateAccount(ctx context.Context, r *account.UpdateRequest) (*account.Account, error) {
+	return m.onUpdate(ctx, r)
+}
+
+func (m *mockService) ConfirmPayment(ctx context.Context, id int64) (*account.Payment, error) {
+	return m.onConfirm(ctx, id)
+}
+
+func (m *mockService) CancelPayment(ctx context.Context, id int64) (*account.Payment, error) {
+	return m.onCancel(ctx, id)
+}
+
+func (m *mockService) GetStatement(ctx context.Context, r *account.StatementRequest, w account.StatementWriter) error {
+	return m.onGetStatement(ctx, r, w)
+}
+
+func (m *mockService) ProposeAdjustment(ctx context.Context, r *account.AdjustmentRequest) (*account.Adjustment, error) {
+	return m.onPropose(ctx, r)
+}
+
+func (m *mockService) ApproveAdjustment(ctx context.Context, id int64) (*account.Adjustment, error) {
+	return m.onApprove(ctx, id)
+}
+
+func (m *mockService) RejectAdjustment(ctx context.Context, id int64) (*account.Adjustment, error) {
+	return m.onReject(ctx, id)
+}
+
+func (m *mockService) GetAdjustments(ctx context.Context, accountID int64) ([]*account.Adjustment, error) {
+	return m.onAdjustments(ctx, accountID)
+}
+
+func (m *mockService) Deposit(ctx context.Context, r *account.FundsRequest) (*account.Payment, error) {
+	return m.onDeposit(ctx, r)
+}
+
+func (m *mockService) Withdraw(ctx context.Context, r *account.FundsRequest) (*account.Payment, error) {
+	return m.onWithdraw(ctx, r)
+}
+
+// testAPIKeys are the API keys accepted by the transport tests.
+var testAPIKeys = map[string]*auth.Principal{
+	"full-access": {
+		Subject:    "apikey:1",
+		Scopes:     []string{auth.ScopeAccountsRead, auth.ScopeAccountsWrite, auth.ScopePaymentsRead, auth.ScopePaymentsWrite, auth.ScopePaymentsReview, auth.ScopeAdjustments},
+		AccountIDs: []int64{1},
+	},
+	"read-only": {
+		Subject:    "apikey:2",
+		Scopes:     []string{auth.ScopeAccountsRead, auth.ScopePaymentsRead},
+		AccountIDs: []int64{1},
+	},
+	"other-owner": {
+		Subject:    "apikey:3",
+		Scopes:     []string{auth.ScopePaymentsWrite},
+		AccountIDs: []int64{2},
+	},
+	"operator": {
+		Subject: "apikey:4",
+		Scopes:  []string{auth.ScopeAccountsRead, auth.ScopeAllAccounts},
+	},
+	"gateway": {
+		Subject: "apikey:5",
+		Scopes:  []string{auth.ScopeDeposits},
+	},
+	"account-admin": {
+		Subject: "apikey:6",
+		Scopes:  []string{auth.ScopeAccountsWrite, auth.ScopeAllAccounts, auth.ScopeAccountTypes},
+	},
+}
+
+var testAuthenticator = auth.AuthenticatorFunc(func(ctx context.Context, c auth.Credentials) (*auth.Principal, error) {
+	if c.APIKey == "" {
+		return nil, auth.ErrNoCredentials
+	}
+	p, ok := testAPIKeys[c.APIKey]
+	if !ok {
+		return nil, auth.ErrInvalidCredentials
+	}
+	return p, nil
+})
+
 // returns mocked server and http client and mocked service for transport testing.
 func initTransportTest(t *testing.T) (*httptest.Server, Service, *mockService) {
 	svc := &mockService{}
-	handler := makeHandler(svc)
+	handler := makeHandler(svc, testAuthenticator, newRateLimiter(RateLimitConfig{}))
 	server := httptest.NewServer(handler)
+	client := newTestClient(t, server, "full-access")
+	return server, client, svc
+}
+
+func newTestClient(t *testing.T, server *httptest.Server, apiKey string) Service {
 	client, err := NewClient(ClientConfig{
 		ServiceURL: server.URL,
 		Timeout:    time.Second,
+		APIKey:     apiKey,
 	})
 	if err != nil {
 		t.Fatal(err)
 	}
-	return server, client, svc
+	return client
 }
 
 func TestTransportApplyPayment(t *testing.T) {
@@ -64,13 +186,45 @@ func TestTransportApplyPayment(t *testing.T) {
 			name:     "some err",
 			request:  makePaymentRequest(t, nil),
 			response: nil,
-			err:      &serviceError{code: 500, Message: "kek some err occurs"},
+			err:      errInternal("kek some err occurs"),
+		},
+		{
+			name:     "domain err",
+			request:  makePaymentRequest(t, nil),
+			response: nil,
+			err:      errBadRequest("failed to apply payment to the sender: %w", account.ErrNotEnoughFunds),
+		},
+		{
+			name:     "validation err",
+			request:  makePaymentRequest(t, nil),
+			response: nil,
+			err:      errBadRequest("payment is invalid: %w", account.ErrFromAndToMustBeDifferent),
+		},
+		{
+			name: "split payment",
+			request: makePaymentRequest(t, func(pr *account.PaymentRequest) {
+				pr.To = 0
+				pr.Legs = []*account.PaymentLeg{{To: 2, Amount: "200"}, {To: 3, Amount: "300"}}
+			}),
+			response: makePayment(t, func(p *account.Payment) {
+				p.To = 0
+				p.Type = account.PaymentTypeSplit
+				p.Legs = []*account.PaymentLeg{{To: 2, Amount: "200"}, {To: 3, Amount: "300"}}
+			}),
+			err: nil,
+		},
+		{
+			name:     "legs sum err",
+			request:  makePaymentRequest(t, nil),
+			response: nil,
+			err:      errBadRequest("payment is invalid: %w", account.ErrLegsSumMismatch),
 		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			svc.onApplyPayment = func(ctx context.Context, p *account.PaymentRequest) (*account.Payment, error) {
+				assert.Equal(t, tc.request, p)
 				return tc.response, tc.err
 			}
 
@@ -81,6 +235,147 @@ func TestTransportApplyPayment(t *testing.T) {
 	}
 }
 
+func TestTransportSettlePayment(t *testing.T) {
+	server, client, svc := initTransportTest(t)
+	defer server.Close()
+
+	confirmed := makePayment(t, func(p *account.Payment) { p.ID = 7 })
+	cancelled := makePayment(t, func(p *account.Payment) {
+		p.ID = 8
+		p.Status = account.PaymentStatusCancelled
+	})
+	svc.onConfirm = func(ctx context.Context, id int64) (*account.Payment, error) {
+		if id != confirmed.ID {
+			return nil, errConflict("failed to settle payment %d: %w", id, account.ErrPaymentNotPending)
+		}
+		return confirmed, nil
+	}
+	svc.onCancel = func(ctx context.Context, id int64) (*account.Payment, error) {
+		assert.Equal(t, cancelled.ID, id)
+		return cancelled, nil
+	}
+
+	got, err := client.ConfirmPayment(context.Background(), 7)
+	assert.NoError(t, err)
+	assert.Equal(t, confirmed, got)
+
+	got, err = client.CancelPayment(context.Background(), 8)
+	assert.NoError(t, err)
+	assert.Equal(t, cancelled, got)
+
+	_, err = client.ConfirmPayment(context.Background(), 9)
+	assert.Equal(t, errConflict("failed to settle payment %d: %w", 9, account.ErrPaymentNotPending), err)
+
+	// the payments are reviewed only by the callers with the review scope.
+	_, err = newTestClient(t, server, "other-owner").ConfirmPayment(context.Background(), 7)
+	var serviceErr *serviceError
+	if assert.True(t, errors.As(err, &serviceErr)) {
+		assert.Equal(t, http.StatusForbidden, serviceErr.code)
+	}
+}
+
+func TestTransportFunds(t *testing.T) {
+	server, client, svc := initTransportTest(t)
+	defer server.Close()
+
+	deposit := makePayment(t, func(p *account.Payment) {
+		p.From = account.SystemAccountFunding
+		p.To = 2
+		p.Type = account.PaymentTypeDeposit
+	})
+	withdrawal := makePayment(t, func(p *account.Payment) {
+		p.To = account.SystemAccountPayouts
+		p.Type = account.PaymentTypeWithdrawal
+	})
+	svc.onDeposit = func(ctx context.Context, r *account.FundsRequest) (*account.Payment, error) {
+		assert.Equal(t, &account.FundsRequest{AccountID: 2, Amount: "500", ExternalRef: "bank:42"}, r)
+		return deposit, nil
+	}
+	svc.onWithdraw = func(ctx context.Context, r *account.FundsRequest) (*account.Payment, error) {
+		assert.Equal(t, &account.FundsRequest{AccountID: 1, Amount: "500", Fee: "1"}, r)
+		return withdrawal, nil
+	}
+
+	// the deposits are made only by the callers with the deposits scope.
+	_, err := client.Deposit(context.Background(), &account.FundsRequest{AccountID: 2, Amount: "500", ExternalRef: "bank:42"})
+	var serviceErr *serviceError
+	if assert.True(t, errors.As(err, &serviceErr)) {
+		assert.Equal(t, http.StatusForbidden, serviceErr.code)
+	}
+
+	got, err := newTestClient(t, server, "gateway").Deposit(context.Background(), &account.FundsRequest{AccountID: 2, Amount: "500", ExternalRef: "bank:42"})
+	assert.NoError(t, err)
+	assert.Equal(t, deposit, got)
+
+	got, err = client.Withdraw(context.Background(), &account.FundsRequest{AccountID: 1, Amount: "500", Fee: "1"})
+	assert.NoError(t, err)
+	assert.Equal(t, withdrawal, got)
+
+	// the caller withdraws only from the owned accounts.
+	_, err = client.Withdraw(context.Background(), &account.FundsRequest{AccountID: 2, Amount: "500"})
+	if assert.True(t, errors.As(err, &serviceErr)) {
+		assert.Equal(t, http.StatusForbidden, serviceErr.code)
+	}
+}
+
+func TestTransportAdjustments(t *testing.T) {
+	server, client, svc := initTransportTest(t)
+	defer server.Close()
+
+	proposed := &account.Adjustment{
+		ID:         3,
+		AccountID:  1,
+		Direction:  account.AdjustmentCredit,
+		Amount:     "10.5",
+		Reason:     "duplicate fee",
+		Status:     account.AdjustmentStatusProposed,
+		ProposedBy: "apikey:1",
+		ProposedAt: parseTime(t, "2001-01-03T10:00:00Z"),
+	}
+	svc.onPropose = func(ctx context.Context, r *account.AdjustmentRequest) (*account.Adjustment, error) {
+		assert.Equal(t, &account.AdjustmentRequest{AccountID: 1, Direction: account.AdjustmentCredit, Amount: "10.5", Reason: "duplicate fee"}, r)
+		return proposed, nil
+	}
+	svc.onApprove = func(ctx context.Context, id int64) (*account.Adjustment, error) {
+		return nil, errForbidden("failed to review adjustment %d: %w", id, account.ErrAdjustmentSelfReview)
+	}
+	svc.onReject = func(ctx context.Context, id int64) (*account.Adjustment, error) {
+		assert.Equal(t, proposed.ID, id)
+		return proposed, nil
+	}
+	svc.onAdjustments = func(ctx context.Context, accountID int64) ([]*account.Adjustment, error) {
+		assert.Equal(t, int64(1), accountID)
+		return []*account.Adjustment{proposed}, nil
+	}
+
+	got, err := client.ProposeAdjustment(context.Background(), &account.AdjustmentRequest{
+		AccountID: 1,
+		Direction: account.AdjustmentCredit,
+		Amount:    "10.5",
+		Reason:    "duplicate fee",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, proposed, got)
+
+	_, err = client.ApproveAdjustment(context.Background(), 3)
+	assert.Equal(t, errForbidden("failed to review adjustment %d: %w", 3, account.ErrAdjustmentSelfReview), err)
+
+	got, err = client.RejectAdjustment(context.Background(), 3)
+	assert.NoError(t, err)
+	assert.Equal(t, proposed, got)
+
+	history, err := client.GetAdjustments(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []*account.Adjustment{proposed}, history)
+
+	// the adjustments are managed only by the callers with the adjustments scope.
+	_, err = newTestClient(t, server, "operator").GetAdjustments(context.Background(), 1)
+	var serviceErr *serviceError
+	if assert.True(t, errors.As(err, &serviceErr)) {
+		assert.Equal(t, http.StatusForbidden, serviceErr.code)
+	}
+}
+
 func TestTransportGetAccount(t *testing.T) {
 	server, client, svc := initTransportTest(t)
 	defer server.Close()
@@ -101,10 +396,7 @@ func TestTransportGetAccount(t *testing.T) {
 			name:     "some err",
 			id:       1,
 			response: nil,
-			err: &serviceError{
-				code:    500,
-				Message: "kek some err occurs",
-			},
+			err:      errInternal("kek some err occurs"),
 		},
 	}
 
@@ -119,6 +411,266 @@ func TestTransportGetAccount(t *testing.T) {
 			assert.Equal(t, tc.response, gotResp)
 		})
 	}
+
+	t.Run("not an owner", func(t *testing.T) {
+		svc.onGetAccount = func(ctx context.Context, id int64) (*account.Account, error) {
+			return makeAccount(t, func(a *account.Account) { a.ID = id }), nil
+		}
+		for key, wantCode := range map[string]int{"read-only": http.StatusForbidden, "operator": http.StatusOK} {
+			_, err := newTestClient(t, server, key).GetAccount(context.Background(), 2)
+			if wantCode == http.StatusOK {
+				assert.NoError(t, err, key)
+				continue
+			}
+			serviceErr, ok := err.(*serviceError)
+			if assert.True(t, ok, key) {
+				assert.Equal(t, wantCode, serviceErr.code, key)
+			}
+		}
+	})
+}
+
+func TestTransportGetAccountBalanceAt(t *testing.T) {
+	server, client, svc := initTransportTest(t)
+	defer server.Close()
+
+	asOf := parseTime(t, "2001-03-31T23:59:59.123456+03:00")
+
+	testCases := []struct {
+		name     string
+		response *account.Account
+		err      error
+	}{
+		{
+			name:     "ok",
+			response: makeAccount(t, func(a *account.Account) { a.Balance = "925.50" }),
+			err:      nil,
+		},
+		{
+			name:     "in the future",
+			response: nil,
+			err:      errBadRequest("balance time is invalid: %w", account.ErrBalanceTimeInFuture),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			svc.onGetBalanceAt = func(ctx context.Context, id int64, at time.Time) (*account.Account, error) {
+				assert.Equal(t, int64(1), id)
+				assert.True(t, asOf.Equal(at), "got as_of %v", at)
+				return tc.response, tc.err
+			}
+
+			gotResp, gotErr := client.GetAccountBalanceAt(context.Background(), 1, asOf)
+			if tc.err != nil {
+				assert.True(t, errors.Is(gotErr, ErrInvalidRequest))
+				assert.True(t, errors.Is(gotErr, account.ErrBalanceTimeInFuture))
+			} else {
+				assert.NoError(t, gotErr)
+			}
+			assert.Equal(t, tc.response, gotResp)
+		})
+	}
+}
+
+func TestTransportUpdateAccount(t *testing.T) {
+	server, client, svc := initTransportTest(t)
+	defer server.Close()
+
+	name := "Savings"
+	gold := "gold"
+	request := &account.UpdateRequest{
+		ID:          1,
+		Version:     3,
+		DisplayName: &name,
+		Metadata:    map[string]*string{"tier": &gold, "legacy_id": nil},
+	}
+
+	testCases := []struct {
+		name     string
+		response *account.Account
+		err      error
+	}{
+		{
+			name:     "ok",
+			response: makeAccount(t, func(a *account.Account) { a.DisplayName = name; a.Version = 4 }),
+		},
+		{
+			name: "stale version",
+			err:  errPreconditionFailed("account 1 has version 5: %w", account.ErrVersionMismatch),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			svc.onUpdate = func(ctx context.Context, r *account.UpdateRequest) (*account.Account, error) {
+				assert.Equal(t, request, r)
+				return tc.response, tc.err
+			}
+
+			gotResp, gotErr := client.UpdateAccount(context.Background(), request)
+			if tc.err != nil {
+				assert.True(t, errors.Is(gotErr, ErrInvalidRequest))
+				assert.True(t, errors.Is(gotErr, account.ErrVersionMismatch))
+			} else {
+				assert.NoError(t, gotErr)
+			}
+			assert.Equal(t, tc.response, gotResp)
+		})
+	}
+
+	t.Run("etag", func(t *testing.T) {
+		svc.onGetAccount = func(ctx context.Context, id int64) (*account.Account, error) {
+			return makeAccount(t, func(a *account.Account) { a.Version = 7 }), nil
+		}
+		req, err := http.NewRequest(http.MethodGet, server.URL+"/api/v1/accounts/1", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(auth.APIKeyHeader, "full-access")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		assert.Equal(t, `W/"7"`, resp.Header.Get("ETag"))
+
+		svc.onGetBalanceAt = func(ctx context.Context, id int64, at time.Time) (*account.Account, error) {
+			return makeAccount(t, func(a *account.Account) { a.Version = 7 }), nil
+		}
+		req, err = http.NewRequest(http.MethodGet, server.URL+"/api/v1/accounts/1?as_of=2001-01-02T08:22:33Z", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(auth.APIKeyHeader, "full-access")
+		resp, err = http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		assert.Empty(t, resp.Header.Get("ETag"))
+	})
+
+	t.Run("type change", func(t *testing.T) {
+		svc.onUpdate = func(ctx context.Context, r *account.UpdateRequest) (*account.Account, error) {
+			return makeAccount(t, func(a *account.Account) { a.Type = *r.Type }), nil
+		}
+		for key, wantStatus := range map[string]int{"full-access": http.StatusForbidden, "account-admin": http.StatusOK} {
+			req, err := http.NewRequest(http.MethodPatch, server.URL+"/api/v1/accounts/1", strings.NewReader(`{"Type":"savings"}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set(auth.APIKeyHeader, key)
+			req.Header.Set("If-Match", `"1"`)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			assert.Equal(t, wantStatus, resp.StatusCode, key)
+		}
+	})
+
+	t.Run("no if-match", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodPatch, server.URL+"/api/v1/accounts/1", strings.NewReader(`{"DisplayName":"Savings"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(auth.APIKeyHeader, "full-access")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusPreconditionRequired, resp.StatusCode)
+	})
+}
+
+func TestTransportListAccounts(t *testing.T) {
+	server, _, svc := initTransportTest(t)
+	defer server.Close()
+
+	createdAt := parseTime(t, "2001-01-02T11:22:33+03:00")
+	page := &account.Page{
+		Accounts: []*account.Account{makeAccount(t, nil)},
+		Next:     account.NewCursor("-balance", makeAccount(t, nil)),
+	}
+
+	testCases := []struct {
+		name        string
+		apiKey      string
+		request     *account.ListRequest
+		wantRequest *account.ListRequest
+		response    *account.Page
+		err         error
+	}{
+		{
+			name:   "all filters of the owner",
+			apiKey: "full-access",
+			request: &account.ListRequest{
+				Status:      account.StatusActive,
+				OwnerRef:    "customer-1",
+				MinBalance:  "10",
+				MaxBalance:  "100.50",
+				CreatedFrom: createdAt,
+				CreatedTo:   createdAt.Add(time.Hour),
+				Labels:      map[string]string{"tier": "gold", "url": "https://example.com"},
+				Sort:        "-balance",
+				Limit:       10,
+				After:       account.NewCursor("-balance", makeAccount(t, nil)),
+			},
+			wantRequest: &account.ListRequest{
+				Status:      account.StatusActive,
+				OwnerRef:    "customer-1",
+				MinBalance:  "10",
+				MaxBalance:  "100.50",
+				CreatedFrom: createdAt,
+				CreatedTo:   createdAt.Add(time.Hour),
+				Labels:      map[string]string{"tier": "gold", "url": "https://example.com"},
+				IDs:         []int64{1},
+				Sort:        "-balance",
+				Limit:       10,
+				After:       account.NewCursor("-balance", makeAccount(t, nil)),
+			},
+			response: page,
+		},
+		{
+			name:        "operator",
+			apiKey:      "operator",
+			request:     &account.ListRequest{},
+			wantRequest: &account.ListRequest{},
+			response:    &account.Page{Accounts: []*account.Account{}},
+		},
+		{
+			name:        "invalid request",
+			apiKey:      "operator",
+			request:     &account.ListRequest{Sort: "name"},
+			wantRequest: &account.ListRequest{Sort: "name"},
+			err:         errBadRequest("list request is invalid: %w", account.ErrUnknownSort),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			svc.onListAccounts = func(ctx context.Context, r *account.ListRequest) (*account.Page, error) {
+				assert.Equal(t, tc.wantRequest.CreatedFrom.UTC(), r.CreatedFrom.UTC())
+				assert.Equal(t, tc.wantRequest.CreatedTo.UTC(), r.CreatedTo.UTC())
+				r.CreatedFrom, r.CreatedTo = tc.wantRequest.CreatedFrom, tc.wantRequest.CreatedTo
+				assert.Equal(t, tc.wantRequest, r)
+				return tc.response, tc.err
+			}
+
+			client := newTestClient(t, server, tc.apiKey)
+			gotResp, gotErr := client.ListAccounts(context.Background(), tc.request)
+			if tc.err != nil {
+				assert.True(t, errors.Is(gotErr, ErrInvalidRequest))
+				assert.True(t, errors.Is(gotErr, account.ErrUnknownSort))
+			} else {
+				assert.NoError(t, gotErr)
+			}
+			assert.Equal(t, tc.response, gotResp)
+		})
+	}
 }
 
 func TestTransportGetPayments(t *testing.T) {
@@ -126,39 +678,456 @@ func TestTransportGetPayments(t *testing.T) {
 	defer server.Close()
 
 	testCases := []struct {
-		name      string
-		accountID int64
-		response  []*account.Payment
-		err       error
+		name     string
+		filter   *account.PaymentFilter
+		response []*account.Payment
+		err      error
 	}{
 		{
-			name:      "ok",
-			accountID: 1,
+			name:   "ok",
+			filter: &account.PaymentFilter{AccountID: 1},
 			response: []*account.Payment{
 				makePayment(t, nil),
 			},
 			err: nil,
 		},
 		{
-			name:      "some err",
-			accountID: 1,
-			response:  nil,
-			err: &serviceError{
-				code:    500,
-				Message: "kek some err occurs",
+			name: "filtered",
+			filter: &account.PaymentFilter{
+				AccountID:   1,
+				ExternalRef: "order#42",
+				Labels:      map[string]string{"channel": "web", "campaign": "spring:2021"},
+			},
+			response: []*account.Payment{
+				makePayment(t, func(p *account.Payment) {
+					p.Description = "Order #42"
+					p.ExternalRef = "order#42"
+					p.Metadata = map[string]string{"channel": "web", "campaign": "spring:2021"}
+				}),
 			},
+			err: nil,
+		},
+		{
+			name:     "some err",
+			filter:   &account.PaymentFilter{AccountID: 1},
+			response: nil,
+			err:      errInternal("kek some err occurs"),
 		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			svc.onGetPayments = func(ctx context.Context, accountID int64) ([]*account.Payment, error) {
+			svc.onGetPayments = func(ctx context.Context, f *account.PaymentFilter) ([]*account.Payment, error) {
+				assert.Equal(t, tc.filter, f)
 				return tc.response, tc.err
 			}
 
-			gotResp, gotErr := client.GetPayments(context.Background(), tc.accountID)
+			gotResp, gotErr := client.GetPayments(context.Background(), tc.filter)
 			assert.Equal(t, tc.err, gotErr)
 			assert.Equal(t, tc.response, gotResp)
 		})
 	}
+
+	t.Run("not an owner", func(t *testing.T) {
+		_, err := newTestClient(t, server, "read-only").GetPayments(context.Background(), &account.PaymentFilter{AccountID: 2})
+		serviceErr, ok := err.(*serviceError)
+		if assert.True(t, ok) {
+			assert.Equal(t, http.StatusForbidden, serviceErr.code)
+		}
+	})
+}
+
+func TestTransportAuth(t *testing.T) {
+	server, _, svc := initTransportTest(t)
+	defer server.Close()
+
+	svc.onApplyPayment = func(ctx context.Context, p *account.PaymentRequest) (*account.Payment, error) {
+		return makePayment(t, nil), nil
+	}
+
+	testCases := []struct {
+		name     string
+		apiKey   string
+		wantCode int
+	}{
+		{
+			name:     "no credentials",
+			apiKey:   "",
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "unknown key",
+			apiKey:   "unknown",
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "missing scope",
+			apiKey:   "read-only",
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "not an owner of the sender",
+			apiKey:   "other-owner",
+			wantCode: http.StatusForbidden,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := newTestClient(t, server, tc.apiKey)
+			_, gotErr := client.ApplyPayment(context.Background(), makePaymentRequest(t, nil))
+			e, ok := gotErr.(*serviceError)
+			if !ok {
+				t.Fatalf("unexpected error: %v", gotErr)
+			}
+			assert.Equal(t, tc.wantCode, e.code)
+		})
+	}
+}
+
+func TestTransportRateLimit(t *testing.T) {
+	svc := &mockService{
+		onGetAccount: func(ctx context.Context, id int64) (*account.Account, error) {
+			return makeAccount(t, nil), nil
+		},
+	}
+	newLimiter := func() *rateLimiter {
+		return newRateLimiter(RateLimitConfig{
+			Default: ratelimit.Rule{Limit: 100, Period: time.Minute},
+			Routes: map[string]ratelimit.Rule{
+				"GetAccount": {Limit: 1, Period: time.Minute},
+			},
+			Unauthenticated: ratelimit.Rule{Limit: 1, Period: time.Minute},
+		})
+	}
+
+	get := func(server *httptest.Server, apiKey string) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, server.URL+"/api/v1/accounts/1", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(auth.APIKeyHeader, apiKey)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	t.Run("client ip", func(t *testing.T) {
+		limiter := newLimiter()
+		handler := makeHandler(svc, testAuthenticator, limiter)
+		handler.Use(limiter.httpMiddleware)
+		server := httptest.NewServer(handler)
+		defer server.Close()
+
+		// the authenticated requests are charged only to their principals.
+		resp := get(server, "full-access")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "1", resp.Header.Get("RateLimit-Limit"))
+		assert.Equal(t, "0", resp.Header.Get("RateLimit-Remaining"))
+		resp = get(server, "operator")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		resp = get(server, "random-key")
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+		// a new key doesn't get a new limit before it is authenticated.
+		resp = get(server, "other-random-key")
+		assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+		assert.Equal(t, "60", resp.Header.Get("Retry-After"))
+		assert.Equal(t, "60", resp.Header.Get("RateLimit-Reset"))
+
+		// the client ip out of its limit is rejected before its credentials are checked.
+		resp = get(server, "read-only")
+		assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	})
+
+	t.Run("principal", func(t *testing.T) {
+		server := httptest.NewServer(makeHandler(svc, testAuthenticator, newLimiter()))
+		defer server.Close()
+
+		resp := get(server, "full-access")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "1", resp.Header.Get("RateLimit-Limit"))
+		assert.Equal(t, "0", resp.Header.Get("RateLimit-Remaining"))
+
+		resp = get(server, "full-access")
+		assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+		assert.Equal(t, "60", resp.Header.Get("Retry-After"))
+
+		// the limits of the other principals are separate.
+		resp = get(server, "operator")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+}
+
+func TestNewServiceError_DomainErrors(t *testing.T) {
+	testCases := []struct {
+		err        error
+		wantCode   string
+		wantParams []invalidParam
+	}{
+		{
+			err:        account.ErrUnknownType,
+			wantCode:   "invalid_type",
+			wantParams: []invalidParam{{Name: "type", Reason: account.ErrUnknownType.Error()}},
+		},
+		{
+			err:        account.ErrNotUserType,
+			wantCode:   "invalid_type",
+			wantParams: []invalidParam{{Name: "type", Reason: account.ErrNotUserType.Error()}},
+		},
+		{err: account.ErrInvalidBalancePolicy, wantCode: "invalid_balance_policy"},
+		{err: account.ErrInvalidInterestRate, wantCode: "invalid_interest_rate"},
+		{err: account.ErrUnknownDayCount, wantCode: "invalid_day_count"},
+		{
+			err:        account.ErrInvalidOpeningBalance,
+			wantCode:   "invalid_opening_balance",
+			wantParams: []invalidParam{{Name: "OpeningBalance", Reason: account.ErrInvalidOpeningBalance.Error()}},
+		},
+		{
+			err:        account.ErrDuplicateImportRow,
+			wantCode:   "duplicate_import_row",
+			wantParams: []invalidParam{{Name: "ID", Reason: account.ErrDuplicateImportRow.Error()}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.wantCode, func(t *testing.T) {
+			got := newServiceError(http.StatusBadRequest, "request is invalid: %w", tc.err)
+			assert.Equal(t, tc.wantCode, got.Code)
+			assert.Equal(t, tc.wantParams, got.InvalidParams)
+			assert.True(t, errors.Is(got, tc.err))
+		})
+	}
+}
+
+func TestTransportProblemDetails(t *testing.T) {
+	server, client, svc := initTransportTest(t)
+	defer server.Close()
+
+	svc.onApplyPayment = func(ctx context.Context, p *account.PaymentRequest) (*account.Payment, error) {
+		return nil, errBadRequest("payment is invalid: %w", account.ErrNotPositiveAmount)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, server.URL+"/api/v1/payments", strings.NewReader(`{"From":1,"To":2,"Amount":"-1"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(auth.APIKeyHeader, "full-access")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var got map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "application/problem+json", resp.Header.Get("Content-Type"))
+	assert.Equal(t, map[string]interface{}{
+		"type":   "urn:problem:wallet-service:invalid_amount",
+		"title":  "Bad Request",
+		"status": float64(http.StatusBadRequest),
+		"detail": "payment is invalid: payment amount is not positive",
+		"code":   "invalid_amount",
+		"invalid_params": []interface{}{
+			map[string]interface{}{"name": "Amount", "reason": "payment amount is not positive"},
+		},
+	}, got)
+
+	_, gotErr := client.ApplyPayment(context.Background(), makePaymentRequest(t, nil))
+	assert.True(t, errors.Is(gotErr, account.ErrNotPositiveAmount))
+	assert.False(t, errors.Is(gotErr, account.ErrNotEnoughFunds))
+}
+
+func TestTransportTracePropagation(t *testing.T) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	otel.SetTracerProvider(sdktrace.NewTracerProvider())
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+
+	server, client, svc := initTransportTest(t)
+	defer server.Close()
+
+	ctx, span := otel.Tracer("test").Start(context.Background(), "test")
+	defer span.End()
+
+	var got trace.SpanContext
+	svc.onGetAccount = func(ctx context.Context, id int64) (*account.Account, error) {
+		got = trace.SpanContextFromContext(ctx)
+		return makeAccount(t, nil), nil
+	}
+
+	_, err := client.GetAccount(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, span.SpanContext().TraceID(), got.TraceID())
+	assert.NotEqual(t, span.SpanContext().SpanID(), got.SpanID())
+}
+
+func TestTransportGetStatement(t *testing.T) {
+	server, client, svc := initTransportTest(t)
+	defer server.Close()
+
+	from := parseTime(t, "2001-01-01T00:00:00Z")
+	to := parseTime(t, "2001-02-01T00:00:00Z")
+	statement := &account.Statement{AccountID: 1, From: from, To: to, OpeningBalance: "1000.00"}
+	entries := []*account.StatementEntry{
+		{Payment: makePayment(t, func(p *account.Payment) { p.ID = 1 }), Amount: "-500.00", Balance: "500.00"},
+	}
+	svc.onGetStatement = func(ctx context.Context, r *account.StatementRequest, w account.StatementWriter) error {
+		if !r.From.Before(r.To) {
+			return errBadRequest("statement request is invalid: %w", account.ErrInvalidStatementPeriod)
+		}
+		assert.True(t, from.Equal(r.From))
+		assert.True(t, to.Equal(r.To))
+		if err := w.Begin(statement); err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if err := w.Entry(e); err != nil {
+				return err
+			}
+		}
+		closing := *statement
+		closing.ClosingBalance = "500.00"
+		return w.End(&closing)
+	}
+
+	t.Run("client", func(t *testing.T) {
+		rec := &statementRecorder{}
+		err := client.GetStatement(context.Background(), &account.StatementRequest{AccountID: 1, From: from, To: to}, rec)
+		assert.NoError(t, err)
+		assert.Equal(t, "1000.00", rec.begin.OpeningBalance)
+		assert.True(t, to.Equal(rec.begin.To))
+		assert.Equal(t, "500.00", rec.end.ClosingBalance)
+		if assert.Len(t, rec.entries, 1) {
+			assert.Equal(t, "-500.00", rec.entries[0].Amount)
+			assert.Equal(t, int64(1), rec.entries[0].Payment.ID)
+			assert.True(t, entries[0].Payment.CreatedAt.Equal(rec.entries[0].Payment.CreatedAt))
+		}
+	})
+
+	t.Run("client error", func(t *testing.T) {
+		err := client.GetStatement(context.Background(), &account.StatementRequest{AccountID: 1, From: to, To: from}, &statementRecorder{})
+		assert.True(t, errors.Is(err, ErrInvalidRequest))
+		assert.True(t, errors.Is(err, account.ErrInvalidStatementPeriod))
+	})
+
+	t.Run("csv", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, server.URL+"/api/v1/accounts/1/statement?from=2001-01-01&to=2001-02-01", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(auth.APIKeyHeader, "full-access")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "text/csv; charset=utf-8", resp.Header.Get("Content-Type"))
+		assert.Equal(t, "Type,Time,PaymentID,From,To,Amount,Balance\n"+
+			"opening,2001-01-01T00:00:00Z,,,,,1000.00\n"+
+			"payment,2001-01-02T08:22:33Z,1,1,2,-500.00,500.00\n"+
+			"closing,2001-02-01T00:00:00Z,,,,,500.00\n", string(body))
+	})
+
+	t.Run("not an owner", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, server.URL+"/api/v1/accounts/2/statement", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(auth.APIKeyHeader, "full-access")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+}
+
+func TestTransportGetStatement_Failed(t *testing.T) {
+	svc := &mockService{}
+	var logs bytes.Buffer
+	server := httptest.NewServer(newAccessLogHandler(
+		makeHandler(svc, testAuthenticator, newRateLimiter(RateLimitConfig{})),
+		log.NewLogfmtLogger(&logs),
+	))
+	defer server.Close()
+
+	// failAfter fails the statement after the given number of entries, the many ones are partly sent.
+	var failAfter int
+	svc.onGetStatement = func(ctx context.Context, r *account.StatementRequest, w account.StatementWriter) error {
+		if err := w.Begin(&account.Statement{AccountID: 1, OpeningBalance: "1000.00"}); err != nil {
+			return err
+		}
+		for i := 0; i < failAfter; i++ {
+			entry := &account.StatementEntry{Payment: makePayment(t, nil), Amount: "-1.00", Balance: "999.00"}
+			if err := w.Entry(entry); err != nil {
+				return err
+			}
+		}
+		return errInternal("failed to read payments: %v", "connection reset")
+	}
+
+	get := func(t *testing.T, format string) (*http.Response, string) {
+		req, err := http.NewRequest(http.MethodGet, server.URL+"/api/v1/accounts/1/statement?format="+format, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(auth.APIKeyHeader, "full-access")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, string(body)
+	}
+
+	t.Run("not sent", func(t *testing.T) {
+		failAfter = 1
+		resp, _ := get(t, statementFormatJSONL)
+		assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+		assert.Equal(t, problemContentType, resp.Header.Get("Content-Type"))
+		assert.Empty(t, resp.Header.Get("Content-Disposition"))
+	})
+
+	t.Run("partly sent jsonl", func(t *testing.T) {
+		failAfter, logs = 100, bytes.Buffer{}
+		resp, body := get(t, statementFormatJSONL)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		lines := strings.Split(strings.TrimSpace(body), "\n")
+		assert.Len(t, lines, 102)
+		assert.Equal(t, `{"Type":"error","Balance":"","Error":"statement is truncated"}`, lines[len(lines)-1])
+
+		logLine := logs.String()
+		for _, want := range []string{"level=error", "request_id=" + resp.Header.Get(requestid.Header), "connection reset"} {
+			assert.True(t, strings.Contains(logLine, want), "%q does not contain %q", logLine, want)
+		}
+
+		err := newTestClient(t, server, "full-access").GetStatement(context.Background(), &account.StatementRequest{AccountID: 1}, &statementRecorder{})
+		assert.True(t, errors.Is(err, errStatementTruncated))
+	})
+
+	t.Run("partly sent csv", func(t *testing.T) {
+		failAfter = 100
+		resp, body := get(t, statementFormatCSV)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.True(t, strings.HasSuffix(body, "\nerror,,,,,,\n"), body)
+	})
 }
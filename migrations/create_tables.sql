@@ -15,3 +15,173 @@ CREATE TABLE IF NOT EXISTS payments (
 CREATE INDEX IF NOT EXISTS payments_from_account_id_idx on payments (from_account_id);
 
 CREATE INDEX IF NOT EXISTS payments_to_account_id_idx on payments (to_account_id);
+
+CREATE TABLE IF NOT EXISTS api_keys (
+  id BIGSERIAL PRIMARY KEY,
+  name VARCHAR(128) NOT NULL,
+  key_hash CHAR(64) NOT NULL UNIQUE,
+  scopes TEXT[] NOT NULL DEFAULT '{}',
+  account_ids BIGINT[] NOT NULL DEFAULT '{}',
+  created_at TIMESTAMP NOT NULL,
+  revoked_at TIMESTAMP
+);
+
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS idempotency_key VARCHAR(64);
+
+CREATE UNIQUE INDEX IF NOT EXISTS payments_idempotency_key_idx on payments (from_account_id, idempotency_key);
+
+CREATE TABLE IF NOT EXISTS audit_log (
+  seq BIGSERIAL PRIMARY KEY,
+  account_seq BIGINT NOT NULL,
+  action VARCHAR(32) NOT NULL,
+  actor VARCHAR(128) NOT NULL,
+  request_id VARCHAR(128),
+  account_id BIGINT NOT NULL,
+  payment_id BIGINT,
+  balance_before VARCHAR(32),
+  balance_after VARCHAR(32),
+  details TEXT,
+  created_at TIMESTAMPTZ NOT NULL,
+  prev_hash CHAR(64),
+  hash CHAR(64) NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS audit_log_account_seq_idx on audit_log (account_id, account_seq);
+
+CREATE INDEX IF NOT EXISTS audit_log_created_at_idx on audit_log (created_at);
+
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+  RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_log_append_only ON audit_log;
+CREATE TRIGGER audit_log_append_only BEFORE UPDATE OR DELETE ON audit_log
+  FOR EACH ROW EXECUTE PROCEDURE audit_log_append_only();
+
+DROP TRIGGER IF EXISTS audit_log_no_truncate ON audit_log;
+CREATE TRIGGER audit_log_no_truncate BEFORE TRUNCATE ON audit_log
+  FOR EACH STATEMENT EXECUTE PROCEDURE audit_log_append_only();
+
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS initial_balance VARCHAR(32);
+
+-- the accounts created before initial_balance was introduced are assumed to match their payments.
+UPDATE accounts a SET initial_balance = (
+  a.balance::numeric
+  - COALESCE((SELECT SUM(amount::numeric) FROM payments WHERE to_account_id = a.id), 0)
+  + COALESCE((SELECT SUM(amount::numeric) FROM payments WHERE from_account_id = a.id), 0)
+)::text
+WHERE initial_balance IS NULL;
+
+CREATE TABLE IF NOT EXISTS balance_snapshots (
+  account_id BIGINT NOT NULL REFERENCES accounts (id),
+  taken_at TIMESTAMP NOT NULL,
+  balance VARCHAR(32) NOT NULL,
+  PRIMARY KEY (account_id, taken_at)
+);
+
+CREATE INDEX IF NOT EXISTS payments_created_at_idx on payments (created_at);
+
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS status VARCHAR(16) NOT NULL DEFAULT 'active';
+
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS metadata JSONB NOT NULL DEFAULT '{}';
+
+CREATE INDEX IF NOT EXISTS accounts_status_idx on accounts (status, id);
+
+CREATE INDEX IF NOT EXISTS accounts_created_at_idx on accounts (created_at, id);
+
+CREATE INDEX IF NOT EXISTS accounts_balance_idx on accounts ((balance::numeric), id);
+
+CREATE INDEX IF NOT EXISTS accounts_metadata_idx on accounts USING GIN (metadata jsonb_path_ops);
+
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS owner_ref VARCHAR(128);
+
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS display_name VARCHAR(128);
+
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 1;
+
+CREATE INDEX IF NOT EXISTS accounts_owner_ref_idx on accounts (owner_ref);
+
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS description VARCHAR(256);
+
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS external_ref VARCHAR(64);
+
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS metadata JSONB NOT NULL DEFAULT '{}';
+
+CREATE UNIQUE INDEX IF NOT EXISTS payments_external_ref_idx on payments (from_account_id, external_ref);
+
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS type VARCHAR(16) NOT NULL DEFAULT 'transfer';
+
+CREATE TABLE IF NOT EXISTS payment_legs (
+  id BIGSERIAL PRIMARY KEY,
+  payment_id BIGINT NOT NULL REFERENCES payments (id),
+  to_account_id BIGINT NOT NULL REFERENCES accounts (id),
+  amount VARCHAR(32) NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS payment_legs_payment_id_idx on payment_legs (payment_id);
+
+CREATE INDEX IF NOT EXISTS payment_legs_to_account_id_idx on payment_legs (to_account_id);
+
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS status VARCHAR(16) NOT NULL DEFAULT 'completed';
+
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS settled_at TIMESTAMP;
+
+UPDATE payments SET settled_at = created_at WHERE settled_at IS NULL AND status = 'completed';
+
+CREATE INDEX IF NOT EXISTS payments_settled_at_idx on payments (settled_at);
+
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS risk_decision VARCHAR(16);
+
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS risk_rules TEXT[];
+
+CREATE INDEX IF NOT EXISTS payments_from_account_id_created_at_idx on payments (from_account_id, created_at);
+
+CREATE TABLE IF NOT EXISTS adjustments (
+  id BIGSERIAL PRIMARY KEY,
+  account_id BIGINT NOT NULL REFERENCES accounts (id),
+  direction VARCHAR(16) NOT NULL,
+  amount VARCHAR(32) NOT NULL,
+  reason VARCHAR(256) NOT NULL,
+  status VARCHAR(16) NOT NULL,
+  proposed_by VARCHAR(128) NOT NULL,
+  proposed_at TIMESTAMP NOT NULL,
+  reviewed_by VARCHAR(128),
+  reviewed_at TIMESTAMP,
+  payment_id BIGINT REFERENCES payments (id)
+);
+
+CREATE INDEX IF NOT EXISTS adjustments_account_id_idx on adjustments (account_id, id);
+
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS type VARCHAR(16) NOT NULL DEFAULT 'user';
+
+UPDATE accounts SET type = 'adjustments' WHERE id = -1 AND type = 'user';
+
+CREATE INDEX IF NOT EXISTS accounts_type_idx on accounts (type, id);
+
+CREATE TABLE IF NOT EXISTS payouts (
+  id BIGSERIAL PRIMARY KEY,
+  payment_id BIGINT NOT NULL UNIQUE REFERENCES payments (id),
+  account_id BIGINT NOT NULL REFERENCES accounts (id),
+  amount VARCHAR(32) NOT NULL,
+  status VARCHAR(16) NOT NULL,
+  provider_ref VARCHAR(128),
+  failure_reason VARCHAR(256),
+  created_at TIMESTAMP NOT NULL,
+  updated_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS payouts_status_idx on payouts (status, id);
+
+CREATE TABLE IF NOT EXISTS interest_accruals (
+  account_id BIGINT NOT NULL REFERENCES accounts (id),
+  day TIMESTAMP NOT NULL,
+  balance VARCHAR(32) NOT NULL,
+  rate VARCHAR(32) NOT NULL,
+  amount VARCHAR(32) NOT NULL,
+  payment_id BIGINT REFERENCES payments (id),
+  PRIMARY KEY (account_id, day)
+);
+
+CREATE INDEX IF NOT EXISTS interest_accruals_unposted_idx on interest_accruals (account_id, day) WHERE payment_id IS NULL;